@@ -1,17 +1,21 @@
 package main
 
 import (
+	"context"
 	"goexpert-api/configs"
 	_ "goexpert-api/docs"
+	"goexpert-api/internal/app"
 	"goexpert-api/internal/entity"
 	"goexpert-api/internal/infra/database"
-	"goexpert-api/internal/infra/webserver/handlers"
-	"log"
+	"goexpert-api/internal/infra/logging"
+	"goexpert-api/internal/infra/outbox"
+	"goexpert-api/internal/infra/tracing"
+	"goexpert-api/internal/infra/webhook"
+	"log/slog"
 	"net/http"
+	"os"
+	"time"
 
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/jwtauth"
-	httpSwagger "github.com/swaggo/http-swagger"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -28,63 +32,79 @@ import (
 // @license.url   http://www.apache.org/licenses/LICENSE-2.0.html
 
 // @host      localhost:8000
-// @BasePath  /
+// @BasePath  /v1
 
 // @securityDefinitions.apiKey ApiKeyAuth
 // @in header
 // @name Authorization
 
+// @securityDefinitions.apiKey XAPIKey
+// @in header
+// @name X-API-Key
+
 func main() {
 	config, err := configs.LoadConfig(".")
 	if err != nil {
 		panic(err)
 	}
-	db, err := gorm.Open(sqlite.Open("test.db"), &gorm.Config{})
+	logger := logging.New(os.Stdout, config.LogLevel, config.LogFormat)
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:     config.TracingExporter,
+		ServiceName:  config.TracingServiceName,
+		OTLPEndpoint: config.TracingOTLPEndpoint,
+		OTLPInsecure: config.TracingOTLPInsecure,
+		Writer:       os.Stdout,
+	})
 	if err != nil {
 		panic(err)
 	}
-	db.AutoMigrate(&entity.Product{}, &entity.User{})
-
-	// Creating services
-	// Products
-	productService := database.NewProductService(db)
-	productHandler := handlers.NewProductHandler(productService)
-	// User
-	userService := database.NewUserService(db)
-	userHandler := handlers.NewUserHandler(userService, config.TokenAuth, config.JWTExpiresIn)
+	defer shutdownTracing(context.Background())
 
-	// Using Chi as router
-	r := chi.NewRouter()
+	db, err := gorm.Open(sqlite.Open("test.db"), &gorm.Config{
+		Logger: database.NewGormLogger(logger),
+	})
+	if err != nil {
+		panic(err)
+	}
+	err = database.Migrate(db, &entity.Product{}, &entity.User{}, &entity.Webhook{}, &entity.WebhookDelivery{}, &entity.PasswordReset{}, &entity.APIKey{}, &entity.OAuthClient{}, &entity.RevokedToken{}, &database.OutboxMessage{})
+	if err != nil {
+		panic(err)
+	}
 
-	// General middlewares
-	// r.Use(middleware.Logger) // Chi Logger
-	r.Use(LogRequest) // Custom Logger
+	router, err := app.New(config,
+		app.WithLogger(logger),
+		app.WithDB(db, "sqlite"),
+		app.WithDocsURL("http://localhost:8000/docs/doc.json"),
+	)
+	if err != nil {
+		panic(err)
+	}
 
-	r.Route("/products", func(r chi.Router) {
-		// Group middlewares
-		r.Use(jwtauth.Verifier(config.TokenAuth))
-		r.Use(jwtauth.Authenticator)
-		// Routes
-		r.Get("/", productHandler.GetProducts)
-		r.Post("/", productHandler.CreateProduct)
-		r.Get("/{id}", productHandler.GetProduct)
-		r.Put("/{id}", productHandler.UpdateProduct)
-		r.Delete("/{id}", productHandler.DeleteProduct)
-	})
+	// Events are published to the log and queued for the webhooks
+	webhookService := database.NewWebhookService(db, logger)
+	publisher := outbox.Publishers{outbox.NewLogPublisher(logger), webhook.NewDispatcher(webhookService, database.NewUserService(db, logger), logger)}
+	relay := outbox.NewRelay(database.NewOutboxService(db, logger), publisher, logger)
+	relay.Interval = time.Duration(config.OutboxRelayInterval) * time.Second
+	relay.BatchSize = config.OutboxBatchSize
+	relay.Retention = time.Duration(config.OutboxRetention) * time.Second
+	if err := relay.Validate(); err != nil {
+		panic(err)
+	}
+	go relay.Run(context.Background())
 
-	r.Route("/user", func(r chi.Router) {
-		// Routes
-		r.Post("/", userHandler.CreateUser)
-		r.Post("/generate_token", userHandler.GetJWT)
-	})
-	r.Get("/docs/*", httpSwagger.Handler(httpSwagger.URL("http://localhost:8000/docs/doc.json")))
-	http.ListenAndServe(":8000", r)
-}
+	sender := webhook.NewSender(webhookService, webhook.NewClient(time.Duration(config.WebhookTimeout)*time.Second), logger)
+	sender.Interval = time.Duration(config.WebhookInterval) * time.Second
+	sender.MaxAttempts = config.WebhookMaxAttempts
+	sender.DisableAfter = config.WebhookDisableAfter
+	if err := sender.Validate(); err != nil {
+		panic(err)
+	}
+	go sender.Run(context.Background())
 
-// Custom Middleware
-func LogRequest(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("request %s %s", r.Method, r.URL.Path)
-		next.ServeHTTP(w, r)
-	})
+	logger.Info("starting server", "addr", ":8000")
+	if err := http.ListenAndServe(":8000", router); err != nil {
+		logger.Error("server stopped", "error", err)
+	}
 }
@@ -1,22 +1,149 @@
 package configs
 
 import (
-	"github.com/go-chi/jwtauth"
+	"errors"
+	"goexpert-api/pkg/jwtkeys"
+	"time"
+
 	"github.com/spf13/viper"
 )
 
-type conf struct {
-	JWTSecret    string `mapstructure:"JWT_SECRET"`
-	JWTExpiresIn int    `mapstructure:"JWT_EXPIRESIN"`
-	TokenAuth    *jwtauth.JWTAuth
+var ErrPrivateKeyRequired = errors.New("JWT_PUBLIC_KEY_FILES requires JWT_PRIVATE_KEY_FILE")
+
+// Config is the configuration of the API, read from the .env file and the
+// environment.
+type Config struct {
+	JWTSecret             string   `mapstructure:"JWT_SECRET"`
+	JWTExpiresIn          int      `mapstructure:"JWT_EXPIRESIN"`
+	JWTPrivateKeyFile     string   `mapstructure:"JWT_PRIVATE_KEY_FILE"`
+	JWTPublicKeyFiles     []string `mapstructure:"JWT_PUBLIC_KEY_FILES"`
+	LogLevel              string   `mapstructure:"LOG_LEVEL"`
+	LogFormat             string   `mapstructure:"LOG_FORMAT"`
+	TracingExporter       string   `mapstructure:"TRACING_EXPORTER"`
+	TracingServiceName    string   `mapstructure:"TRACING_SERVICE_NAME"`
+	TracingOTLPEndpoint   string   `mapstructure:"TRACING_OTLP_ENDPOINT"`
+	TracingOTLPInsecure   bool     `mapstructure:"TRACING_OTLP_INSECURE"`
+	LoginIPRatePerMin     int      `mapstructure:"LOGIN_IP_RATE_PER_MIN"`
+	LoginIPBurst          int      `mapstructure:"LOGIN_IP_BURST"`
+	LoginEmailRatePerMin  int      `mapstructure:"LOGIN_EMAIL_RATE_PER_MIN"`
+	LoginEmailBurst       int      `mapstructure:"LOGIN_EMAIL_BURST"`
+	LoginLockoutFailures  int      `mapstructure:"LOGIN_LOCKOUT_FAILURES"`
+	LoginLockoutBaseDelay int      `mapstructure:"LOGIN_LOCKOUT_BASE_DELAY"`
+	LoginLockoutMaxDelay  int      `mapstructure:"LOGIN_LOCKOUT_MAX_DELAY"`
+	CORSAllowedOrigins    []string `mapstructure:"CORS_ALLOWED_ORIGINS"`
+	CORSAllowedMethods    []string `mapstructure:"CORS_ALLOWED_METHODS"`
+	CORSAllowedHeaders    []string `mapstructure:"CORS_ALLOWED_HEADERS"`
+	CORSAllowCredentials  bool     `mapstructure:"CORS_ALLOW_CREDENTIALS"`
+	CORSMaxAge            int      `mapstructure:"CORS_MAX_AGE"`
+	IdempotencyKeyTTL     int      `mapstructure:"IDEMPOTENCY_KEY_TTL"`
+	CacheBackend          string   `mapstructure:"CACHE_BACKEND"`
+	CacheTTL              int      `mapstructure:"CACHE_TTL"`
+	CacheSize             int      `mapstructure:"CACHE_SIZE"`
+	RedisAddr             string   `mapstructure:"REDIS_ADDR"`
+	OutboxRelayInterval   int      `mapstructure:"OUTBOX_RELAY_INTERVAL"`
+	OutboxBatchSize       int      `mapstructure:"OUTBOX_BATCH_SIZE"`
+	OutboxRetention       int      `mapstructure:"OUTBOX_RETENTION"`
+	WebhookInterval       int      `mapstructure:"WEBHOOK_INTERVAL"`
+	WebhookTimeout        int      `mapstructure:"WEBHOOK_TIMEOUT"`
+	WebhookMaxAttempts    int      `mapstructure:"WEBHOOK_MAX_ATTEMPTS"`
+	WebhookDisableAfter   int      `mapstructure:"WEBHOOK_DISABLE_AFTER"`
+	PasswordMinLength     int      `mapstructure:"PASSWORD_MIN_LENGTH"`
+	PasswordMinClasses    int      `mapstructure:"PASSWORD_MIN_CLASSES"`
+	PasswordBreachedFile  string   `mapstructure:"PASSWORD_BREACHED_FILE"`
+	PasswordResetURL      string   `mapstructure:"PASSWORD_RESET_URL"`
+	PasswordResetTTL      int      `mapstructure:"PASSWORD_RESET_TTL"`
+	PasswordResetPerHour  int      `mapstructure:"PASSWORD_RESET_PER_HOUR"`
+	VerificationURL       string   `mapstructure:"EMAIL_VERIFICATION_URL"`
+	VerificationTTL       int      `mapstructure:"EMAIL_VERIFICATION_TTL"`
+	VerificationSecret    string   `mapstructure:"EMAIL_VERIFICATION_SECRET"`
+	VerificationPerHour   int      `mapstructure:"EMAIL_VERIFICATION_PER_HOUR"`
+	UnverifiedLogin       string   `mapstructure:"UNVERIFIED_LOGIN"`
+	MFAIssuer             string   `mapstructure:"MFA_ISSUER"`
+	MFAChallengeTTL       int      `mapstructure:"MFA_CHALLENGE_TTL"`
+	MFASecret             string   `mapstructure:"MFA_SECRET"`
+	MFARequiredRoles      []string `mapstructure:"MFA_REQUIRED_ROLES"`
+	APIKeyTTLDays         int      `mapstructure:"API_KEY_TTL_DAYS"`
+	APIKeyMaxTTLDays      int      `mapstructure:"API_KEY_MAX_TTL_DAYS"`
+	OAuthTokenTTL         int      `mapstructure:"OAUTH_TOKEN_TTL"`
+	MailBackend           string   `mapstructure:"MAIL_BACKEND"`
+	MailFrom              string   `mapstructure:"MAIL_FROM"`
+	MailDir               string   `mapstructure:"MAIL_DIR"`
+	SMTPHost              string   `mapstructure:"SMTP_HOST"`
+	SMTPPort              int      `mapstructure:"SMTP_PORT"`
+	SMTPUsername          string   `mapstructure:"SMTP_USERNAME"`
+	SMTPPassword          string   `mapstructure:"SMTP_PASSWORD"`
+	LegacyDeprecatedAtStr string   `mapstructure:"LEGACY_ROUTES_DEPRECATED_AT"`
+	LegacySunsetAtStr     string   `mapstructure:"LEGACY_ROUTES_SUNSET_AT"`
+	LegacyDeprecatedAt    time.Time
+	LegacySunsetAt        time.Time
+	TokenAuth             *jwtkeys.KeySet
 }
 
-func LoadConfig(path string) (*conf, error) {
-	var cfg *conf
+func LoadConfig(path string) (*Config, error) {
+	var cfg *Config
 	viper.SetConfigName("app_config")
 	viper.SetConfigType("env")
 	viper.AddConfigPath(path)
 	viper.SetConfigFile(".env")
+	viper.SetDefault("JWT_PRIVATE_KEY_FILE", "")
+	viper.SetDefault("JWT_PUBLIC_KEY_FILES", "")
+	viper.SetDefault("LOG_LEVEL", "info")
+	viper.SetDefault("LOG_FORMAT", "json")
+	viper.SetDefault("TRACING_EXPORTER", "none")
+	viper.SetDefault("TRACING_SERVICE_NAME", "goexpert-api")
+	viper.SetDefault("TRACING_OTLP_ENDPOINT", "localhost:4318")
+	viper.SetDefault("TRACING_OTLP_INSECURE", true)
+	viper.SetDefault("LOGIN_IP_RATE_PER_MIN", 20)
+	viper.SetDefault("LOGIN_IP_BURST", 10)
+	viper.SetDefault("LOGIN_EMAIL_RATE_PER_MIN", 5)
+	viper.SetDefault("LOGIN_EMAIL_BURST", 5)
+	viper.SetDefault("LOGIN_LOCKOUT_FAILURES", 5)
+	viper.SetDefault("LOGIN_LOCKOUT_BASE_DELAY", 30)
+	viper.SetDefault("LOGIN_LOCKOUT_MAX_DELAY", 3600)
+	viper.SetDefault("CORS_ALLOWED_ORIGINS", "")
+	viper.SetDefault("CORS_ALLOWED_METHODS", "GET,POST,PUT,DELETE,OPTIONS")
+	viper.SetDefault("CORS_ALLOWED_HEADERS", "Accept,Authorization,Content-Type,Idempotency-Key,X-API-Key,X-Request-ID")
+	viper.SetDefault("CORS_ALLOW_CREDENTIALS", false)
+	viper.SetDefault("CORS_MAX_AGE", 300)
+	viper.SetDefault("IDEMPOTENCY_KEY_TTL", 86400)
+	viper.SetDefault("CACHE_BACKEND", "none")
+	viper.SetDefault("CACHE_TTL", 60)
+	viper.SetDefault("CACHE_SIZE", 1000)
+	viper.SetDefault("REDIS_ADDR", "localhost:6379")
+	viper.SetDefault("OUTBOX_RELAY_INTERVAL", 5)
+	viper.SetDefault("OUTBOX_BATCH_SIZE", 100)
+	viper.SetDefault("OUTBOX_RETENTION", 604800)
+	viper.SetDefault("WEBHOOK_INTERVAL", 5)
+	viper.SetDefault("WEBHOOK_TIMEOUT", 10)
+	viper.SetDefault("WEBHOOK_MAX_ATTEMPTS", 8)
+	viper.SetDefault("WEBHOOK_DISABLE_AFTER", 20)
+	viper.SetDefault("PASSWORD_MIN_LENGTH", 8)
+	viper.SetDefault("PASSWORD_MIN_CLASSES", 2)
+	viper.SetDefault("PASSWORD_BREACHED_FILE", "")
+	viper.SetDefault("PASSWORD_RESET_URL", "http://localhost:3000/reset_password")
+	viper.SetDefault("PASSWORD_RESET_TTL", 3600)
+	viper.SetDefault("PASSWORD_RESET_PER_HOUR", 3)
+	viper.SetDefault("EMAIL_VERIFICATION_URL", "http://localhost:8000/v1/user/verify")
+	viper.SetDefault("EMAIL_VERIFICATION_TTL", 86400)
+	viper.SetDefault("EMAIL_VERIFICATION_SECRET", "")
+	viper.SetDefault("EMAIL_VERIFICATION_PER_HOUR", 3)
+	viper.SetDefault("UNVERIFIED_LOGIN", "limit")
+	viper.SetDefault("MFA_ISSUER", "Go Expert API")
+	viper.SetDefault("MFA_CHALLENGE_TTL", 300)
+	viper.SetDefault("MFA_SECRET", "")
+	viper.SetDefault("MFA_REQUIRED_ROLES", "")
+	viper.SetDefault("API_KEY_TTL_DAYS", 90)
+	viper.SetDefault("API_KEY_MAX_TTL_DAYS", 365)
+	viper.SetDefault("OAUTH_TOKEN_TTL", 3600)
+	viper.SetDefault("MAIL_BACKEND", "file")
+	viper.SetDefault("MAIL_FROM", "Go Expert API <no-reply@localhost>")
+	viper.SetDefault("MAIL_DIR", "mail")
+	viper.SetDefault("SMTP_HOST", "localhost")
+	viper.SetDefault("SMTP_PORT", 587)
+	viper.SetDefault("SMTP_USERNAME", "")
+	viper.SetDefault("SMTP_PASSWORD", "")
+	viper.SetDefault("LEGACY_ROUTES_DEPRECATED_AT", "")
+	viper.SetDefault("LEGACY_ROUTES_SUNSET_AT", "")
 	viper.AutomaticEnv()
 	err := viper.ReadInConfig()
 	if err != nil {
@@ -26,6 +153,37 @@ func LoadConfig(path string) (*conf, error) {
 	if err != nil {
 		return nil, err
 	}
-	cfg.TokenAuth = jwtauth.New("HS256", []byte(cfg.JWTSecret), nil)
+	cfg.LegacyDeprecatedAt, err = parseDate(cfg.LegacyDeprecatedAtStr)
+	if err != nil {
+		return nil, err
+	}
+	cfg.LegacySunsetAt, err = parseDate(cfg.LegacySunsetAtStr)
+	if err != nil {
+		return nil, err
+	}
+	cfg.TokenAuth, err = loadTokenAuth(cfg)
+	if err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
+
+// loadTokenAuth returns the keys of the tokens: the private key file and the
+// public key files if set, or else the JWT secret.
+func loadTokenAuth(cfg *Config) (*jwtkeys.KeySet, error) {
+	if cfg.JWTPrivateKeyFile == "" {
+		if len(cfg.JWTPublicKeyFiles) > 0 {
+			return nil, ErrPrivateKeyRequired
+		}
+		return jwtkeys.NewHMAC([]byte(cfg.JWTSecret)), nil
+	}
+	return jwtkeys.LoadPEM(cfg.JWTPrivateKeyFile, cfg.JWTPublicKeyFiles...)
+}
+
+// parseDate parses dates in the YYYY-MM-DD format, empty strings are zero.
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
@@ -0,0 +1,557 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"goexpert-api/configs"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/cache"
+	"goexpert-api/internal/infra/database"
+	"goexpert-api/internal/infra/database/memory"
+	"goexpert-api/internal/infra/idempotency"
+	"goexpert-api/internal/infra/mail"
+	"goexpert-api/internal/infra/metrics"
+	"goexpert-api/internal/infra/ratelimit"
+	"goexpert-api/internal/infra/webserver"
+	"goexpert-api/internal/infra/webserver/handlers"
+	"goexpert-api/internal/infra/webserver/middlewares"
+	"goexpert-api/pkg/jwtkeys"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrConfigIsRequired     = errors.New("config is required")
+	ErrRepositoryIsRequired = errors.New("product and user repositories are required")
+	ErrMailerIsRequired     = errors.New("a mailer is required to verify the emails")
+	ErrSecretIsRequired     = errors.New("secret is required")
+	ErrPositiveIsRequired   = errors.New("positive value is required")
+)
+
+type options struct {
+	logger           *slog.Logger
+	metrics          *metrics.Metrics
+	db               *gorm.DB
+	dbSystem         string
+	products         database.ProductInterface
+	users            database.UserInterface
+	webhooks         database.WebhookInterface
+	passwordResets   database.PasswordResetInterface
+	apiKeys          database.APIKeyInterface
+	oauthClients     database.OAuthClientInterface
+	revokedTokens    database.RevokedTokenInterface
+	transactions     database.TransactionManager
+	mailer           mail.Mailer
+	rateLimitStore   ratelimit.Store
+	idempotencyStore idempotency.Store
+	cacheBackend     cache.Backend
+	now              func() time.Time
+	background       func(fn func())
+	docsURL          string
+}
+
+// Option customizes the dependencies used by New.
+type Option func(*options)
+
+// WithLogger sets the logger of the API, slog.Default() if not set.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// WithMetrics sets the metrics of the API, so the caller can also expose them
+// elsewhere. A new registry is used if not set.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(o *options) { o.metrics = m }
+}
+
+// WithDB stores products and users with GORM. The metrics and tracing plugins
+// are registered on db, labeled with system (e.g. "sqlite").
+func WithDB(db *gorm.DB, system string) Option {
+	return func(o *options) {
+		o.db = db
+		o.dbSystem = system
+	}
+}
+
+// WithProductRepository overrides the product repository, including the one
+// created by WithDB.
+func WithProductRepository(products database.ProductInterface) Option {
+	return func(o *options) { o.products = products }
+}
+
+// WithUserRepository overrides the user repository, including the one created
+// by WithDB.
+func WithUserRepository(users database.UserInterface) Option {
+	return func(o *options) { o.users = users }
+}
+
+// WithWebhookRepository overrides the webhook repository, including the one
+// created by WithDB. The /webhooks routes are only served with a repository.
+func WithWebhookRepository(webhooks database.WebhookInterface) Option {
+	return func(o *options) { o.webhooks = webhooks }
+}
+
+// WithPasswordResetRepository overrides the password reset repository,
+// including the one created by WithDB. The password reset routes are only
+// served with a repository and a mailer.
+func WithPasswordResetRepository(resets database.PasswordResetInterface) Option {
+	return func(o *options) { o.passwordResets = resets }
+}
+
+// WithAPIKeyRepository overrides the API key repository, including the one
+// created by WithDB. API keys are only managed and accepted with a repository.
+func WithAPIKeyRepository(apiKeys database.APIKeyInterface) Option {
+	return func(o *options) { o.apiKeys = apiKeys }
+}
+
+// WithOAuthRepositories overrides the OAuth client and revoked token
+// repositories, including the ones created by WithDB. The OAuth routes are
+// only served with both.
+func WithOAuthRepositories(clients database.OAuthClientInterface, revokedTokens database.RevokedTokenInterface) Option {
+	return func(o *options) {
+		o.oauthClients = clients
+		o.revokedTokens = revokedTokens
+	}
+}
+
+// WithTransactionManager overrides the transactions of the writes that must be
+// atomic, including the ones created by WithDB. Without a database they are
+// run by a memory.TransactionManager, which rolls nothing back.
+func WithTransactionManager(transactions database.TransactionManager) Option {
+	return func(o *options) { o.transactions = transactions }
+}
+
+// WithMailer sets the mailer of the emails sent to the users, overriding the
+// one selected by the MAIL_BACKEND config.
+func WithMailer(mailer mail.Mailer) Option {
+	return func(o *options) { o.mailer = mailer }
+}
+
+// WithRateLimitStore sets the store of the login limits, in memory if not set.
+func WithRateLimitStore(store ratelimit.Store) Option {
+	return func(o *options) { o.rateLimitStore = store }
+}
+
+// WithClock sets the clock of the rate limits, time.Now if not set, so tests
+// don't depend on how long the requests take.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) { o.now = now }
+}
+
+// WithBackground sets how the work done after the response, such as sending
+// the password reset emails, is run. Each fn runs in a new goroutine if not
+// set.
+func WithBackground(run func(fn func())) Option {
+	return func(o *options) { o.background = run }
+}
+
+// WithIdempotencyStore sets the store of the Idempotency-Key responses, in
+// memory if not set.
+func WithIdempotencyStore(store idempotency.Store) Option {
+	return func(o *options) { o.idempotencyStore = store }
+}
+
+// WithCacheBackend sets the cache of the product reads, overriding the one
+// selected by the CACHE_BACKEND config.
+func WithCacheBackend(backend cache.Backend) Option {
+	return func(o *options) { o.cacheBackend = backend }
+}
+
+// WithDocsURL sets the URL of the swagger document, /docs/doc.json if not set.
+func WithDocsURL(url string) Option {
+	return func(o *options) { o.docsURL = url }
+}
+
+// New builds the complete API from the config. Repositories must be given with
+// WithDB or WithProductRepository and WithUserRepository.
+func New(config *configs.Config, opts ...Option) (http.Handler, error) {
+	if config == nil {
+		return nil, ErrConfigIsRequired
+	}
+	err := requirePositive(
+		setting{"JWT_EXPIRESIN", config.JWTExpiresIn},
+		setting{"IDEMPOTENCY_KEY_TTL", config.IdempotencyKeyTTL},
+		setting{"LOGIN_LOCKOUT_FAILURES", config.LoginLockoutFailures},
+		setting{"LOGIN_LOCKOUT_BASE_DELAY", config.LoginLockoutBaseDelay},
+		setting{"LOGIN_LOCKOUT_MAX_DELAY", config.LoginLockoutMaxDelay},
+		setting{"MFA_CHALLENGE_TTL", config.MFAChallengeTTL},
+	)
+	if err != nil {
+		return nil, err
+	}
+	o := &options{docsURL: "/docs/doc.json"}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.logger == nil {
+		o.logger = slog.Default()
+	}
+	if o.metrics == nil {
+		o.metrics = metrics.New()
+	}
+	if o.now == nil {
+		o.now = time.Now
+	}
+	if o.rateLimitStore == nil {
+		store := ratelimit.NewMemoryStore()
+		store.Now = o.now
+		o.rateLimitStore = store
+	}
+	if o.idempotencyStore == nil {
+		o.idempotencyStore = idempotency.NewMemoryStore()
+	}
+	if o.db != nil {
+		if err := setupDB(o); err != nil {
+			return nil, err
+		}
+	}
+	if o.products == nil || o.users == nil {
+		return nil, ErrRepositoryIsRequired
+	}
+
+	if o.cacheBackend == nil {
+		backend, err := newCacheBackend(config)
+		if err != nil {
+			return nil, err
+		}
+		o.cacheBackend = backend
+	}
+	if o.cacheBackend != nil {
+		o.products = cache.NewProductService(o.products, o.cacheBackend, time.Duration(config.CacheTTL)*time.Second, o.logger)
+	}
+	if o.transactions == nil {
+		o.transactions = newTransactionManager(o)
+	}
+
+	tokenAuth := config.TokenAuth
+	if tokenAuth == nil {
+		if config.JWTSecret == "" {
+			return nil, fmt.Errorf("%w: JWT_SECRET", ErrSecretIsRequired)
+		}
+		tokenAuth = jwtkeys.NewHMAC([]byte(config.JWTSecret))
+	}
+
+	// newTokenBucket names the settings of the bucket when they are invalid
+	newTokenBucket := func(rate float64, burst int, settings string) (*ratelimit.TokenBucket, error) {
+		bucket, err := ratelimit.NewTokenBucket(o.rateLimitStore, rate, burst)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, settings)
+		}
+		bucket.Now = o.now
+		return bucket, nil
+	}
+	loginIPLimiter, err := newTokenBucket(float64(config.LoginIPRatePerMin)/60, config.LoginIPBurst, "LOGIN_IP_RATE_PER_MIN and LOGIN_IP_BURST")
+	if err != nil {
+		return nil, err
+	}
+	loginEmailLimiter, err := newTokenBucket(float64(config.LoginEmailRatePerMin)/60, config.LoginEmailBurst, "LOGIN_EMAIL_RATE_PER_MIN and LOGIN_EMAIL_BURST")
+	if err != nil {
+		return nil, err
+	}
+	lockout := ratelimit.NewLockout(
+		o.rateLimitStore,
+		config.LoginLockoutFailures,
+		time.Duration(config.LoginLockoutBaseDelay)*time.Second,
+		time.Duration(config.LoginLockoutMaxDelay)*time.Second,
+	)
+	lockout.Now = o.now
+	loginGuard := ratelimit.NewLoginGuard(loginEmailLimiter, lockout)
+
+	passwordPolicy, err := newPasswordPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.mailer == nil {
+		o.mailer, err = newMailer(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	unverifiedLogin := config.UnverifiedLogin
+	switch unverifiedLogin {
+	case "":
+		unverifiedLogin = handlers.UnverifiedLoginAllow
+	case handlers.UnverifiedLoginAllow, handlers.UnverifiedLoginLimit, handlers.UnverifiedLoginDeny:
+	default:
+		return nil, handlers.ErrInvalidUnverifiedLogin
+	}
+	var emailVerificationHandler *handlers.EmailVerificationHandler
+	if o.mailer != nil {
+		secret := config.VerificationSecret
+		if secret == "" {
+			secret = config.JWTSecret
+		}
+		// JWT_SECRET may be empty when the tokens are signed with a private key
+		if secret == "" {
+			return nil, fmt.Errorf("%w: EMAIL_VERIFICATION_SECRET or JWT_SECRET", ErrSecretIsRequired)
+		}
+		if err := requirePositive(setting{"EMAIL_VERIFICATION_TTL", config.VerificationTTL}); err != nil {
+			return nil, err
+		}
+		limiter, err := newTokenBucket(float64(config.VerificationPerHour)/3600, config.VerificationPerHour, "EMAIL_VERIFICATION_PER_HOUR")
+		if err != nil {
+			return nil, err
+		}
+		emailVerificationHandler = handlers.NewEmailVerificationHandler(
+			o.users,
+			o.mailer,
+			[]byte(secret),
+			config.VerificationURL,
+			time.Duration(config.VerificationTTL)*time.Second,
+			limiter,
+			o.logger,
+		)
+	} else if unverifiedLogin != handlers.UnverifiedLoginAllow {
+		return nil, ErrMailerIsRequired
+	}
+
+	var passwordResetHandler *handlers.PasswordResetHandler
+	if o.passwordResets != nil && o.mailer != nil {
+		if err := requirePositive(setting{"PASSWORD_RESET_TTL", config.PasswordResetTTL}); err != nil {
+			return nil, err
+		}
+		limiter, err := newTokenBucket(float64(config.PasswordResetPerHour)/3600, config.PasswordResetPerHour, "PASSWORD_RESET_PER_HOUR")
+		if err != nil {
+			return nil, err
+		}
+		passwordResetHandler = handlers.NewPasswordResetHandler(
+			o.users,
+			o.passwordResets,
+			o.transactions,
+			o.mailer,
+			passwordPolicy,
+			limiter,
+			loginGuard,
+			config.PasswordResetURL,
+			time.Duration(config.PasswordResetTTL)*time.Second,
+			o.logger,
+		)
+		passwordResetHandler.Background = o.background
+	}
+
+	for _, role := range config.MFARequiredRoles {
+		if !slices.Contains(entity.Roles, role) {
+			return nil, entity.ErrInvalidRole
+		}
+	}
+	mfaSecret := config.MFASecret
+	if mfaSecret == "" {
+		mfaSecret = config.JWTSecret
+	}
+	if mfaSecret == "" {
+		return nil, fmt.Errorf("%w: MFA_SECRET or JWT_SECRET", ErrSecretIsRequired)
+	}
+	mfaHandler := handlers.NewMFAHandler(
+		o.users,
+		tokenAuth,
+		config.JWTExpiresIn,
+		[]byte(mfaSecret),
+		time.Duration(config.MFAChallengeTTL)*time.Second,
+		config.MFAIssuer,
+		loginGuard,
+		o.logger,
+	)
+
+	var apiKeyHandler *handlers.APIKeyHandler
+	if o.apiKeys != nil {
+		err := requirePositive(
+			setting{"API_KEY_TTL_DAYS", config.APIKeyTTLDays},
+			setting{"API_KEY_MAX_TTL_DAYS", config.APIKeyMaxTTLDays},
+		)
+		if err != nil {
+			return nil, err
+		}
+		apiKeyHandler = handlers.NewAPIKeyHandler(
+			o.apiKeys,
+			time.Duration(config.APIKeyTTLDays)*24*time.Hour,
+			time.Duration(config.APIKeyMaxTTLDays)*24*time.Hour,
+			o.logger,
+		)
+	}
+
+	var oauthHandler *handlers.OAuthHandler
+	if o.oauthClients != nil && o.revokedTokens != nil {
+		if err := requirePositive(setting{"OAUTH_TOKEN_TTL", config.OAuthTokenTTL}); err != nil {
+			return nil, err
+		}
+		oauthHandler = handlers.NewOAuthHandler(
+			o.oauthClients,
+			o.revokedTokens,
+			o.users,
+			tokenAuth,
+			time.Duration(config.OAuthTokenTTL)*time.Second,
+			o.logger,
+		)
+	}
+
+	var webhookHandler *handlers.WebhookHandler
+	if o.webhooks != nil {
+		webhookHandler = handlers.NewWebhookHandler(o.webhooks, o.users, o.logger)
+	}
+
+	return webserver.NewRouter(webserver.RouterConfig{
+		ProductHandler:           handlers.NewProductHandler(o.products, o.logger, o.metrics),
+		UserHandler:              handlers.NewUserHandler(o.users, tokenAuth, config.JWTExpiresIn, o.logger, o.metrics, loginGuard, passwordPolicy, emailVerificationHandler, unverifiedLogin, mfaHandler),
+		AdminHandler:             handlers.NewAdminHandler(o.users, o.logger),
+		MFAHandler:               mfaHandler,
+		APIKeyHandler:            apiKeyHandler,
+		OAuthHandler:             oauthHandler,
+		JWKSHandler:              handlers.NewJWKSHandler(tokenAuth, o.logger),
+		UserService:              o.users,
+		WebhookHandler:           webhookHandler,
+		PasswordResetHandler:     passwordResetHandler,
+		EmailVerificationHandler: emailVerificationHandler,
+		RequireVerifiedEmail:     unverifiedLogin != handlers.UnverifiedLoginAllow,
+		MFARequiredRoles:         config.MFARequiredRoles,
+		TokenAuth:                tokenAuth,
+		Logger:                   o.logger,
+		Metrics:                  o.metrics,
+		CORS: middlewares.CORSConfig{
+			AllowedOrigins:   config.CORSAllowedOrigins,
+			AllowedMethods:   config.CORSAllowedMethods,
+			AllowedHeaders:   config.CORSAllowedHeaders,
+			AllowCredentials: config.CORSAllowCredentials,
+			MaxAge:           config.CORSMaxAge,
+		},
+		LoginIPLimiter:     loginIPLimiter,
+		IdempotencyStore:   o.idempotencyStore,
+		IdempotencyKeyTTL:  time.Duration(config.IdempotencyKeyTTL) * time.Second,
+		LegacyDeprecatedAt: config.LegacyDeprecatedAt,
+		LegacySunsetAt:     config.LegacySunsetAt,
+		DocsURL:            o.docsURL,
+	}), nil
+}
+
+// setting is a numeric config named by its environment variable.
+type setting struct {
+	name  string
+	value int
+}
+
+// requirePositive returns ErrPositiveIsRequired naming the first setting that
+// is not positive, as zero durations and limits would expire or reject
+// everything.
+func requirePositive(settings ...setting) error {
+	for _, s := range settings {
+		if s.value <= 0 {
+			return fmt.Errorf("%w: %s", ErrPositiveIsRequired, s.name)
+		}
+	}
+	return nil
+}
+
+// newPasswordPolicy builds the password policy from the config, reading the
+// breached passwords file if set.
+func newPasswordPolicy(config *configs.Config) (*entity.PasswordPolicy, error) {
+	policy := &entity.PasswordPolicy{
+		MinLength:  config.PasswordMinLength,
+		MinClasses: config.PasswordMinClasses,
+	}
+	if config.PasswordBreachedFile == "" {
+		return policy, nil
+	}
+	file, err := os.Open(config.PasswordBreachedFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	policy.Breached, err = entity.ReadBreachedPasswords(file)
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// setupDB instruments the GORM database and creates the repositories that
+// were not given explicitly.
+func setupDB(o *options) error {
+	if err := o.db.Use(database.NewMetricsPlugin(o.metrics.DBQueryDuration)); err != nil {
+		return err
+	}
+	if err := o.db.Use(database.NewTracingPlugin(o.dbSystem)); err != nil {
+		return err
+	}
+	sqlDB, err := o.db.DB()
+	if err != nil {
+		return err
+	}
+	if err := o.metrics.RegisterDB(o.dbSystem, sqlDB); err != nil {
+		return err
+	}
+	if o.products == nil {
+		o.products = database.NewProductService(o.db, o.logger)
+	}
+	if o.users == nil {
+		o.users = database.NewUserService(o.db, o.logger)
+	}
+	if o.webhooks == nil {
+		o.webhooks = database.NewWebhookService(o.db, o.logger)
+	}
+	if o.passwordResets == nil {
+		o.passwordResets = database.NewPasswordResetService(o.db, o.logger)
+	}
+	if o.apiKeys == nil {
+		o.apiKeys = database.NewAPIKeyService(o.db, o.logger)
+	}
+	if o.oauthClients == nil {
+		o.oauthClients = database.NewOAuthClientService(o.db, o.logger)
+	}
+	if o.revokedTokens == nil {
+		o.revokedTokens = database.NewRevokedTokenService(o.db, o.logger)
+	}
+	return nil
+}
+
+// newTransactionManager runs the transactions with the repositories of the
+// API, so the writes go through the product cache.
+func newTransactionManager(o *options) database.TransactionManager {
+	if o.db == nil {
+		return memory.NewTransactionManager(database.Repositories{
+			Products:       o.products,
+			Users:          o.users,
+			PasswordResets: o.passwordResets,
+		})
+	}
+	transactions := database.NewTransactionService(o.db, o.logger)
+	transactions.Repositories.Products = o.products
+	transactions.Repositories.Users = o.users
+	transactions.Repositories.PasswordResets = o.passwordResets
+	return transactions
+}
+
+// newCacheBackend returns the backend selected by the config, nil when
+// caching is disabled.
+func newCacheBackend(config *configs.Config) (cache.Backend, error) {
+	switch config.CacheBackend {
+	case "", cache.BackendNone:
+		return nil, nil
+	case cache.BackendMemory:
+		return cache.NewLRU(config.CacheSize), nil
+	case cache.BackendRedis:
+		return cache.NewRedis(redis.NewClient(&redis.Options{Addr: config.RedisAddr})), nil
+	default:
+		return nil, cache.ErrInvalidBackend
+	}
+}
+
+// newMailer returns the mailer selected by the config, nil when emails are
+// disabled.
+func newMailer(config *configs.Config) (mail.Mailer, error) {
+	switch config.MailBackend {
+	case "", mail.BackendNone:
+		return nil, nil
+	case mail.BackendSMTP:
+		return mail.NewSMTPMailer(config.SMTPHost, config.SMTPPort, config.SMTPUsername, config.SMTPPassword, config.MailFrom), nil
+	case mail.BackendFile:
+		return mail.NewFileMailer(config.MailDir, config.MailFrom), nil
+	default:
+		return nil, mail.ErrInvalidBackend
+	}
+}
@@ -0,0 +1,358 @@
+package app
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"goexpert-api/configs"
+	"goexpert-api/internal/dto"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/cache"
+	"goexpert-api/internal/infra/database"
+	"goexpert-api/internal/infra/database/memory"
+	"goexpert-api/internal/infra/mail"
+	"goexpert-api/internal/infra/ratelimit"
+	"goexpert-api/internal/infra/webserver/handlers"
+	"goexpert-api/pkg/jwtkeys"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestConfig() *configs.Config {
+	return &configs.Config{
+		JWTSecret:             "secret",
+		JWTExpiresIn:          300,
+		LoginIPRatePerMin:     60,
+		LoginIPBurst:          10,
+		LoginEmailRatePerMin:  60,
+		LoginEmailBurst:       10,
+		LoginLockoutFailures:  5,
+		LoginLockoutBaseDelay: 60,
+		LoginLockoutMaxDelay:  3600,
+		IdempotencyKeyTTL:     3600,
+		PasswordResetTTL:      3600,
+		PasswordResetPerHour:  3,
+		VerificationTTL:       3600,
+		VerificationPerHour:   3,
+		MFAChallengeTTL:       300,
+		APIKeyTTLDays:         30,
+		APIKeyMaxTTLDays:      90,
+		OAuthTokenTTL:         600,
+	}
+}
+
+func serve(handler http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestNewWhenConfigIsMissing(t *testing.T) {
+	_, err := New(nil)
+	assert.Equal(t, ErrConfigIsRequired, err)
+}
+
+func TestNewWhenRepositoriesAreMissing(t *testing.T) {
+	_, err := New(newTestConfig())
+	assert.Equal(t, ErrRepositoryIsRequired, err)
+
+	_, err = New(newTestConfig(), WithProductRepository(memory.NewProductService()))
+	assert.Equal(t, ErrRepositoryIsRequired, err)
+}
+
+func TestNewWithRepositories(t *testing.T) {
+	handler, err := New(newTestConfig(),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		WithProductRepository(memory.NewProductService()),
+		WithUserRepository(memory.NewUserService()),
+	)
+	assert.Nil(t, err)
+
+	w := serve(handler, http.MethodGet, "/v1/products", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestNewWithDB(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Error(err)
+	}
+	db.AutoMigrate(&entity.Product{}, &entity.User{}, &database.OutboxMessage{})
+
+	handler, err := New(newTestConfig(),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		WithDB(db, "sqlite"),
+	)
+	assert.Nil(t, err)
+
+	w := serve(handler, http.MethodPost, "/v1/user", "", `{"name":"John Doe","email":"john@doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = serve(handler, http.MethodPost, "/v1/user", "", `{"name":"John Doe","email":"John@Doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = serve(handler, http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var output dto.GetJWTOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+
+	w = serve(handler, http.MethodPost, "/v1/products", output.AccessToken, `{"name":"Product 1","price":10}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var count int64
+	db.Model(&entity.Product{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// Queries are instrumented by the plugins registered on the database
+	w = serve(handler, http.MethodGet, "/metrics", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `goexpert_api_db_query_duration_seconds_count{operation="create",table="products"} 1`)
+}
+
+func TestNewWithDBResetsPasswordsInTransaction(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	db.AutoMigrate(&entity.User{}, &entity.PasswordReset{}, &database.OutboxMessage{})
+	ctx := context.Background()
+	user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+	assert.Nil(t, database.NewUserService(db, slog.Default()).Create(ctx, user))
+	reset, token, err := entity.NewPasswordReset(user, time.Hour, time.Now().UTC())
+	assert.Nil(t, err)
+	assert.Nil(t, database.NewPasswordResetService(db, slog.Default()).Create(ctx, reset))
+
+	failUpdates := true
+	db.Callback().Update().Before("gorm:update").Register("test:fail_user_updates", func(tx *gorm.DB) {
+		if failUpdates && tx.Statement.Table == "users" {
+			tx.AddError(errors.New("update failed"))
+		}
+	})
+
+	config := newTestConfig()
+	handler, err := New(config,
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		WithDB(db, "sqlite"),
+		WithMailer(mail.NewMemoryMailer()),
+	)
+	assert.Nil(t, err)
+
+	body := `{"token":"` + token + `","password":"def45678"}`
+	w := serve(handler, http.MethodPost, "/v1/user/password/reset", "", body)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// The token was not spent by the failed reset
+	failUpdates = false
+	w = serve(handler, http.MethodPost, "/v1/user/password/reset", "", body)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = serve(handler, http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"def45678"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestNewWithCacheBackend(t *testing.T) {
+	config := newTestConfig()
+	config.CacheBackend = "unknown"
+	_, err := New(config, WithProductRepository(memory.NewProductService()), WithUserRepository(memory.NewUserService()))
+	assert.ErrorIs(t, err, cache.ErrInvalidBackend)
+
+	config.CacheBackend = cache.BackendMemory
+	_, err = New(config, WithProductRepository(memory.NewProductService()), WithUserRepository(memory.NewUserService()))
+	assert.Nil(t, err)
+}
+
+func TestNewWithBreachedPasswordsFile(t *testing.T) {
+	config := newTestConfig()
+	config.PasswordBreachedFile = "missing.txt"
+	_, err := New(config, WithProductRepository(memory.NewProductService()), WithUserRepository(memory.NewUserService()))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	config.PasswordBreachedFile = filepath.Join(t.TempDir(), "breached.txt")
+	assert.Nil(t, os.WriteFile(config.PasswordBreachedFile, []byte("abc123\n"), 0o600))
+	handler, err := New(config, WithProductRepository(memory.NewProductService()), WithUserRepository(memory.NewUserService()))
+	assert.Nil(t, err)
+	w := serve(handler, http.MethodPost, "/v1/user", "", `{"name":"John Doe","email":"john@doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "password_breached")
+}
+
+func TestNewWithMailBackend(t *testing.T) {
+	config := newTestConfig()
+	config.MailBackend = "unknown"
+	_, err := New(config, WithProductRepository(memory.NewProductService()), WithUserRepository(memory.NewUserService()))
+	assert.ErrorIs(t, err, mail.ErrInvalidBackend)
+
+	// Password resets are only served with a mailer
+	config.MailBackend = mail.BackendNone
+	handler, err := New(config,
+		WithProductRepository(memory.NewProductService()),
+		WithUserRepository(memory.NewUserService()),
+		WithPasswordResetRepository(memory.NewPasswordResetService()),
+	)
+	assert.Nil(t, err)
+	w := serve(handler, http.MethodPost, "/v1/user/password/forgot", "", `{"email":"john@doe.com"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	config.MailBackend = mail.BackendFile
+	config.MailDir = t.TempDir()
+	config.PasswordResetURL = "http://localhost:3000/reset_password"
+	users := memory.NewUserService()
+	user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+	assert.Nil(t, users.Create(context.Background(), user))
+	var sending sync.WaitGroup
+	handler, err = New(config,
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		WithProductRepository(memory.NewProductService()),
+		WithUserRepository(users),
+		WithPasswordResetRepository(memory.NewPasswordResetService()),
+		WithBackground(func(fn func()) {
+			sending.Add(1)
+			go func() {
+				defer sending.Done()
+				fn()
+			}()
+		}),
+	)
+	assert.Nil(t, err)
+	w = serve(handler, http.MethodPost, "/v1/user/password/forgot", "", `{"email":"john@doe.com"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	// The email is sent after the response
+	sending.Wait()
+	files, _ := filepath.Glob(filepath.Join(config.MailDir, "*.eml"))
+	assert.Len(t, files, 1)
+}
+
+func TestNewWithUnverifiedLogin(t *testing.T) {
+	config := newTestConfig()
+	config.UnverifiedLogin = "maybe"
+	_, err := New(config, WithProductRepository(memory.NewProductService()), WithUserRepository(memory.NewUserService()))
+	assert.ErrorIs(t, err, handlers.ErrInvalidUnverifiedLogin)
+
+	// Users could never verify the email without a mailer
+	config.UnverifiedLogin = handlers.UnverifiedLoginDeny
+	_, err = New(config, WithProductRepository(memory.NewProductService()), WithUserRepository(memory.NewUserService()))
+	assert.ErrorIs(t, err, ErrMailerIsRequired)
+
+	_, err = New(config,
+		WithProductRepository(memory.NewProductService()),
+		WithUserRepository(memory.NewUserService()),
+		WithMailer(mail.NewMemoryMailer()),
+	)
+	assert.Nil(t, err)
+}
+
+func TestNewWithoutSecrets(t *testing.T) {
+	newApp := func(config *configs.Config) error {
+		_, err := New(config,
+			WithProductRepository(memory.NewProductService()),
+			WithUserRepository(memory.NewUserService()),
+			WithMailer(mail.NewMemoryMailer()),
+		)
+		return err
+	}
+	config := newTestConfig()
+	config.JWTSecret = ""
+	assert.ErrorIs(t, newApp(config), ErrSecretIsRequired)
+
+	// Tokens signed with a private key still need the secrets of the MFA and
+	// verification tokens
+	_, private, _ := ed25519.GenerateKey(rand.Reader)
+	keys, err := jwtkeys.New(private)
+	assert.Nil(t, err)
+	config.TokenAuth = keys
+	assert.ErrorIs(t, newApp(config), ErrSecretIsRequired)
+	config.VerificationSecret = "verification secret"
+	assert.ErrorIs(t, newApp(config), ErrSecretIsRequired)
+	config.MFASecret = "mfa secret"
+	assert.Nil(t, newApp(config))
+}
+
+func TestNewWithoutRateLimits(t *testing.T) {
+	newApp := func(config *configs.Config) error {
+		_, err := New(config,
+			WithProductRepository(memory.NewProductService()),
+			WithUserRepository(memory.NewUserService()),
+			WithPasswordResetRepository(memory.NewPasswordResetService()),
+			WithMailer(mail.NewMemoryMailer()),
+		)
+		return err
+	}
+	for _, clear := range []func(*configs.Config){
+		func(c *configs.Config) { c.LoginIPRatePerMin = 0 },
+		func(c *configs.Config) { c.LoginIPBurst = 0 },
+		func(c *configs.Config) { c.LoginEmailRatePerMin = 0 },
+		func(c *configs.Config) { c.LoginEmailBurst = -1 },
+		func(c *configs.Config) { c.VerificationPerHour = 0 },
+		func(c *configs.Config) { c.PasswordResetPerHour = 0 },
+	} {
+		config := newTestConfig()
+		clear(config)
+		assert.ErrorIs(t, newApp(config), ratelimit.ErrInvalidRate)
+	}
+}
+
+func TestNewWithoutPositiveSettings(t *testing.T) {
+	newApp := func(config *configs.Config) error {
+		_, err := New(config,
+			WithProductRepository(memory.NewProductService()),
+			WithUserRepository(memory.NewUserService()),
+			WithPasswordResetRepository(memory.NewPasswordResetService()),
+			WithAPIKeyRepository(memory.NewAPIKeyService()),
+			WithOAuthRepositories(memory.NewOAuthClientService(), memory.NewRevokedTokenService()),
+			WithMailer(mail.NewMemoryMailer()),
+		)
+		return err
+	}
+	assert.Nil(t, newApp(newTestConfig()))
+	for name, clear := range map[string]func(*configs.Config){
+		"JWT_EXPIRESIN":            func(c *configs.Config) { c.JWTExpiresIn = 0 },
+		"IDEMPOTENCY_KEY_TTL":      func(c *configs.Config) { c.IdempotencyKeyTTL = 0 },
+		"LOGIN_LOCKOUT_FAILURES":   func(c *configs.Config) { c.LoginLockoutFailures = 0 },
+		"LOGIN_LOCKOUT_BASE_DELAY": func(c *configs.Config) { c.LoginLockoutBaseDelay = -1 },
+		"LOGIN_LOCKOUT_MAX_DELAY":  func(c *configs.Config) { c.LoginLockoutMaxDelay = 0 },
+		"MFA_CHALLENGE_TTL":        func(c *configs.Config) { c.MFAChallengeTTL = 0 },
+		"EMAIL_VERIFICATION_TTL":   func(c *configs.Config) { c.VerificationTTL = 0 },
+		"PASSWORD_RESET_TTL":       func(c *configs.Config) { c.PasswordResetTTL = 0 },
+		"API_KEY_TTL_DAYS":         func(c *configs.Config) { c.APIKeyTTLDays = 0 },
+		"API_KEY_MAX_TTL_DAYS":     func(c *configs.Config) { c.APIKeyMaxTTLDays = 0 },
+		"OAUTH_TOKEN_TTL":          func(c *configs.Config) { c.OAuthTokenTTL = 0 },
+	} {
+		config := newTestConfig()
+		clear(config)
+		err := newApp(config)
+		assert.ErrorIs(t, err, ErrPositiveIsRequired, name)
+		assert.ErrorContains(t, err, name)
+	}
+}
+
+func TestNewWithMFARequiredRoles(t *testing.T) {
+	config := newTestConfig()
+	config.MFARequiredRoles = []string{"root"}
+	_, err := New(config, WithProductRepository(memory.NewProductService()), WithUserRepository(memory.NewUserService()))
+	assert.ErrorIs(t, err, entity.ErrInvalidRole)
+
+	config.MFARequiredRoles = []string{entity.RoleAdmin}
+	_, err = New(config, WithProductRepository(memory.NewProductService()), WithUserRepository(memory.NewUserService()))
+	assert.Nil(t, err)
+}
@@ -1,7 +1,11 @@
 package dto
 
+import "time"
+
 type ErrorOutput struct {
 	Message string `json:"message"`
+	// Identifies the error for clients, only set by some endpoints
+	Code string `json:"code,omitempty"`
 }
 
 type CreateProductInput struct {
@@ -19,6 +23,33 @@ type CreateUserInput struct {
 	Password string `json:"password"`
 }
 
+type UpdateUserInput struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type ChangePasswordInput struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type ForgotPasswordInput struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordInput struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type ResendVerificationInput struct {
+	Email string `json:"email"`
+}
+
+type UpdateUserRoleInput struct {
+	Role string `json:"role"`
+}
+
 type GetJWTInput struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
@@ -27,3 +58,105 @@ type GetJWTInput struct {
 type GetJWTOutput struct {
 	AccessToken string `json:"access_token"`
 }
+
+// MFAChallengeOutput is returned by the login of users with two-factor
+// authentication, instead of the access token.
+type MFAChallengeOutput struct {
+	MFAToken string `json:"mfa_token"`
+	// Seconds until the MFA token expires
+	ExpiresIn int `json:"expires_in"`
+}
+
+type VerifyMFAInput struct {
+	MFAToken string `json:"mfa_token"`
+	// A code of the authenticator app or a recovery code
+	Code string `json:"code"`
+}
+
+type MFACodeInput struct {
+	Code string `json:"code"`
+}
+
+type StartMFAOutput struct {
+	Secret string `json:"secret"`
+	// otpauth:// URI shown as a QR code to the authenticator app
+	URI string `json:"uri"`
+}
+
+type EnableMFAOutput struct {
+	// Shown only once, each code can be used once instead of a code of the app
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type CreateAPIKeyInput struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// Days until the key expires, the default of the API when zero
+	ExpiresInDays int `json:"expires_in_days,omitempty"`
+}
+
+type CreateAPIKeyOutput struct {
+	ID string `json:"id"`
+	// Shown only once, sent in the X-API-Key header
+	Key       string    `json:"key"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type CreateOAuthClientInput struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type CreateOAuthClientOutput struct {
+	ClientID string `json:"client_id"`
+	// Shown only once
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+}
+
+// OAuthTokenOutput is the access token response of RFC 6749.
+type OAuthTokenOutput struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	// Seconds until the token expires
+	ExpiresIn int    `json:"expires_in"`
+	Scope     string `json:"scope"`
+}
+
+// OAuthErrorOutput is the error response of RFC 6749, also used by the
+// introspection and revocation endpoints.
+type OAuthErrorOutput struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// IntrospectionOutput is the introspection response of RFC 7662. Inactive
+// tokens only have Active.
+type IntrospectionOutput struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+}
+
+type CreateWebhookInput struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	// Generated when empty
+	Secret string `json:"secret,omitempty"`
+}
+
+type CreateWebhookOutput struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+type UpdateWebhookInput struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Active     bool     `json:"active"`
+}
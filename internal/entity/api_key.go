@@ -0,0 +1,116 @@
+package entity
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"goexpert-api/pkg/entity"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidAPIKey        = errors.New("invalid api key")
+	ErrAPIKeyNameTooLong    = errors.New("name is too long")
+	ErrInvalidAPIKeyExpires = errors.New("invalid expiration")
+)
+
+// APIKeyPrefix starts every API key, so leaked keys are easy to spot.
+const APIKeyPrefix = "gxk_"
+
+// APIKeyClaim is the claim with the ID of the API key that authenticated a
+// request, set on the tokens built for the requests made with API keys.
+const APIKeyClaim = "api_key_id"
+
+const maxAPIKeyNameLength = 100
+
+// APIKey is a credential of a user for machine clients, such as CI scripts,
+// limited to Scopes and valid until ExpiresAt or until revoked. Keys have the
+// form gxk_<prefix>_<secret>. Only the prefix, used to look the key up, and
+// the SHA-256 hash of the whole key are stored, so the key is only shown when
+// it is created.
+type APIKey struct {
+	ID         entity.ID  `json:"id"`
+	UserID     string     `json:"-" gorm:"index"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix" gorm:"uniqueIndex"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes" gorm:"serializer:json"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// NewAPIKey creates a key of the user named name, returning it with the key
+// to show to the user.
+func NewAPIKey(userID, name string, scopes []string, expiresAt, now time.Time) (*APIKey, string, error) {
+	scopes, err := NormalizeScopes(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	prefix := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	apiKey := &APIKey{
+		ID:        entity.NewID(),
+		UserID:    userID,
+		Name:      strings.TrimSpace(name),
+		Prefix:    hex.EncodeToString(prefix),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+	key := APIKeyPrefix + apiKey.Prefix + "_" + hex.EncodeToString(secret)
+	apiKey.KeyHash = hashAPIKey(key)
+	if err := apiKey.Validate(now); err != nil {
+		return nil, "", err
+	}
+	return apiKey, key, nil
+}
+
+func (k *APIKey) Validate(now time.Time) error {
+	if k.Name == "" {
+		return ErrNameIsRequired
+	}
+	if len(k.Name) > maxAPIKeyNameLength {
+		return ErrAPIKeyNameTooLong
+	}
+	if !k.ExpiresAt.After(now) {
+		return ErrInvalidAPIKeyExpires
+	}
+	return nil
+}
+
+// ParseAPIKey returns the prefix of key, used to find it.
+func ParseAPIKey(key string) (string, error) {
+	prefix, secret, ok := strings.Cut(strings.TrimPrefix(key, APIKeyPrefix), "_")
+	if !strings.HasPrefix(key, APIKeyPrefix) || !ok || prefix == "" || secret == "" {
+		return "", ErrInvalidAPIKey
+	}
+	return prefix, nil
+}
+
+// Matches tells if key is this key.
+func (k *APIKey) Matches(key string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashAPIKey(key)), []byte(k.KeyHash)) == 1
+}
+
+// Valid tells if the key was not revoked and didn't expire.
+func (k *APIKey) Valid(now time.Time) bool {
+	return k.RevokedAt == nil && now.Before(k.ExpiresAt)
+}
+
+// hashAPIKey returns the hash stored for key. The keys are random, so a plain
+// SHA-256 is enough.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,71 @@
+package entity
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAPIKey(t *testing.T) {
+	now := time.Now()
+
+	apiKey, key, err := NewAPIKey("user-id", " CI ", []string{ScopeProductsWrite, ScopeProductsRead, ScopeProductsWrite}, now.Add(time.Hour), now)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, apiKey.ID)
+	assert.Equal(t, "user-id", apiKey.UserID)
+	assert.Equal(t, "CI", apiKey.Name)
+	assert.Equal(t, []string{ScopeProductsRead, ScopeProductsWrite}, apiKey.Scopes)
+	assert.True(t, strings.HasPrefix(key, APIKeyPrefix+apiKey.Prefix+"_"))
+	assert.NotContains(t, apiKey.KeyHash, key)
+	assert.True(t, apiKey.Matches(key))
+	assert.False(t, apiKey.Matches(key+"0"))
+	assert.True(t, apiKey.Valid(now))
+
+	prefix, err := ParseAPIKey(key)
+	assert.Nil(t, err)
+	assert.Equal(t, apiKey.Prefix, prefix)
+
+	other, otherKey, _ := NewAPIKey("user-id", "CI", []string{ScopeProductsRead}, now.Add(time.Hour), now)
+	assert.NotEqual(t, apiKey.Prefix, other.Prefix)
+	assert.NotEqual(t, key, otherKey)
+	assert.False(t, apiKey.Matches(otherKey))
+}
+
+func TestNewAPIKeyWhenInvalid(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		scopes    []string
+		expiresAt time.Time
+		err       error
+	}{
+		{" ", []string{ScopeProductsRead}, now.Add(time.Hour), ErrNameIsRequired},
+		{strings.Repeat("a", 101), []string{ScopeProductsRead}, now.Add(time.Hour), ErrAPIKeyNameTooLong},
+		{"CI", nil, now.Add(time.Hour), ErrScopesRequired},
+		{"CI", []string{"users:write"}, now.Add(time.Hour), ErrInvalidScope},
+		{"CI", []string{ScopeProductsRead}, now, ErrInvalidAPIKeyExpires},
+	}
+	for _, tt := range tests {
+		_, _, err := NewAPIKey("user-id", tt.name, tt.scopes, tt.expiresAt, now)
+		assert.Equal(t, tt.err, err, tt.name)
+	}
+}
+
+func TestParseAPIKeyWhenInvalid(t *testing.T) {
+	for _, key := range []string{"", "abc", "gxk_", "gxk_abc", "gxk__abc", "gxk_abc_", "xyz_abc_def"} {
+		_, err := ParseAPIKey(key)
+		assert.Equal(t, ErrInvalidAPIKey, err, key)
+	}
+}
+
+func TestAPIKeyValid(t *testing.T) {
+	now := time.Now()
+	apiKey, _, err := NewAPIKey("user-id", "CI", []string{ScopeProductsRead}, now.Add(time.Hour), now)
+	assert.Nil(t, err)
+
+	assert.False(t, apiKey.Valid(now.Add(time.Hour)))
+	apiKey.RevokedAt = &now
+	assert.False(t, apiKey.Valid(now))
+}
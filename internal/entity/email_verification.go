@@ -0,0 +1,38 @@
+package entity
+
+import "time"
+
+const emailVerificationPurpose = "email_verification"
+
+// emailVerificationClaims are the contents of an email verification token.
+type emailVerificationClaims struct {
+	UserID    string `json:"sub"`
+	Email     string `json:"email"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// NewEmailVerificationToken returns a token proving that the user owns its
+// current email, valid until expiresAt. Nothing is stored: the token is signed
+// with HMAC-SHA256 using secret.
+func NewEmailVerificationToken(user *User, secret []byte, expiresAt time.Time) (string, error) {
+	return signToken(emailVerificationPurpose, emailVerificationClaims{
+		UserID:    user.ID.String(),
+		Email:     user.Email,
+		ExpiresAt: expiresAt.Unix(),
+	}, secret)
+}
+
+// ParseEmailVerificationToken returns the ID and email of the user of a token
+// created by NewEmailVerificationToken, or ErrInvalidToken if its signature
+// doesn't match or it expired. The caller must check the user still has the
+// email.
+func ParseEmailVerificationToken(token string, secret []byte, now time.Time) (userID, email string, err error) {
+	var claims emailVerificationClaims
+	if err := parseSignedToken(emailVerificationPurpose, token, secret, &claims); err != nil {
+		return "", "", err
+	}
+	if !now.Before(time.Unix(claims.ExpiresAt, 0)) {
+		return "", "", ErrInvalidToken
+	}
+	return claims.UserID, claims.Email, nil
+}
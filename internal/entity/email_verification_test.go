@@ -0,0 +1,49 @@
+package entity
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailVerificationToken(t *testing.T) {
+	user, _ := NewUser("John Doe", "john@doe.com", "abc123")
+	secret := []byte("secret")
+	now := time.Now()
+
+	token, err := NewEmailVerificationToken(user, secret, now.Add(time.Hour))
+	assert.Nil(t, err)
+
+	userID, email, err := ParseEmailVerificationToken(token, secret, now)
+	assert.Nil(t, err)
+	assert.Equal(t, user.ID.String(), userID)
+	assert.Equal(t, "john@doe.com", email)
+}
+
+func TestEmailVerificationTokenWhenInvalid(t *testing.T) {
+	user, _ := NewUser("John Doe", "john@doe.com", "abc123")
+	secret := []byte("secret")
+	now := time.Now()
+	token, _ := NewEmailVerificationToken(user, secret, now.Add(time.Hour))
+	payload, signature, _ := strings.Cut(token, ".")
+	other, _ := NewEmailVerificationToken(&User{ID: user.ID, Email: "jane@doe.com"}, secret, now.Add(time.Hour))
+	otherPayload, _, _ := strings.Cut(other, ".")
+
+	for name, tc := range map[string]struct {
+		token  string
+		secret string
+		now    time.Time
+	}{
+		"empty":           {"", "secret", now},
+		"no signature":    {payload, "secret", now},
+		"wrong secret":    {token, "other", now},
+		"expired":         {token, "secret", now.Add(time.Hour)},
+		"changed payload": {otherPayload + "." + signature, "secret", now},
+		"bad payload":     {"abc." + tokenSignature(emailVerificationPurpose, "abc", secret), "secret", now},
+	} {
+		_, _, err := ParseEmailVerificationToken(tc.token, []byte(tc.secret), tc.now)
+		assert.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+}
@@ -0,0 +1,52 @@
+package entity
+
+import (
+	"encoding/json"
+	"goexpert-api/pkg/entity"
+	"time"
+)
+
+// Types of the domain events.
+const (
+	EventProductCreated = "product.created"
+	EventProductUpdated = "product.updated"
+	EventProductDeleted = "product.deleted"
+	EventUserRegistered = "user.registered"
+	EventUserUpdated    = "user.updated"
+	EventUserDeleted    = "user.deleted"
+)
+
+// Event records a change of an aggregate (a product or a user) so it can be
+// published to other services.
+type Event struct {
+	ID          entity.ID       `json:"id"`
+	Type        string          `json:"type"`
+	AggregateID string          `json:"aggregate_id"`
+	Payload     json.RawMessage `json:"payload"`
+	OccurredAt  time.Time       `json:"occurred_at"`
+}
+
+// NewEvent creates an event with payload encoded as JSON.
+func NewEvent(eventType, aggregateID string, payload any) (*Event, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Event{
+		ID:          entity.NewID(),
+		Type:        eventType,
+		AggregateID: aggregateID,
+		Payload:     data,
+		OccurredAt:  time.Now(),
+	}, nil
+}
+
+// ProductDeletedPayload is the payload of EventProductDeleted.
+type ProductDeletedPayload struct {
+	ID entity.ID `json:"id"`
+}
+
+// UserDeletedPayload is the payload of EventUserDeleted.
+type UserDeletedPayload struct {
+	ID entity.ID `json:"id"`
+}
@@ -0,0 +1,29 @@
+package entity
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewEvent(t *testing.T) {
+	user, _ := NewUser("John Doe", "john@doe.com", "abc123")
+	e, err := NewEvent(EventUserRegistered, user.ID.String(), user)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, e.ID)
+	assert.Equal(t, EventUserRegistered, e.Type)
+	assert.Equal(t, user.ID.String(), e.AggregateID)
+	assert.NotEmpty(t, e.OccurredAt)
+
+	var payload map[string]any
+	assert.Nil(t, json.Unmarshal(e.Payload, &payload))
+	assert.Equal(t, "john@doe.com", payload["email"])
+	assert.NotContains(t, payload, "password")
+}
+
+func TestNewEventWhenPayloadIsInvalid(t *testing.T) {
+	e, err := NewEvent(EventProductCreated, "id", make(chan int))
+	assert.Nil(t, e)
+	assert.NotNil(t, err)
+}
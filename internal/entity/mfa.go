@@ -0,0 +1,186 @@
+package entity
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"goexpert-api/pkg/totp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication already enabled")
+	ErrMFANotStarted     = errors.New("two-factor authentication enrollment not started")
+	ErrInvalidMFACode    = errors.New("invalid two-factor authentication code")
+)
+
+const (
+	// RecoveryCodeCount is the number of recovery codes of a user
+	RecoveryCodeCount = 10
+	// mfaSkew is the number of time steps accepted before and after the
+	// current one, for clocks that drift
+	mfaSkew             = 1
+	mfaChallengePurpose = "mfa_challenge"
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// MFAFields are the fields of User of the two-factor authentication.
+var MFAFields = []string{"MFASecret", "MFAEnabledAt", "MFARecoveryCodes", "MFALastStep"}
+
+// MFACode is the second factor used in a verification: the time step of a
+// TOTP code, or the hash of a recovery code.
+type MFACode struct {
+	Step             int64
+	RecoveryCodeHash string
+}
+
+// StartMFA generates the TOTP secret of the user, to be added to an
+// authenticator app. The enrollment ends with EnableMFA.
+func (u *User) StartMFA() (string, error) {
+	if u.MFAEnabled() {
+		return "", ErrMFAAlreadyEnabled
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return "", err
+	}
+	u.MFASecret = secret
+	return secret, nil
+}
+
+// EnableMFA ends the enrollment with a code of the authenticator app, proving
+// it has the secret, and returns the recovery codes of the user. Only their
+// hashes are kept.
+func (u *User) EnableMFA(code string, now time.Time) ([]string, error) {
+	if u.MFAEnabled() {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if u.MFASecret == "" {
+		return nil, ErrMFANotStarted
+	}
+	if !u.useTOTP(code, now) {
+		return nil, ErrInvalidMFACode
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	u.MFAEnabledAt = &now
+	u.MFARecoveryCodes = hashes
+	return codes, nil
+}
+
+func (u *User) DisableMFA() {
+	u.MFASecret = ""
+	u.MFAEnabledAt = nil
+	u.MFARecoveryCodes = nil
+	u.MFALastStep = 0
+}
+
+func (u *User) MFAEnabled() bool {
+	return u.MFAEnabledAt != nil
+}
+
+// VerifyMFA tells if code is a valid TOTP code or an unused recovery code of
+// the user, and returns the one used. Both can only be used once, so it must
+// be saved with the repository, which refuses it if a concurrent request
+// used it first.
+func (u *User) VerifyMFA(code string, now time.Time) (MFACode, bool) {
+	if !u.MFAEnabled() {
+		return MFACode{}, false
+	}
+	code = strings.TrimSpace(code)
+	if u.useTOTP(code, now) {
+		return MFACode{Step: u.MFALastStep}, true
+	}
+	hash := hashRecoveryCode(code)
+	if u.useRecoveryCode(hash) {
+		return MFACode{RecoveryCodeHash: hash}, true
+	}
+	return MFACode{}, false
+}
+
+// useTOTP validates a TOTP code, refusing the codes of the time steps already
+// used.
+func (u *User) useTOTP(code string, now time.Time) bool {
+	step, ok := totp.Validate(u.MFASecret, code, now, mfaSkew)
+	if !ok || step <= u.MFALastStep {
+		return false
+	}
+	u.MFALastStep = step
+	return true
+}
+
+func (u *User) useRecoveryCode(hash string) bool {
+	for i, stored := range u.MFARecoveryCodes {
+		if stored == hash {
+			// A new slice, as copies of the user may share the old one
+			remaining := make([]string, 0, len(u.MFARecoveryCodes)-1)
+			remaining = append(remaining, u.MFARecoveryCodes[:i]...)
+			u.MFARecoveryCodes = append(remaining, u.MFARecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// newRecoveryCodes returns RecoveryCodeCount random codes, such as
+// abcd-efgh-ijkl-mnop, and their hashes.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for range RecoveryCodeCount {
+		data := make([]byte, 10)
+		if _, err := rand.Read(data); err != nil {
+			return nil, nil, err
+		}
+		encoded := strings.ToLower(recoveryCodeEncoding.EncodeToString(data))
+		code := encoded[0:4] + "-" + encoded[4:8] + "-" + encoded[8:12] + "-" + encoded[12:16]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode returns the hash stored for code, ignoring its case and
+// dashes. The codes are random, so a plain SHA-256 is enough.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// mfaChallengeClaims are the contents of an MFA challenge token.
+type mfaChallengeClaims struct {
+	UserID       string `json:"sub"`
+	TokenVersion int    `json:"ver"`
+	ExpiresAt    int64  `json:"exp"`
+}
+
+// NewMFAChallengeToken returns the token of a user who logged in with the
+// password, exchanged with a code of the second factor for an access token
+// until expiresAt. It is signed with HMAC-SHA256 using secret, and is revoked
+// with the TokenVersion of the user.
+func NewMFAChallengeToken(user *User, secret []byte, expiresAt time.Time) (string, error) {
+	return signToken(mfaChallengePurpose, mfaChallengeClaims{
+		UserID:       user.ID.String(),
+		TokenVersion: user.TokenVersion,
+		ExpiresAt:    expiresAt.Unix(),
+	}, secret)
+}
+
+// ParseMFAChallengeToken returns the ID and TokenVersion of the user of a
+// token created by NewMFAChallengeToken, or ErrInvalidToken if its signature
+// doesn't match or it expired.
+func ParseMFAChallengeToken(token string, secret []byte, now time.Time) (userID string, tokenVersion int, err error) {
+	var claims mfaChallengeClaims
+	if err := parseSignedToken(mfaChallengePurpose, token, secret, &claims); err != nil {
+		return "", 0, err
+	}
+	if !now.Before(time.Unix(claims.ExpiresAt, 0)) {
+		return "", 0, ErrInvalidToken
+	}
+	return claims.UserID, claims.TokenVersion, nil
+}
@@ -0,0 +1,106 @@
+package entity
+
+import (
+	"goexpert-api/pkg/totp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserEnableMFA(t *testing.T) {
+	user, _ := NewUser("John Doe", "john@doe.com", "abc123")
+	now := time.Now()
+	assert.False(t, user.MFAEnabled())
+
+	_, err := user.EnableMFA("123456", now)
+	assert.Equal(t, ErrMFANotStarted, err)
+
+	secret, err := user.StartMFA()
+	assert.Nil(t, err)
+	assert.Equal(t, secret, user.MFASecret)
+	assert.False(t, user.MFAEnabled())
+
+	_, err = user.EnableMFA("000000", now.Add(-time.Hour))
+	assert.Equal(t, ErrInvalidMFACode, err)
+	code, _ := totp.Code(secret, totp.Step(now))
+	codes, err := user.EnableMFA(code, now)
+	assert.Nil(t, err)
+	assert.True(t, user.MFAEnabled())
+	assert.Len(t, codes, RecoveryCodeCount)
+	assert.Len(t, user.MFARecoveryCodes, RecoveryCodeCount)
+	assert.NotContains(t, user.MFARecoveryCodes, codes[0])
+	assert.Regexp(t, `^[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}$`, codes[0])
+
+	_, err = user.StartMFA()
+	assert.Equal(t, ErrMFAAlreadyEnabled, err)
+	_, err = user.EnableMFA(code, now)
+	assert.Equal(t, ErrMFAAlreadyEnabled, err)
+
+	user.DisableMFA()
+	assert.False(t, user.MFAEnabled())
+	assert.Empty(t, user.MFASecret)
+	assert.Empty(t, user.MFARecoveryCodes)
+}
+
+func TestUserVerifyMFA(t *testing.T) {
+	user, _ := NewUser("John Doe", "john@doe.com", "abc123")
+	now := time.Now()
+	secret, _ := user.StartMFA()
+	code, _ := totp.Code(secret, totp.Step(now)-1)
+	_, ok := user.VerifyMFA(code, now)
+	assert.False(t, ok)
+	codes, _ := user.EnableMFA(code, now)
+
+	// Codes are used only once, and the codes before them are refused
+	_, ok = user.VerifyMFA(code, now)
+	assert.False(t, ok)
+	code, _ = totp.Code(secret, totp.Step(now))
+	used, ok := user.VerifyMFA(code, now)
+	assert.True(t, ok)
+	assert.Equal(t, MFACode{Step: totp.Step(now)}, used)
+	_, ok = user.VerifyMFA(code, now)
+	assert.False(t, ok)
+	code, _ = totp.Code(secret, totp.Step(now)+1)
+	_, ok = user.VerifyMFA(code, now)
+	assert.True(t, ok)
+
+	// Recovery codes, ignoring case and dashes
+	recoveryCodes := user.MFARecoveryCodes
+	used, ok = user.VerifyMFA(" "+strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))+" ", now)
+	assert.True(t, ok)
+	assert.Equal(t, MFACode{RecoveryCodeHash: recoveryCodes[0]}, used)
+	assert.Len(t, user.MFARecoveryCodes, RecoveryCodeCount-1)
+	assert.Len(t, recoveryCodes, RecoveryCodeCount)
+	_, ok = user.VerifyMFA(codes[0], now)
+	assert.False(t, ok)
+	_, ok = user.VerifyMFA(codes[RecoveryCodeCount-1], now)
+	assert.True(t, ok)
+	_, ok = user.VerifyMFA("abcd-efgh-ijkl-mnop", now)
+	assert.False(t, ok)
+}
+
+func TestMFAChallengeToken(t *testing.T) {
+	user, _ := NewUser("John Doe", "john@doe.com", "abc123")
+	user.TokenVersion = 3
+	secret := []byte("secret")
+	now := time.Now()
+
+	token, err := NewMFAChallengeToken(user, secret, now.Add(time.Minute))
+	assert.Nil(t, err)
+	userID, tokenVersion, err := ParseMFAChallengeToken(token, secret, now)
+	assert.Nil(t, err)
+	assert.Equal(t, user.ID.String(), userID)
+	assert.Equal(t, 3, tokenVersion)
+
+	_, _, err = ParseMFAChallengeToken(token, secret, now.Add(time.Minute))
+	assert.Equal(t, ErrInvalidToken, err)
+	_, _, err = ParseMFAChallengeToken(token, []byte("other"), now)
+	assert.Equal(t, ErrInvalidToken, err)
+
+	// Tokens of other purposes are refused
+	verification, _ := NewEmailVerificationToken(user, secret, now.Add(time.Minute))
+	_, _, err = ParseMFAChallengeToken(verification, secret, now)
+	assert.Equal(t, ErrInvalidToken, err)
+}
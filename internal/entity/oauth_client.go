@@ -0,0 +1,99 @@
+package entity
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"goexpert-api/pkg/entity"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrClientNameTooLong = errors.New("name is too long")
+
+// ClientIDClaim is the JWT claim with the ID of the OAuth client a token was
+// issued to. Tokens without it were issued to users.
+const ClientIDClaim = "client_id"
+
+const maxClientNameLength = 100
+
+// OAuthClient is a service that gets access tokens with the OAuth 2.0 client
+// credentials grant, authenticated by its ID and secret, limited to Scopes.
+// Only the SHA-256 hash of the secret is stored, so the secret is only shown
+// when the client is created.
+type OAuthClient struct {
+	ID         entity.ID `json:"client_id"`
+	Name       string    `json:"name"`
+	SecretHash string    `json:"-"`
+	Scopes     []string  `json:"scopes" gorm:"serializer:json"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// NewOAuthClient creates a client allowed to get tokens with scopes,
+// returning it with its secret.
+func NewOAuthClient(name string, scopes []string) (*OAuthClient, string, error) {
+	scopes, err := NormalizeScopes(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", ErrNameIsRequired
+	}
+	if len(name) > maxClientNameLength {
+		return nil, "", ErrClientNameTooLong
+	}
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return nil, "", err
+	}
+	secret := "gxs_" + hex.EncodeToString(data)
+	return &OAuthClient{
+		ID:         entity.NewID(),
+		Name:       name,
+		SecretHash: hashClientSecret(secret),
+		Scopes:     scopes,
+		CreatedAt:  time.Now(),
+	}, secret, nil
+}
+
+// Authenticate tells if secret is the secret of the client.
+func (c *OAuthClient) Authenticate(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashClientSecret(secret)), []byte(c.SecretHash)) == 1
+}
+
+// GrantScopes returns the scopes of a token requested with scopes, all the
+// scopes of the client when none are requested. Scopes the client is not
+// allowed are refused with ErrInvalidScope.
+func (c *OAuthClient) GrantScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return slices.Clone(c.Scopes), nil
+	}
+	scopes, err := NormalizeScopes(scopes)
+	if err != nil {
+		return nil, err
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(c.Scopes, scope) {
+			return nil, ErrInvalidScope
+		}
+	}
+	return scopes, nil
+}
+
+// hashClientSecret returns the hash stored for secret. The secrets are
+// random, so a plain SHA-256 is enough.
+func hashClientSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// RevokedToken is an access token revoked before it expired, refused until
+// ExpiresAt. Tokens are identified by their jti claim.
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey"`
+	ExpiresAt time.Time `gorm:"index"`
+}
@@ -0,0 +1,59 @@
+package entity
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewOAuthClient(t *testing.T) {
+	client, secret, err := NewOAuthClient(" billing ", []string{ScopeProductsWrite, ScopeProductsRead})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, client.ID)
+	assert.Equal(t, "billing", client.Name)
+	assert.Equal(t, []string{ScopeProductsRead, ScopeProductsWrite}, client.Scopes)
+	assert.True(t, strings.HasPrefix(secret, "gxs_"))
+	assert.NotContains(t, client.SecretHash, secret)
+	assert.True(t, client.Authenticate(secret))
+	assert.False(t, client.Authenticate(secret+"0"))
+	assert.False(t, client.Authenticate(""))
+
+	other, otherSecret, _ := NewOAuthClient("billing", []string{ScopeProductsRead})
+	assert.NotEqual(t, client.ID, other.ID)
+	assert.NotEqual(t, secret, otherSecret)
+	assert.False(t, client.Authenticate(otherSecret))
+}
+
+func TestNewOAuthClientWhenInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		err    error
+	}{
+		{" ", []string{ScopeProductsRead}, ErrNameIsRequired},
+		{strings.Repeat("a", 101), []string{ScopeProductsRead}, ErrClientNameTooLong},
+		{"billing", nil, ErrScopesRequired},
+		{"billing", []string{"users:write"}, ErrInvalidScope},
+	}
+	for _, tt := range tests {
+		_, _, err := NewOAuthClient(tt.name, tt.scopes)
+		assert.Equal(t, tt.err, err, tt.name)
+	}
+}
+
+func TestOAuthClientGrantScopes(t *testing.T) {
+	client, _, _ := NewOAuthClient("billing", []string{ScopeProductsRead})
+
+	scopes, err := client.GrantScopes(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{ScopeProductsRead}, scopes)
+	scopes, err = client.GrantScopes([]string{ScopeProductsRead, ScopeProductsRead})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{ScopeProductsRead}, scopes)
+
+	_, err = client.GrantScopes([]string{ScopeProductsWrite})
+	assert.Equal(t, ErrInvalidScope, err)
+	_, err = client.GrantScopes([]string{"users:write"})
+	assert.Equal(t, ErrInvalidScope, err)
+}
@@ -0,0 +1,87 @@
+package entity
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrPasswordTooShort = errors.New("password is too short")
+	ErrPasswordTooLong  = errors.New("password is too long")
+	ErrPasswordTooWeak  = errors.New("password is too weak")
+	ErrPasswordBreached = errors.New("password is known to be breached")
+)
+
+// maxPasswordBytes is the longest password bcrypt can hash.
+const maxPasswordBytes = 72
+
+// PasswordPolicy is the set of rules a new password must follow. Zero values
+// disable a rule, except for the bcrypt length limit, which always applies.
+type PasswordPolicy struct {
+	// Minimum number of characters
+	MinLength int
+	// Minimum number of character classes used: lowercase and uppercase
+	// letters, digits and symbols
+	MinClasses int
+	// Breached passwords, lowercased, refused whatever their case
+	Breached map[string]struct{}
+}
+
+func (p *PasswordPolicy) Validate(password string) error {
+	if password == "" {
+		return ErrPasswordIsRequired
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("%w: at most %d bytes", ErrPasswordTooLong, maxPasswordBytes)
+	}
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("%w: at least %d characters", ErrPasswordTooShort, p.MinLength)
+	}
+	if passwordClasses(password) < p.MinClasses {
+		return fmt.Errorf("%w: use at least %d of lowercase, uppercase, digits and symbols", ErrPasswordTooWeak, p.MinClasses)
+	}
+	if _, ok := p.Breached[strings.ToLower(password)]; ok {
+		return ErrPasswordBreached
+	}
+	return nil
+}
+
+func passwordClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// ReadBreachedPasswords reads a list of breached passwords, one per line.
+// Empty lines and lines starting with # are ignored.
+func ReadBreachedPasswords(r io.Reader) (map[string]struct{}, error) {
+	breached := make(map[string]struct{})
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		breached[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return breached, nil
+}
@@ -0,0 +1,56 @@
+package entity
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"goexpert-api/pkg/entity"
+	"time"
+)
+
+// PasswordReset lets a user who forgot the password set a new one. Only the
+// SHA-256 hash of the token sent by email is stored, and the token is valid
+// once, until ExpiresAt, and while the password of the user is not changed.
+type PasswordReset struct {
+	ID        entity.ID `json:"id"`
+	UserID    string    `json:"user_id" gorm:"index"`
+	TokenHash string    `json:"-" gorm:"uniqueIndex"`
+	// TokenVersion of the user when the reset was requested
+	TokenVersion int        `json:"-"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	UsedAt       *time.Time `json:"used_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// NewPasswordReset creates a reset of the password of user valid for ttl,
+// returning it with the token to send to the user.
+func NewPasswordReset(user *User, ttl time.Duration, now time.Time) (*PasswordReset, string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return nil, "", err
+	}
+	token := hex.EncodeToString(data)
+	return &PasswordReset{
+		ID:           entity.NewID(),
+		UserID:       user.ID.String(),
+		TokenHash:    HashPasswordResetToken(token),
+		TokenVersion: user.TokenVersion,
+		ExpiresAt:    now.Add(ttl),
+		CreatedAt:    now,
+	}, token, nil
+}
+
+// HashPasswordResetToken returns the hash stored for token. The tokens are
+// random, so a plain SHA-256 is enough to look them up.
+func HashPasswordResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Valid tells if the reset can still change the password of user.
+func (p *PasswordReset) Valid(user *User, now time.Time) bool {
+	return p.UsedAt == nil &&
+		now.Before(p.ExpiresAt) &&
+		p.UserID == user.ID.String() &&
+		p.TokenVersion == user.TokenVersion
+}
@@ -0,0 +1,44 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPasswordReset(t *testing.T) {
+	user, _ := NewUser("John Doe", "john@doe.com", "abc123")
+	now := time.Now()
+
+	reset, token, err := NewPasswordReset(user, time.Hour, now)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, reset.ID)
+	assert.Equal(t, user.ID.String(), reset.UserID)
+	assert.Len(t, token, 64)
+	assert.NotEqual(t, token, reset.TokenHash)
+	assert.Equal(t, HashPasswordResetToken(token), reset.TokenHash)
+	assert.Equal(t, now.Add(time.Hour), reset.ExpiresAt)
+	assert.True(t, reset.Valid(user, now))
+
+	_, other, _ := NewPasswordReset(user, time.Hour, now)
+	assert.NotEqual(t, token, other)
+}
+
+func TestPasswordResetValid(t *testing.T) {
+	user, _ := NewUser("John Doe", "john@doe.com", "abc123")
+	other, _ := NewUser("Jane Doe", "jane@doe.com", "abc123")
+	now := time.Now()
+
+	reset, _, _ := NewPasswordReset(user, time.Hour, now)
+	assert.False(t, reset.Valid(user, now.Add(time.Hour)))
+	assert.False(t, reset.Valid(other, now))
+
+	reset.UsedAt = &now
+	assert.False(t, reset.Valid(user, now))
+
+	// Changing the password invalidates the resets requested before
+	reset, _, _ = NewPasswordReset(user, time.Hour, now)
+	assert.Nil(t, user.ChangePassword("def456"))
+	assert.False(t, reset.Valid(user, now))
+}
@@ -0,0 +1,46 @@
+package entity
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := &PasswordPolicy{
+		MinLength:  8,
+		MinClasses: 3,
+		Breached:   map[string]struct{}{"password1!": {}},
+	}
+	assert.Nil(t, policy.Validate("Tr0ub4dor"))
+	assert.Nil(t, policy.Validate("correct horse 1"))
+	assert.Nil(t, policy.Validate("sEnhaçãõ1"))
+
+	tests := map[string]error{
+		"":                      ErrPasswordIsRequired,
+		"Ab1!":                  ErrPasswordTooShort,
+		"abcdefghij":            ErrPasswordTooWeak,
+		"abcdefgh12":            ErrPasswordTooWeak,
+		"Password1!":            ErrPasswordBreached,
+		strings.Repeat("a", 73): ErrPasswordTooLong,
+	}
+	for password, want := range tests {
+		assert.ErrorIs(t, policy.Validate(password), want, password)
+	}
+}
+
+func TestZeroPasswordPolicy(t *testing.T) {
+	policy := &PasswordPolicy{}
+	assert.Nil(t, policy.Validate("1"))
+	assert.ErrorIs(t, policy.Validate(""), ErrPasswordIsRequired)
+	assert.ErrorIs(t, policy.Validate(strings.Repeat("a", 73)), ErrPasswordTooLong)
+}
+
+func TestReadBreachedPasswords(t *testing.T) {
+	breached, err := ReadBreachedPasswords(strings.NewReader("# common passwords\n123456\n\n  Password \nqwerty\n"))
+	assert.Nil(t, err)
+	assert.Len(t, breached, 3)
+	assert.Contains(t, breached, "password")
+	assert.Contains(t, breached, "123456")
+}
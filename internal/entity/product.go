@@ -15,10 +15,10 @@ var (
 )
 
 type Product struct {
-	ID        entity.ID `json:"id"`
-	Name      string    `json:"name"`
-	Price     float64   `json:"price"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        entity.ID `json:"id" xml:"id"`
+	Name      string    `json:"name" xml:"name"`
+	Price     float64   `json:"price" xml:"price"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
 }
 
 func NewProduct(name string, price float64) (*Product, error) {
@@ -0,0 +1,45 @@
+package entity
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrScopesRequired = errors.New("scopes are required")
+	ErrInvalidScope   = errors.New("invalid scope")
+)
+
+// Scopes limit what the credentials of machine clients can do. The tokens of
+// users logged in with the password have no scopes and can do everything.
+const (
+	ScopeProductsRead  = "products:read"
+	ScopeProductsWrite = "products:write"
+)
+
+var Scopes = []string{ScopeProductsRead, ScopeProductsWrite}
+
+// ScopeClaim is the JWT claim with the scopes of the token, separated by
+// spaces. Tokens without it are not limited by scopes.
+const ScopeClaim = "scope"
+
+// NormalizeScopes validates scopes, returning them sorted and without
+// repetitions.
+func NormalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, ErrScopesRequired
+	}
+	normalized := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if !slices.Contains(Scopes, scope) {
+			return nil, ErrInvalidScope
+		}
+		if !slices.Contains(normalized, scope) {
+			normalized = append(normalized, scope)
+		}
+	}
+	slices.Sort(normalized)
+	return normalized, nil
+}
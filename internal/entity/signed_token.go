@@ -0,0 +1,48 @@
+package entity
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// signToken returns claims encoded as JSON and signed with HMAC-SHA256 using
+// secret. The purpose is signed too, so a token is only accepted by
+// parseSignedToken for the same purpose.
+func signToken(purpose string, claims any, secret []byte) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + tokenSignature(purpose, encoded, secret), nil
+}
+
+// parseSignedToken decodes into claims a token returned by signToken for
+// purpose, or returns ErrInvalidToken. The claims must be checked by the
+// caller, e.g. their expiration.
+func parseSignedToken(purpose, token string, secret []byte, claims any) error {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(tokenSignature(purpose, encoded, secret))) {
+		return ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func tokenSignature(purpose, payload string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose + "." + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -1,32 +1,198 @@
 package entity
 
 import (
+	"errors"
 	"goexpert-api/pkg/entity"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	ErrEmailIsRequired    = errors.New("email is required")
+	ErrInvalidEmail       = errors.New("invalid email")
+	ErrPasswordIsRequired = errors.New("password is required")
+	ErrInvalidRole        = errors.New("invalid role")
+)
+
+// Roles of the users. Admins manage the other users.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+var Roles = []string{RoleUser, RoleAdmin}
+
+// maxEmailLength is the longest address allowed by RFC 5321.
+const maxEmailLength = 254
+
+// TokenVersionClaim is the JWT claim with the TokenVersion of the user the
+// token was issued to. Tokens without it are of version 0.
+const TokenVersionClaim = "ver"
+
 type User struct {
 	ID       entity.ID `json:"id"`
 	Name     string    `json:"name"`
-	Email    string    `json:"email" gorm:"unique;not null"`
+	Email    string    `json:"email" gorm:"not null;uniqueIndex:idx_users_email,expression:(lower(email))"`
 	Password string    `json:"-"`
+	Role     string    `json:"role" gorm:"not null;default:user"`
+	// Disabled users can't log in and their tokens are refused
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	// Set when the user opens the link sent to the email, nil while pending
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	// Set by an admin, the user must change the password before using the API
+	PasswordResetRequired bool `json:"password_reset_required" gorm:"not null;default:false"`
+	// Incremented to revoke the tokens issued before
+	TokenVersion int `json:"-" gorm:"not null;default:0"`
+	// Secret of the TOTP codes, set when the enrollment starts
+	MFASecret string `json:"-"`
+	// Set when the enrollment is confirmed with a code
+	MFAEnabledAt *time.Time `json:"mfa_enabled_at,omitempty"`
+	// Hashes of the unused recovery codes
+	MFARecoveryCodes []string `json:"-" gorm:"serializer:json"`
+	// Time step of the last TOTP code used, so codes are only used once
+	MFALastStep int64 `json:"-" gorm:"not null;default:0"`
 }
 
+// NewUser creates a user with a bcrypt hash of password, pending the
+// verification of the email. The name is trimmed and the email normalized
+// with NormalizeEmail. The password policy is not checked here, see
+// PasswordPolicy.
 func NewUser(name, email, password string) (*User, error) {
+	user := &User{
+		ID:    entity.NewID(),
+		Name:  strings.TrimSpace(name),
+		Email: NormalizeEmail(email),
+		Role:  RoleUser,
+	}
+	if err := user.Validate(); err != nil {
+		return nil, err
+	}
+	if err := user.setPassword(password); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ChangePassword replaces the password of the user and revokes the tokens
+// issued before. The password policy is not checked here.
+func (u *User) ChangePassword(password string) error {
+	if err := u.setPassword(password); err != nil {
+		return err
+	}
+	u.PasswordResetRequired = false
+	u.TokenVersion++
+	return nil
+}
+
+// RequirePasswordReset revokes the tokens of the user, who must change the
+// password before using the API again.
+func (u *User) RequirePasswordReset() {
+	u.PasswordResetRequired = true
+	u.TokenVersion++
+}
+
+// ChangeEmail replaces the email of the user, normalized with NormalizeEmail.
+// A different email must be verified again.
+func (u *User) ChangeEmail(email string) {
+	email = NormalizeEmail(email)
+	if email != u.Email {
+		u.Email = email
+		u.EmailVerifiedAt = nil
+	}
+}
+
+func (u *User) VerifyEmail(now time.Time) {
+	if u.EmailVerifiedAt == nil {
+		u.EmailVerifiedAt = &now
+	}
+}
+
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// Disable blocks the user from logging in and using its tokens.
+func (u *User) Disable(now time.Time) {
+	if u.DisabledAt == nil {
+		u.DisabledAt = &now
+	}
+}
+
+func (u *User) Enable() {
+	u.DisabledAt = nil
+}
+
+func (u *User) Disabled() bool {
+	return u.DisabledAt != nil
+}
+
+func (u *User) SetRole(role string) error {
+	if !slices.Contains(Roles, role) {
+		return ErrInvalidRole
+	}
+	u.Role = role
+	return nil
+}
+
+func (u *User) setPassword(password string) error {
+	if password == "" {
+		return ErrPasswordIsRequired
+	}
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	u.Password = string(hash)
+	return nil
+}
+
+func (u *User) Validate() error {
+	if u.ID.String() == "" {
+		return ErrIDIsRequired
+	}
+	if _, err := entity.ParseID(u.ID.String()); err != nil {
+		return ErrInvalidID
+	}
+	if u.Name == "" {
+		return ErrNameIsRequired
+	}
+	if u.Email == "" {
+		return ErrEmailIsRequired
+	}
+	if !validEmail(u.Email) {
+		return ErrInvalidEmail
+	}
+	if !slices.Contains(Roles, u.Role) {
+		return ErrInvalidRole
 	}
-	return &User{
-		ID:       entity.NewID(),
-		Name:     name,
-		Email:    email,
-		Password: string(hash),
-	}, nil
+	return nil
 }
 
 func (u *User) ValidatePassword(password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
 	return err == nil
 }
+
+// NormalizeEmail trims and lowercases an email, so the same address is always
+// stored and looked up the same way.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// validEmail accepts a bare address, such as john@doe.com, with a dot in the
+// domain. Display names (John <john@doe.com>) are refused.
+func validEmail(email string) bool {
+	if len(email) > maxEmailLength {
+		return false
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		return false
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	return strings.Contains(domain, ".") && !strings.HasPrefix(domain, ".") && !strings.HasSuffix(domain, ".")
+}
@@ -2,6 +2,7 @@ package entity
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -45,3 +46,116 @@ func TestUserValidatePassword(t *testing.T) {
 		"Password is not stored as plaintext",
 	)
 }
+
+func TestNewUserNormalizesEmail(t *testing.T) {
+	user, err := NewUser("  John Doe ", " John@Doe.COM ", "abc123")
+	assert.Nil(t, err)
+	assert.Equal(t, "John Doe", user.Name)
+	assert.Equal(t, "john@doe.com", user.Email)
+}
+
+func TestNewUserWhenInvalid(t *testing.T) {
+	tests := []struct {
+		name, email, password string
+		err                   error
+	}{
+		{"", "john@doe.com", "abc123", ErrNameIsRequired},
+		{"   ", "john@doe.com", "abc123", ErrNameIsRequired},
+		{"John Doe", "", "abc123", ErrEmailIsRequired},
+		{"John Doe", "john", "abc123", ErrInvalidEmail},
+		{"John Doe", "john@doe", "abc123", ErrInvalidEmail},
+		{"John Doe", "john@@doe.com", "abc123", ErrInvalidEmail},
+		{"John Doe", "John <john@doe.com>", "abc123", ErrInvalidEmail},
+		{"John Doe", "john@doe.com", "", ErrPasswordIsRequired},
+	}
+	for _, tt := range tests {
+		user, err := NewUser(tt.name, tt.email, tt.password)
+		assert.ErrorIs(t, err, tt.err, tt.email)
+		assert.Nil(t, user)
+	}
+}
+
+func TestUserValidate(t *testing.T) {
+	user, err := NewUser("John Doe", "john@doe.com", "abc123")
+	assert.Nil(t, err)
+	assert.Nil(t, user.Validate())
+
+	user.Email = "john.doe"
+	assert.ErrorIs(t, user.Validate(), ErrInvalidEmail)
+}
+
+func TestUserChangePassword(t *testing.T) {
+	user, err := NewUser("John Doe", "john@doe.com", "abc123")
+	assert.Nil(t, err)
+
+	assert.Nil(t, user.ChangePassword("def456"))
+	assert.True(t, user.ValidatePassword("def456"))
+	assert.False(t, user.ValidatePassword("abc123"))
+	assert.Equal(t, 1, user.TokenVersion)
+
+	assert.ErrorIs(t, user.ChangePassword(""), ErrPasswordIsRequired)
+	assert.True(t, user.ValidatePassword("def456"))
+	assert.Equal(t, 1, user.TokenVersion)
+}
+
+func TestUserRoles(t *testing.T) {
+	user, err := NewUser("John Doe", "john@doe.com", "abc123")
+	assert.Nil(t, err)
+	assert.Equal(t, RoleUser, user.Role)
+
+	assert.Nil(t, user.SetRole(RoleAdmin))
+	assert.Equal(t, RoleAdmin, user.Role)
+	assert.ErrorIs(t, user.SetRole("root"), ErrInvalidRole)
+	assert.Equal(t, RoleAdmin, user.Role)
+
+	user.Role = ""
+	assert.ErrorIs(t, user.Validate(), ErrInvalidRole)
+}
+
+func TestUserDisable(t *testing.T) {
+	user, err := NewUser("John Doe", "john@doe.com", "abc123")
+	assert.Nil(t, err)
+	assert.False(t, user.Disabled())
+
+	now := time.Now()
+	user.Disable(now)
+	assert.True(t, user.Disabled())
+	// Disabling again keeps the first date
+	user.Disable(now.Add(time.Hour))
+	assert.Equal(t, now, *user.DisabledAt)
+
+	user.Enable()
+	assert.False(t, user.Disabled())
+}
+
+func TestUserRequirePasswordReset(t *testing.T) {
+	user, err := NewUser("John Doe", "john@doe.com", "abc123")
+	assert.Nil(t, err)
+
+	user.RequirePasswordReset()
+	assert.True(t, user.PasswordResetRequired)
+	assert.Equal(t, 1, user.TokenVersion)
+
+	assert.Nil(t, user.ChangePassword("def456"))
+	assert.False(t, user.PasswordResetRequired)
+	assert.Equal(t, 2, user.TokenVersion)
+}
+
+func TestUserVerifyEmail(t *testing.T) {
+	user, err := NewUser("John Doe", "john@doe.com", "abc123")
+	assert.Nil(t, err)
+	assert.False(t, user.EmailVerified())
+
+	now := time.Now()
+	user.VerifyEmail(now)
+	assert.True(t, user.EmailVerified())
+	user.VerifyEmail(now.Add(time.Hour))
+	assert.Equal(t, now, *user.EmailVerifiedAt)
+
+	// Only a different email must be verified again
+	user.ChangeEmail(" John@Doe.com ")
+	assert.True(t, user.EmailVerified())
+	user.ChangeEmail("Jane@Doe.com")
+	assert.Equal(t, "jane@doe.com", user.Email)
+	assert.False(t, user.EmailVerified())
+}
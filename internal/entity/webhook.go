@@ -0,0 +1,207 @@
+package entity
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"goexpert-api/pkg/entity"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidURL         = errors.New("invalid url")
+	ErrURLNotAllowed      = errors.New("url host is not allowed")
+	ErrEventTypesRequired = errors.New("event types are required")
+	ErrInvalidEventType   = errors.New("invalid event type")
+)
+
+// EventTypes are the event types webhooks can subscribe to.
+var EventTypes = []string{
+	EventProductCreated,
+	EventProductUpdated,
+	EventProductDeleted,
+	EventUserRegistered,
+	EventUserUpdated,
+	EventUserDeleted,
+}
+
+// AdminEventTypes are the event types only admins can subscribe to, as their
+// payloads have the data of every user.
+var AdminEventTypes = []string{
+	EventUserRegistered,
+	EventUserUpdated,
+	EventUserDeleted,
+}
+
+// Webhook is a subscription of a user to receive events by HTTP. Payloads are
+// signed with Secret, which is only shown when the webhook is created.
+type Webhook struct {
+	ID         entity.ID  `json:"id"`
+	OwnerID    string     `json:"-" gorm:"index"`
+	URL        string     `json:"url"`
+	EventTypes []string   `json:"event_types" gorm:"serializer:json"`
+	Secret     string     `json:"-"`
+	Active     bool       `json:"active"`
+	Failures   int        `json:"failures"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// NewWebhook creates an active webhook, generating a secret if none is given.
+func NewWebhook(ownerID, url string, eventTypes []string, secret string) (*Webhook, error) {
+	if secret == "" {
+		var err error
+		secret, err = NewWebhookSecret()
+		if err != nil {
+			return nil, err
+		}
+	}
+	webhook := &Webhook{
+		ID:         entity.NewID(),
+		OwnerID:    ownerID,
+		URL:        url,
+		EventTypes: eventTypes,
+		Secret:     secret,
+		Active:     true,
+		CreatedAt:  time.Now(),
+	}
+	if err := webhook.Validate(); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// NewWebhookSecret returns a random secret to sign webhook payloads.
+func NewWebhookSecret() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(data), nil
+}
+
+func (w *Webhook) Validate() error {
+	if w.ID.String() == "" {
+		return ErrIDIsRequired
+	}
+	if _, err := entity.ParseID(w.ID.String()); err != nil {
+		return ErrInvalidID
+	}
+	parsed, err := url.Parse(w.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return ErrInvalidURL
+	}
+	if !WebhookHostAllowed(parsed.Hostname()) {
+		return ErrURLNotAllowed
+	}
+	if len(w.EventTypes) == 0 {
+		return ErrEventTypesRequired
+	}
+	for _, eventType := range w.EventTypes {
+		if !slices.Contains(EventTypes, eventType) {
+			return ErrInvalidEventType
+		}
+	}
+	return nil
+}
+
+// Subscribes tells if the webhook receives events of the given type.
+func (w *Webhook) Subscribes(eventType string) bool {
+	return slices.Contains(w.EventTypes, eventType)
+}
+
+// AdminOnly tells if the webhook subscribes to any of the AdminEventTypes.
+func (w *Webhook) AdminOnly() bool {
+	return slices.ContainsFunc(w.EventTypes, func(eventType string) bool {
+		return slices.Contains(AdminEventTypes, eventType)
+	})
+}
+
+// WebhookHostAllowed tells if webhooks can be sent to host. Local names and
+// loopback, link-local, private and unspecified addresses are refused, so
+// webhooks can't reach the internal services. Names are checked again by the
+// sender once resolved, with WebhookAddrAllowed.
+func WebhookHostAllowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return WebhookAddrAllowed(addr)
+	}
+	return true
+}
+
+// WebhookAddrAllowed tells if webhooks can be sent to addr.
+func WebhookAddrAllowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() &&
+		!addr.IsLoopback() &&
+		!addr.IsPrivate() &&
+		!addr.IsLinkLocalUnicast() &&
+		!addr.IsLinkLocalMulticast() &&
+		!addr.IsInterfaceLocalMulticast() &&
+		!addr.IsUnspecified() &&
+		!sharedAddressSpace.Contains(addr)
+}
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), not covered by
+// netip.Addr.IsPrivate.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// Enable activates the webhook again, forgetting its past failures.
+func (w *Webhook) Enable() {
+	w.Active = true
+	w.Failures = 0
+	w.DisabledAt = nil
+}
+
+// Status of a webhook delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is the delivery of an event to a webhook, retried until it
+// succeeds or runs out of attempts. StatusCode and Error are the result of the
+// last attempt.
+type WebhookDelivery struct {
+	ID            entity.ID       `json:"id"`
+	WebhookID     entity.ID       `json:"webhook_id" gorm:"uniqueIndex:idx_webhook_deliveries_event"`
+	EventID       entity.ID       `json:"event_id" gorm:"uniqueIndex:idx_webhook_deliveries_event"`
+	EventType     string          `json:"event_type"`
+	Payload       json.RawMessage `json:"-"`
+	Status        string          `json:"status" gorm:"index"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at" gorm:"index"`
+	StatusCode    int             `json:"status_code,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
+
+// NewWebhookDelivery creates a pending delivery of event to webhook. The
+// payload is the whole event encoded as JSON.
+func NewWebhookDelivery(webhook *Webhook, event Event) (*WebhookDelivery, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &WebhookDelivery{
+		ID:            entity.NewID(),
+		WebhookID:     webhook.ID,
+		EventID:       event.ID,
+		EventType:     event.Type,
+		Payload:       payload,
+		Status:        DeliveryPending,
+		NextAttemptAt: now.UTC(),
+		CreatedAt:     now,
+	}, nil
+}
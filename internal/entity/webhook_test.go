@@ -0,0 +1,107 @@
+package entity
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewWebhook(t *testing.T) {
+	w, err := NewWebhook("owner", "https://example.com/hooks", []string{EventProductCreated}, "")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, w.ID)
+	assert.Equal(t, "owner", w.OwnerID)
+	assert.True(t, w.Active)
+	assert.True(t, strings.HasPrefix(w.Secret, "whsec_"))
+	assert.True(t, w.Subscribes(EventProductCreated))
+	assert.False(t, w.Subscribes(EventProductDeleted))
+
+	w, err = NewWebhook("owner", "https://example.com/hooks", []string{EventProductCreated}, "my secret")
+	assert.Nil(t, err)
+	assert.Equal(t, "my secret", w.Secret)
+}
+
+func TestWebhookWhenURLIsInvalid(t *testing.T) {
+	for _, url := range []string{"", "example.com/hooks", "ftp://example.com", "https://", "://"} {
+		w, err := NewWebhook("owner", url, []string{EventProductCreated}, "")
+		assert.Nil(t, w)
+		assert.Equal(t, ErrInvalidURL, err, url)
+	}
+}
+
+func TestWebhookWhenHostIsNotAllowed(t *testing.T) {
+	urls := []string{
+		"http://localhost:8000/hooks",
+		"http://api.localhost/hooks",
+		"http://127.0.0.1/hooks",
+		"http://[::1]/hooks",
+		"http://0.0.0.0/hooks",
+		"http://10.0.0.1/hooks",
+		"http://172.16.0.1/hooks",
+		"http://192.168.1.1/hooks",
+		"http://100.64.0.1/hooks",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[fe80::1]/hooks",
+		"http://[fd00::1]/hooks",
+		"http://[::ffff:127.0.0.1]/hooks",
+	}
+	for _, url := range urls {
+		w, err := NewWebhook("owner", url, []string{EventProductCreated}, "")
+		assert.Nil(t, w)
+		assert.Equal(t, ErrURLNotAllowed, err, url)
+	}
+
+	w, err := NewWebhook("owner", "https://93.184.216.34/hooks", []string{EventProductCreated}, "")
+	assert.Nil(t, err)
+	assert.NotNil(t, w)
+}
+
+func TestWebhookAdminOnly(t *testing.T) {
+	w, _ := NewWebhook("owner", "https://example.com/hooks", []string{EventProductCreated, EventProductDeleted}, "")
+	assert.False(t, w.AdminOnly())
+
+	for _, eventType := range []string{EventUserRegistered, EventUserUpdated, EventUserDeleted} {
+		w, _ = NewWebhook("owner", "https://example.com/hooks", []string{EventProductCreated, eventType}, "")
+		assert.True(t, w.AdminOnly(), eventType)
+	}
+}
+
+func TestWebhookWhenEventTypesAreInvalid(t *testing.T) {
+	w, err := NewWebhook("owner", "https://example.com/hooks", nil, "")
+	assert.Nil(t, w)
+	assert.Equal(t, ErrEventTypesRequired, err)
+
+	w, err = NewWebhook("owner", "https://example.com/hooks", []string{EventProductCreated, "product.sold"}, "")
+	assert.Nil(t, w)
+	assert.Equal(t, ErrInvalidEventType, err)
+}
+
+func TestWebhookEnable(t *testing.T) {
+	w, _ := NewWebhook("owner", "https://example.com/hooks", []string{EventProductCreated}, "")
+	w.Active = false
+	w.Failures = 10
+
+	w.Enable()
+	assert.True(t, w.Active)
+	assert.Equal(t, 0, w.Failures)
+	assert.Nil(t, w.DisabledAt)
+}
+
+func TestNewWebhookDelivery(t *testing.T) {
+	w, _ := NewWebhook("owner", "https://example.com/hooks", []string{EventProductCreated}, "")
+	product, _ := NewProduct("Product 1", 10)
+	event, _ := NewEvent(EventProductCreated, product.ID.String(), product)
+
+	d, err := NewWebhookDelivery(w, *event)
+	assert.Nil(t, err)
+	assert.Equal(t, w.ID, d.WebhookID)
+	assert.Equal(t, event.ID, d.EventID)
+	assert.Equal(t, DeliveryPending, d.Status)
+
+	var payload Event
+	assert.Nil(t, json.Unmarshal(d.Payload, &payload))
+	assert.Equal(t, event.ID, payload.ID)
+	assert.JSONEq(t, string(event.Payload), string(payload.Payload))
+}
@@ -0,0 +1,29 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Backends that can be selected in the configuration.
+const (
+	BackendNone   = "none"
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+)
+
+var (
+	ErrMiss           = errors.New("cache miss")
+	ErrInvalidBackend = errors.New("invalid cache backend")
+)
+
+// Backend stores values by key. The methods behave like the Redis commands of
+// the same name, so any Redis compatible server can be used.
+type Backend interface {
+	// Get returns ErrMiss when the key is not cached or has expired.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set stores value for ttl, or without expiration if ttl is zero.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Del(ctx context.Context, keys ...string) error
+}
@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUGetAndSet(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(10)
+
+	_, err := c.Get(ctx, "key")
+	assert.ErrorIs(t, err, ErrMiss)
+
+	assert.Nil(t, c.Set(ctx, "key", []byte("value"), time.Minute))
+	value, err := c.Get(ctx, "key")
+	assert.Nil(t, err)
+	assert.Equal(t, "value", string(value))
+
+	assert.Nil(t, c.Set(ctx, "key", []byte("new value"), time.Minute))
+	value, err = c.Get(ctx, "key")
+	assert.Nil(t, err)
+	assert.Equal(t, "new value", string(value))
+	assert.Equal(t, 1, c.Len())
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(2)
+
+	c.Set(ctx, "a", []byte("a"), 0)
+	c.Set(ctx, "b", []byte("b"), 0)
+	// Reading a makes b the least recently used
+	c.Get(ctx, "a")
+	c.Set(ctx, "c", []byte("c"), 0)
+
+	assert.Equal(t, 2, c.Len())
+	_, err := c.Get(ctx, "b")
+	assert.ErrorIs(t, err, ErrMiss)
+	_, err = c.Get(ctx, "a")
+	assert.Nil(t, err)
+	_, err = c.Get(ctx, "c")
+	assert.Nil(t, err)
+}
+
+func TestLRUExpiresKeys(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	c := NewLRU(10)
+	c.now = func() time.Time { return now }
+
+	c.Set(ctx, "short", []byte("value"), time.Second)
+	c.Set(ctx, "forever", []byte("value"), 0)
+
+	now = now.Add(time.Second)
+	_, err := c.Get(ctx, "short")
+	assert.ErrorIs(t, err, ErrMiss)
+	_, err = c.Get(ctx, "forever")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, c.Len())
+}
+
+func TestLRUDel(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(10)
+	c.Set(ctx, "a", []byte("a"), 0)
+	c.Set(ctx, "b", []byte("b"), 0)
+
+	assert.Nil(t, c.Del(ctx, "a", "b", "missing"))
+	assert.Equal(t, 0, c.Len())
+}
@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	productKeyPrefix = "products:id:"
+	// Lists are cached under the current version, changed on every write, so
+	// all of them are invalidated at once.
+	productListVersionKey = "products:list:version"
+	productListKeyPrefix  = "products:list:"
+)
+
+// ProductService is a read-through cache in front of a
+// database.ProductInterface. Reads are served from the Backend, concurrent
+// misses of the same key share a single call to Next, and writes made through
+// it invalidate the cached entries. Inside a database transaction reads go
+// straight to Next, so uncommitted rows are never cached, and the entries are
+// invalidated once it is committed. Backend failures are logged and the reads
+// fall back to Next.
+type ProductService struct {
+	Next    database.ProductInterface
+	Backend Backend
+	TTL     time.Duration
+	Logger  *slog.Logger
+	group   singleflight.Group
+}
+
+func NewProductService(next database.ProductInterface, backend Backend, ttl time.Duration, logger *slog.Logger) *ProductService {
+	return &ProductService{Next: next, Backend: backend, TTL: ttl, Logger: logger}
+}
+
+func (p *ProductService) Create(ctx context.Context, product *entity.Product) error {
+	if err := p.Next.Create(ctx, product); err != nil {
+		return err
+	}
+	p.invalidateAfterCommit(ctx)
+	return nil
+}
+
+func (p *ProductService) FindByID(ctx context.Context, id string) (*entity.Product, error) {
+	if database.InTransaction(ctx) {
+		return p.Next.FindByID(ctx, id)
+	}
+	var product entity.Product
+	err := p.load(ctx, productKeyPrefix+id, &product, func(ctx context.Context) (any, error) {
+		return p.Next.FindByID(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+func (p *ProductService) FindAll(ctx context.Context, page, limit int, sort string) ([]entity.Product, error) {
+	if database.InTransaction(ctx) {
+		return p.Next.FindAll(ctx, page, limit, sort)
+	}
+	version, err := p.listVersion(ctx)
+	if err != nil {
+		p.Logger.WarnContext(ctx, "error reading cache", "key", productListVersionKey, "error", err)
+		return p.Next.FindAll(ctx, page, limit, sort)
+	}
+	var products []entity.Product
+	key := fmt.Sprintf("%s%s:%d:%d:%s", productListKeyPrefix, version, page, limit, sort)
+	err = p.load(ctx, key, &products, func(ctx context.Context) (any, error) {
+		return p.Next.FindAll(ctx, page, limit, sort)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+func (p *ProductService) Update(ctx context.Context, product *entity.Product) error {
+	if err := p.Next.Update(ctx, product); err != nil {
+		return err
+	}
+	p.invalidateAfterCommit(ctx, productKeyPrefix+product.ID.String())
+	return nil
+}
+
+func (p *ProductService) Delete(ctx context.Context, id string) error {
+	if err := p.Next.Delete(ctx, id); err != nil {
+		return err
+	}
+	p.invalidateAfterCommit(ctx, productKeyPrefix+id)
+	return nil
+}
+
+// load decodes the value cached at key into value. On a miss the value is
+// loaded with fn, shared with the concurrent callers and cached. Errors from
+// fn, such as database.ErrNotFound, are not cached.
+func (p *ProductService) load(ctx context.Context, key string, value any, fn func(ctx context.Context) (any, error)) error {
+	data, err := p.Backend.Get(ctx, key)
+	if err == nil {
+		if err := json.Unmarshal(data, value); err == nil {
+			return nil
+		}
+		p.Logger.WarnContext(ctx, "invalid cache entry", "key", key)
+	} else if !errors.Is(err, ErrMiss) {
+		p.Logger.WarnContext(ctx, "error reading cache", "key", key, "error", err)
+	}
+
+	results := p.group.DoChan(key, func() (any, error) {
+		// Not canceled by the caller that happened to start the load
+		ctx := context.WithoutCancel(ctx)
+		result, err := fn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.Backend.Set(ctx, key, data, p.TTL); err != nil {
+			p.Logger.WarnContext(ctx, "error writing cache", "key", key, "error", err)
+		}
+		return data, nil
+	})
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case result := <-results:
+		if result.Err != nil {
+			return result.Err
+		}
+		// Every caller decodes its own copy of the shared value
+		return json.Unmarshal(result.Val.([]byte), value)
+	}
+}
+
+// listVersion returns the version the product lists are cached under,
+// starting a new one if there is none.
+func (p *ProductService) listVersion(ctx context.Context) (string, error) {
+	version, err := p.Backend.Get(ctx, productListVersionKey)
+	if err == nil {
+		return string(version), nil
+	}
+	if !errors.Is(err, ErrMiss) {
+		return "", err
+	}
+	return p.newListVersion(ctx)
+}
+
+func (p *ProductService) newListVersion(ctx context.Context) (string, error) {
+	version := strconv.FormatInt(time.Now().UnixNano(), 36)
+	if err := p.Backend.Set(ctx, productListVersionKey, []byte(version), 0); err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
+// invalidateAfterCommit invalidates keys once the transaction of ctx is
+// committed, right away if there is none.
+func (p *ProductService) invalidateAfterCommit(ctx context.Context, keys ...string) {
+	database.AfterCommit(ctx, func(ctx context.Context) { p.invalidate(ctx, keys...) })
+}
+
+// invalidate removes keys and every cached list after a write.
+func (p *ProductService) invalidate(ctx context.Context, keys ...string) {
+	if len(keys) > 0 {
+		if err := p.Backend.Del(ctx, keys...); err != nil {
+			p.Logger.ErrorContext(ctx, "error invalidating cache", "keys", keys, "error", err)
+		}
+	}
+	if _, err := p.newListVersion(ctx); err != nil {
+		p.Logger.ErrorContext(ctx, "error invalidating cache", "keys", productListVersionKey, "error", err)
+	}
+}
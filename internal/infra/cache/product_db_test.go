@@ -0,0 +1,238 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/cache"
+	"goexpert-api/internal/infra/database"
+	"goexpert-api/internal/infra/database/databasetest"
+	"goexpert-api/internal/infra/database/memory"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var errBackend = errors.New("backend is down")
+
+// countingProductService counts the reads that reach the repository. Reads
+// wait for release to be closed, if it is set.
+type countingProductService struct {
+	*memory.ProductService
+	finds   atomic.Int32
+	release chan struct{}
+}
+
+func newCountingProductService() *countingProductService {
+	return &countingProductService{ProductService: memory.NewProductService()}
+}
+
+func (p *countingProductService) FindByID(ctx context.Context, id string) (*entity.Product, error) {
+	p.finds.Add(1)
+	if p.release != nil {
+		<-p.release
+	}
+	return p.ProductService.FindByID(ctx, id)
+}
+
+func (p *countingProductService) FindAll(ctx context.Context, page, limit int, sort string) ([]entity.Product, error) {
+	p.finds.Add(1)
+	return p.ProductService.FindAll(ctx, page, limit, sort)
+}
+
+type failingBackend struct{}
+
+func (failingBackend) Get(context.Context, string) ([]byte, error) { return nil, errBackend }
+func (failingBackend) Set(context.Context, string, []byte, time.Duration) error {
+	return errBackend
+}
+func (failingBackend) Del(context.Context, ...string) error { return errBackend }
+
+func newTestProduct(t *testing.T, name string) *entity.Product {
+	product, err := entity.NewProduct(name, 10)
+	assert.Nil(t, err)
+	return product
+}
+
+func TestProductServiceConformance(t *testing.T) {
+	t.Run("LRU", func(t *testing.T) {
+		databasetest.TestProductInterface(t, func(t *testing.T) database.ProductInterface {
+			return cache.NewProductService(memory.NewProductService(), cache.NewLRU(100), time.Minute, slog.Default())
+		})
+	})
+	t.Run("Redis", func(t *testing.T) {
+		databasetest.TestProductInterface(t, func(t *testing.T) database.ProductInterface {
+			client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+			t.Cleanup(func() { client.Close() })
+			return cache.NewProductService(memory.NewProductService(), cache.NewRedis(client), time.Minute, slog.Default())
+		})
+	})
+}
+
+func TestProductServiceServesReadsFromCache(t *testing.T) {
+	ctx := context.Background()
+	next := newCountingProductService()
+	products := cache.NewProductService(next, cache.NewLRU(100), time.Minute, slog.Default())
+	product := newTestProduct(t, "Product 1")
+	assert.Nil(t, products.Create(ctx, product))
+
+	for range 3 {
+		productFound, err := products.FindByID(ctx, product.ID.String())
+		assert.Nil(t, err)
+		assert.Equal(t, product.Name, productFound.Name)
+		_, err = products.FindAll(ctx, 1, 10, "asc")
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, int32(2), next.finds.Load())
+}
+
+func TestProductServiceDoesNotCacheNotFound(t *testing.T) {
+	ctx := context.Background()
+	next := newCountingProductService()
+	products := cache.NewProductService(next, cache.NewLRU(100), time.Minute, slog.Default())
+	product := newTestProduct(t, "Product 1")
+
+	_, err := products.FindByID(ctx, product.ID.String())
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	// Created straight in the repository
+	assert.Nil(t, next.Create(ctx, product))
+	_, err = products.FindByID(ctx, product.ID.String())
+	assert.Nil(t, err)
+}
+
+func TestProductServiceInvalidatesOnWrite(t *testing.T) {
+	ctx := context.Background()
+	products := cache.NewProductService(memory.NewProductService(), cache.NewLRU(100), time.Minute, slog.Default())
+	product := newTestProduct(t, "Product 1")
+	assert.Nil(t, products.Create(ctx, product))
+	products.FindByID(ctx, product.ID.String())
+	productsFound, _ := products.FindAll(ctx, 0, 0, "asc")
+	assert.Len(t, productsFound, 1)
+
+	// Create
+	assert.Nil(t, products.Create(ctx, newTestProduct(t, "Product 2")))
+	productsFound, _ = products.FindAll(ctx, 0, 0, "asc")
+	assert.Len(t, productsFound, 2)
+
+	// Update
+	product.Name = "Updated product 1"
+	assert.Nil(t, products.Update(ctx, product))
+	productFound, err := products.FindByID(ctx, product.ID.String())
+	assert.Nil(t, err)
+	assert.Equal(t, "Updated product 1", productFound.Name)
+	productsFound, _ = products.FindAll(ctx, 0, 0, "asc")
+	assert.Equal(t, "Updated product 1", productsFound[0].Name)
+
+	// Delete
+	assert.Nil(t, products.Delete(ctx, product.ID.String()))
+	_, err = products.FindByID(ctx, product.ID.String())
+	assert.ErrorIs(t, err, database.ErrNotFound)
+	productsFound, _ = products.FindAll(ctx, 0, 0, "asc")
+	assert.Len(t, productsFound, 1)
+}
+
+func TestProductServiceSharesConcurrentMisses(t *testing.T) {
+	ctx := context.Background()
+	next := newCountingProductService()
+	products := cache.NewProductService(next, cache.NewLRU(100), time.Minute, slog.Default())
+	product := newTestProduct(t, "Product 1")
+	assert.Nil(t, next.Create(ctx, product))
+	next.release = make(chan struct{})
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			productFound, err := products.FindByID(ctx, product.ID.String())
+			assert.Nil(t, err)
+			assert.Equal(t, product.Name, productFound.Name)
+		}()
+	}
+	// Give every goroutine the time to wait on the first load
+	time.Sleep(50 * time.Millisecond)
+	close(next.release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), next.finds.Load())
+}
+
+func TestProductServiceStopsWaitingWhenContextIsCanceled(t *testing.T) {
+	next := newCountingProductService()
+	next.release = make(chan struct{})
+	defer close(next.release)
+	products := cache.NewProductService(next, cache.NewLRU(100), time.Minute, slog.Default())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := products.FindByID(ctx, entity.Product{}.ID.String())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestProductServiceWhenBackendFails(t *testing.T) {
+	ctx := context.Background()
+	next := newCountingProductService()
+	products := cache.NewProductService(next, failingBackend{}, time.Minute, slog.Default())
+	product := newTestProduct(t, "Product 1")
+
+	assert.Nil(t, products.Create(ctx, product))
+	productFound, err := products.FindByID(ctx, product.ID.String())
+	assert.Nil(t, err)
+	assert.Equal(t, product.Name, productFound.Name)
+	productsFound, err := products.FindAll(ctx, 0, 0, "asc")
+	assert.Nil(t, err)
+	assert.Len(t, productsFound, 1)
+	assert.Nil(t, products.Delete(ctx, product.ID.String()))
+}
+
+func TestProductServiceInTransaction(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	db.AutoMigrate(&entity.Product{}, &database.OutboxMessage{})
+	ctx := context.Background()
+	products := cache.NewProductService(database.NewProductService(db, slog.Default()), cache.NewLRU(100), time.Minute, slog.Default())
+	tx := database.NewTransactionService(db, slog.Default())
+	tx.Repositories.Products = products
+
+	// Rows read before the rollback are not cached
+	rolledBack := newTestProduct(t, "Product 1")
+	err = tx.Run(ctx, func(ctx context.Context, repos database.Repositories) error {
+		assert.Nil(t, repos.Products.Create(ctx, rolledBack))
+		_, err := repos.Products.FindByID(ctx, rolledBack.ID.String())
+		assert.Nil(t, err)
+		return errors.New("abort")
+	})
+	assert.NotNil(t, err)
+	_, err = products.FindByID(ctx, rolledBack.ID.String())
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	// Cached entries are invalidated once the write is committed
+	product := newTestProduct(t, "Product 2")
+	assert.Nil(t, products.Create(ctx, product))
+	_, err = products.FindByID(ctx, product.ID.String())
+	assert.Nil(t, err)
+	err = tx.Run(ctx, func(ctx context.Context, repos database.Repositories) error {
+		product.Name = "Product 3"
+		return repos.Products.Update(ctx, product)
+	})
+	assert.Nil(t, err)
+	found, err := products.FindByID(ctx, product.ID.String())
+	assert.Nil(t, err)
+	assert.Equal(t, "Product 3", found.Name)
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestRedis returns a Backend connected to an in-process Redis stand-in.
+func newTestRedis(t *testing.T) (*Redis, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedis(client), server
+}
+
+func TestRedisGetAndSet(t *testing.T) {
+	ctx := context.Background()
+	c, server := newTestRedis(t)
+
+	_, err := c.Get(ctx, "key")
+	assert.ErrorIs(t, err, ErrMiss)
+
+	assert.Nil(t, c.Set(ctx, "key", []byte("value"), time.Minute))
+	value, err := c.Get(ctx, "key")
+	assert.Nil(t, err)
+	assert.Equal(t, "value", string(value))
+	assert.Equal(t, time.Minute, server.TTL("key"))
+
+	server.FastForward(time.Minute)
+	_, err = c.Get(ctx, "key")
+	assert.ErrorIs(t, err, ErrMiss)
+}
+
+func TestRedisDel(t *testing.T) {
+	ctx := context.Background()
+	c, server := newTestRedis(t)
+	c.Set(ctx, "a", []byte("a"), 0)
+	c.Set(ctx, "b", []byte("b"), 0)
+
+	assert.Nil(t, c.Del(ctx, "a", "b"))
+	assert.Nil(t, c.Del(ctx))
+	assert.False(t, server.Exists("a"))
+	assert.False(t, server.Exists("b"))
+}
+
+func TestRedisWhenServerIsDown(t *testing.T) {
+	c, server := newTestRedis(t)
+	server.Close()
+
+	_, err := c.Get(context.Background(), "key")
+	assert.NotNil(t, err)
+	assert.NotErrorIs(t, err, ErrMiss)
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+type APIKeyService struct {
+	DB     *gorm.DB
+	Logger *slog.Logger
+}
+
+func NewAPIKeyService(db *gorm.DB, logger *slog.Logger) *APIKeyService {
+	return &APIKeyService{DB: db, Logger: logger}
+}
+
+func (s *APIKeyService) Create(ctx context.Context, apiKey *entity.APIKey) (err error) {
+	ctx, span := tracer.Start(ctx, "APIKeyService.Create", trace.WithAttributes(
+		attribute.String("user.id", apiKey.UserID),
+	))
+	defer func() { endSpan(span, err) }()
+
+	err = translateError(conn(ctx, s.DB).Create(apiKey).Error)
+	if err != nil {
+		s.Logger.ErrorContext(ctx, "error creating api key", "user_id", apiKey.UserID, "error", err)
+	}
+	return err
+}
+
+func (s *APIKeyService) FindByID(ctx context.Context, id string) (_ *entity.APIKey, err error) {
+	ctx, span := tracer.Start(ctx, "APIKeyService.FindByID", trace.WithAttributes(
+		attribute.String("api_key.id", id),
+	))
+	defer func() { endSpan(span, err) }()
+
+	var apiKey entity.APIKey
+	err = conn(ctx, s.DB).Where("id = ?", id).First(&apiKey).Error
+	if err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
+func (s *APIKeyService) FindByPrefix(ctx context.Context, prefix string) (_ *entity.APIKey, err error) {
+	ctx, span := tracer.Start(ctx, "APIKeyService.FindByPrefix")
+	defer func() { endSpan(span, err) }()
+
+	var apiKey entity.APIKey
+	err = conn(ctx, s.DB).Where("prefix = ?", prefix).First(&apiKey).Error
+	if err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
+func (s *APIKeyService) FindByUser(ctx context.Context, userID string) (_ []entity.APIKey, err error) {
+	ctx, span := tracer.Start(ctx, "APIKeyService.FindByUser", trace.WithAttributes(
+		attribute.String("user.id", userID),
+	))
+	defer func() { endSpan(span, err) }()
+
+	var apiKeys []entity.APIKey
+	err = conn(ctx, s.DB).Where("user_id = ?", userID).Order("created_at, id").Find(&apiKeys).Error
+	return apiKeys, err
+}
+
+func (s *APIKeyService) Revoke(ctx context.Context, id string, now time.Time) (err error) {
+	ctx, span := tracer.Start(ctx, "APIKeyService.Revoke", trace.WithAttributes(
+		attribute.String("api_key.id", id),
+	))
+	defer func() { endSpan(span, err) }()
+
+	result := conn(ctx, s.DB).Model(&entity.APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", now)
+	if result.Error != nil {
+		s.Logger.ErrorContext(ctx, "error revoking api key", "id", id, "error", result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *APIKeyService) UpdateLastUsed(ctx context.Context, id string, now time.Time) (err error) {
+	ctx, span := tracer.Start(ctx, "APIKeyService.UpdateLastUsed", trace.WithAttributes(
+		attribute.String("api_key.id", id),
+	))
+	defer func() { endSpan(span, err) }()
+
+	err = conn(ctx, s.DB).Model(&entity.APIKey{}).Where("id = ?", id).Update("last_used_at", now).Error
+	if err != nil {
+		s.Logger.ErrorContext(ctx, "error updating api key", "id", id, "error", err)
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+package database
+
+import "gorm.io/gorm"
+
+// registerCallbacks registers hooks that run before and after every GORM
+// operation. The hooks receive the operation name (create, query, update,
+// delete, row or raw).
+func registerCallbacks(db *gorm.DB, plugin string, before, after func(operation string) func(*gorm.DB)) error {
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").Register(plugin+":before_create", before("create")); err != nil {
+		return err
+	}
+	if err := cb.Create().After("gorm:create").Register(plugin+":after_create", after("create")); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").Register(plugin+":before_query", before("query")); err != nil {
+		return err
+	}
+	if err := cb.Query().After("gorm:query").Register(plugin+":after_query", after("query")); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register(plugin+":before_update", before("update")); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:update").Register(plugin+":after_update", after("update")); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").Register(plugin+":before_delete", before("delete")); err != nil {
+		return err
+	}
+	if err := cb.Delete().After("gorm:delete").Register(plugin+":after_delete", after("delete")); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register(plugin+":before_row", before("row")); err != nil {
+		return err
+	}
+	if err := cb.Row().After("gorm:row").Register(plugin+":after_row", after("row")); err != nil {
+		return err
+	}
+	if err := cb.Raw().Before("gorm:raw").Register(plugin+":before_raw", before("raw")); err != nil {
+		return err
+	}
+	return cb.Raw().After("gorm:raw").Register(plugin+":after_raw", after("raw"))
+}
@@ -0,0 +1,63 @@
+package database_test
+
+import (
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"goexpert-api/internal/infra/database/databasetest"
+	"log/slog"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: opens a new database
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	db.AutoMigrate(&entity.Product{}, &entity.User{}, &entity.Webhook{}, &entity.WebhookDelivery{}, &entity.PasswordReset{}, &entity.APIKey{}, &entity.OAuthClient{}, &entity.RevokedToken{}, &database.OutboxMessage{})
+	return db
+}
+
+func TestProductServiceConformance(t *testing.T) {
+	databasetest.TestProductInterface(t, func(t *testing.T) database.ProductInterface {
+		return database.NewProductService(openTestDB(t), slog.Default())
+	})
+}
+
+func TestUserServiceConformance(t *testing.T) {
+	databasetest.TestUserInterface(t, func(t *testing.T) database.UserInterface {
+		return database.NewUserService(openTestDB(t), slog.Default())
+	})
+}
+
+func TestPasswordResetServiceConformance(t *testing.T) {
+	databasetest.TestPasswordResetInterface(t, func(t *testing.T) database.PasswordResetInterface {
+		return database.NewPasswordResetService(openTestDB(t), slog.Default())
+	})
+}
+
+func TestAPIKeyServiceConformance(t *testing.T) {
+	databasetest.TestAPIKeyInterface(t, func(t *testing.T) database.APIKeyInterface {
+		return database.NewAPIKeyService(openTestDB(t), slog.Default())
+	})
+}
+
+func TestOAuthClientServiceConformance(t *testing.T) {
+	databasetest.TestOAuthClientInterface(t, func(t *testing.T) database.OAuthClientInterface {
+		return database.NewOAuthClientService(openTestDB(t), slog.Default())
+	})
+}
+
+func TestRevokedTokenServiceConformance(t *testing.T) {
+	databasetest.TestRevokedTokenInterface(t, func(t *testing.T) database.RevokedTokenInterface {
+		return database.NewRevokedTokenService(openTestDB(t), slog.Default())
+	})
+}
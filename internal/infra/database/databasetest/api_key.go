@@ -0,0 +1,102 @@
+package databasetest
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	entityPkg "goexpert-api/pkg/entity"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAPIKeyInterface runs the conformance suite against the implementations
+// returned by newService, which must be empty.
+func TestAPIKeyInterface(t *testing.T, newService func(t *testing.T) database.APIKeyInterface) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	scopes := []string{entity.ScopeProductsRead, entity.ScopeProductsWrite}
+
+	t.Run("Create and find", func(t *testing.T) {
+		apiKeyService := newService(t)
+		apiKey, key, _ := entity.NewAPIKey("user-id", "CI", scopes, now.Add(time.Hour), now)
+
+		assert.Nil(t, apiKeyService.Create(ctx, apiKey))
+		// Not a duplicated key, which is only reported for unique fields
+		assert.NotNil(t, apiKeyService.Create(ctx, apiKey))
+
+		prefix, _ := entity.ParseAPIKey(key)
+		found, err := apiKeyService.FindByPrefix(ctx, prefix)
+		assert.Nil(t, err)
+		assert.Equal(t, apiKey.ID, found.ID)
+		assert.Equal(t, "user-id", found.UserID)
+		assert.Equal(t, "CI", found.Name)
+		assert.Equal(t, scopes, found.Scopes)
+		assert.True(t, apiKey.ExpiresAt.Equal(found.ExpiresAt))
+		assert.True(t, found.Matches(key))
+		assert.Nil(t, found.LastUsedAt)
+		assert.Nil(t, found.RevokedAt)
+
+		found, err = apiKeyService.FindByID(ctx, apiKey.ID.String())
+		assert.Nil(t, err)
+		assert.True(t, found.Matches(key))
+
+		_, err = apiKeyService.FindByPrefix(ctx, "unknown")
+		assert.ErrorIs(t, err, database.ErrNotFound)
+		_, err = apiKeyService.FindByID(ctx, entityPkg.NewID().String())
+		assert.ErrorIs(t, err, database.ErrNotFound)
+	})
+
+	t.Run("FindByUser", func(t *testing.T) {
+		apiKeyService := newService(t)
+		first, _, _ := entity.NewAPIKey("user-id", "first", scopes, now.Add(time.Hour), now)
+		second, _, _ := entity.NewAPIKey("user-id", "second", scopes, now.Add(time.Hour), now.Add(time.Second))
+		other, _, _ := entity.NewAPIKey("other-id", "other", scopes, now.Add(time.Hour), now)
+		for _, apiKey := range []*entity.APIKey{first, second, other} {
+			assert.Nil(t, apiKeyService.Create(ctx, apiKey))
+		}
+		assert.Nil(t, apiKeyService.Revoke(ctx, first.ID.String(), now))
+
+		apiKeys, err := apiKeyService.FindByUser(ctx, "user-id")
+		assert.Nil(t, err)
+		if assert.Len(t, apiKeys, 2) {
+			assert.Equal(t, first.ID, apiKeys[0].ID)
+			assert.NotNil(t, apiKeys[0].RevokedAt)
+			assert.Equal(t, second.ID, apiKeys[1].ID)
+		}
+
+		apiKeys, err = apiKeyService.FindByUser(ctx, "unknown")
+		assert.Nil(t, err)
+		assert.Empty(t, apiKeys)
+	})
+
+	t.Run("Revoke only once", func(t *testing.T) {
+		apiKeyService := newService(t)
+		apiKey, _, _ := entity.NewAPIKey("user-id", "CI", scopes, now.Add(time.Hour), now)
+		assert.Nil(t, apiKeyService.Create(ctx, apiKey))
+
+		assert.Nil(t, apiKeyService.Revoke(ctx, apiKey.ID.String(), now))
+		assert.ErrorIs(t, apiKeyService.Revoke(ctx, apiKey.ID.String(), now), database.ErrNotFound)
+		assert.ErrorIs(t, apiKeyService.Revoke(ctx, entityPkg.NewID().String(), now), database.ErrNotFound)
+
+		found, err := apiKeyService.FindByID(ctx, apiKey.ID.String())
+		assert.Nil(t, err)
+		assert.True(t, now.Equal(*found.RevokedAt))
+		assert.False(t, found.Valid(now))
+	})
+
+	t.Run("UpdateLastUsed keeps the revocation", func(t *testing.T) {
+		apiKeyService := newService(t)
+		apiKey, _, _ := entity.NewAPIKey("user-id", "CI", scopes, now.Add(time.Hour), now)
+		assert.Nil(t, apiKeyService.Create(ctx, apiKey))
+		assert.Nil(t, apiKeyService.Revoke(ctx, apiKey.ID.String(), now))
+
+		assert.Nil(t, apiKeyService.UpdateLastUsed(ctx, apiKey.ID.String(), now.Add(time.Minute)))
+
+		found, err := apiKeyService.FindByID(ctx, apiKey.ID.String())
+		assert.Nil(t, err)
+		assert.True(t, now.Add(time.Minute).Equal(*found.LastUsedAt))
+		assert.NotNil(t, found.RevokedAt)
+	})
+}
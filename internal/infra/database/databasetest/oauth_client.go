@@ -0,0 +1,117 @@
+package databasetest
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	entityPkg "goexpert-api/pkg/entity"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestOAuthClientInterface runs the conformance suite against the
+// implementations returned by newService, which must be empty.
+func TestOAuthClientInterface(t *testing.T, newService func(t *testing.T) database.OAuthClientInterface) {
+	ctx := context.Background()
+	scopes := []string{entity.ScopeProductsRead, entity.ScopeProductsWrite}
+
+	t.Run("Create and find", func(t *testing.T) {
+		clientService := newService(t)
+		client, secret, _ := entity.NewOAuthClient("billing", scopes)
+
+		assert.Nil(t, clientService.Create(ctx, client))
+		// Not a duplicated key, which is only reported for unique fields
+		assert.NotNil(t, clientService.Create(ctx, client))
+
+		found, err := clientService.FindByID(ctx, client.ID.String())
+		assert.Nil(t, err)
+		assert.Equal(t, client.ID, found.ID)
+		assert.Equal(t, "billing", found.Name)
+		assert.Equal(t, scopes, found.Scopes)
+		assert.True(t, found.Authenticate(secret))
+
+		_, err = clientService.FindByID(ctx, entityPkg.NewID().String())
+		assert.ErrorIs(t, err, database.ErrNotFound)
+	})
+
+	t.Run("FindAll", func(t *testing.T) {
+		clientService := newService(t)
+		clients, err := clientService.FindAll(ctx)
+		assert.Nil(t, err)
+		assert.Empty(t, clients)
+
+		first, _, _ := entity.NewOAuthClient("first", scopes)
+		second, _, _ := entity.NewOAuthClient("second", scopes)
+		second.CreatedAt = first.CreatedAt.Add(time.Second)
+		for _, client := range []*entity.OAuthClient{first, second} {
+			assert.Nil(t, clientService.Create(ctx, client))
+		}
+
+		clients, err = clientService.FindAll(ctx)
+		assert.Nil(t, err)
+		if assert.Len(t, clients, 2) {
+			assert.Equal(t, first.ID, clients[0].ID)
+			assert.Equal(t, second.ID, clients[1].ID)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		clientService := newService(t)
+		client, _, _ := entity.NewOAuthClient("billing", scopes)
+		assert.Nil(t, clientService.Create(ctx, client))
+
+		assert.Nil(t, clientService.Delete(ctx, client.ID.String()))
+		_, err := clientService.FindByID(ctx, client.ID.String())
+		assert.ErrorIs(t, err, database.ErrNotFound)
+		assert.ErrorIs(t, clientService.Delete(ctx, client.ID.String()), database.ErrNotFound)
+	})
+
+	t.Run("Scopes are not shared", func(t *testing.T) {
+		clientService := newService(t)
+		client, _, _ := entity.NewOAuthClient("billing", scopes)
+		assert.Nil(t, clientService.Create(ctx, client))
+
+		found, _ := clientService.FindByID(ctx, client.ID.String())
+		found.Scopes[0] = "changed"
+		found, _ = clientService.FindByID(ctx, client.ID.String())
+		assert.Equal(t, scopes, found.Scopes)
+	})
+}
+
+// TestRevokedTokenInterface runs the conformance suite against the
+// implementations returned by newService, which must be empty.
+func TestRevokedTokenInterface(t *testing.T, newService func(t *testing.T) database.RevokedTokenInterface) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	t.Run("Revoke", func(t *testing.T) {
+		tokenService := newService(t)
+		revoked, err := tokenService.IsRevoked(ctx, "jti")
+		assert.Nil(t, err)
+		assert.False(t, revoked)
+
+		assert.Nil(t, tokenService.Revoke(ctx, "jti", now.Add(time.Hour), now))
+		assert.Nil(t, tokenService.Revoke(ctx, "jti", now.Add(time.Hour), now))
+		revoked, err = tokenService.IsRevoked(ctx, "jti")
+		assert.Nil(t, err)
+		assert.True(t, revoked)
+		revoked, _ = tokenService.IsRevoked(ctx, "other")
+		assert.False(t, revoked)
+	})
+
+	t.Run("Expired tokens are purged", func(t *testing.T) {
+		tokenService := newService(t)
+		assert.Nil(t, tokenService.Revoke(ctx, "expired", now.Add(time.Minute), now))
+		assert.Nil(t, tokenService.Revoke(ctx, "valid", now.Add(time.Hour), now))
+
+		assert.Nil(t, tokenService.Revoke(ctx, "new", now.Add(2*time.Hour), now.Add(30*time.Minute)))
+		revoked, _ := tokenService.IsRevoked(ctx, "expired")
+		assert.False(t, revoked)
+		revoked, _ = tokenService.IsRevoked(ctx, "valid")
+		assert.True(t, revoked)
+		revoked, _ = tokenService.IsRevoked(ctx, "new")
+		assert.True(t, revoked)
+	})
+}
@@ -0,0 +1,56 @@
+package databasetest
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPasswordResetInterface runs the conformance suite against the
+// implementations returned by newService, which must be empty.
+func TestPasswordResetInterface(t *testing.T, newService func(t *testing.T) database.PasswordResetInterface) {
+	ctx := context.Background()
+	user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	t.Run("Create and FindByTokenHash", func(t *testing.T) {
+		resetService := newService(t)
+		reset, token, _ := entity.NewPasswordReset(user, time.Hour, now)
+
+		assert.Nil(t, resetService.Create(ctx, reset))
+
+		found, err := resetService.FindByTokenHash(ctx, entity.HashPasswordResetToken(token))
+		assert.Nil(t, err)
+		assert.Equal(t, reset.ID, found.ID)
+		assert.Equal(t, user.ID.String(), found.UserID)
+		assert.True(t, reset.ExpiresAt.Equal(found.ExpiresAt))
+		assert.Nil(t, found.UsedAt)
+
+		_, err = resetService.FindByTokenHash(ctx, token)
+		assert.ErrorIs(t, err, database.ErrNotFound)
+	})
+
+	t.Run("Use only once", func(t *testing.T) {
+		resetService := newService(t)
+		reset, token, _ := entity.NewPasswordReset(user, time.Hour, now)
+		assert.Nil(t, resetService.Create(ctx, reset))
+
+		assert.Nil(t, resetService.Use(ctx, reset.ID.String(), now))
+		assert.ErrorIs(t, resetService.Use(ctx, reset.ID.String(), now), database.ErrNotFound)
+
+		found, err := resetService.FindByTokenHash(ctx, entity.HashPasswordResetToken(token))
+		assert.Nil(t, err)
+		assert.NotNil(t, found.UsedAt)
+		assert.False(t, found.Valid(user, now))
+	})
+
+	t.Run("Use when not found", func(t *testing.T) {
+		resetService := newService(t)
+		reset, _, _ := entity.NewPasswordReset(user, time.Hour, now)
+		assert.ErrorIs(t, resetService.Use(ctx, reset.ID.String(), now), database.ErrNotFound)
+	})
+}
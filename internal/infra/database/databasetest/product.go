@@ -0,0 +1,173 @@
+// Package databasetest has the conformance suites every implementation of the
+// database interfaces must pass.
+package databasetest
+
+import (
+	"context"
+	"fmt"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestProductInterface runs the conformance suite against the implementations
+// returned by newService, which must be empty.
+func TestProductInterface(t *testing.T, newService func(t *testing.T) database.ProductInterface) {
+	ctx := context.Background()
+
+	t.Run("Create and FindByID", func(t *testing.T) {
+		productService := newService(t)
+		product, _ := entity.NewProduct("Product 1", 10)
+
+		err := productService.Create(ctx, product)
+		assert.Nil(t, err)
+
+		productFound, err := productService.FindByID(ctx, product.ID.String())
+		assert.Nil(t, err)
+		assert.Equal(t, product.ID, productFound.ID)
+		assert.Equal(t, product.Name, productFound.Name)
+		assert.Equal(t, product.Price, productFound.Price)
+	})
+
+	t.Run("Create with duplicated ID", func(t *testing.T) {
+		productService := newService(t)
+		product, _ := entity.NewProduct("Product 1", 10)
+
+		assert.Nil(t, productService.Create(ctx, product))
+		assert.NotNil(t, productService.Create(ctx, product))
+	})
+
+	t.Run("FindByID when not found", func(t *testing.T) {
+		productService := newService(t)
+
+		productFound, err := productService.FindByID(ctx, "abc123")
+		assert.ErrorIs(t, err, database.ErrNotFound)
+		assert.Nil(t, productFound)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		productService := newService(t)
+		product, _ := entity.NewProduct("Product 1", 10)
+		assert.Nil(t, productService.Create(ctx, product))
+
+		product.Name = "Updated product 1"
+		product.Price = 20
+		err := productService.Update(ctx, product)
+		assert.Nil(t, err)
+
+		productFound, err := productService.FindByID(ctx, product.ID.String())
+		assert.Nil(t, err)
+		assert.Equal(t, "Updated product 1", productFound.Name)
+		assert.Equal(t, 20.0, productFound.Price)
+	})
+
+	t.Run("Update when not found", func(t *testing.T) {
+		productService := newService(t)
+		product, _ := entity.NewProduct("Product 1", 10)
+
+		err := productService.Update(ctx, product)
+		assert.ErrorIs(t, err, database.ErrNotFound)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		productService := newService(t)
+		product, _ := entity.NewProduct("Product 1", 10)
+		assert.Nil(t, productService.Create(ctx, product))
+
+		err := productService.Delete(ctx, product.ID.String())
+		assert.Nil(t, err)
+
+		_, err = productService.FindByID(ctx, product.ID.String())
+		assert.ErrorIs(t, err, database.ErrNotFound)
+	})
+
+	t.Run("Delete when not found", func(t *testing.T) {
+		productService := newService(t)
+		product, _ := entity.NewProduct("Product 1", 10)
+
+		err := productService.Delete(ctx, product.ID.String())
+		assert.ErrorIs(t, err, database.ErrNotFound)
+	})
+
+	t.Run("FindAll when empty", func(t *testing.T) {
+		productService := newService(t)
+
+		productsFound, err := productService.FindAll(ctx, 0, 0, "")
+		assert.Nil(t, err)
+		assert.Empty(t, productsFound)
+	})
+
+	t.Run("FindAll sorted by creation", func(t *testing.T) {
+		productService := newService(t)
+		products := createProducts(t, productService, 5)
+
+		productsFound, err := productService.FindAll(ctx, 0, 0, "")
+		assert.Nil(t, err)
+		assertSameProducts(t, products, productsFound)
+
+		productsFound, err = productService.FindAll(ctx, 0, 0, "asc")
+		assert.Nil(t, err)
+		assertSameProducts(t, products, productsFound)
+
+		productsFound, err = productService.FindAll(ctx, 0, 0, "invalid")
+		assert.Nil(t, err)
+		assertSameProducts(t, products, productsFound)
+
+		var reversed []entity.Product
+		for i := len(products) - 1; i >= 0; i-- {
+			reversed = append(reversed, products[i])
+		}
+		productsFound, err = productService.FindAll(ctx, 0, 0, "desc")
+		assert.Nil(t, err)
+		assertSameProducts(t, reversed, productsFound)
+	})
+
+	t.Run("FindAll with pagination", func(t *testing.T) {
+		productService := newService(t)
+		items := 24
+		products := createProducts(t, productService, items)
+
+		limit := 10
+		pages := int(math.Ceil(float64(items) / float64(limit)))
+		for page := range pages {
+			productsFound, err := productService.FindAll(ctx, page+1, limit, "asc")
+			assert.Nil(t, err)
+			end := min((page+1)*limit, items)
+			assertSameProducts(t, products[page*limit:end], productsFound)
+		}
+
+		productsFound, err := productService.FindAll(ctx, pages+1, limit, "asc")
+		assert.Nil(t, err)
+		assert.Empty(t, productsFound)
+	})
+}
+
+// createProducts creates n products, each one created after the previous.
+func createProducts(t *testing.T, productService database.ProductInterface, n int) []entity.Product {
+	start := time.Now().Add(-time.Hour)
+	var products []entity.Product
+	for i := range n {
+		product, _ := entity.NewProduct(fmt.Sprintf("Product %d", i+1), rand.Float64()+1)
+		product.CreatedAt = start.Add(time.Duration(i) * time.Second)
+		assert.Nil(t, productService.Create(context.Background(), product))
+		products = append(products, *product)
+	}
+	return products
+}
+
+func assertSameProducts(t *testing.T, expected, found []entity.Product) {
+	t.Helper()
+	if !assert.Len(t, found, len(expected)) {
+		return
+	}
+	for i := range expected {
+		assert.Equal(t, expected[i].ID, found[i].ID)
+		assert.Equal(t, expected[i].Name, found[i].Name)
+		assert.Equal(t, expected[i].Price, found[i].Price)
+	}
+}
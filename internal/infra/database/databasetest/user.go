@@ -0,0 +1,375 @@
+package databasetest
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	entityPkg "goexpert-api/pkg/entity"
+	"goexpert-api/pkg/totp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestUserInterface runs the conformance suite against the implementations
+// returned by newService, which must be empty.
+func TestUserInterface(t *testing.T, newService func(t *testing.T) database.UserInterface) {
+	ctx := context.Background()
+
+	t.Run("Create and FindByEmail", func(t *testing.T) {
+		userService := newService(t)
+		user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+
+		err := userService.Create(ctx, user)
+		assert.Nil(t, err)
+
+		userFound, err := userService.FindByEmail(ctx, "john@doe.com")
+		assert.Nil(t, err)
+		assert.Equal(t, user.ID, userFound.ID)
+		assert.Equal(t, user.Name, userFound.Name)
+		assert.Equal(t, user.Email, userFound.Email)
+		assert.Equal(t, user.Password, userFound.Password)
+	})
+
+	t.Run("Create with duplicated email", func(t *testing.T) {
+		userService := newService(t)
+		user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+		other, _ := entity.NewUser("Other John", "john@doe.com", "abc123")
+
+		assert.Nil(t, userService.Create(ctx, user))
+		assert.ErrorIs(t, userService.Create(ctx, other), database.ErrDuplicatedKey)
+	})
+
+	t.Run("Create with duplicated ID", func(t *testing.T) {
+		userService := newService(t)
+		user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+
+		assert.Nil(t, userService.Create(ctx, user))
+		user.Email = "jane@doe.com"
+		err := userService.Create(ctx, user)
+		assert.NotNil(t, err)
+		// Not reported as a duplicated email
+		assert.NotErrorIs(t, err, database.ErrDuplicatedKey)
+	})
+
+	t.Run("Emails are unique whatever their case", func(t *testing.T) {
+		userService := newService(t)
+		user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+		other, _ := entity.NewUser("Other John", "john@doe.com", "abc123")
+		// Stored before emails were normalized
+		other.Email = "John@Doe.com"
+
+		assert.Nil(t, userService.Create(ctx, user))
+		assert.ErrorIs(t, userService.Create(ctx, other), database.ErrDuplicatedKey)
+
+		userFound, err := userService.FindByEmail(ctx, "JOHN@doe.com")
+		assert.Nil(t, err)
+		assert.Equal(t, user.ID, userFound.ID)
+	})
+
+	t.Run("FindByEmail when not found", func(t *testing.T) {
+		userService := newService(t)
+		user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+		assert.Nil(t, userService.Create(ctx, user))
+
+		userFound, err := userService.FindByEmail(ctx, "j@doe.com")
+		assert.ErrorIs(t, err, database.ErrNotFound)
+		assert.Nil(t, userFound)
+	})
+
+	t.Run("FindByID", func(t *testing.T) {
+		userService := newService(t)
+		user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+		assert.Nil(t, userService.Create(ctx, user))
+
+		userFound, err := userService.FindByID(ctx, user.ID.String())
+		assert.Nil(t, err)
+		assert.Equal(t, user.Email, userFound.Email)
+
+		userFound, err = userService.FindByID(ctx, entityPkg.NewID().String())
+		assert.ErrorIs(t, err, database.ErrNotFound)
+		assert.Nil(t, userFound)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		userService := newService(t)
+		user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+		assert.Nil(t, userService.Create(ctx, user))
+
+		user.Name = "John Updated"
+		user.Email = "john.updated@doe.com"
+		assert.Nil(t, user.ChangePassword("def456"))
+		assert.Nil(t, userService.Update(ctx, user, "Name", "Email", "Password", "TokenVersion"))
+
+		userFound, err := userService.FindByEmail(ctx, "john.updated@doe.com")
+		assert.Nil(t, err)
+		assert.Equal(t, "John Updated", userFound.Name)
+		assert.True(t, userFound.ValidatePassword("def456"))
+		assert.Equal(t, 1, userFound.TokenVersion)
+		_, err = userService.FindByEmail(ctx, "john@doe.com")
+		assert.ErrorIs(t, err, database.ErrNotFound)
+	})
+
+	t.Run("Update with duplicated email", func(t *testing.T) {
+		userService := newService(t)
+		user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+		other, _ := entity.NewUser("Jane Doe", "jane@doe.com", "abc123")
+		assert.Nil(t, userService.Create(ctx, user))
+		assert.Nil(t, userService.Create(ctx, other))
+
+		other.Email = "john@doe.com"
+		assert.ErrorIs(t, userService.Update(ctx, other, "Email"), database.ErrDuplicatedKey)
+		// Keeping its own email is not a duplicate
+		user.Name = "John Updated"
+		assert.Nil(t, userService.Update(ctx, user, "Name", "Email"))
+	})
+
+	t.Run("Update when not found", func(t *testing.T) {
+		userService := newService(t)
+		user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+		assert.ErrorIs(t, userService.Update(ctx, user, "Name"), database.ErrNotFound)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		userService := newService(t)
+		user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+		assert.Nil(t, userService.Create(ctx, user))
+
+		assert.Nil(t, userService.Delete(ctx, user.ID.String()))
+		_, err := userService.FindByID(ctx, user.ID.String())
+		assert.ErrorIs(t, err, database.ErrNotFound)
+		assert.ErrorIs(t, userService.Delete(ctx, user.ID.String()), database.ErrNotFound)
+	})
+
+	t.Run("FindAll", func(t *testing.T) {
+		userService := newService(t)
+		for _, data := range [][2]string{
+			{"Zoe Smith", "zoe@example.com"},
+			{"John Doe", "john@doe.com"},
+			{"Jane Doe", "jane@doe.com"},
+			{"Adam Smith", "adam@example.com"},
+		} {
+			user, _ := entity.NewUser(data[0], data[1], "abc123")
+			assert.Nil(t, userService.Create(ctx, user))
+		}
+		emails := func(users []entity.User) []string {
+			var emails []string
+			for _, user := range users {
+				emails = append(emails, user.Email)
+			}
+			return emails
+		}
+
+		users, err := userService.FindAll(ctx, 0, 0, "")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"adam@example.com", "jane@doe.com", "john@doe.com", "zoe@example.com"}, emails(users))
+
+		users, err = userService.FindAll(ctx, 2, 3, "")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"zoe@example.com"}, emails(users))
+
+		// Searched by email or name, whatever the case
+		users, err = userService.FindAll(ctx, 0, 0, "DOE")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"jane@doe.com", "john@doe.com"}, emails(users))
+		users, err = userService.FindAll(ctx, 1, 1, "smith")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"adam@example.com"}, emails(users))
+
+		users, err = userService.FindAll(ctx, 5, 10, "")
+		assert.Nil(t, err)
+		assert.Empty(t, users)
+	})
+
+	t.Run("Update keeps role and state", func(t *testing.T) {
+		userService := newService(t)
+		user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+		assert.Nil(t, userService.Create(ctx, user))
+		userFound, err := userService.FindByID(ctx, user.ID.String())
+		assert.Nil(t, err)
+		assert.False(t, userFound.EmailVerified())
+
+		assert.Nil(t, user.SetRole(entity.RoleAdmin))
+		user.Disable(time.Now())
+		user.RequirePasswordReset()
+		user.VerifyEmail(time.Now())
+		assert.Nil(t, userService.Update(ctx, user, "Role", "DisabledAt", "PasswordResetRequired", "TokenVersion", "EmailVerifiedAt"))
+
+		userFound, err = userService.FindByID(ctx, user.ID.String())
+		assert.Nil(t, err)
+		assert.Equal(t, entity.RoleAdmin, userFound.Role)
+		assert.True(t, userFound.EmailVerified())
+		assert.True(t, userFound.Disabled())
+		assert.True(t, userFound.PasswordResetRequired)
+		assert.Equal(t, 1, userFound.TokenVersion)
+	})
+
+	t.Run("Update keeps the fields changed meanwhile", func(t *testing.T) {
+		userService := newService(t)
+		user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+		assert.Nil(t, userService.Create(ctx, user))
+		stale, err := userService.FindByID(ctx, user.ID.String())
+		assert.Nil(t, err)
+
+		// An admin disables the user and revokes the tokens
+		user.Disable(time.Now())
+		user.RequirePasswordReset()
+		assert.Nil(t, userService.Update(ctx, user, "DisabledAt", "PasswordResetRequired", "TokenVersion"))
+		// While the user changes the name and the password
+		stale.Name = "John Updated"
+		assert.Nil(t, userService.Update(ctx, stale, "Name"))
+		assert.Nil(t, stale.ChangePassword("def456"))
+		assert.Nil(t, userService.Update(ctx, stale, "Password", "PasswordResetRequired", "TokenVersion"))
+
+		// The user is reloaded
+		assert.True(t, stale.Disabled())
+		assert.Equal(t, 2, stale.TokenVersion)
+		userFound, err := userService.FindByID(ctx, user.ID.String())
+		assert.Nil(t, err)
+		assert.Equal(t, "John Updated", userFound.Name)
+		assert.True(t, userFound.ValidatePassword("def456"))
+		assert.True(t, userFound.Disabled())
+		assert.False(t, userFound.PasswordResetRequired)
+		// Both revocations count
+		assert.Equal(t, 2, userFound.TokenVersion)
+	})
+
+	t.Run("UpdateAsAdmin", func(t *testing.T) {
+		userService := newService(t)
+		admin, _ := entity.NewUser("Admin", "admin@doe.com", "abc123")
+		assert.Nil(t, admin.SetRole(entity.RoleAdmin))
+		user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+		assert.Nil(t, userService.Create(ctx, admin))
+		assert.Nil(t, userService.Create(ctx, user))
+
+		user.Disable(time.Now())
+		assert.Nil(t, userService.UpdateAsAdmin(ctx, admin.ID.String(), user, "DisabledAt"))
+		assert.True(t, user.Disabled())
+
+		// Refused once the admin is demoted or disabled
+		admin.Role = entity.RoleUser
+		assert.Nil(t, userService.Update(ctx, admin, "Role"))
+		user.Enable()
+		assert.ErrorIs(t, userService.UpdateAsAdmin(ctx, admin.ID.String(), user, "DisabledAt"), database.ErrNotAdmin)
+		assert.Nil(t, admin.SetRole(entity.RoleAdmin))
+		admin.Disable(time.Now())
+		assert.Nil(t, userService.Update(ctx, admin, "Role", "DisabledAt"))
+		assert.ErrorIs(t, userService.UpdateAsAdmin(ctx, admin.ID.String(), user, "DisabledAt"), database.ErrNotAdmin)
+		assert.ErrorIs(t, userService.UpdateAsAdmin(ctx, entityPkg.NewID().String(), user, "DisabledAt"), database.ErrNotAdmin)
+
+		userFound, err := userService.FindByID(ctx, user.ID.String())
+		assert.Nil(t, err)
+		assert.True(t, userFound.Disabled())
+	})
+
+	t.Run("Update keeps the two-factor authentication", func(t *testing.T) {
+		userService := newService(t)
+		user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+		assert.Nil(t, userService.Create(ctx, user))
+
+		secret, err := user.StartMFA()
+		assert.Nil(t, err)
+		now := time.Now()
+		code, _ := totp.Code(secret, totp.Step(now))
+		recoveryCodes, err := user.EnableMFA(code, now)
+		assert.Nil(t, err)
+		assert.Nil(t, userService.Update(ctx, user, "MFASecret", "MFAEnabledAt", "MFARecoveryCodes", "MFALastStep"))
+
+		userFound, err := userService.FindByID(ctx, user.ID.String())
+		assert.Nil(t, err)
+		assert.True(t, userFound.MFAEnabled())
+		assert.Equal(t, secret, userFound.MFASecret)
+		assert.Equal(t, totp.Step(now), userFound.MFALastStep)
+		// The codes used before are refused
+		_, ok := userFound.VerifyMFA(code, now)
+		assert.False(t, ok)
+		_, ok = userFound.VerifyMFA(recoveryCodes[0], now)
+		assert.True(t, ok)
+		assert.Nil(t, userService.Update(ctx, userFound, "MFARecoveryCodes"))
+
+		userFound, err = userService.FindByID(ctx, user.ID.String())
+		assert.Nil(t, err)
+		assert.Len(t, userFound.MFARecoveryCodes, entity.RecoveryCodeCount-1)
+		_, ok = userFound.VerifyMFA(recoveryCodes[0], now)
+		assert.False(t, ok)
+	})
+
+	t.Run("UpdateMFA", func(t *testing.T) {
+		userService := newService(t)
+		user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+		assert.Nil(t, userService.Create(ctx, user))
+		secret, _ := user.StartMFA()
+		assert.Nil(t, userService.UpdateMFA(ctx, user, "", false))
+		assert.Equal(t, secret, user.MFASecret)
+
+		// Two requests confirming the enrollment, only the first saves it
+		now := time.Now()
+		code, _ := totp.Code(secret, totp.Step(now))
+		second, _ := userService.FindByID(ctx, user.ID.String())
+		_, err := user.EnableMFA(code, now)
+		assert.Nil(t, err)
+		assert.Nil(t, userService.UpdateMFA(ctx, user, secret, false))
+		_, err = second.EnableMFA(code, now)
+		assert.Nil(t, err)
+		assert.ErrorIs(t, userService.UpdateMFA(ctx, second, secret, false), database.ErrNotFound)
+		userFound, err := userService.FindByID(ctx, user.ID.String())
+		assert.Nil(t, err)
+		assert.Equal(t, user.MFARecoveryCodes, userFound.MFARecoveryCodes)
+
+		// A restart read before an admin disabled it doesn't bring it back
+		stale, _ := userService.FindByID(ctx, user.ID.String())
+		user.DisableMFA()
+		assert.Nil(t, userService.UpdateMFA(ctx, user, secret, true))
+		stale.DisableMFA()
+		_, err = stale.StartMFA()
+		assert.Nil(t, err)
+		assert.ErrorIs(t, userService.UpdateMFA(ctx, stale, secret, false), database.ErrNotFound)
+		userFound, err = userService.FindByID(ctx, user.ID.String())
+		assert.Nil(t, err)
+		assert.False(t, userFound.MFAEnabled())
+		assert.Empty(t, userFound.MFASecret)
+	})
+
+	t.Run("UseMFACode", func(t *testing.T) {
+		userService := newService(t)
+		user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+		assert.Nil(t, userService.Create(ctx, user))
+		secret, _ := user.StartMFA()
+		now := time.Now()
+		code, _ := totp.Code(secret, totp.Step(now))
+		recoveryCodes, err := user.EnableMFA(code, now)
+		assert.Nil(t, err)
+		assert.Nil(t, userService.Update(ctx, user, "MFASecret", "MFAEnabledAt", "MFARecoveryCodes", "MFALastStep"))
+
+		// Two requests verifying the same codes, only the first saves them
+		code, _ = totp.Code(secret, totp.Step(now)+1)
+		first, _ := userService.FindByID(ctx, user.ID.String())
+		second, _ := userService.FindByID(ctx, user.ID.String())
+		used, ok := first.VerifyMFA(code, now)
+		assert.True(t, ok)
+		assert.Nil(t, userService.UseMFACode(ctx, user.ID.String(), used))
+		used, ok = second.VerifyMFA(code, now)
+		assert.True(t, ok)
+		assert.ErrorIs(t, userService.UseMFACode(ctx, user.ID.String(), used), database.ErrNotFound)
+		// Nor the codes of the steps before
+		assert.ErrorIs(t, userService.UseMFACode(ctx, user.ID.String(), entity.MFACode{Step: totp.Step(now)}), database.ErrNotFound)
+
+		used, ok = first.VerifyMFA(recoveryCodes[0], now)
+		assert.True(t, ok)
+		assert.Nil(t, userService.UseMFACode(ctx, user.ID.String(), used))
+		used, ok = second.VerifyMFA(recoveryCodes[0], now)
+		assert.True(t, ok)
+		assert.ErrorIs(t, userService.UseMFACode(ctx, user.ID.String(), used), database.ErrNotFound)
+		used, _ = second.VerifyMFA(recoveryCodes[1], now)
+		assert.Nil(t, userService.UseMFACode(ctx, user.ID.String(), used))
+
+		userFound, err := userService.FindByID(ctx, user.ID.String())
+		assert.Nil(t, err)
+		assert.Equal(t, totp.Step(now)+1, userFound.MFALastStep)
+		assert.Equal(t, user.MFARecoveryCodes[2:], userFound.MFARecoveryCodes)
+
+		err = userService.UseMFACode(ctx, entityPkg.NewID().String(), entity.MFACode{Step: totp.Step(now) + 2})
+		assert.ErrorIs(t, err, database.ErrNotFound)
+	})
+}
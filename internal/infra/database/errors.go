@@ -0,0 +1,71 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Errors returned by every implementation of the repository interfaces.
+var (
+	ErrNotFound      = gorm.ErrRecordNotFound
+	ErrDuplicatedKey = gorm.ErrDuplicatedKey
+	// ErrNotAdmin is returned by UserInterface.UpdateAsAdmin when the admin
+	// was demoted, disabled or deleted
+	ErrNotAdmin = errors.New("not an enabled admin")
+)
+
+// Codes of the unique constraint violations of each database. Primary key
+// violations have their own code in SQLite, and are left untranslated.
+const (
+	sqliteConstraintUnique  = 2067
+	postgresUniqueViolation = "23505"
+	mysqlDuplicateEntry     = 1062
+)
+
+// driverError has the fields of the errors of the SQLite, Postgres and MySQL
+// drivers telling a unique constraint was violated. They are read from the
+// error encoded as JSON, like GORM does, so the drivers are not imported.
+type driverError struct {
+	// SQLite
+	ExtendedCode int
+	// Postgres, also an int in SQLite
+	Code json.RawMessage
+	// MySQL
+	Number int
+}
+
+// translateError returns ErrDuplicatedKey when err is a violation of one of
+// the unique indexes, or of any unique constraint if none is given, and err
+// otherwise. The index is found by its name in the message of the error,
+// which every driver includes, so GORM must not be opened with
+// TranslateError, which drops it.
+func translateError(err error, indexes ...string) error {
+	if err == nil || errors.Is(err, ErrDuplicatedKey) {
+		return err
+	}
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		data, marshalErr := json.Marshal(e)
+		if marshalErr != nil {
+			continue
+		}
+		var fields driverError
+		if json.Unmarshal(data, &fields) != nil {
+			continue
+		}
+		if fields.ExtendedCode == sqliteConstraintUnique ||
+			string(fields.Code) == `"`+postgresUniqueViolation+`"` ||
+			fields.Number == mysqlDuplicateEntry {
+			if len(indexes) == 0 || slices.ContainsFunc(indexes, func(index string) bool {
+				return strings.Contains(e.Error(), index)
+			}) {
+				return ErrDuplicatedKey
+			}
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// Errors shaped like the ones of the database drivers.
+type (
+	sqliteError struct {
+		Code         int
+		ExtendedCode int
+		message      string
+	}
+	postgresError struct {
+		Code    string
+		Message string
+	}
+	mysqlError struct {
+		Number  uint16
+		Message string
+	}
+)
+
+func (e sqliteError) Error() string   { return "sqlite error: " + e.message }
+func (e postgresError) Error() string { return e.Message }
+func (e mysqlError) Error() string    { return e.Message }
+
+func TestTranslateError(t *testing.T) {
+	duplicated := []error{
+		gorm.ErrDuplicatedKey,
+		sqliteError{Code: 19, ExtendedCode: 2067},
+		&postgresError{Code: "23505", Message: "duplicate key value violates unique constraint"},
+		&mysqlError{Number: 1062, Message: "Duplicate entry"},
+		fmt.Errorf("creating user: %w", sqliteError{Code: 19, ExtendedCode: 2067}),
+	}
+	for _, err := range duplicated {
+		assert.ErrorIs(t, translateError(err), ErrDuplicatedKey, err.Error())
+	}
+
+	others := []error{
+		errors.New("database is down"),
+		gorm.ErrRecordNotFound,
+		// Primary key violations have their own code in SQLite
+		sqliteError{Code: 19, ExtendedCode: 1555},
+		// Foreign key violations
+		sqliteError{Code: 19, ExtendedCode: 787},
+		&postgresError{Code: "23503"},
+		&mysqlError{Number: 1452},
+	}
+	for _, err := range others {
+		assert.Equal(t, err, translateError(err))
+	}
+	assert.Nil(t, translateError(nil))
+}
+
+func TestTranslateErrorOfIndex(t *testing.T) {
+	duplicated := []error{
+		sqliteError{Code: 19, ExtendedCode: 2067, message: "UNIQUE constraint failed: index 'idx_users_email'"},
+		&postgresError{Code: "23505", Message: `duplicate key value violates unique constraint "idx_users_email"`},
+		&mysqlError{Number: 1062, Message: "Duplicate entry 'john@doe.com' for key 'users.idx_users_email'"},
+	}
+	for _, err := range duplicated {
+		assert.ErrorIs(t, translateError(err, "idx_users_email"), ErrDuplicatedKey, err.Error())
+	}
+
+	// Other unique indexes, such as the primary key in Postgres
+	others := []error{
+		sqliteError{Code: 19, ExtendedCode: 2067, message: "UNIQUE constraint failed: users.id"},
+		&postgresError{Code: "23505", Message: `duplicate key value violates unique constraint "users_pkey"`},
+		&mysqlError{Number: 1062, Message: "Duplicate entry 'abc' for key 'users.PRIMARY'"},
+	}
+	for _, err := range others {
+		assert.Equal(t, err, translateError(err, "idx_users_email"), err.Error())
+	}
+}
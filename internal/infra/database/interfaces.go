@@ -1,16 +1,123 @@
 package database
 
-import "goexpert-api/internal/entity"
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"time"
+)
 
 type UserInterface interface {
-	Create(user *entity.User) error
-	FindByEmail(email string) (*entity.User, error)
+	Create(ctx context.Context, user *entity.User) error
+	FindByID(ctx context.Context, id string) (*entity.User, error)
+	FindByEmail(ctx context.Context, email string) (*entity.User, error)
+	// FindAll returns the users ordered by email, only the ones whose name or
+	// email contain search if it is not empty. Pages start at 1, and a zero
+	// page or limit returns every user.
+	FindAll(ctx context.Context, page, limit int, search string) ([]entity.User, error)
+	// Update saves the fields of user, named as in entity.User, and reloads
+	// it. The other columns are left as they are, so changes made to them
+	// meanwhile, such as an admin disabling the user, are not undone.
+	// TokenVersion is incremented rather than overwritten, so no revocation
+	// is lost.
+	Update(ctx context.Context, user *entity.User, fields ...string) error
+	// UpdateAsAdmin is Update made by the admin of adminID, only while it is
+	// an enabled admin. It returns ErrNotAdmin otherwise, so an admin demoted
+	// or disabled meanwhile changes nothing.
+	UpdateAsAdmin(ctx context.Context, adminID string, user *entity.User, fields ...string) error
+	// UpdateMFA saves the two-factor authentication of user, entity.MFAFields,
+	// only while its secret and whether it is enabled are still secret and
+	// enabled, as read before the change. It returns ErrNotFound otherwise,
+	// so a concurrent change, such as an admin disabling it, is not undone.
+	UpdateMFA(ctx context.Context, user *entity.User, secret string, enabled bool) error
+	// UseMFACode saves the second factor used by the user, the TOTP step as
+	// the last one or the recovery code removed, without emitting an event.
+	// It returns ErrNotFound if the step is not after the last one or the
+	// user has no such recovery code, so concurrent requests can't use a code
+	// twice.
+	UseMFACode(ctx context.Context, id string, code entity.MFACode) error
+	Delete(ctx context.Context, id string) error
 }
 
 type ProductInterface interface {
-	Create(product *entity.Product) error
-	FindAll(page, limit int, sort string) ([]entity.Product, error)
-	FindByID(id string) (*entity.Product, error)
-	Update(product *entity.Product) error
-	Delete(id string) error
+	Create(ctx context.Context, product *entity.Product) error
+	FindAll(ctx context.Context, page, limit int, sort string) ([]entity.Product, error)
+	FindByID(ctx context.Context, id string) (*entity.Product, error)
+	Update(ctx context.Context, product *entity.Product) error
+	Delete(ctx context.Context, id string) error
+}
+
+// Repositories are the repositories bound to a transaction.
+type Repositories struct {
+	Products       ProductInterface
+	Users          UserInterface
+	Outbox         OutboxInterface
+	PasswordResets PasswordResetInterface
+}
+
+type TransactionManager interface {
+	// Run calls fn with repositories that share a transaction, committed when
+	// fn returns nil and rolled back when it returns an error or panics. Calls
+	// made with the ctx given to fn are nested in a savepoint.
+	Run(ctx context.Context, fn func(ctx context.Context, repos Repositories) error) error
+}
+
+type OutboxInterface interface {
+	Add(ctx context.Context, events ...*entity.Event) error
+	Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]OutboxMessage, error)
+	MarkPublished(ctx context.Context, id string, at time.Time) error
+	MarkFailed(ctx context.Context, id string, cause error, nextAttemptAt time.Time) error
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+type WebhookInterface interface {
+	Create(ctx context.Context, webhook *entity.Webhook) error
+	FindByID(ctx context.Context, id string) (*entity.Webhook, error)
+	FindByOwner(ctx context.Context, ownerID string) ([]entity.Webhook, error)
+	FindActiveByEventType(ctx context.Context, eventType string) ([]entity.Webhook, error)
+	Update(ctx context.Context, webhook *entity.Webhook) error
+	Delete(ctx context.Context, id string) error
+	RecordFailure(ctx context.Context, id string, disableAfter int, now time.Time) (bool, error)
+	RecordSuccess(ctx context.Context, id string) error
+	AddDeliveries(ctx context.Context, deliveries ...*entity.WebhookDelivery) error
+	ClaimDeliveries(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]entity.WebhookDelivery, error)
+	UpdateDelivery(ctx context.Context, delivery *entity.WebhookDelivery) error
+	FindDeliveries(ctx context.Context, webhookID string, page, limit int) ([]entity.WebhookDelivery, error)
+}
+
+type PasswordResetInterface interface {
+	Create(ctx context.Context, reset *entity.PasswordReset) error
+	FindByTokenHash(ctx context.Context, tokenHash string) (*entity.PasswordReset, error)
+	// Use marks the reset as used at now. It returns ErrNotFound if the reset
+	// was already used, so concurrent requests can't use a token twice.
+	Use(ctx context.Context, id string, now time.Time) error
+}
+
+type APIKeyInterface interface {
+	Create(ctx context.Context, apiKey *entity.APIKey) error
+	FindByID(ctx context.Context, id string) (*entity.APIKey, error)
+	FindByPrefix(ctx context.Context, prefix string) (*entity.APIKey, error)
+	// FindByUser returns the keys of the user, revoked ones included, in the
+	// order they were created.
+	FindByUser(ctx context.Context, userID string) ([]entity.APIKey, error)
+	// Revoke marks the key as revoked at now. It returns ErrNotFound if the key
+	// doesn't exist or was already revoked.
+	Revoke(ctx context.Context, id string, now time.Time) error
+	// UpdateLastUsed records that the key was used at now, changing nothing
+	// else, so it doesn't undo a concurrent Revoke.
+	UpdateLastUsed(ctx context.Context, id string, now time.Time) error
+}
+
+type OAuthClientInterface interface {
+	Create(ctx context.Context, client *entity.OAuthClient) error
+	FindByID(ctx context.Context, id string) (*entity.OAuthClient, error)
+	// FindAll returns the clients in the order they were created.
+	FindAll(ctx context.Context) ([]entity.OAuthClient, error)
+	Delete(ctx context.Context, id string) error
+}
+
+type RevokedTokenInterface interface {
+	// Revoke refuses the token jti until expiresAt, when it expires anyway.
+	// Tokens that expired before now are purged.
+	Revoke(ctx context.Context, jti string, expiresAt, now time.Time) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
 }
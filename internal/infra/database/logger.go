@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	gormLogger "gorm.io/gorm/logger"
+)
+
+const slowQueryThreshold = 200 * time.Millisecond
+
+// GormLogger sends GORM logs to a slog.Logger. Queries are logged at debug
+// level, slow queries as warnings and failed queries as errors.
+type GormLogger struct {
+	Logger *slog.Logger
+	Level  gormLogger.LogLevel
+}
+
+func NewGormLogger(logger *slog.Logger) *GormLogger {
+	return &GormLogger{Logger: logger, Level: gormLogger.Info}
+}
+
+func (l *GormLogger) LogMode(level gormLogger.LogLevel) gormLogger.Interface {
+	newLogger := *l
+	newLogger.Level = level
+	return &newLogger
+}
+
+func (l *GormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if l.Level >= gormLogger.Info {
+		l.Logger.InfoContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l *GormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if l.Level >= gormLogger.Warn {
+		l.Logger.WarnContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l *GormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if l.Level >= gormLogger.Error {
+		l.Logger.ErrorContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l *GormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.Level <= gormLogger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	sql, rows := fc()
+	attrs := []any{"sql", sql, "rows", rows, "duration", elapsed}
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.Level >= gormLogger.Error:
+		l.Logger.ErrorContext(ctx, "query failed", append(attrs, "error", err)...)
+	case elapsed > slowQueryThreshold && l.Level >= gormLogger.Warn:
+		l.Logger.WarnContext(ctx, "slow query", attrs...)
+	case l.Level >= gormLogger.Info:
+		l.Logger.DebugContext(ctx, "query", attrs...)
+	}
+}
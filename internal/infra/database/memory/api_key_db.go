@@ -0,0 +1,105 @@
+package memory
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"slices"
+	"sync"
+	"time"
+)
+
+// APIKeyService is a database.APIKeyInterface kept in memory, safe for
+// concurrent use.
+type APIKeyService struct {
+	mu      sync.Mutex
+	apiKeys []entity.APIKey
+}
+
+func NewAPIKeyService() *APIKeyService {
+	return &APIKeyService{}
+}
+
+func (s *APIKeyService) Create(ctx context.Context, apiKey *entity.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.apiKeys {
+		if existing.ID == apiKey.ID || existing.Prefix == apiKey.Prefix {
+			return database.ErrDuplicatedKey
+		}
+	}
+	s.apiKeys = append(s.apiKeys, cloneAPIKey(*apiKey))
+	return nil
+}
+
+func (s *APIKeyService) FindByID(ctx context.Context, id string) (*entity.APIKey, error) {
+	return s.find(func(apiKey *entity.APIKey) bool { return apiKey.ID.String() == id })
+}
+
+func (s *APIKeyService) FindByPrefix(ctx context.Context, prefix string) (*entity.APIKey, error) {
+	return s.find(func(apiKey *entity.APIKey) bool { return apiKey.Prefix == prefix })
+}
+
+func (s *APIKeyService) FindByUser(ctx context.Context, userID string) ([]entity.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var apiKeys []entity.APIKey
+	for _, apiKey := range s.apiKeys {
+		if apiKey.UserID == userID {
+			apiKeys = append(apiKeys, cloneAPIKey(apiKey))
+		}
+	}
+	return apiKeys, nil
+}
+
+func (s *APIKeyService) Revoke(ctx context.Context, id string, now time.Time) error {
+	return s.update(id, func(apiKey *entity.APIKey) bool {
+		if apiKey.RevokedAt != nil {
+			return false
+		}
+		apiKey.RevokedAt = &now
+		return true
+	})
+}
+
+func (s *APIKeyService) UpdateLastUsed(ctx context.Context, id string, now time.Time) error {
+	return s.update(id, func(apiKey *entity.APIKey) bool {
+		apiKey.LastUsedAt = &now
+		return true
+	})
+}
+
+func (s *APIKeyService) find(match func(*entity.APIKey) bool) (*entity.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.apiKeys {
+		if match(&s.apiKeys[i]) {
+			apiKey := cloneAPIKey(s.apiKeys[i])
+			return &apiKey, nil
+		}
+	}
+	return nil, database.ErrNotFound
+}
+
+// update calls fn with the key of id, returning ErrNotFound if there is none
+// or fn returns false.
+func (s *APIKeyService) update(id string, fn func(*entity.APIKey) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.apiKeys {
+		if s.apiKeys[i].ID.String() == id {
+			if !fn(&s.apiKeys[i]) {
+				return database.ErrNotFound
+			}
+			return nil
+		}
+	}
+	return database.ErrNotFound
+}
+
+// cloneAPIKey copies the scopes, so callers don't share them with the stored
+// key.
+func cloneAPIKey(apiKey entity.APIKey) entity.APIKey {
+	apiKey.Scopes = slices.Clone(apiKey.Scopes)
+	return apiKey
+}
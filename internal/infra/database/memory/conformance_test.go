@@ -0,0 +1,43 @@
+package memory
+
+import (
+	"goexpert-api/internal/infra/database"
+	"goexpert-api/internal/infra/database/databasetest"
+	"testing"
+)
+
+func TestProductServiceConformance(t *testing.T) {
+	databasetest.TestProductInterface(t, func(t *testing.T) database.ProductInterface {
+		return NewProductService()
+	})
+}
+
+func TestUserServiceConformance(t *testing.T) {
+	databasetest.TestUserInterface(t, func(t *testing.T) database.UserInterface {
+		return NewUserService()
+	})
+}
+
+func TestPasswordResetServiceConformance(t *testing.T) {
+	databasetest.TestPasswordResetInterface(t, func(t *testing.T) database.PasswordResetInterface {
+		return NewPasswordResetService()
+	})
+}
+
+func TestAPIKeyServiceConformance(t *testing.T) {
+	databasetest.TestAPIKeyInterface(t, func(t *testing.T) database.APIKeyInterface {
+		return NewAPIKeyService()
+	})
+}
+
+func TestOAuthClientServiceConformance(t *testing.T) {
+	databasetest.TestOAuthClientInterface(t, func(t *testing.T) database.OAuthClientInterface {
+		return NewOAuthClientService()
+	})
+}
+
+func TestRevokedTokenServiceConformance(t *testing.T) {
+	databasetest.TestRevokedTokenInterface(t, func(t *testing.T) database.RevokedTokenInterface {
+		return NewRevokedTokenService()
+	})
+}
@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"slices"
+	"sync"
+)
+
+// OAuthClientService is a database.OAuthClientInterface kept in memory, safe
+// for concurrent use.
+type OAuthClientService struct {
+	mu      sync.Mutex
+	clients []entity.OAuthClient
+}
+
+func NewOAuthClientService() *OAuthClientService {
+	return &OAuthClientService{}
+}
+
+func (s *OAuthClientService) Create(ctx context.Context, client *entity.OAuthClient) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.clients {
+		if existing.ID == client.ID {
+			return database.ErrDuplicatedKey
+		}
+	}
+	s.clients = append(s.clients, cloneOAuthClient(*client))
+	return nil
+}
+
+func (s *OAuthClientService) FindByID(ctx context.Context, id string) (*entity.OAuthClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, client := range s.clients {
+		if client.ID.String() == id {
+			client = cloneOAuthClient(client)
+			return &client, nil
+		}
+	}
+	return nil, database.ErrNotFound
+}
+
+func (s *OAuthClientService) FindAll(ctx context.Context) ([]entity.OAuthClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var clients []entity.OAuthClient
+	for _, client := range s.clients {
+		clients = append(clients, cloneOAuthClient(client))
+	}
+	return clients, nil
+}
+
+func (s *OAuthClientService) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, client := range s.clients {
+		if client.ID.String() == id {
+			s.clients = slices.Delete(s.clients, i, i+1)
+			return nil
+		}
+	}
+	return database.ErrNotFound
+}
+
+// cloneOAuthClient copies the scopes, so callers don't share them with the
+// stored client.
+func cloneOAuthClient(client entity.OAuthClient) entity.OAuthClient {
+	client.Scopes = slices.Clone(client.Scopes)
+	return client
+}
@@ -0,0 +1,55 @@
+package memory
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"sync"
+	"time"
+)
+
+// PasswordResetService is a database.PasswordResetInterface kept in memory,
+// safe for concurrent use.
+type PasswordResetService struct {
+	mu     sync.Mutex
+	resets map[string]entity.PasswordReset
+}
+
+func NewPasswordResetService() *PasswordResetService {
+	return &PasswordResetService{resets: make(map[string]entity.PasswordReset)}
+}
+
+func (s *PasswordResetService) Create(ctx context.Context, reset *entity.PasswordReset) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, existing := range s.resets {
+		if id == reset.ID.String() || existing.TokenHash == reset.TokenHash {
+			return database.ErrDuplicatedKey
+		}
+	}
+	s.resets[reset.ID.String()] = *reset
+	return nil
+}
+
+func (s *PasswordResetService) FindByTokenHash(ctx context.Context, tokenHash string) (*entity.PasswordReset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, reset := range s.resets {
+		if reset.TokenHash == tokenHash {
+			return &reset, nil
+		}
+	}
+	return nil, database.ErrNotFound
+}
+
+func (s *PasswordResetService) Use(ctx context.Context, id string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reset, ok := s.resets[id]
+	if !ok || reset.UsedAt != nil {
+		return database.ErrNotFound
+	}
+	reset.UsedAt = &now
+	s.resets[id] = reset
+	return nil
+}
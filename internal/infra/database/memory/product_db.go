@@ -0,0 +1,102 @@
+package memory
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"sort"
+	"sync"
+)
+
+// ProductService is a database.ProductInterface kept in memory, safe for
+// concurrent use.
+type ProductService struct {
+	mu       sync.RWMutex
+	products []entity.Product
+}
+
+func NewProductService() *ProductService {
+	return &ProductService{}
+}
+
+func (p *ProductService) Create(ctx context.Context, product *entity.Product) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.indexOf(product.ID.String()) >= 0 {
+		return database.ErrDuplicatedKey
+	}
+	p.products = append(p.products, *product)
+	return nil
+}
+
+func (p *ProductService) FindByID(ctx context.Context, id string) (*entity.Product, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	i := p.indexOf(id)
+	if i < 0 {
+		return nil, database.ErrNotFound
+	}
+	product := p.products[i]
+	return &product, nil
+}
+
+func (p *ProductService) Update(ctx context.Context, product *entity.Product) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := p.indexOf(product.ID.String())
+	if i < 0 {
+		return database.ErrNotFound
+	}
+	p.products[i] = *product
+	return nil
+}
+
+func (p *ProductService) Delete(ctx context.Context, id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := p.indexOf(id)
+	if i < 0 {
+		return database.ErrNotFound
+	}
+	p.products = append(p.products[:i], p.products[i+1:]...)
+	return nil
+}
+
+func (p *ProductService) FindAll(ctx context.Context, page, limit int, sortOrder string) ([]entity.Product, error) {
+	p.mu.RLock()
+	products := make([]entity.Product, len(p.products))
+	copy(products, p.products)
+	p.mu.RUnlock()
+
+	// Products are kept in insertion order, so a stable sort keeps it for
+	// products created at the same time
+	sort.SliceStable(products, func(i, j int) bool {
+		if sortOrder == "desc" {
+			return products[i].CreatedAt.After(products[j].CreatedAt)
+		}
+		return products[i].CreatedAt.Before(products[j].CreatedAt)
+	})
+	if page != 0 && limit != 0 {
+		offset := (page - 1) * limit
+		if offset < 0 {
+			offset = 0
+		}
+		if offset > len(products) {
+			offset = len(products)
+		}
+		products = products[offset:]
+		if limit > 0 && limit < len(products) {
+			products = products[:limit]
+		}
+	}
+	return products, nil
+}
+
+func (p *ProductService) indexOf(id string) int {
+	for i, product := range p.products {
+		if product.ID.String() == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,47 @@
+package memory
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProductServiceConcurrentAccess(t *testing.T) {
+	productService := NewProductService()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			product, _ := entity.NewProduct("Product", 10)
+			assert.Nil(t, productService.Create(ctx, product))
+			_, err := productService.FindAll(ctx, 1, 10, "asc")
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+
+	products, err := productService.FindAll(ctx, 0, 0, "")
+	assert.Nil(t, err)
+	assert.Len(t, products, 50)
+}
+
+func TestProductServiceReturnsCopies(t *testing.T) {
+	productService := NewProductService()
+	ctx := context.Background()
+	product, _ := entity.NewProduct("Product 1", 10)
+	assert.Nil(t, productService.Create(ctx, product))
+
+	product.Name = "Changed outside"
+	productFound, _ := productService.FindByID(ctx, product.ID.String())
+	assert.Equal(t, "Product 1", productFound.Name)
+
+	productFound.Name = "Changed again"
+	productFound, _ = productService.FindByID(ctx, product.ID.String())
+	assert.Equal(t, "Product 1", productFound.Name)
+}
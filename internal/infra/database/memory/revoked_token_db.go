@@ -0,0 +1,40 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RevokedTokenService is a database.RevokedTokenInterface kept in memory,
+// safe for concurrent use.
+type RevokedTokenService struct {
+	mu sync.Mutex
+	// Expiration of the revoked tokens by jti
+	tokens map[string]time.Time
+}
+
+func NewRevokedTokenService() *RevokedTokenService {
+	return &RevokedTokenService{tokens: make(map[string]time.Time)}
+}
+
+func (s *RevokedTokenService) Revoke(ctx context.Context, jti string, expiresAt, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for existing, existingExpiresAt := range s.tokens {
+		if existingExpiresAt.Before(now) {
+			delete(s.tokens, existing)
+		}
+	}
+	if _, ok := s.tokens[jti]; !ok {
+		s.tokens[jti] = expiresAt
+	}
+	return nil
+}
+
+func (s *RevokedTokenService) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.tokens[jti]
+	return ok, nil
+}
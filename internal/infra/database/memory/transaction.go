@@ -0,0 +1,21 @@
+package memory
+
+import (
+	"context"
+	"goexpert-api/internal/infra/database"
+)
+
+// TransactionManager is a database.TransactionManager for the repositories
+// kept in memory. It calls fn with Repositories but rolls nothing back, so it
+// is only meant for tests that don't depend on atomicity.
+type TransactionManager struct {
+	Repositories database.Repositories
+}
+
+func NewTransactionManager(repos database.Repositories) *TransactionManager {
+	return &TransactionManager{Repositories: repos}
+}
+
+func (m *TransactionManager) Run(ctx context.Context, fn func(ctx context.Context, repos database.Repositories) error) error {
+	return fn(ctx, m.Repositories)
+}
@@ -0,0 +1,166 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var errDuplicatedID = errors.New("duplicated user id")
+
+// UserService is a database.UserInterface kept in memory, safe for concurrent
+// use.
+type UserService struct {
+	mu    sync.RWMutex
+	users map[string]entity.User
+}
+
+func NewUserService() *UserService {
+	return &UserService{users: make(map[string]entity.User)}
+}
+
+func (u *UserService) Create(ctx context.Context, user *entity.User) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	// ErrDuplicatedKey is only for the emails, like the database does
+	if _, ok := u.users[user.ID.String()]; ok {
+		return errDuplicatedID
+	}
+	for _, existing := range u.users {
+		if strings.EqualFold(existing.Email, user.Email) {
+			return database.ErrDuplicatedKey
+		}
+	}
+	u.users[user.ID.String()] = *user
+	return nil
+}
+
+func (u *UserService) FindByID(ctx context.Context, id string) (*entity.User, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	user, ok := u.users[id]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	return &user, nil
+}
+
+func (u *UserService) FindByEmail(ctx context.Context, email string) (*entity.User, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	for _, user := range u.users {
+		if strings.EqualFold(user.Email, entity.NormalizeEmail(email)) {
+			return &user, nil
+		}
+	}
+	return nil, database.ErrNotFound
+}
+
+func (u *UserService) FindAll(ctx context.Context, page, limit int, search string) ([]entity.User, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	search = strings.ToLower(strings.TrimSpace(search))
+	users := make([]entity.User, 0, len(u.users))
+	for _, user := range u.users {
+		if strings.Contains(strings.ToLower(user.Email), search) || strings.Contains(strings.ToLower(user.Name), search) {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Email < users[j].Email })
+	if page != 0 && limit != 0 {
+		start := min((page-1)*limit, len(users))
+		end := min(start+limit, len(users))
+		users = users[start:end]
+	}
+	return users, nil
+}
+
+func (u *UserService) Update(ctx context.Context, user *entity.User, fields ...string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.update(user, fields)
+}
+
+func (u *UserService) UpdateAsAdmin(ctx context.Context, adminID string, user *entity.User, fields ...string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	admin, ok := u.users[adminID]
+	if !ok || admin.Role != entity.RoleAdmin || admin.Disabled() {
+		return database.ErrNotAdmin
+	}
+	return u.update(user, fields)
+}
+
+func (u *UserService) UpdateMFA(ctx context.Context, user *entity.User, secret string, enabled bool) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	stored, ok := u.users[user.ID.String()]
+	if !ok || stored.MFASecret != secret || stored.MFAEnabled() != enabled {
+		return database.ErrNotFound
+	}
+	return u.update(user, entity.MFAFields)
+}
+
+// update saves fields of user, with the lock held.
+func (u *UserService) update(user *entity.User, fields []string) error {
+	stored, ok := u.users[user.ID.String()]
+	if !ok {
+		return database.ErrNotFound
+	}
+	for id, existing := range u.users {
+		if id != user.ID.String() && slices.Contains(fields, "Email") && strings.EqualFold(existing.Email, user.Email) {
+			return database.ErrDuplicatedKey
+		}
+	}
+	source := reflect.ValueOf(user).Elem()
+	target := reflect.ValueOf(&stored).Elem()
+	for _, field := range fields {
+		if field == "TokenVersion" {
+			stored.TokenVersion++
+			continue
+		}
+		target.FieldByName(field).Set(source.FieldByName(field))
+	}
+	u.users[user.ID.String()] = stored
+	*user = stored
+	return nil
+}
+
+func (u *UserService) UseMFACode(ctx context.Context, id string, code entity.MFACode) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	user, ok := u.users[id]
+	if !ok {
+		return database.ErrNotFound
+	}
+	if code.RecoveryCodeHash != "" {
+		i := slices.Index(user.MFARecoveryCodes, code.RecoveryCodeHash)
+		if i < 0 {
+			return database.ErrNotFound
+		}
+		user.MFARecoveryCodes = slices.Delete(slices.Clone(user.MFARecoveryCodes), i, i+1)
+	} else {
+		if code.Step <= user.MFALastStep {
+			return database.ErrNotFound
+		}
+		user.MFALastStep = code.Step
+	}
+	u.users[id] = user
+	return nil
+}
+
+func (u *UserService) Delete(ctx context.Context, id string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, ok := u.users[id]; !ok {
+		return database.ErrNotFound
+	}
+	delete(u.users, id)
+	return nil
+}
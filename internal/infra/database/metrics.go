@@ -0,0 +1,51 @@
+package database
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+const metricsStartKey = "metrics:start"
+
+// MetricsPlugin is a GORM plugin that observes the duration of every query.
+type MetricsPlugin struct {
+	QueryDuration *prometheus.HistogramVec
+}
+
+func NewMetricsPlugin(queryDuration *prometheus.HistogramVec) *MetricsPlugin {
+	return &MetricsPlugin{QueryDuration: queryDuration}
+}
+
+func (p *MetricsPlugin) Name() string {
+	return "metrics"
+}
+
+func (p *MetricsPlugin) Initialize(db *gorm.DB) error {
+	return registerCallbacks(db, p.Name(), p.before, p.after)
+}
+
+func (p *MetricsPlugin) before(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		db.InstanceSet(metricsStartKey, time.Now())
+	}
+}
+
+func (p *MetricsPlugin) after(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(metricsStartKey)
+		if !ok {
+			return
+		}
+		start, ok := value.(time.Time)
+		if !ok {
+			return
+		}
+		table := db.Statement.Table
+		if table == "" {
+			table = "unknown"
+		}
+		p.QueryDuration.WithLabelValues(operation, table).Observe(time.Since(start).Seconds())
+	}
+}
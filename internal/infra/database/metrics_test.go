@@ -0,0 +1,30 @@
+package database
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/metrics"
+	"log/slog"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsPluginObservesQueries(t *testing.T) {
+	db, teardownTest := setupTestCase(t)
+	defer teardownTest()
+
+	m := metrics.New()
+	err := db.Use(NewMetricsPlugin(m.DBQueryDuration))
+	assert.Nil(t, err)
+
+	productService := NewProductService(db, slog.Default())
+	product, _ := entity.NewProduct("Product 1", 10)
+	assert.Nil(t, productService.Create(context.Background(), product))
+	_, err = productService.FindByID(context.Background(), product.ID.String())
+	assert.Nil(t, err)
+
+	// Creating a product also inserts its event in the outbox
+	assert.Equal(t, 3, testutil.CollectAndCount(m.DBQueryDuration))
+}
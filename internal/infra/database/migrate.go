@@ -0,0 +1,46 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"goexpert-api/internal/entity"
+
+	"gorm.io/gorm"
+)
+
+// ErrDuplicatedEmails is returned by Migrate when users have the same email in
+// different cases, which the old unique constraint allowed and the index on
+// lower(email) refuses. The accounts may belong to different people, so they
+// are merged or changed by hand.
+var ErrDuplicatedEmails = errors.New("emails registered more than once in different cases")
+
+// Migrate creates or updates the tables of models with AutoMigrate, first
+// checking the users can have the unique index on their emails.
+func Migrate(db *gorm.DB, models ...any) error {
+	if err := checkDuplicatedEmails(db); err != nil {
+		return err
+	}
+	return db.AutoMigrate(models...)
+}
+
+// checkDuplicatedEmails returns ErrDuplicatedEmails when the users table
+// exists without the index on the emails, and has emails registered more than
+// once.
+func checkDuplicatedEmails(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&entity.User{}) || migrator.HasIndex(&entity.User{}, emailIndex) {
+		return nil
+	}
+	var emails []string
+	err := db.Model(&entity.User{}).
+		Group("lower(email)").
+		Having("COUNT(*) > 1").
+		Pluck("lower(email)", &emails).Error
+	if err != nil {
+		return err
+	}
+	if len(emails) > 0 {
+		return fmt.Errorf("%w: %d emails", ErrDuplicatedEmails, len(emails))
+	}
+	return nil
+}
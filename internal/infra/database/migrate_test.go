@@ -0,0 +1,39 @@
+package database
+
+import (
+	"goexpert-api/internal/entity"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// legacyUser is the users table before the index on lower(email).
+type legacyUser struct {
+	ID    string `gorm:"primaryKey"`
+	Name  string
+	Email string `gorm:"unique"`
+}
+
+func (legacyUser) TableName() string { return "users" }
+
+func TestMigrateWithDuplicatedEmails(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, db.AutoMigrate(&legacyUser{}))
+	assert.Nil(t, db.Create(&legacyUser{ID: "1", Name: "John Doe", Email: "john@doe.com"}).Error)
+	assert.Nil(t, db.Create(&legacyUser{ID: "2", Name: "John Doe", Email: "John@Doe.com"}).Error)
+
+	err = Migrate(db, &entity.User{}, &OutboxMessage{})
+	assert.ErrorIs(t, err, ErrDuplicatedEmails)
+	assert.False(t, db.Migrator().HasIndex(&entity.User{}, emailIndex))
+
+	assert.Nil(t, db.Delete(&legacyUser{ID: "2"}).Error)
+	assert.Nil(t, Migrate(db, &entity.User{}, &OutboxMessage{}))
+	assert.True(t, db.Migrator().HasIndex(&entity.User{}, emailIndex))
+	// Checked only until the index exists
+	assert.Nil(t, Migrate(db, &entity.User{}, &OutboxMessage{}))
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+type OAuthClientService struct {
+	DB     *gorm.DB
+	Logger *slog.Logger
+}
+
+func NewOAuthClientService(db *gorm.DB, logger *slog.Logger) *OAuthClientService {
+	return &OAuthClientService{DB: db, Logger: logger}
+}
+
+func (s *OAuthClientService) Create(ctx context.Context, client *entity.OAuthClient) (err error) {
+	ctx, span := tracer.Start(ctx, "OAuthClientService.Create", trace.WithAttributes(
+		attribute.String("oauth_client.id", client.ID.String()),
+	))
+	defer func() { endSpan(span, err) }()
+
+	err = translateError(conn(ctx, s.DB).Create(client).Error)
+	if err != nil {
+		s.Logger.ErrorContext(ctx, "error creating oauth client", "id", client.ID.String(), "error", err)
+	}
+	return err
+}
+
+func (s *OAuthClientService) FindByID(ctx context.Context, id string) (_ *entity.OAuthClient, err error) {
+	ctx, span := tracer.Start(ctx, "OAuthClientService.FindByID", trace.WithAttributes(
+		attribute.String("oauth_client.id", id),
+	))
+	defer func() { endSpan(span, err) }()
+
+	var client entity.OAuthClient
+	err = conn(ctx, s.DB).Where("id = ?", id).First(&client).Error
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (s *OAuthClientService) FindAll(ctx context.Context) (_ []entity.OAuthClient, err error) {
+	ctx, span := tracer.Start(ctx, "OAuthClientService.FindAll")
+	defer func() { endSpan(span, err) }()
+
+	var clients []entity.OAuthClient
+	err = conn(ctx, s.DB).Order("created_at, id").Find(&clients).Error
+	return clients, err
+}
+
+func (s *OAuthClientService) Delete(ctx context.Context, id string) (err error) {
+	ctx, span := tracer.Start(ctx, "OAuthClientService.Delete", trace.WithAttributes(
+		attribute.String("oauth_client.id", id),
+	))
+	defer func() { endSpan(span, err) }()
+
+	result := conn(ctx, s.DB).Where("id = ?", id).Delete(&entity.OAuthClient{})
+	if result.Error != nil {
+		s.Logger.ErrorContext(ctx, "error deleting oauth client", "id", id, "error", result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// OutboxMessage is an event stored in the outbox table until it is published.
+// Times are stored in UTC so they can be compared by the database.
+type OutboxMessage struct {
+	entity.Event  `gorm:"embedded"`
+	Attempts      int
+	NextAttemptAt time.Time  `gorm:"index"`
+	PublishedAt   *time.Time `gorm:"index"`
+	LastError     string
+}
+
+func newOutboxMessage(event *entity.Event) *OutboxMessage {
+	return &OutboxMessage{Event: *event, NextAttemptAt: event.OccurredAt.UTC()}
+}
+
+// addEvent records an event in the outbox through tx, the transaction of the
+// change it describes.
+func addEvent(tx *gorm.DB, eventType, aggregateID string, payload any) error {
+	event, err := entity.NewEvent(eventType, aggregateID, payload)
+	if err != nil {
+		return err
+	}
+	return tx.Create(newOutboxMessage(event)).Error
+}
+
+type OutboxService struct {
+	DB     *gorm.DB
+	Logger *slog.Logger
+}
+
+func NewOutboxService(db *gorm.DB, logger *slog.Logger) *OutboxService {
+	return &OutboxService{DB: db, Logger: logger}
+}
+
+func (o *OutboxService) Add(ctx context.Context, events ...*entity.Event) (err error) {
+	ctx, span := tracer.Start(ctx, "OutboxService.Add", trace.WithAttributes(
+		attribute.Int("events", len(events)),
+	))
+	defer func() { endSpan(span, err) }()
+
+	messages := make([]*OutboxMessage, len(events))
+	for i, event := range events {
+		messages[i] = newOutboxMessage(event)
+	}
+	return conn(ctx, o.DB).Create(messages).Error
+}
+
+// Claim returns the oldest unpublished messages due at now, counting a new
+// attempt for each one. Claimed messages are not returned again before lease
+// is over, so several relays can share the outbox.
+func (o *OutboxService) Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) (_ []OutboxMessage, err error) {
+	ctx, span := tracer.Start(ctx, "OutboxService.Claim")
+	defer func() { endSpan(span, err) }()
+
+	now = now.UTC()
+	var candidates []OutboxMessage
+	err = conn(ctx, o.DB).
+		Where("published_at IS NULL AND next_attempt_at <= ?", now).
+		Order("occurred_at").
+		Limit(limit).
+		Find(&candidates).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := candidates[:0]
+	leaseUntil := now.Add(lease)
+	for _, message := range candidates {
+		// Only one relay updates the message if several claim it at once
+		result := conn(ctx, o.DB).
+			Model(&OutboxMessage{}).
+			Where("id = ? AND published_at IS NULL AND next_attempt_at <= ?", message.ID, now).
+			Updates(map[string]any{
+				"attempts":        gorm.Expr("attempts + 1"),
+				"next_attempt_at": leaseUntil,
+			})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			message.Attempts++
+			message.NextAttemptAt = leaseUntil
+			claimed = append(claimed, message)
+		}
+	}
+	span.SetAttributes(attribute.Int("claimed", len(claimed)))
+	return claimed, nil
+}
+
+func (o *OutboxService) MarkPublished(ctx context.Context, id string, at time.Time) (err error) {
+	ctx, span := tracer.Start(ctx, "OutboxService.MarkPublished", trace.WithAttributes(
+		attribute.String("event.id", id),
+	))
+	defer func() { endSpan(span, err) }()
+
+	return conn(ctx, o.DB).
+		Model(&OutboxMessage{}).
+		Where("id = ?", id).
+		Updates(map[string]any{"published_at": at.UTC(), "last_error": ""}).
+		Error
+}
+
+func (o *OutboxService) MarkFailed(ctx context.Context, id string, cause error, nextAttemptAt time.Time) (err error) {
+	ctx, span := tracer.Start(ctx, "OutboxService.MarkFailed", trace.WithAttributes(
+		attribute.String("event.id", id),
+	))
+	defer func() { endSpan(span, err) }()
+
+	return conn(ctx, o.DB).
+		Model(&OutboxMessage{}).
+		Where("id = ?", id).
+		Updates(map[string]any{"last_error": cause.Error(), "next_attempt_at": nextAttemptAt.UTC()}).
+		Error
+}
+
+// Purge deletes the messages published before the given time.
+func (o *OutboxService) Purge(ctx context.Context, before time.Time) (_ int64, err error) {
+	ctx, span := tracer.Start(ctx, "OutboxService.Purge")
+	defer func() { endSpan(span, err) }()
+
+	result := conn(ctx, o.DB).
+		Where("published_at IS NOT NULL AND published_at < ?", before.UTC()).
+		Delete(&OutboxMessage{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,161 @@
+package database_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func findOutboxMessages(t *testing.T, db *gorm.DB) []database.OutboxMessage {
+	var messages []database.OutboxMessage
+	assert.Nil(t, db.Order("occurred_at").Find(&messages).Error)
+	return messages
+}
+
+func TestRepositoriesRecordEvents(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	products := database.NewProductService(db, slog.Default())
+	users := database.NewUserService(db, slog.Default())
+
+	product, _ := entity.NewProduct("Product 1", 10)
+	assert.Nil(t, products.Create(ctx, product))
+	product.Name = "Updated product 1"
+	assert.Nil(t, products.Update(ctx, product))
+	assert.Nil(t, products.Delete(ctx, product.ID.String()))
+	user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+	assert.Nil(t, users.Create(ctx, user))
+	user.Name = "John Updated"
+	assert.Nil(t, users.Update(ctx, user, "Name"))
+	assert.Nil(t, users.Delete(ctx, user.ID.String()))
+
+	messages := findOutboxMessages(t, db)
+	assert.Len(t, messages, 6)
+	expected := []struct {
+		eventType   string
+		aggregateID string
+	}{
+		{entity.EventProductCreated, product.ID.String()},
+		{entity.EventProductUpdated, product.ID.String()},
+		{entity.EventProductDeleted, product.ID.String()},
+		{entity.EventUserRegistered, user.ID.String()},
+		{entity.EventUserUpdated, user.ID.String()},
+		{entity.EventUserDeleted, user.ID.String()},
+	}
+	for i, message := range messages {
+		assert.Equal(t, expected[i].eventType, message.Type)
+		assert.Equal(t, expected[i].aggregateID, message.AggregateID)
+		assert.Nil(t, message.PublishedAt)
+	}
+
+	var updated entity.Product
+	assert.Nil(t, json.Unmarshal(messages[1].Payload, &updated))
+	assert.Equal(t, "Updated product 1", updated.Name)
+	var deleted entity.ProductDeletedPayload
+	assert.Nil(t, json.Unmarshal(messages[2].Payload, &deleted))
+	assert.Equal(t, product.ID, deleted.ID)
+	// Passwords are never published
+	assert.NotContains(t, string(messages[4].Payload), `"password"`)
+	assert.NotContains(t, string(messages[4].Payload), user.Password)
+}
+
+func TestRepositoriesDoNotRecordEventsOfFailedChanges(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	products := database.NewProductService(db, slog.Default())
+	product, _ := entity.NewProduct("Product 1", 10)
+	assert.Nil(t, products.Create(ctx, product))
+
+	assert.NotNil(t, products.Create(ctx, product))
+	assert.Len(t, findOutboxMessages(t, db), 1)
+}
+
+func TestOutboxClaim(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	outbox := database.NewOutboxService(db, slog.Default())
+	first, _ := entity.NewEvent(entity.EventProductCreated, "1", nil)
+	second, _ := entity.NewEvent(entity.EventProductCreated, "2", nil)
+	second.OccurredAt = first.OccurredAt.Add(time.Millisecond)
+	assert.Nil(t, outbox.Add(ctx, second, first))
+	now := second.OccurredAt.Add(time.Second)
+
+	messages, err := outbox.Claim(ctx, now, 10, time.Minute)
+	assert.Nil(t, err)
+	assert.Len(t, messages, 2)
+	assert.Equal(t, first.ID, messages[0].ID)
+	assert.Equal(t, second.ID, messages[1].ID)
+	assert.Equal(t, 1, messages[0].Attempts)
+
+	// Claimed messages are hidden until the lease is over
+	messages, err = outbox.Claim(ctx, now, 10, time.Minute)
+	assert.Nil(t, err)
+	assert.Empty(t, messages)
+
+	messages, err = outbox.Claim(ctx, now.Add(time.Minute), 1, time.Minute)
+	assert.Nil(t, err)
+	assert.Len(t, messages, 1)
+	assert.Equal(t, first.ID, messages[0].ID)
+	assert.Equal(t, 2, messages[0].Attempts)
+}
+
+func TestOutboxClaimSkipsFutureMessages(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	outbox := database.NewOutboxService(db, slog.Default())
+	event, _ := entity.NewEvent(entity.EventProductCreated, "1", nil)
+	assert.Nil(t, outbox.Add(ctx, event))
+
+	messages, err := outbox.Claim(ctx, event.OccurredAt.Add(-time.Second), 10, time.Minute)
+	assert.Nil(t, err)
+	assert.Empty(t, messages)
+}
+
+func TestOutboxMarkPublished(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	outbox := database.NewOutboxService(db, slog.Default())
+	event, _ := entity.NewEvent(entity.EventProductCreated, "1", nil)
+	assert.Nil(t, outbox.Add(ctx, event))
+	now := event.OccurredAt.Add(time.Second)
+
+	assert.Nil(t, outbox.MarkPublished(ctx, event.ID.String(), now))
+	messages, err := outbox.Claim(ctx, now.Add(time.Hour), 10, time.Minute)
+	assert.Nil(t, err)
+	assert.Empty(t, messages)
+
+	purged, err := outbox.Purge(ctx, now)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), purged)
+	purged, err = outbox.Purge(ctx, now.Add(time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), purged)
+	assert.Empty(t, findOutboxMessages(t, db))
+}
+
+func TestOutboxMarkFailed(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	outbox := database.NewOutboxService(db, slog.Default())
+	event, _ := entity.NewEvent(entity.EventProductCreated, "1", nil)
+	assert.Nil(t, outbox.Add(ctx, event))
+	now := event.OccurredAt.Add(time.Second)
+
+	assert.Nil(t, outbox.MarkFailed(ctx, event.ID.String(), errors.New("broker is down"), now.Add(time.Minute)))
+	messages, err := outbox.Claim(ctx, now, 10, time.Minute)
+	assert.Nil(t, err)
+	assert.Empty(t, messages)
+
+	messages, err = outbox.Claim(ctx, now.Add(time.Minute), 10, time.Minute)
+	assert.Nil(t, err)
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "broker is down", messages[0].LastError)
+}
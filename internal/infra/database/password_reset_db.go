@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+type PasswordResetService struct {
+	DB     *gorm.DB
+	Logger *slog.Logger
+}
+
+func NewPasswordResetService(db *gorm.DB, logger *slog.Logger) *PasswordResetService {
+	return &PasswordResetService{DB: db, Logger: logger}
+}
+
+func (s *PasswordResetService) Create(ctx context.Context, reset *entity.PasswordReset) (err error) {
+	ctx, span := tracer.Start(ctx, "PasswordResetService.Create", trace.WithAttributes(
+		attribute.String("user.id", reset.UserID),
+	))
+	defer func() { endSpan(span, err) }()
+
+	err = conn(ctx, s.DB).Create(reset).Error
+	if err != nil {
+		s.Logger.ErrorContext(ctx, "error creating password reset", "user_id", reset.UserID, "error", err)
+	}
+	return err
+}
+
+func (s *PasswordResetService) FindByTokenHash(ctx context.Context, tokenHash string) (_ *entity.PasswordReset, err error) {
+	ctx, span := tracer.Start(ctx, "PasswordResetService.FindByTokenHash")
+	defer func() { endSpan(span, err) }()
+
+	var reset entity.PasswordReset
+	err = conn(ctx, s.DB).Where("token_hash = ?", tokenHash).First(&reset).Error
+	if err != nil {
+		return nil, err
+	}
+	return &reset, nil
+}
+
+func (s *PasswordResetService) Use(ctx context.Context, id string, now time.Time) (err error) {
+	ctx, span := tracer.Start(ctx, "PasswordResetService.Use", trace.WithAttributes(
+		attribute.String("password_reset.id", id),
+	))
+	defer func() { endSpan(span, err) }()
+
+	result := conn(ctx, s.DB).Model(&entity.PasswordReset{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", now)
+	if result.Error != nil {
+		s.Logger.ErrorContext(ctx, "error using password reset", "id", id, "error", result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
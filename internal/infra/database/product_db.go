@@ -1,57 +1,122 @@
 package database
 
 import (
+	"context"
 	"goexpert-api/internal/entity"
+	"log/slog"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
+// ProductService stores products with GORM. Every change is recorded in the
+// outbox in the same transaction, nested in the one of the context if any.
 type ProductService struct {
-	DB *gorm.DB
+	DB     *gorm.DB
+	Logger *slog.Logger
 }
 
-func NewProductService(db *gorm.DB) *ProductService {
-	return &ProductService{DB: db}
+func NewProductService(db *gorm.DB, logger *slog.Logger) *ProductService {
+	return &ProductService{DB: db, Logger: logger}
 }
 
-func (p *ProductService) Create(product *entity.Product) error {
-	return p.DB.Create(product).Error
+func (p *ProductService) Create(ctx context.Context, product *entity.Product) (err error) {
+	ctx, span := tracer.Start(ctx, "ProductService.Create", trace.WithAttributes(
+		attribute.String("product.id", product.ID.String()),
+	))
+	defer func() { endSpan(span, err) }()
+
+	err = conn(ctx, p.DB).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(product).Error; err != nil {
+			return err
+		}
+		return addEvent(tx, entity.EventProductCreated, product.ID.String(), product)
+	})
+	if err != nil {
+		p.Logger.ErrorContext(ctx, "error creating product", "id", product.ID.String(), "error", err)
+		return err
+	}
+	p.Logger.DebugContext(ctx, "product created", "id", product.ID.String())
+	return nil
 }
 
-func (p *ProductService) FindByID(id string) (*entity.Product, error) {
+func (p *ProductService) FindByID(ctx context.Context, id string) (_ *entity.Product, err error) {
+	ctx, span := tracer.Start(ctx, "ProductService.FindByID", trace.WithAttributes(
+		attribute.String("product.id", id),
+	))
+	defer func() { endSpan(span, err) }()
+
 	var product entity.Product
-	err := p.DB.Where("id = ?", id).First(&product).Error
+	err = conn(ctx, p.DB).Where("id = ?", id).First(&product).Error
 	if err != nil {
 		return nil, err
 	}
 	return &product, nil
 }
 
-func (p *ProductService) Update(product *entity.Product) error {
-	_, err := p.FindByID(product.ID.String())
+func (p *ProductService) Update(ctx context.Context, product *entity.Product) (err error) {
+	ctx, span := tracer.Start(ctx, "ProductService.Update", trace.WithAttributes(
+		attribute.String("product.id", product.ID.String()),
+	))
+	defer func() { endSpan(span, err) }()
+
+	_, err = p.FindByID(ctx, product.ID.String())
+	if err != nil {
+		return err
+	}
+	err = conn(ctx, p.DB).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(product).Error; err != nil {
+			return err
+		}
+		return addEvent(tx, entity.EventProductUpdated, product.ID.String(), product)
+	})
 	if err != nil {
+		p.Logger.ErrorContext(ctx, "error updating product", "id", product.ID.String(), "error", err)
 		return err
 	}
-	return p.DB.Save(product).Error
+	p.Logger.DebugContext(ctx, "product updated", "id", product.ID.String())
+	return nil
 }
 
-func (p *ProductService) Delete(id string) error {
-	product, err := p.FindByID(id)
+func (p *ProductService) Delete(ctx context.Context, id string) (err error) {
+	ctx, span := tracer.Start(ctx, "ProductService.Delete", trace.WithAttributes(
+		attribute.String("product.id", id),
+	))
+	defer func() { endSpan(span, err) }()
+
+	product, err := p.FindByID(ctx, id)
 	if err != nil {
 		return err
 	}
-	return p.DB.Delete(product).Error
+	err = conn(ctx, p.DB).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(product).Error; err != nil {
+			return err
+		}
+		return addEvent(tx, entity.EventProductDeleted, id, entity.ProductDeletedPayload{ID: product.ID})
+	})
+	if err != nil {
+		p.Logger.ErrorContext(ctx, "error deleting product", "id", id, "error", err)
+		return err
+	}
+	p.Logger.DebugContext(ctx, "product deleted", "id", id)
+	return nil
 }
 
-func (p *ProductService) FindAll(page, limit int, sort string) ([]entity.Product, error) {
+func (p *ProductService) FindAll(ctx context.Context, page, limit int, sort string) (_ []entity.Product, err error) {
+	ctx, span := tracer.Start(ctx, "ProductService.FindAll", trace.WithAttributes(
+		attribute.Int("page", page),
+		attribute.Int("limit", limit),
+	))
+	defer func() { endSpan(span, err) }()
+
 	var products []entity.Product
-	var err error
-	if sort != "" || (sort != "asc" && sort != "desc") {
+	if sort != "asc" && sort != "desc" {
 		sort = "asc"
 	}
 	if page != 0 && limit != 0 {
 		// Busca com paginação
-		err = p.DB.
+		err = conn(ctx, p.DB).
 			Limit(limit).
 			Offset((page - 1) * limit).
 			Order("created_at " + sort).
@@ -59,7 +124,7 @@ func (p *ProductService) FindAll(page, limit int, sort string) ([]entity.Product
 			Error
 	} else {
 		// Busca normal
-		err = p.DB.
+		err = conn(ctx, p.DB).
 			Order("created_at " + sort).
 			Find(&products).
 			Error
@@ -1,8 +1,10 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"goexpert-api/internal/entity"
+	"log/slog"
 	"math"
 	"math/rand"
 	"testing"
@@ -17,7 +19,7 @@ func setupTestCase(t *testing.T) (*gorm.DB, func()) {
 	if err != nil {
 		t.Error(err)
 	}
-	db.AutoMigrate(&entity.Product{})
+	db.AutoMigrate(&entity.Product{}, &OutboxMessage{})
 	return db, func() {
 		// teardown
 	}
@@ -28,9 +30,9 @@ func TestCreateProduct(t *testing.T) {
 	defer teardownTest()
 
 	product, err := entity.NewProduct("Product 1", 10)
-	productService := NewProductService(db)
+	productService := NewProductService(db, slog.Default())
 
-	err = productService.Create(product)
+	err = productService.Create(context.Background(), product)
 	assert.Nil(t, err)
 
 	var productFound entity.Product
@@ -46,12 +48,12 @@ func TestUserFindByIDWhenValidID(t *testing.T) {
 	defer teardownTest()
 
 	product, err := entity.NewProduct("Product 1", 10)
-	productService := NewProductService(db)
+	productService := NewProductService(db, slog.Default())
 
-	err = productService.Create(product)
+	err = productService.Create(context.Background(), product)
 	assert.Nil(t, err)
 
-	productFound, err := productService.FindByID(product.ID.String())
+	productFound, err := productService.FindByID(context.Background(), product.ID.String())
 	assert.Nil(t, err)
 	assert.Equal(t, product.ID, productFound.ID)
 	assert.Equal(t, product.Name, productFound.Name)
@@ -63,12 +65,12 @@ func TestUserFindByIDWhenInvalidID(t *testing.T) {
 	defer teardownTest()
 
 	product, err := entity.NewProduct("Product 1", 10)
-	productService := NewProductService(db)
+	productService := NewProductService(db, slog.Default())
 
-	err = productService.Create(product)
+	err = productService.Create(context.Background(), product)
 	assert.Nil(t, err)
 
-	productFound, err := productService.FindByID("abc123")
+	productFound, err := productService.FindByID(context.Background(), "abc123")
 	assert.Equal(t, "record not found", err.Error())
 	assert.Nil(t, productFound)
 }
@@ -78,16 +80,16 @@ func TestUserUpdateWhenProductExists(t *testing.T) {
 	defer teardownTest()
 
 	product, err := entity.NewProduct("Product 1", 10)
-	productService := NewProductService(db)
+	productService := NewProductService(db, slog.Default())
 
-	err = productService.Create(product)
+	err = productService.Create(context.Background(), product)
 	assert.Nil(t, err)
 
 	product.Name = "Updated product 1"
-	err = productService.Update(product)
+	err = productService.Update(context.Background(), product)
 	assert.Nil(t, err)
 
-	productFound, err := productService.FindByID(product.ID.String())
+	productFound, err := productService.FindByID(context.Background(), product.ID.String())
 	assert.Nil(t, err)
 	assert.Equal(t, product.ID, productFound.ID)
 	assert.Equal(t, product.Name, productFound.Name)
@@ -99,9 +101,9 @@ func TestUserUpdateWhenProductDoesntExists(t *testing.T) {
 	defer teardownTest()
 
 	product, err := entity.NewProduct("Product 1", 10)
-	productService := NewProductService(db)
+	productService := NewProductService(db, slog.Default())
 
-	err = productService.Update(product)
+	err = productService.Update(context.Background(), product)
 	assert.Equal(t, "record not found", err.Error())
 }
 
@@ -110,15 +112,15 @@ func TestUserDeleteWhenProductExists(t *testing.T) {
 	defer teardownTest()
 
 	product, err := entity.NewProduct("Product 1", 10)
-	productService := NewProductService(db)
+	productService := NewProductService(db, slog.Default())
 
-	err = productService.Create(product)
+	err = productService.Create(context.Background(), product)
 	assert.Nil(t, err)
 
-	err = productService.Delete(product.ID.String())
+	err = productService.Delete(context.Background(), product.ID.String())
 	assert.Nil(t, err)
 
-	productFound, err := productService.FindByID(product.ID.String())
+	productFound, err := productService.FindByID(context.Background(), product.ID.String())
 	assert.Equal(t, "record not found", err.Error())
 	assert.Nil(t, productFound)
 }
@@ -128,9 +130,9 @@ func TestUserDeleteWhenProductDoesntExists(t *testing.T) {
 	defer teardownTest()
 
 	product, err := entity.NewProduct("Product 1", 10)
-	productService := NewProductService(db)
+	productService := NewProductService(db, slog.Default())
 
-	err = productService.Delete(product.ID.String())
+	err = productService.Delete(context.Background(), product.ID.String())
 	assert.Equal(t, "record not found", err.Error())
 }
 
@@ -138,7 +140,7 @@ func TestProductsFindAll(t *testing.T) {
 	db, teardownTest := setupTestCase(t)
 	defer teardownTest()
 
-	productService := NewProductService(db)
+	productService := NewProductService(db, slog.Default())
 
 	var products []entity.Product
 	for i := range 24 {
@@ -148,7 +150,7 @@ func TestProductsFindAll(t *testing.T) {
 		products = append(products, *product)
 	}
 
-	productsFound, err := productService.FindAll(0, 0, "")
+	productsFound, err := productService.FindAll(context.Background(), 0, 0, "")
 	assert.Nil(t, err)
 	assert.Len(t, productsFound, 24)
 	for i := range 24 {
@@ -162,7 +164,7 @@ func TestProductsFindAllWithPagination(t *testing.T) {
 	db, teardownTest := setupTestCase(t)
 	defer teardownTest()
 
-	productService := NewProductService(db)
+	productService := NewProductService(db, slog.Default())
 
 	var products []entity.Product
 	items := 24
@@ -176,7 +178,7 @@ func TestProductsFindAllWithPagination(t *testing.T) {
 	limit := 10
 	pages := int(math.Ceil(float64(items) / float64(limit)))
 	for page := range pages {
-		productsFound, err := productService.FindAll(page+1, limit, "asc")
+		productsFound, err := productService.FindAll(context.Background(), page+1, limit, "asc")
 		assert.Nil(t, err)
 		assert.LessOrEqual(t, len(productsFound), limit)
 		for item := range len(productsFound) {
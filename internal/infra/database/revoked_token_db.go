@@ -0,0 +1,53 @@
+package database
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RevokedTokenService struct {
+	DB     *gorm.DB
+	Logger *slog.Logger
+}
+
+func NewRevokedTokenService(db *gorm.DB, logger *slog.Logger) *RevokedTokenService {
+	return &RevokedTokenService{DB: db, Logger: logger}
+}
+
+func (s *RevokedTokenService) Revoke(ctx context.Context, jti string, expiresAt, now time.Time) (err error) {
+	ctx, span := tracer.Start(ctx, "RevokedTokenService.Revoke", trace.WithAttributes(
+		attribute.String("token.jti", jti),
+	))
+	defer func() { endSpan(span, err) }()
+
+	err = conn(ctx, s.DB).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at < ?", now).Delete(&entity.RevokedToken{}).Error; err != nil {
+			return err
+		}
+		// Revoking a revoked token does nothing
+		token := entity.RevokedToken{JTI: jti, ExpiresAt: expiresAt}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&token).Error
+	})
+	if err != nil {
+		s.Logger.ErrorContext(ctx, "error revoking token", "jti", jti, "error", err)
+	}
+	return err
+}
+
+func (s *RevokedTokenService) IsRevoked(ctx context.Context, jti string) (_ bool, err error) {
+	ctx, span := tracer.Start(ctx, "RevokedTokenService.IsRevoked", trace.WithAttributes(
+		attribute.String("token.jti", jti),
+	))
+	defer func() { endSpan(span, err) }()
+
+	var count int64
+	err = conn(ctx, s.DB).Model(&entity.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const (
+	tracerName      = "goexpert-api/internal/infra/database"
+	tracingSpanKey  = "tracing:span"
+	maxStatementLen = 1024
+)
+
+var tracer = otel.Tracer(tracerName)
+
+// endSpan records err on the span, unless it is a not found error, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TracingPlugin is a GORM plugin that creates a span for every query. Queries
+// must be run with db.WithContext to be linked to the caller span.
+type TracingPlugin struct {
+	System string
+}
+
+func NewTracingPlugin(system string) *TracingPlugin {
+	return &TracingPlugin{System: system}
+}
+
+func (p *TracingPlugin) Name() string {
+	return "tracing"
+}
+
+func (p *TracingPlugin) Initialize(db *gorm.DB) error {
+	return registerCallbacks(db, p.Name(), p.before, p.after)
+}
+
+func (p *TracingPlugin) before(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx, span := tracer.Start(
+			db.Statement.Context,
+			"gorm."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", p.System),
+				attribute.String("db.operation", operation),
+			),
+		)
+		db.Statement.Context = ctx
+		db.InstanceSet(tracingSpanKey, span)
+	}
+}
+
+func (p *TracingPlugin) after(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(tracingSpanKey)
+		if !ok {
+			return
+		}
+		span, ok := value.(trace.Span)
+		if !ok {
+			return
+		}
+		statement := db.Statement.SQL.String()
+		if len(statement) > maxStatementLen {
+			statement = statement[:maxStatementLen]
+		}
+		span.SetAttributes(
+			attribute.String("db.sql.table", db.Statement.Table),
+			attribute.String("db.statement", statement),
+			attribute.Int64("db.rows_affected", db.RowsAffected),
+		)
+		endSpan(span, db.Error)
+	}
+}
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingPluginCreatesQuerySpans(t *testing.T) {
+	db, teardownTest := setupTestCase(t)
+	defer teardownTest()
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	err := db.Use(NewTracingPlugin("sqlite"))
+	assert.Nil(t, err)
+
+	productService := NewProductService(db, slog.Default())
+	product, _ := entity.NewProduct("Product 1", 10)
+	err = productService.Create(context.Background(), product)
+	assert.Nil(t, err)
+
+	// The product and its outbox event are inserted in the same transaction
+	spans := recorder.Ended()
+	assert.Len(t, spans, 3)
+	assert.Equal(t, "gorm.create", spans[0].Name())
+	assert.Equal(t, "gorm.create", spans[1].Name())
+	assert.Equal(t, "ProductService.Create", spans[2].Name())
+	assert.Equal(t, spans[2].SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, spans[2].SpanContext().SpanID(), spans[1].Parent().SpanID())
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+)
+
+type txContextKey struct{}
+
+// txState is the transaction carried by the context, with the functions to
+// call once it is committed.
+type txState struct {
+	db          *gorm.DB
+	parent      *txState
+	afterCommit []func(context.Context)
+}
+
+// TransactionService runs functions in GORM transactions. The repositories of
+// this package join the transaction of the context they are called with, so
+// Repositories may be decorated (e.g. cached) and still share it.
+type TransactionService struct {
+	DB           *gorm.DB
+	Repositories Repositories
+	Logger       *slog.Logger
+}
+
+// NewTransactionService creates a service whose Repositories are the ones of
+// this package using db.
+func NewTransactionService(db *gorm.DB, logger *slog.Logger) *TransactionService {
+	return &TransactionService{
+		DB: db,
+		Repositories: Repositories{
+			Products:       NewProductService(db, logger),
+			Users:          NewUserService(db, logger),
+			Outbox:         NewOutboxService(db, logger),
+			PasswordResets: NewPasswordResetService(db, logger),
+		},
+		Logger: logger,
+	}
+}
+
+// Run starts a transaction, or a savepoint when ctx already carries one, and
+// calls fn with the repositories. Panics are rolled back and re-raised.
+func (s *TransactionService) Run(ctx context.Context, fn func(ctx context.Context, repos Repositories) error) (err error) {
+	parent, nested := ctx.Value(txContextKey{}).(*txState)
+	db := s.DB
+	if nested {
+		db = parent.db
+	}
+	ctx, span := tracer.Start(ctx, "TransactionService.Run")
+	span.SetAttributes(attribute.Bool("db.transaction.nested", nested))
+	defer func() {
+		if p := recover(); p != nil {
+			endSpan(span, fmt.Errorf("panic: %v", p))
+			panic(p)
+		}
+		endSpan(span, err)
+	}()
+
+	state := &txState{parent: parent}
+	// GORM uses a savepoint when Transaction is called on a transaction
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		state.db = tx
+		return fn(context.WithValue(ctx, txContextKey{}, state), s.Repositories)
+	})
+	if err != nil {
+		s.Logger.DebugContext(ctx, "transaction rolled back", "nested", nested, "error", err)
+		return err
+	}
+	if nested {
+		// Only committed with the outer transaction
+		parent.afterCommit = append(parent.afterCommit, state.afterCommit...)
+		return nil
+	}
+	for _, fn := range state.afterCommit {
+		fn(ctx)
+	}
+	return nil
+}
+
+// InTransaction tells if ctx carries a transaction started by
+// TransactionService.Run.
+func InTransaction(ctx context.Context) bool {
+	_, ok := ctx.Value(txContextKey{}).(*txState)
+	return ok
+}
+
+// AfterCommit calls fn once the transaction of ctx is committed, or right away
+// when ctx carries no transaction. It is not called if the transaction, or the
+// savepoint fn was registered in, is rolled back.
+func AfterCommit(ctx context.Context, fn func(ctx context.Context)) {
+	state, ok := ctx.Value(txContextKey{}).(*txState)
+	if !ok {
+		fn(ctx)
+		return
+	}
+	state.afterCommit = append(state.afterCommit, fn)
+}
+
+// conn returns db, or the transaction carried by ctx, bound to ctx.
+func conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if state, ok := ctx.Value(txContextKey{}).(*txState); ok {
+		db = state.db
+	}
+	return db.WithContext(ctx)
+}
@@ -0,0 +1,236 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errAbort = errors.New("abort")
+
+type transactionTestCase struct {
+	tx       *database.TransactionService
+	products *database.ProductService
+	users    *database.UserService
+}
+
+func setupTransactionTest(t *testing.T) *transactionTestCase {
+	db := openTestDB(t)
+	return &transactionTestCase{
+		tx:       database.NewTransactionService(db, slog.Default()),
+		products: database.NewProductService(db, slog.Default()),
+		users:    database.NewUserService(db, slog.Default()),
+	}
+}
+
+func newTestProduct(t *testing.T, name string) *entity.Product {
+	product, err := entity.NewProduct(name, 10)
+	assert.Nil(t, err)
+	return product
+}
+
+func TestTransactionCommits(t *testing.T) {
+	tc := setupTransactionTest(t)
+	product := newTestProduct(t, "Product 1")
+	user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+
+	err := tc.tx.Run(context.Background(), func(ctx context.Context, repos database.Repositories) error {
+		if err := repos.Products.Create(ctx, product); err != nil {
+			return err
+		}
+		return repos.Users.Create(ctx, user)
+	})
+	assert.Nil(t, err)
+
+	_, err = tc.products.FindByID(context.Background(), product.ID.String())
+	assert.Nil(t, err)
+	_, err = tc.users.FindByEmail(context.Background(), "john@doe.com")
+	assert.Nil(t, err)
+}
+
+func TestTransactionRollsBackOnError(t *testing.T) {
+	tc := setupTransactionTest(t)
+	product := newTestProduct(t, "Product 1")
+
+	err := tc.tx.Run(context.Background(), func(ctx context.Context, repos database.Repositories) error {
+		if err := repos.Products.Create(ctx, product); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	assert.ErrorIs(t, err, errAbort)
+
+	_, err = tc.products.FindByID(context.Background(), product.ID.String())
+	assert.ErrorIs(t, err, database.ErrNotFound)
+}
+
+func TestTransactionRollsBackAcrossRepositories(t *testing.T) {
+	tc := setupTransactionTest(t)
+	existing, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+	assert.Nil(t, tc.users.Create(context.Background(), existing))
+	product := newTestProduct(t, "Product 1")
+	duplicated, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+	duplicated.ID = existing.ID
+
+	err := tc.tx.Run(context.Background(), func(ctx context.Context, repos database.Repositories) error {
+		if err := repos.Products.Create(ctx, product); err != nil {
+			return err
+		}
+		return repos.Users.Create(ctx, duplicated)
+	})
+	assert.NotNil(t, err)
+
+	_, err = tc.products.FindByID(context.Background(), product.ID.String())
+	assert.ErrorIs(t, err, database.ErrNotFound)
+}
+
+func TestTransactionRollsBackOnPanic(t *testing.T) {
+	tc := setupTransactionTest(t)
+	product := newTestProduct(t, "Product 1")
+
+	assert.PanicsWithValue(t, "boom", func() {
+		tc.tx.Run(context.Background(), func(ctx context.Context, repos database.Repositories) error {
+			if err := repos.Products.Create(ctx, product); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	})
+
+	_, err := tc.products.FindByID(context.Background(), product.ID.String())
+	assert.ErrorIs(t, err, database.ErrNotFound)
+}
+
+func TestNestedTransactionRollsBackToSavepoint(t *testing.T) {
+	tc := setupTransactionTest(t)
+	outer := newTestProduct(t, "Product 1")
+	inner := newTestProduct(t, "Product 2")
+
+	err := tc.tx.Run(context.Background(), func(ctx context.Context, repos database.Repositories) error {
+		if err := repos.Products.Create(ctx, outer); err != nil {
+			return err
+		}
+		err := tc.tx.Run(ctx, func(ctx context.Context, repos database.Repositories) error {
+			if err := repos.Products.Create(ctx, inner); err != nil {
+				return err
+			}
+			return errAbort
+		})
+		assert.ErrorIs(t, err, errAbort)
+		// The outer transaction goes on after the savepoint is rolled back
+		return nil
+	})
+	assert.Nil(t, err)
+
+	_, err = tc.products.FindByID(context.Background(), outer.ID.String())
+	assert.Nil(t, err)
+	_, err = tc.products.FindByID(context.Background(), inner.ID.String())
+	assert.ErrorIs(t, err, database.ErrNotFound)
+}
+
+func TestNestedTransactionIsRolledBackWithOuter(t *testing.T) {
+	tc := setupTransactionTest(t)
+	inner := newTestProduct(t, "Product 1")
+
+	err := tc.tx.Run(context.Background(), func(ctx context.Context, repos database.Repositories) error {
+		err := tc.tx.Run(ctx, func(ctx context.Context, repos database.Repositories) error {
+			return repos.Products.Create(ctx, inner)
+		})
+		assert.Nil(t, err)
+		return errAbort
+	})
+	assert.ErrorIs(t, err, errAbort)
+
+	_, err = tc.products.FindByID(context.Background(), inner.ID.String())
+	assert.ErrorIs(t, err, database.ErrNotFound)
+}
+
+func TestNestedTransactionRollsBackToSavepointOnPanic(t *testing.T) {
+	tc := setupTransactionTest(t)
+	outer := newTestProduct(t, "Product 1")
+	inner := newTestProduct(t, "Product 2")
+
+	err := tc.tx.Run(context.Background(), func(ctx context.Context, repos database.Repositories) error {
+		if err := repos.Products.Create(ctx, outer); err != nil {
+			return err
+		}
+		assert.Panics(t, func() {
+			tc.tx.Run(ctx, func(ctx context.Context, repos database.Repositories) error {
+				if err := repos.Products.Create(ctx, inner); err != nil {
+					return err
+				}
+				panic("boom")
+			})
+		})
+		return nil
+	})
+	assert.Nil(t, err)
+
+	_, err = tc.products.FindByID(context.Background(), outer.ID.String())
+	assert.Nil(t, err)
+	_, err = tc.products.FindByID(context.Background(), inner.ID.String())
+	assert.ErrorIs(t, err, database.ErrNotFound)
+}
+
+func TestTransactionIsJoinedByRepositoriesOfTheContext(t *testing.T) {
+	tc := setupTransactionTest(t)
+	product := newTestProduct(t, "Product 1")
+
+	err := tc.tx.Run(context.Background(), func(ctx context.Context, repos database.Repositories) error {
+		// Not one of repos, but called with the context of the transaction
+		if err := tc.products.Create(ctx, product); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	assert.ErrorIs(t, err, errAbort)
+
+	_, err = tc.products.FindByID(context.Background(), product.ID.String())
+	assert.ErrorIs(t, err, database.ErrNotFound)
+	messages, err := database.NewOutboxService(tc.tx.DB, slog.Default()).Claim(context.Background(), time.Now().Add(time.Minute), 10, time.Minute)
+	assert.Nil(t, err)
+	assert.Empty(t, messages, "the event is rolled back with the product")
+}
+
+func TestAfterCommit(t *testing.T) {
+	tc := setupTransactionTest(t)
+	var called []string
+	record := func(name string) func(context.Context) {
+		return func(context.Context) { called = append(called, name) }
+	}
+
+	database.AfterCommit(context.Background(), record("no transaction"))
+	assert.Equal(t, []string{"no transaction"}, called)
+
+	called = nil
+	err := tc.tx.Run(context.Background(), func(ctx context.Context, repos database.Repositories) error {
+		assert.True(t, database.InTransaction(ctx))
+		database.AfterCommit(ctx, record("outer"))
+		tc.tx.Run(ctx, func(ctx context.Context, repos database.Repositories) error {
+			database.AfterCommit(ctx, record("committed savepoint"))
+			return nil
+		})
+		tc.tx.Run(ctx, func(ctx context.Context, repos database.Repositories) error {
+			database.AfterCommit(ctx, record("rolled back savepoint"))
+			return errAbort
+		})
+		assert.Empty(t, called, "not called before the commit")
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"outer", "committed savepoint"}, called)
+
+	called = nil
+	tc.tx.Run(context.Background(), func(ctx context.Context, repos database.Repositories) error {
+		database.AfterCommit(ctx, record("rolled back"))
+		return errAbort
+	})
+	assert.Empty(t, called)
+	assert.False(t, database.InTransaction(context.Background()))
+}
@@ -1,28 +1,291 @@
 package database
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"goexpert-api/internal/entity"
+	"log/slog"
+	"slices"
+	"strings"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// emailIndex is the unique index of the emails of the users, on lower(email).
+const emailIndex = "idx_users_email"
+
 type UserService struct {
-	DB *gorm.DB
+	DB     *gorm.DB
+	Logger *slog.Logger
 }
 
-func NewUserService(db *gorm.DB) *UserService {
-	return &UserService{DB: db}
+func NewUserService(db *gorm.DB, logger *slog.Logger) *UserService {
+	return &UserService{DB: db, Logger: logger}
 }
 
-func (u *UserService) Create(user *entity.User) error {
-	return u.DB.Create(user).Error
+func (u *UserService) Create(ctx context.Context, user *entity.User) (err error) {
+	ctx, span := tracer.Start(ctx, "UserService.Create", trace.WithAttributes(
+		attribute.String("user.id", user.ID.String()),
+	))
+	defer func() { endSpan(span, err) }()
+
+	err = conn(ctx, u.DB).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return addEvent(tx, entity.EventUserRegistered, user.ID.String(), user)
+	})
+	err = translateError(err, emailIndex)
+	if errors.Is(err, ErrDuplicatedKey) {
+		u.Logger.InfoContext(ctx, "user already exists", "id", user.ID.String())
+		return err
+	}
+	if err != nil {
+		u.Logger.ErrorContext(ctx, "error creating user", "id", user.ID.String(), "error", err)
+		return err
+	}
+	u.Logger.DebugContext(ctx, "user created", "id", user.ID.String())
+	return nil
 }
 
-func (u *UserService) FindByEmail(email string) (*entity.User, error) {
+func (u *UserService) FindByID(ctx context.Context, id string) (_ *entity.User, err error) {
+	ctx, span := tracer.Start(ctx, "UserService.FindByID", trace.WithAttributes(
+		attribute.String("user.id", id),
+	))
+	defer func() { endSpan(span, err) }()
+
 	var user entity.User
-	err := u.DB.Where("email = ?", email).First(&user).Error
+	err = conn(ctx, u.DB).Where("id = ?", id).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
+
+func (u *UserService) FindByEmail(ctx context.Context, email string) (_ *entity.User, err error) {
+	ctx, span := tracer.Start(ctx, "UserService.FindByEmail")
+	defer func() { endSpan(span, err) }()
+
+	var user entity.User
+	err = conn(ctx, u.DB).Where("lower(email) = ?", entity.NormalizeEmail(email)).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (u *UserService) FindAll(ctx context.Context, page, limit int, search string) (_ []entity.User, err error) {
+	ctx, span := tracer.Start(ctx, "UserService.FindAll", trace.WithAttributes(
+		attribute.Int("page", page),
+		attribute.Int("limit", limit),
+	))
+	defer func() { endSpan(span, err) }()
+
+	var users []entity.User
+	query := conn(ctx, u.DB).Order("email")
+	if search = strings.ToLower(strings.TrimSpace(search)); search != "" {
+		pattern := "%" + search + "%"
+		query = query.Where("lower(email) LIKE ? OR lower(name) LIKE ?", pattern, pattern)
+	}
+	if page != 0 && limit != 0 {
+		query = query.Limit(limit).Offset((page - 1) * limit)
+	}
+	err = query.Find(&users).Error
+	return users, err
+}
+
+func (u *UserService) Update(ctx context.Context, user *entity.User, fields ...string) (err error) {
+	ctx, span := tracer.Start(ctx, "UserService.Update", trace.WithAttributes(
+		attribute.String("user.id", user.ID.String()),
+		attribute.StringSlice("user.fields", fields),
+	))
+	defer func() { endSpan(span, err) }()
+
+	return u.update(ctx, "", user, fields)
+}
+
+func (u *UserService) UpdateMFA(ctx context.Context, user *entity.User, secret string, enabled bool) (err error) {
+	ctx, span := tracer.Start(ctx, "UserService.UpdateMFA", trace.WithAttributes(
+		attribute.String("user.id", user.ID.String()),
+	))
+	defer func() { endSpan(span, err) }()
+
+	condition := clause.Expr{SQL: "mfa_secret = ? AND mfa_enabled_at IS NULL", Vars: []any{secret}}
+	if enabled {
+		condition.SQL = "mfa_secret = ? AND mfa_enabled_at IS NOT NULL"
+	}
+	return u.update(ctx, "", user, entity.MFAFields, condition)
+}
+
+func (u *UserService) UpdateAsAdmin(ctx context.Context, adminID string, user *entity.User, fields ...string) (err error) {
+	ctx, span := tracer.Start(ctx, "UserService.UpdateAsAdmin", trace.WithAttributes(
+		attribute.String("user.id", user.ID.String()),
+		attribute.String("admin.id", adminID),
+		attribute.StringSlice("user.fields", fields),
+	))
+	defer func() { endSpan(span, err) }()
+
+	return u.update(ctx, adminID, user, fields)
+}
+
+// update saves fields of user, only while adminID is an enabled admin if it
+// is not empty. With conditions, it returns ErrNotFound when the row doesn't
+// match them.
+func (u *UserService) update(ctx context.Context, adminID string, user *entity.User, fields []string, conditions ...clause.Expression) error {
+	err := conn(ctx, u.DB).Transaction(func(tx *gorm.DB) error {
+		if adminID != "" {
+			// Locked until the commit, so the admin can't be demoted or
+			// disabled meanwhile, by the user for instance
+			var admin entity.User
+			err := tx.Clauses(clause.Locking{Strength: "SHARE"}).
+				Where("id = ? AND role = ? AND disabled_at IS NULL", adminID, entity.RoleAdmin).
+				Take(&admin).Error
+			if errors.Is(err, ErrNotFound) {
+				return ErrNotAdmin
+			}
+			if err != nil {
+				return err
+			}
+		}
+		columns := slices.DeleteFunc(slices.Clone(fields), func(field string) bool { return field == "TokenVersion" })
+		if len(columns) > 0 {
+			query := tx.Model(user)
+			if len(conditions) > 0 {
+				query = query.Clauses(clause.Where{Exprs: conditions})
+			}
+			result := query.Select(columns).Updates(user)
+			if result.Error != nil {
+				return result.Error
+			}
+			if len(conditions) > 0 && result.RowsAffected == 0 {
+				return ErrNotFound
+			}
+		}
+		if len(columns) < len(fields) {
+			// Incremented, so the revocations made meanwhile are kept
+			err := tx.Model(&entity.User{}).Where("id = ?", user.ID.String()).
+				UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error
+			if err != nil {
+				return err
+			}
+		}
+		var stored entity.User
+		if err := tx.Where("id = ?", user.ID.String()).First(&stored).Error; err != nil {
+			return err
+		}
+		*user = stored
+		return addEvent(tx, entity.EventUserUpdated, user.ID.String(), user)
+	})
+	err = translateError(err, emailIndex)
+	if errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if errors.Is(err, ErrNotAdmin) {
+		u.Logger.WarnContext(ctx, "user update refused", "id", user.ID.String(), "admin_id", adminID, "reason", "not an admin")
+		return err
+	}
+	if errors.Is(err, ErrDuplicatedKey) {
+		u.Logger.InfoContext(ctx, "email already in use", "id", user.ID.String())
+		return err
+	}
+	if err != nil {
+		u.Logger.ErrorContext(ctx, "error updating user", "id", user.ID.String(), "error", err)
+		return err
+	}
+	u.Logger.DebugContext(ctx, "user updated", "id", user.ID.String())
+	return nil
+}
+
+func (u *UserService) UseMFACode(ctx context.Context, id string, code entity.MFACode) (err error) {
+	ctx, span := tracer.Start(ctx, "UserService.UseMFACode", trace.WithAttributes(
+		attribute.String("user.id", id),
+	))
+	defer func() { endSpan(span, err) }()
+
+	if code.RecoveryCodeHash != "" {
+		err = u.useRecoveryCode(ctx, id, code.RecoveryCodeHash)
+	} else {
+		err = u.useMFAStep(ctx, id, code.Step)
+	}
+	if errors.Is(err, ErrNotFound) {
+		u.Logger.InfoContext(ctx, "mfa code already used", "id", id)
+		return err
+	}
+	if err != nil {
+		u.Logger.ErrorContext(ctx, "error using mfa code", "id", id, "error", err)
+		return err
+	}
+	return nil
+}
+
+func (u *UserService) useMFAStep(ctx context.Context, id string, step int64) error {
+	result := conn(ctx, u.DB).Model(&entity.User{}).
+		Where("id = ? AND mfa_last_step < ?", id, step).
+		Update("mfa_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// useRecoveryCode removes the recovery code only if the codes are the ones
+// read, trying again when other codes of the user were used meanwhile.
+func (u *UserService) useRecoveryCode(ctx context.Context, id, hash string) error {
+	for {
+		user, err := u.FindByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		i := slices.Index(user.MFARecoveryCodes, hash)
+		if i < 0 {
+			return ErrNotFound
+		}
+		// Stored as JSON by the serializer of the field
+		codes, err := json.Marshal(user.MFARecoveryCodes)
+		if err != nil {
+			return err
+		}
+		remaining := slices.Delete(slices.Clone(user.MFARecoveryCodes), i, i+1)
+		result := conn(ctx, u.DB).Model(&entity.User{}).
+			Where("id = ? AND mfa_recovery_codes = ?", id, string(codes)).
+			Select("MFARecoveryCodes").
+			Updates(&entity.User{MFARecoveryCodes: remaining})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			return nil
+		}
+	}
+}
+
+func (u *UserService) Delete(ctx context.Context, id string) (err error) {
+	ctx, span := tracer.Start(ctx, "UserService.Delete", trace.WithAttributes(
+		attribute.String("user.id", id),
+	))
+	defer func() { endSpan(span, err) }()
+
+	user, err := u.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = conn(ctx, u.DB).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(user).Error; err != nil {
+			return err
+		}
+		return addEvent(tx, entity.EventUserDeleted, id, entity.UserDeletedPayload{ID: user.ID})
+	})
+	if err != nil {
+		u.Logger.ErrorContext(ctx, "error deleting user", "id", id, "error", err)
+		return err
+	}
+	u.Logger.DebugContext(ctx, "user deleted", "id", id)
+	return nil
+}
@@ -1,7 +1,9 @@
 package database
 
 import (
+	"context"
 	"goexpert-api/internal/entity"
+	"log/slog"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -14,11 +16,11 @@ func TestCreateUser(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	db.AutoMigrate(&entity.User{})
+	db.AutoMigrate(&entity.User{}, &OutboxMessage{})
 	user, err := entity.NewUser("John Doe", "john@doe.com", "abc123")
-	userService := NewUserService(db)
+	userService := NewUserService(db, slog.Default())
 
-	err = userService.Create(user)
+	err = userService.Create(context.Background(), user)
 	assert.Nil(t, err)
 
 	var userFound entity.User
@@ -35,14 +37,14 @@ func TestUserFindByEmailWhenValidEmail(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	db.AutoMigrate(&entity.User{})
+	db.AutoMigrate(&entity.User{}, &OutboxMessage{})
 	user, err := entity.NewUser("John Doe", "john@doe.com", "abc123")
-	userService := NewUserService(db)
+	userService := NewUserService(db, slog.Default())
 
-	err = userService.Create(user)
+	err = userService.Create(context.Background(), user)
 	assert.Nil(t, err)
 
-	userFound, err := userService.FindByEmail("john@doe.com")
+	userFound, err := userService.FindByEmail(context.Background(), "john@doe.com")
 	assert.Nil(t, err)
 	assert.Equal(t, user.ID, userFound.ID)
 	assert.Equal(t, user.Name, userFound.Name)
@@ -55,14 +57,33 @@ func TestUserFindByEmailWhenInvalidEmail(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	db.AutoMigrate(&entity.User{})
+	db.AutoMigrate(&entity.User{}, &OutboxMessage{})
 	user, err := entity.NewUser("John Doe", "john@doe.com", "abc123")
-	userService := NewUserService(db)
+	userService := NewUserService(db, slog.Default())
 
-	err = userService.Create(user)
+	err = userService.Create(context.Background(), user)
 	assert.Nil(t, err)
 
-	userFound, err := userService.FindByEmail("j@doe.com")
+	userFound, err := userService.FindByEmail(context.Background(), "j@doe.com")
 	assert.Equal(t, "record not found", err.Error())
 	assert.Nil(t, userFound)
 }
+
+func TestUserUseMFACodeWithoutEvent(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Error(err)
+	}
+	db.AutoMigrate(&entity.User{}, &OutboxMessage{})
+	user, err := entity.NewUser("John Doe", "john@doe.com", "abc123")
+	userService := NewUserService(db, slog.Default())
+	assert.Nil(t, userService.Create(context.Background(), user))
+
+	err = userService.UseMFACode(context.Background(), user.ID.String(), entity.MFACode{Step: 1})
+	assert.Nil(t, err)
+
+	var events []OutboxMessage
+	assert.Nil(t, db.Find(&events).Error)
+	assert.Len(t, events, 1)
+	assert.Equal(t, entity.EventUserRegistered, events[0].Type)
+}
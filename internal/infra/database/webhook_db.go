@@ -0,0 +1,252 @@
+package database
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type WebhookService struct {
+	DB     *gorm.DB
+	Logger *slog.Logger
+}
+
+func NewWebhookService(db *gorm.DB, logger *slog.Logger) *WebhookService {
+	return &WebhookService{DB: db, Logger: logger}
+}
+
+func (s *WebhookService) Create(ctx context.Context, webhook *entity.Webhook) (err error) {
+	ctx, span := tracer.Start(ctx, "WebhookService.Create", trace.WithAttributes(
+		attribute.String("webhook.id", webhook.ID.String()),
+	))
+	defer func() { endSpan(span, err) }()
+
+	err = conn(ctx, s.DB).Create(webhook).Error
+	if err != nil {
+		s.Logger.ErrorContext(ctx, "error creating webhook", "id", webhook.ID.String(), "error", err)
+	}
+	return err
+}
+
+func (s *WebhookService) FindByID(ctx context.Context, id string) (_ *entity.Webhook, err error) {
+	ctx, span := tracer.Start(ctx, "WebhookService.FindByID", trace.WithAttributes(
+		attribute.String("webhook.id", id),
+	))
+	defer func() { endSpan(span, err) }()
+
+	var webhook entity.Webhook
+	err = conn(ctx, s.DB).Where("id = ?", id).First(&webhook).Error
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (s *WebhookService) FindByOwner(ctx context.Context, ownerID string) (_ []entity.Webhook, err error) {
+	ctx, span := tracer.Start(ctx, "WebhookService.FindByOwner")
+	defer func() { endSpan(span, err) }()
+
+	var webhooks []entity.Webhook
+	err = conn(ctx, s.DB).Where("owner_id = ?", ownerID).Order("created_at").Find(&webhooks).Error
+	return webhooks, err
+}
+
+// FindActiveByEventType returns the active webhooks subscribed to eventType.
+func (s *WebhookService) FindActiveByEventType(ctx context.Context, eventType string) (_ []entity.Webhook, err error) {
+	ctx, span := tracer.Start(ctx, "WebhookService.FindActiveByEventType", trace.WithAttributes(
+		attribute.String("event.type", eventType),
+	))
+	defer func() { endSpan(span, err) }()
+
+	// Event types are stored as JSON, so they are filtered here
+	var webhooks []entity.Webhook
+	err = conn(ctx, s.DB).Where("active = ?", true).Find(&webhooks).Error
+	if err != nil {
+		return nil, err
+	}
+	subscribed := webhooks[:0]
+	for _, webhook := range webhooks {
+		if webhook.Subscribes(eventType) {
+			subscribed = append(subscribed, webhook)
+		}
+	}
+	return subscribed, nil
+}
+
+// Update saves the settings of the webhook. The failures are only saved for an
+// active webhook, which is how it is enabled again; otherwise they are kept
+// as counted by RecordFailure and RecordSuccess.
+func (s *WebhookService) Update(ctx context.Context, webhook *entity.Webhook) (err error) {
+	ctx, span := tracer.Start(ctx, "WebhookService.Update", trace.WithAttributes(
+		attribute.String("webhook.id", webhook.ID.String()),
+	))
+	defer func() { endSpan(span, err) }()
+
+	if _, err = s.FindByID(ctx, webhook.ID.String()); err != nil {
+		return err
+	}
+	columns := []string{"url", "event_types", "active", "disabled_at"}
+	if webhook.Active {
+		columns = append(columns, "failures")
+	}
+	err = conn(ctx, s.DB).Model(webhook).Select(columns).Updates(webhook).Error
+	if err != nil {
+		s.Logger.ErrorContext(ctx, "error updating webhook", "id", webhook.ID.String(), "error", err)
+	}
+	return err
+}
+
+// Delete removes the webhook and its deliveries.
+func (s *WebhookService) Delete(ctx context.Context, id string) (err error) {
+	ctx, span := tracer.Start(ctx, "WebhookService.Delete", trace.WithAttributes(
+		attribute.String("webhook.id", id),
+	))
+	defer func() { endSpan(span, err) }()
+
+	webhook, err := s.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = conn(ctx, s.DB).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", webhook.ID).Delete(&entity.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(webhook).Error
+	})
+	if err != nil {
+		s.Logger.ErrorContext(ctx, "error deleting webhook", "id", id, "error", err)
+	}
+	return err
+}
+
+// RecordFailure counts a failed delivery attempt and disables the webhook
+// once it has failed disableAfter times in a row. It returns whether the
+// webhook was disabled by this failure.
+func (s *WebhookService) RecordFailure(ctx context.Context, id string, disableAfter int, now time.Time) (disabled bool, err error) {
+	ctx, span := tracer.Start(ctx, "WebhookService.RecordFailure", trace.WithAttributes(
+		attribute.String("webhook.id", id),
+	))
+	defer func() { endSpan(span, err) }()
+
+	err = conn(ctx, s.DB).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&entity.Webhook{}).
+			Where("id = ?", id).
+			Update("failures", gorm.Expr("failures + 1")).
+			Error
+		if err != nil {
+			return err
+		}
+		result := tx.Model(&entity.Webhook{}).
+			Where("id = ? AND active = ? AND failures >= ?", id, true, disableAfter).
+			Updates(map[string]any{"active": false, "disabled_at": now.UTC()})
+		disabled = result.RowsAffected == 1
+		return result.Error
+	})
+	return disabled, err
+}
+
+// RecordSuccess resets the failures of the webhook after a delivery.
+func (s *WebhookService) RecordSuccess(ctx context.Context, id string) (err error) {
+	ctx, span := tracer.Start(ctx, "WebhookService.RecordSuccess", trace.WithAttributes(
+		attribute.String("webhook.id", id),
+	))
+	defer func() { endSpan(span, err) }()
+
+	return conn(ctx, s.DB).
+		Model(&entity.Webhook{}).
+		Where("id = ? AND failures > 0", id).
+		Update("failures", 0).
+		Error
+}
+
+// AddDeliveries stores new deliveries, ignoring the ones of an event that was
+// already added to the same webhook.
+func (s *WebhookService) AddDeliveries(ctx context.Context, deliveries ...*entity.WebhookDelivery) (err error) {
+	ctx, span := tracer.Start(ctx, "WebhookService.AddDeliveries", trace.WithAttributes(
+		attribute.Int("deliveries", len(deliveries)),
+	))
+	defer func() { endSpan(span, err) }()
+
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return conn(ctx, s.DB).Clauses(clause.OnConflict{DoNothing: true}).Create(deliveries).Error
+}
+
+// ClaimDeliveries returns the oldest pending deliveries due at now, hiding
+// them from other claims until lease is over.
+func (s *WebhookService) ClaimDeliveries(ctx context.Context, now time.Time, limit int, lease time.Duration) (_ []entity.WebhookDelivery, err error) {
+	ctx, span := tracer.Start(ctx, "WebhookService.ClaimDeliveries")
+	defer func() { endSpan(span, err) }()
+
+	now = now.UTC()
+	var candidates []entity.WebhookDelivery
+	err = conn(ctx, s.DB).
+		Where("status = ? AND next_attempt_at <= ?", entity.DeliveryPending, now).
+		Order("created_at").
+		Limit(limit).
+		Find(&candidates).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := candidates[:0]
+	leaseUntil := now.Add(lease)
+	for _, delivery := range candidates {
+		result := conn(ctx, s.DB).
+			Model(&entity.WebhookDelivery{}).
+			Where("id = ? AND status = ? AND next_attempt_at <= ?", delivery.ID, entity.DeliveryPending, now).
+			Updates(map[string]any{
+				"attempts":        gorm.Expr("attempts + 1"),
+				"next_attempt_at": leaseUntil,
+			})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			delivery.Attempts++
+			delivery.NextAttemptAt = leaseUntil
+			claimed = append(claimed, delivery)
+		}
+	}
+	span.SetAttributes(attribute.Int("claimed", len(claimed)))
+	return claimed, nil
+}
+
+// UpdateDelivery saves the result of a delivery attempt.
+func (s *WebhookService) UpdateDelivery(ctx context.Context, delivery *entity.WebhookDelivery) (err error) {
+	ctx, span := tracer.Start(ctx, "WebhookService.UpdateDelivery", trace.WithAttributes(
+		attribute.String("delivery.id", delivery.ID.String()),
+	))
+	defer func() { endSpan(span, err) }()
+
+	delivery.NextAttemptAt = delivery.NextAttemptAt.UTC()
+	return conn(ctx, s.DB).
+		Model(delivery).
+		Select("status", "next_attempt_at", "status_code", "error", "updated_at").
+		Updates(delivery).
+		Error
+}
+
+// FindDeliveries returns the deliveries of a webhook, newest first.
+func (s *WebhookService) FindDeliveries(ctx context.Context, webhookID string, page, limit int) (_ []entity.WebhookDelivery, err error) {
+	ctx, span := tracer.Start(ctx, "WebhookService.FindDeliveries", trace.WithAttributes(
+		attribute.String("webhook.id", webhookID),
+	))
+	defer func() { endSpan(span, err) }()
+
+	var deliveries []entity.WebhookDelivery
+	query := conn(ctx, s.DB).Where("webhook_id = ?", webhookID).Order("created_at desc")
+	if page != 0 && limit != 0 {
+		query = query.Limit(limit).Offset((page - 1) * limit)
+	}
+	err = query.Find(&deliveries).Error
+	return deliveries, err
+}
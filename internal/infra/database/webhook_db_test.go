@@ -0,0 +1,151 @@
+package database_test
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	entityPkg "goexpert-api/pkg/entity"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestWebhook(t *testing.T, ownerID string, eventTypes ...string) *entity.Webhook {
+	webhook, err := entity.NewWebhook(ownerID, "https://example.com/hooks", eventTypes, "")
+	assert.Nil(t, err)
+	return webhook
+}
+
+func newTestDelivery(t *testing.T, webhook *entity.Webhook) *entity.WebhookDelivery {
+	event, _ := entity.NewEvent(entity.EventProductCreated, "1", nil)
+	delivery, err := entity.NewWebhookDelivery(webhook, *event)
+	assert.Nil(t, err)
+	return delivery
+}
+
+func TestWebhookServiceCRUD(t *testing.T) {
+	ctx := context.Background()
+	webhooks := database.NewWebhookService(openTestDB(t), slog.Default())
+	webhook := newTestWebhook(t, "owner", entity.EventProductCreated)
+	assert.Nil(t, webhooks.Create(ctx, webhook))
+	assert.Nil(t, webhooks.Create(ctx, newTestWebhook(t, "other", entity.EventProductCreated)))
+
+	webhookFound, err := webhooks.FindByID(ctx, webhook.ID.String())
+	assert.Nil(t, err)
+	assert.Equal(t, webhook.URL, webhookFound.URL)
+	assert.Equal(t, webhook.EventTypes, webhookFound.EventTypes)
+	assert.Equal(t, webhook.Secret, webhookFound.Secret)
+
+	owned, err := webhooks.FindByOwner(ctx, "owner")
+	assert.Nil(t, err)
+	assert.Len(t, owned, 1)
+
+	webhook.URL = "https://example.com/new"
+	webhook.EventTypes = []string{entity.EventProductDeleted}
+	assert.Nil(t, webhooks.Update(ctx, webhook))
+	webhookFound, _ = webhooks.FindByID(ctx, webhook.ID.String())
+	assert.Equal(t, "https://example.com/new", webhookFound.URL)
+	assert.Equal(t, []string{entity.EventProductDeleted}, webhookFound.EventTypes)
+
+	assert.Nil(t, webhooks.AddDeliveries(ctx, newTestDelivery(t, webhook)))
+	assert.Nil(t, webhooks.Delete(ctx, webhook.ID.String()))
+	_, err = webhooks.FindByID(ctx, webhook.ID.String())
+	assert.ErrorIs(t, err, database.ErrNotFound)
+	deliveries, _ := webhooks.FindDeliveries(ctx, webhook.ID.String(), 0, 0)
+	assert.Empty(t, deliveries)
+
+	assert.ErrorIs(t, webhooks.Update(ctx, webhook), database.ErrNotFound)
+	assert.ErrorIs(t, webhooks.Delete(ctx, webhook.ID.String()), database.ErrNotFound)
+}
+
+func TestWebhookServiceFindActiveByEventType(t *testing.T) {
+	ctx := context.Background()
+	webhooks := database.NewWebhookService(openTestDB(t), slog.Default())
+	created := newTestWebhook(t, "owner", entity.EventProductCreated)
+	both := newTestWebhook(t, "owner", entity.EventProductCreated, entity.EventProductDeleted)
+	disabled := newTestWebhook(t, "owner", entity.EventProductCreated)
+	disabled.Active = false
+	for _, webhook := range []*entity.Webhook{created, both, disabled} {
+		assert.Nil(t, webhooks.Create(ctx, webhook))
+	}
+
+	found, err := webhooks.FindActiveByEventType(ctx, entity.EventProductCreated)
+	assert.Nil(t, err)
+	assert.Len(t, found, 2)
+	found, err = webhooks.FindActiveByEventType(ctx, entity.EventProductDeleted)
+	assert.Nil(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, both.ID, found[0].ID)
+}
+
+func TestWebhookServiceDisablesAfterFailures(t *testing.T) {
+	ctx := context.Background()
+	webhooks := database.NewWebhookService(openTestDB(t), slog.Default())
+	webhook := newTestWebhook(t, "owner", entity.EventProductCreated)
+	assert.Nil(t, webhooks.Create(ctx, webhook))
+	id := webhook.ID.String()
+
+	disabled, err := webhooks.RecordFailure(ctx, id, 3, time.Now())
+	assert.Nil(t, err)
+	assert.False(t, disabled)
+	// A success resets the count
+	assert.Nil(t, webhooks.RecordSuccess(ctx, id))
+	for range 2 {
+		disabled, _ = webhooks.RecordFailure(ctx, id, 3, time.Now())
+		assert.False(t, disabled)
+	}
+	disabled, err = webhooks.RecordFailure(ctx, id, 3, time.Now())
+	assert.Nil(t, err)
+	assert.True(t, disabled)
+
+	webhookFound, _ := webhooks.FindByID(ctx, id)
+	assert.False(t, webhookFound.Active)
+	assert.Equal(t, 3, webhookFound.Failures)
+	assert.NotNil(t, webhookFound.DisabledAt)
+
+	// Enabled again by the owner
+	webhookFound.Enable()
+	assert.Nil(t, webhooks.Update(ctx, webhookFound))
+	webhookFound, _ = webhooks.FindByID(ctx, id)
+	assert.True(t, webhookFound.Active)
+	assert.Equal(t, 0, webhookFound.Failures)
+	assert.Nil(t, webhookFound.DisabledAt)
+}
+
+func TestWebhookServiceDeliveries(t *testing.T) {
+	ctx := context.Background()
+	webhooks := database.NewWebhookService(openTestDB(t), slog.Default())
+	webhook := newTestWebhook(t, "owner", entity.EventProductCreated)
+	assert.Nil(t, webhooks.Create(ctx, webhook))
+	delivery := newTestDelivery(t, webhook)
+
+	assert.Nil(t, webhooks.AddDeliveries(ctx, delivery))
+	// The same event is only delivered once to a webhook
+	duplicated := *delivery
+	duplicated.ID = entityPkg.NewID()
+	assert.Nil(t, webhooks.AddDeliveries(ctx, &duplicated))
+	deliveries, err := webhooks.FindDeliveries(ctx, webhook.ID.String(), 1, 10)
+	assert.Nil(t, err)
+	assert.Len(t, deliveries, 1)
+
+	now := time.Now().Add(time.Second)
+	claimed, err := webhooks.ClaimDeliveries(ctx, now, 10, time.Minute)
+	assert.Nil(t, err)
+	assert.Len(t, claimed, 1)
+	assert.Equal(t, 1, claimed[0].Attempts)
+	claimed, _ = webhooks.ClaimDeliveries(ctx, now, 10, time.Minute)
+	assert.Empty(t, claimed)
+
+	delivery.Status = entity.DeliverySucceeded
+	delivery.StatusCode = 200
+	assert.Nil(t, webhooks.UpdateDelivery(ctx, delivery))
+	claimed, _ = webhooks.ClaimDeliveries(ctx, now.Add(time.Hour), 10, time.Minute)
+	assert.Empty(t, claimed)
+
+	deliveries, _ = webhooks.FindDeliveries(ctx, webhook.ID.String(), 0, 0)
+	assert.Equal(t, entity.DeliverySucceeded, deliveries[0].Status)
+	assert.Equal(t, 200, deliveries[0].StatusCode)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+}
@@ -0,0 +1,56 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStoreReserve(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	stored, err := store.Reserve(ctx, "key", "hash", time.Hour)
+	assert.Nil(t, err)
+	assert.Nil(t, stored)
+
+	_, err = store.Reserve(ctx, "key", "hash", time.Hour)
+	assert.Equal(t, ErrInProgress, err)
+	_, err = store.Reserve(ctx, "key", "other hash", time.Hour)
+	assert.Equal(t, ErrMismatch, err)
+
+	response := Response{StatusCode: http.StatusCreated, Body: []byte("body")}
+	assert.Nil(t, store.Complete(ctx, "key", response))
+	stored, err = store.Reserve(ctx, "key", "hash", time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, &response, stored)
+}
+
+func TestMemoryStoreRelease(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	store.Reserve(ctx, "key", "hash", time.Hour)
+	assert.Nil(t, store.Release(ctx, "key"))
+
+	stored, err := store.Reserve(ctx, "key", "other hash", time.Hour)
+	assert.Nil(t, err)
+	assert.Nil(t, stored)
+}
+
+func TestMemoryStoreExpiration(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	store.Reserve(ctx, "key", "hash", time.Minute)
+	now = now.Add(time.Minute)
+
+	stored, err := store.Reserve(ctx, "key", "other hash", time.Minute)
+	assert.Nil(t, err, "expired keys can be reused")
+	assert.Nil(t, stored)
+}
@@ -0,0 +1,35 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrInProgress is returned when a request with the same key is still
+	// being processed.
+	ErrInProgress = errors.New("request with the same idempotency key in progress")
+	// ErrMismatch is returned when the key was used with a different request.
+	ErrMismatch = errors.New("idempotency key used with a different request")
+)
+
+// Response is the stored response replayed for repeated requests.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Store keeps the responses of requests sent with an idempotency key.
+type Store interface {
+	// Reserve marks key as in progress for requestHash. If key was already
+	// used it returns the stored response, ErrInProgress when there is none
+	// yet, or ErrMismatch when requestHash differs.
+	Reserve(ctx context.Context, key, requestHash string, ttl time.Duration) (*Response, error)
+	// Complete stores the response of a reserved key.
+	Complete(ctx context.Context, key string, response Response) error
+	// Release removes a reserved key so the request can be retried.
+	Release(ctx context.Context, key string) error
+}
@@ -0,0 +1,132 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	subjectKey
+	accessLogKey
+)
+
+// New creates a structured logger writing to w. Format can be "json" or
+// "text" (defaults to json) and level one of debug, info, warn or error.
+func New(w io.Writer, level, format string) *slog.Logger {
+	options := &slog.HandlerOptions{Level: ParseLevel(level)}
+	var handler slog.Handler
+	if strings.ToLower(format) == "text" {
+		handler = slog.NewTextHandler(w, options)
+	} else {
+		handler = slog.NewJSONHandler(w, options)
+	}
+	return slog.New(NewContextHandler(handler))
+}
+
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// ContextHandler adds the request ID, the authenticated subject and the trace
+// IDs stored in the context to every record logged through the *Context
+// methods.
+type ContextHandler struct {
+	slog.Handler
+}
+
+func NewContextHandler(handler slog.Handler) *ContextHandler {
+	return &ContextHandler{Handler: handler}
+}
+
+func (h *ContextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if sub := Subject(ctx); sub != "" {
+		record.AddAttrs(slog.String("sub", sub))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(
+			slog.String("trace_id", span.TraceID().String()),
+			slog.String("span_id", span.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithSubject stores the authenticated subject in the context and records it
+// on the access log entry of the request, if there is one.
+func WithSubject(ctx context.Context, sub string) context.Context {
+	if entry := accessLogFromContext(ctx); entry != nil {
+		entry.setSubject(sub)
+	}
+	return context.WithValue(ctx, subjectKey, sub)
+}
+
+func Subject(ctx context.Context) string {
+	sub, _ := ctx.Value(subjectKey).(string)
+	return sub
+}
+
+// AccessLog holds request data that is only known by inner handlers but is
+// reported by the access log middleware once the request is done.
+type AccessLog struct {
+	mu      sync.Mutex
+	subject string
+}
+
+func WithAccessLog(ctx context.Context) (context.Context, *AccessLog) {
+	entry := &AccessLog{}
+	return context.WithValue(ctx, accessLogKey, entry), entry
+}
+
+func (a *AccessLog) Subject() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.subject
+}
+
+func (a *AccessLog) setSubject(sub string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.subject = sub
+}
+
+func accessLogFromContext(ctx context.Context) *AccessLog {
+	entry, _ := ctx.Value(accessLogKey).(*AccessLog)
+	return entry
+}
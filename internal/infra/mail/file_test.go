@@ -0,0 +1,39 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	mailer := NewFileMailer(dir, "no-reply@example.com")
+
+	err := mailer.Send(context.Background(), Message{To: "john@doe.com", Subject: "Hi", Body: "Hello"})
+	assert.Nil(t, err)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+	data, err := os.ReadFile(files[0])
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), "To: john@doe.com\r\n")
+	assert.Contains(t, string(data), "\r\n\r\nHello")
+}
+
+func TestMemoryMailer(t *testing.T) {
+	mailer := NewMemoryMailer()
+	assert.Empty(t, mailer.Messages())
+
+	message := Message{To: "john@doe.com", Subject: "Hi", Body: "Hello"}
+	assert.Nil(t, mailer.Send(context.Background(), message))
+	assert.Equal(t, []Message{message}, mailer.Messages())
+
+	err := mailer.Send(context.Background(), Message{To: "john", Subject: "Hi"})
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+	assert.Len(t, mailer.Messages(), 1)
+}
@@ -0,0 +1,64 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Backends that can be selected in the configuration.
+const (
+	BackendNone = "none"
+	BackendSMTP = "smtp"
+	BackendFile = "file"
+)
+
+var (
+	ErrInvalidBackend = errors.New("invalid mail backend")
+	ErrInvalidHeader  = errors.New("invalid mail header")
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails.
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// MailerFunc adapts a function to the Mailer interface.
+type MailerFunc func(ctx context.Context, message Message) error
+
+func (f MailerFunc) Send(ctx context.Context, message Message) error {
+	return f(ctx, message)
+}
+
+// format returns message from the from address in the RFC 5322 format, with
+// CRLF line endings.
+func format(message Message, from string, now time.Time) ([]byte, error) {
+	if strings.ContainsAny(message.To+message.Subject+from, "\r\n") {
+		return nil, ErrInvalidHeader
+	}
+	if _, err := mail.ParseAddress(message.To); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", message.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(message.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes(), nil
+}
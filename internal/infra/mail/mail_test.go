@@ -0,0 +1,37 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	data, err := format(Message{
+		To:      "john@doe.com",
+		Subject: "Redefinição de senha",
+		Body:    "Line 1\nLine 2",
+	}, "API <no-reply@example.com>", now)
+	assert.Nil(t, err)
+
+	message := string(data)
+	assert.Contains(t, message, "From: API <no-reply@example.com>\r\n")
+	assert.Contains(t, message, "To: john@doe.com\r\n")
+	assert.Contains(t, message, "Subject: =?utf-8?q?Redefini=C3=A7=C3=A3o_de_senha?=\r\n")
+	assert.Contains(t, message, "Date: Tue, 02 Jan 2024 03:04:05 +0000\r\n")
+	assert.True(t, strings.HasSuffix(message, "\r\n\r\nLine 1\r\nLine 2"))
+}
+
+func TestFormatWhenHeaderIsInvalid(t *testing.T) {
+	for _, message := range []Message{
+		{To: "john@doe.com\r\nBcc: jane@doe.com", Subject: "Hi"},
+		{To: "john@doe.com", Subject: "Hi\nBcc: jane@doe.com"},
+		{To: "john", Subject: "Hi"},
+	} {
+		_, err := format(message, "no-reply@example.com", time.Now())
+		assert.ErrorIs(t, err, ErrInvalidHeader, message.To)
+	}
+}
@@ -0,0 +1,39 @@
+package mail
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SMTPMailer sends emails through an SMTP server, using STARTTLS when the
+// server supports it. Authentication is only used with a username.
+type SMTPMailer struct {
+	Addr string
+	Auth smtp.Auth
+	From string
+}
+
+func NewSMTPMailer(host string, port int, username, password, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{
+		Addr: net.JoinHostPort(host, strconv.Itoa(port)),
+		Auth: auth,
+		From: from,
+	}
+}
+
+// Send delivers message to the server. The context is not used, as net/smtp
+// does not support cancellation.
+func (m *SMTPMailer) Send(ctx context.Context, message Message) error {
+	data, err := format(message, m.From, time.Now())
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{message.To}, data)
+}
@@ -0,0 +1,81 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveSMTP accepts one connection on listener, answering the commands of a
+// message without authentication, and sends the transcript to received.
+func serveSMTP(listener net.Listener, received chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		close(received)
+		return
+	}
+	defer conn.Close()
+	var transcript strings.Builder
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ready")
+	data := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		transcript.WriteString(line)
+		switch {
+		case data && line == ".\r\n":
+			data = false
+			reply("250 OK")
+		case data:
+		case strings.HasPrefix(line, "EHLO"):
+			reply("250 localhost")
+		case strings.HasPrefix(line, "DATA"):
+			data = true
+			reply("354 go ahead")
+		case strings.HasPrefix(line, "QUIT"):
+			reply("221 bye")
+			received <- transcript.String()
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+	received <- transcript.String()
+}
+
+func TestSMTPMailer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	received := make(chan string, 1)
+	go serveSMTP(listener, received)
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	mailer := NewSMTPMailer("127.0.0.1", port, "", "", "no-reply@example.com")
+	assert.Equal(t, "127.0.0.1:"+strconv.Itoa(port), mailer.Addr)
+	assert.Nil(t, mailer.Auth)
+
+	err = mailer.Send(context.Background(), Message{To: "john@doe.com", Subject: "Hi", Body: "Hello"})
+	assert.Nil(t, err)
+
+	transcript := <-received
+	assert.Contains(t, transcript, "MAIL FROM:<no-reply@example.com>")
+	assert.Contains(t, transcript, "RCPT TO:<john@doe.com>")
+	assert.Contains(t, transcript, "Subject: Hi\r\n")
+	assert.Contains(t, transcript, "\r\nHello\r\n.\r\n")
+}
+
+func TestSMTPMailerWithUsername(t *testing.T) {
+	mailer := NewSMTPMailer("smtp.example.com", 587, "user", "pass", "no-reply@example.com")
+	assert.Equal(t, "smtp.example.com:587", mailer.Addr)
+	assert.NotNil(t, mailer.Auth)
+}
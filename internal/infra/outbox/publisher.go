@@ -0,0 +1,53 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"goexpert-api/internal/entity"
+	"log/slog"
+)
+
+// Publisher delivers events to other services. An event may be published more
+// than once, so consumers must ignore the IDs they have already seen.
+type Publisher interface {
+	Publish(ctx context.Context, event entity.Event) error
+}
+
+// PublisherFunc adapts a function to the Publisher interface.
+type PublisherFunc func(ctx context.Context, event entity.Event) error
+
+func (f PublisherFunc) Publish(ctx context.Context, event entity.Event) error {
+	return f(ctx, event)
+}
+
+// LogPublisher writes the events to a logger, for when there is no broker.
+type LogPublisher struct {
+	Logger *slog.Logger
+}
+
+func NewLogPublisher(logger *slog.Logger) *LogPublisher {
+	return &LogPublisher{Logger: logger}
+}
+
+func (p *LogPublisher) Publish(ctx context.Context, event entity.Event) error {
+	p.Logger.InfoContext(ctx, "event published",
+		"event_id", event.ID.String(),
+		"type", event.Type,
+		"aggregate_id", event.AggregateID,
+	)
+	return nil
+}
+
+// Publishers publishes every event to all of its publishers, failing if any of
+// them fails. On a retry the event is published again to all of them.
+type Publishers []Publisher
+
+func (p Publishers) Publish(ctx context.Context, event entity.Event) error {
+	var errs []error
+	for _, publisher := range p {
+		if err := publisher.Publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,131 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"goexpert-api/internal/infra/database"
+	"log/slog"
+	"time"
+)
+
+var ErrInvalidRelay = errors.New("relay interval and batch size must be positive")
+
+// Relay publishes the events recorded in the outbox. A message is marked as
+// published only after the publisher accepts it, so events are delivered at
+// least once. Failures are retried with an exponential backoff and do not
+// block the following events, which may then be published out of order.
+type Relay struct {
+	Outbox    database.OutboxInterface
+	Publisher Publisher
+	Logger    *slog.Logger
+	// Time between polls of the outbox
+	Interval  time.Duration
+	BatchSize int
+	// Time a relay has to publish the messages it claimed before they are
+	// claimed again
+	Lease time.Duration
+	// Delay before the first retry, doubled on each new failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Published messages are purged after Retention, kept if zero
+	Retention time.Duration
+	now       func() time.Time
+}
+
+func NewRelay(outbox database.OutboxInterface, publisher Publisher, logger *slog.Logger) *Relay {
+	return &Relay{
+		Outbox:    outbox,
+		Publisher: publisher,
+		Logger:    logger,
+		Interval:  5 * time.Second,
+		BatchSize: 100,
+		Lease:     time.Minute,
+		BaseDelay: time.Second,
+		MaxDelay:  time.Hour,
+		Retention: 7 * 24 * time.Hour,
+		now:       time.Now,
+	}
+}
+
+// Validate returns ErrInvalidRelay unless Interval and BatchSize are
+// positive, as Run would panic without an interval and claim empty batches
+// forever without a batch size.
+func (r *Relay) Validate() error {
+	if r.Interval <= 0 || r.BatchSize <= 0 {
+		return ErrInvalidRelay
+	}
+	return nil
+}
+
+// Run polls the outbox until ctx is canceled. It returns right away the error
+// of Validate.
+func (r *Relay) Run(ctx context.Context) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		// Keeps publishing while there are full batches waiting
+		for {
+			claimed, err := r.RelayOnce(ctx)
+			if err != nil {
+				r.Logger.ErrorContext(ctx, "error relaying outbox", "error", err)
+			}
+			if err != nil || claimed < r.BatchSize {
+				break
+			}
+		}
+		if r.Retention > 0 {
+			if _, err := r.Outbox.Purge(ctx, r.now().Add(-r.Retention)); err != nil {
+				r.Logger.ErrorContext(ctx, "error purging outbox", "error", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayOnce publishes a batch of due messages and returns how many were
+// claimed.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	messages, err := r.Outbox.Claim(ctx, r.now(), r.BatchSize, r.Lease)
+	if err != nil {
+		return 0, err
+	}
+	for _, message := range messages {
+		id := message.ID.String()
+		if err := r.Publisher.Publish(ctx, message.Event); err != nil {
+			delay := Backoff(r.BaseDelay, r.MaxDelay, message.Attempts)
+			r.Logger.WarnContext(ctx, "error publishing event",
+				"event_id", id,
+				"type", message.Type,
+				"attempts", message.Attempts,
+				"retry_in", delay,
+				"error", err,
+			)
+			if err := r.Outbox.MarkFailed(ctx, id, err, r.now().Add(delay)); err != nil {
+				r.Logger.ErrorContext(ctx, "error recording publish failure", "event_id", id, "error", err)
+			}
+			continue
+		}
+		// If this fails the event is published again once the lease is over
+		if err := r.Outbox.MarkPublished(ctx, id, r.now()); err != nil {
+			r.Logger.ErrorContext(ctx, "error marking event as published", "event_id", id, "error", err)
+		}
+	}
+	return len(messages), nil
+}
+
+// Backoff returns the delay before retrying after the given number of failed
+// attempts: base, doubled for each previous attempt, up to max.
+func Backoff(base, max time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	return min(delay, max)
+}
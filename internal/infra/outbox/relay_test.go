@@ -0,0 +1,186 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var errPublish = errors.New("broker is down")
+
+// recordingPublisher records the published events, failing while err is set.
+type recordingPublisher struct {
+	mu     sync.Mutex
+	events []entity.Event
+	err    error
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, event entity.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.events = append(p.events, event)
+	return nil
+}
+
+func (p *recordingPublisher) published() []entity.Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]entity.Event(nil), p.events...)
+}
+
+// unmarkableOutbox fails to mark messages as published.
+type unmarkableOutbox struct {
+	database.OutboxInterface
+}
+
+func (unmarkableOutbox) MarkPublished(context.Context, string, time.Time) error {
+	return errors.New("database is down")
+}
+
+type relayTestCase struct {
+	outbox    *database.OutboxService
+	publisher *recordingPublisher
+	relay     *Relay
+	now       time.Time
+}
+
+func setupRelayTest(t *testing.T) *relayTestCase {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	db.AutoMigrate(&database.OutboxMessage{})
+
+	tc := &relayTestCase{
+		outbox:    database.NewOutboxService(db, slog.Default()),
+		publisher: &recordingPublisher{},
+		now:       time.Now(),
+	}
+	tc.relay = NewRelay(tc.outbox, tc.publisher, slog.Default())
+	tc.relay.now = func() time.Time { return tc.now }
+	return tc
+}
+
+func (tc *relayTestCase) addEvent(t *testing.T, aggregateID string) *entity.Event {
+	event, err := entity.NewEvent(entity.EventProductCreated, aggregateID, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, tc.outbox.Add(context.Background(), event))
+	tc.now = event.OccurredAt.Add(time.Millisecond)
+	return event
+}
+
+func TestRelayPublishesEvents(t *testing.T) {
+	tc := setupRelayTest(t)
+	first := tc.addEvent(t, "1")
+	second := tc.addEvent(t, "2")
+
+	claimed, err := tc.relay.RelayOnce(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, claimed)
+	published := tc.publisher.published()
+	assert.Len(t, published, 2)
+	assert.Equal(t, first.ID, published[0].ID)
+	assert.Equal(t, second.ID, published[1].ID)
+
+	// Published events are not sent again
+	tc.now = tc.now.Add(time.Hour)
+	claimed, err = tc.relay.RelayOnce(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, claimed)
+}
+
+func TestRelayRetriesFailedEvents(t *testing.T) {
+	tc := setupRelayTest(t)
+	event := tc.addEvent(t, "1")
+	tc.publisher.err = errPublish
+
+	for _, delay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		claimed, err := tc.relay.RelayOnce(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 1, claimed)
+
+		// Not retried before the backoff delay
+		tc.now = tc.now.Add(delay - time.Millisecond)
+		claimed, _ = tc.relay.RelayOnce(context.Background())
+		assert.Equal(t, 0, claimed)
+		tc.now = tc.now.Add(time.Millisecond)
+	}
+
+	tc.publisher.err = nil
+	claimed, err := tc.relay.RelayOnce(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, claimed)
+	assert.Equal(t, event.ID, tc.publisher.published()[0].ID)
+}
+
+func TestRelayPublishesAgainWhenNotMarked(t *testing.T) {
+	tc := setupRelayTest(t)
+	event := tc.addEvent(t, "1")
+	tc.relay.Outbox = unmarkableOutbox{tc.outbox}
+
+	tc.relay.RelayOnce(context.Background())
+	tc.now = tc.now.Add(tc.relay.Lease)
+	tc.relay.RelayOnce(context.Background())
+
+	published := tc.publisher.published()
+	assert.Len(t, published, 2)
+	assert.Equal(t, event.ID, published[0].ID)
+	assert.Equal(t, event.ID, published[1].ID)
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, Backoff(time.Second, 10*time.Second, 1))
+	assert.Equal(t, 2*time.Second, Backoff(time.Second, 10*time.Second, 2))
+	assert.Equal(t, 8*time.Second, Backoff(time.Second, 10*time.Second, 4))
+	assert.Equal(t, 10*time.Second, Backoff(time.Second, 10*time.Second, 5))
+	assert.Equal(t, 10*time.Second, Backoff(time.Second, 10*time.Second, 100))
+}
+
+func TestRelayRunStopsWhenContextIsCanceled(t *testing.T) {
+	tc := setupRelayTest(t)
+	tc.addEvent(t, "1")
+	tc.relay.Interval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		assert.Nil(t, tc.relay.Run(ctx))
+		close(done)
+	}()
+	assert.Eventually(t, func() bool { return len(tc.publisher.published()) == 1 }, time.Second, time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("relay did not stop")
+	}
+}
+
+func TestRelayRunWithoutIntervalOrBatchSize(t *testing.T) {
+	tc := setupRelayTest(t)
+	tc.addEvent(t, "1")
+
+	tc.relay.Interval = 0
+	assert.Equal(t, ErrInvalidRelay, tc.relay.Run(context.Background()))
+	tc.relay.Interval = time.Millisecond
+	tc.relay.BatchSize = 0
+	assert.Equal(t, ErrInvalidRelay, tc.relay.Run(context.Background()))
+	assert.Empty(t, tc.publisher.published())
+}
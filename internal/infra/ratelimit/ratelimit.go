@@ -0,0 +1,164 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+var (
+	ErrRateLimited = errors.New("rate limit exceeded")
+	ErrLocked      = errors.New("too many failed attempts")
+	ErrInvalidRate = errors.New("rate and burst must be positive")
+)
+
+// TokenBucket allows bursts of up to Burst requests per key, refilled at Rate
+// tokens per second.
+type TokenBucket struct {
+	Store Store
+	Rate  float64
+	Burst int
+	// Now is the clock of the refills, time.Now by default
+	Now func() time.Time
+}
+
+// NewTokenBucket returns ErrInvalidRate unless rate and burst are positive, as
+// a bucket without refills or tokens would reject every request.
+func NewTokenBucket(store Store, rate float64, burst int) (*TokenBucket, error) {
+	if !(rate > 0) || burst <= 0 {
+		return nil, ErrInvalidRate
+	}
+	return &TokenBucket{Store: store, Rate: rate, Burst: burst, Now: time.Now}, nil
+}
+
+// Allow takes a token from the bucket of key. When the bucket is empty it
+// returns ErrRateLimited and how long until a token is available.
+func (b *TokenBucket) Allow(ctx context.Context, key string) (time.Duration, error) {
+	if !(b.Rate > 0) || b.Burst <= 0 {
+		return 0, ErrInvalidRate
+	}
+	now := b.Now()
+	allowed := false
+	// Time to refill a full bucket, after which the state is the same as a
+	// missing one.
+	ttl := time.Duration(float64(b.Burst) / b.Rate * float64(time.Second))
+	state, err := b.Store.Update(ctx, "bucket:"+key, ttl, func(state State) State {
+		tokens := float64(b.Burst)
+		if !state.Updated.IsZero() {
+			elapsed := now.Sub(state.Updated).Seconds()
+			tokens = math.Min(float64(b.Burst), state.Tokens+elapsed*b.Rate)
+		}
+		if tokens >= 1 {
+			tokens--
+			allowed = true
+		}
+		return State{Tokens: tokens, Updated: now}
+	})
+	if err != nil {
+		return 0, err
+	}
+	if !allowed {
+		missing := 1 - state.Tokens
+		return time.Duration(missing / b.Rate * float64(time.Second)), ErrRateLimited
+	}
+	return 0, nil
+}
+
+// Lockout locks a key after MaxFailures consecutive failures. Every failure
+// past the limit doubles the lock duration, starting at BaseDelay and capped
+// at MaxDelay.
+type Lockout struct {
+	Store       Store
+	MaxFailures int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Now is the clock of the locks, time.Now by default
+	Now func() time.Time
+}
+
+func NewLockout(store Store, maxFailures int, baseDelay, maxDelay time.Duration) *Lockout {
+	return &Lockout{
+		Store:       store,
+		MaxFailures: maxFailures,
+		BaseDelay:   baseDelay,
+		MaxDelay:    maxDelay,
+		Now:         time.Now,
+	}
+}
+
+// Check returns ErrLocked and the remaining lock time when key is locked.
+func (l *Lockout) Check(ctx context.Context, key string) (time.Duration, error) {
+	now := l.Now()
+	state, err := l.Store.Get(ctx, "lockout:"+key)
+	if err != nil {
+		return 0, err
+	}
+	if now.Before(state.LockedUntil) {
+		return state.LockedUntil.Sub(now), ErrLocked
+	}
+	return 0, nil
+}
+
+// Fail records a failure for key, locking it when the limit is reached.
+func (l *Lockout) Fail(ctx context.Context, key string) error {
+	now := l.Now()
+	_, err := l.Store.Update(ctx, "lockout:"+key, l.ttl(), func(state State) State {
+		state.Failures++
+		if state.Failures >= l.MaxFailures {
+			state.LockedUntil = now.Add(l.delay(state.Failures - l.MaxFailures))
+		}
+		return state
+	})
+	return err
+}
+
+// Reset clears the failures of key, e.g. after a successful login.
+func (l *Lockout) Reset(ctx context.Context, key string) error {
+	return l.Store.Delete(ctx, "lockout:"+key)
+}
+
+func (l *Lockout) delay(exponent int) time.Duration {
+	if exponent > 30 {
+		return l.MaxDelay
+	}
+	delay := l.BaseDelay * time.Duration(1<<exponent)
+	if delay > l.MaxDelay || delay <= 0 {
+		return l.MaxDelay
+	}
+	return delay
+}
+
+// Failures are forgotten once the longest lock has passed without new ones.
+func (l *Lockout) ttl() time.Duration {
+	return 2 * l.MaxDelay
+}
+
+// LoginGuard combines a token bucket and a lockout to protect logins of a
+// single key (e.g. the account email).
+type LoginGuard struct {
+	Limiter *TokenBucket
+	Lockout *Lockout
+}
+
+func NewLoginGuard(limiter *TokenBucket, lockout *Lockout) *LoginGuard {
+	return &LoginGuard{Limiter: limiter, Lockout: lockout}
+}
+
+// Allow returns ErrLocked or ErrRateLimited, and when to try again, if a login
+// attempt for key must be refused.
+func (g *LoginGuard) Allow(ctx context.Context, key string) (time.Duration, error) {
+	retryAfter, err := g.Lockout.Check(ctx, key)
+	if err != nil {
+		return retryAfter, err
+	}
+	return g.Limiter.Allow(ctx, key)
+}
+
+func (g *LoginGuard) Failure(ctx context.Context, key string) error {
+	return g.Lockout.Fail(ctx, key)
+}
+
+func (g *LoginGuard) Success(ctx context.Context, key string) error {
+	return g.Lockout.Reset(ctx, key)
+}
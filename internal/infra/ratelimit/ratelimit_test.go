@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func setupTestCase() (*fakeClock, *MemoryStore) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	store.Now = clock.Now
+	return clock, store
+}
+
+func TestTokenBucketAllowsBurst(t *testing.T) {
+	clock, store := setupTestCase()
+	bucket, err := NewTokenBucket(store, 1, 3)
+	assert.Nil(t, err)
+	bucket.Now = clock.Now
+	ctx := context.Background()
+
+	for range 3 {
+		_, err = bucket.Allow(ctx, "key")
+		assert.Nil(t, err)
+	}
+	retryAfter, err := bucket.Allow(ctx, "key")
+	assert.Equal(t, ErrRateLimited, err)
+	assert.Equal(t, time.Second, retryAfter)
+
+	_, err = bucket.Allow(ctx, "other")
+	assert.Nil(t, err, "keys have their own buckets")
+}
+
+func TestTokenBucketWithoutRateOrBurst(t *testing.T) {
+	_, store := setupTestCase()
+	for _, c := range []struct {
+		rate  float64
+		burst int
+	}{{0, 1}, {-1, 1}, {math.NaN(), 1}, {1, 0}, {1, -1}} {
+		_, err := NewTokenBucket(store, c.rate, c.burst)
+		assert.ErrorIs(t, err, ErrInvalidRate, "rate %v, burst %d", c.rate, c.burst)
+	}
+
+	bucket := &TokenBucket{Store: store, Now: time.Now}
+	_, err := bucket.Allow(context.Background(), "key")
+	assert.ErrorIs(t, err, ErrInvalidRate)
+}
+
+func TestTokenBucketRefills(t *testing.T) {
+	clock, store := setupTestCase()
+	bucket, err := NewTokenBucket(store, 0.5, 1)
+	assert.Nil(t, err)
+	bucket.Now = clock.Now
+	ctx := context.Background()
+
+	_, err = bucket.Allow(ctx, "key")
+	assert.Nil(t, err)
+	clock.Advance(time.Second)
+	retryAfter, err := bucket.Allow(ctx, "key")
+	assert.Equal(t, ErrRateLimited, err)
+	assert.Equal(t, time.Second, retryAfter)
+
+	clock.Advance(time.Second)
+	_, err = bucket.Allow(ctx, "key")
+	assert.Nil(t, err)
+}
+
+func TestLockoutBackoff(t *testing.T) {
+	clock, store := setupTestCase()
+	lockout := NewLockout(store, 3, 10*time.Second, 25*time.Second)
+	lockout.Now = clock.Now
+	ctx := context.Background()
+
+	for range 2 {
+		assert.Nil(t, lockout.Fail(ctx, "key"))
+	}
+	_, err := lockout.Check(ctx, "key")
+	assert.Nil(t, err)
+
+	assert.Nil(t, lockout.Fail(ctx, "key"))
+	retryAfter, err := lockout.Check(ctx, "key")
+	assert.Equal(t, ErrLocked, err)
+	assert.Equal(t, 10*time.Second, retryAfter)
+
+	assert.Nil(t, lockout.Fail(ctx, "key"))
+	retryAfter, _ = lockout.Check(ctx, "key")
+	assert.Equal(t, 20*time.Second, retryAfter)
+
+	assert.Nil(t, lockout.Fail(ctx, "key"))
+	retryAfter, _ = lockout.Check(ctx, "key")
+	assert.Equal(t, 25*time.Second, retryAfter, "delay is capped")
+
+	clock.Advance(25 * time.Second)
+	_, err = lockout.Check(ctx, "key")
+	assert.Nil(t, err)
+}
+
+func TestLockoutReset(t *testing.T) {
+	clock, store := setupTestCase()
+	lockout := NewLockout(store, 1, time.Minute, time.Hour)
+	lockout.Now = clock.Now
+	ctx := context.Background()
+
+	assert.Nil(t, lockout.Fail(ctx, "key"))
+	_, err := lockout.Check(ctx, "key")
+	assert.Equal(t, ErrLocked, err)
+
+	assert.Nil(t, lockout.Reset(ctx, "key"))
+	_, err = lockout.Check(ctx, "key")
+	assert.Nil(t, err)
+}
+
+func TestMemoryStoreExpiresKeys(t *testing.T) {
+	clock, store := setupTestCase()
+	ctx := context.Background()
+
+	_, err := store.Update(ctx, "key", time.Minute, func(state State) State {
+		state.Failures++
+		return state
+	})
+	assert.Nil(t, err)
+	state, _ := store.Get(ctx, "key")
+	assert.Equal(t, 1, state.Failures)
+
+	clock.Advance(time.Minute)
+	state, _ = store.Get(ctx, "key")
+	assert.Equal(t, State{}, state)
+}
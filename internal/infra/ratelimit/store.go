@@ -0,0 +1,24 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// State is what the limiters persist for each key. Token buckets use Tokens
+// and Updated, the lockout uses Failures and LockedUntil.
+type State struct {
+	Tokens      float64
+	Updated     time.Time
+	Failures    int
+	LockedUntil time.Time
+}
+
+// Store persists limiter states. Missing or expired keys have the zero State.
+// Update must load the current state of key, apply fn and save the result for
+// ttl as a single atomic operation.
+type Store interface {
+	Get(ctx context.Context, key string) (State, error)
+	Update(ctx context.Context, key string, ttl time.Duration, fn func(State) State) (State, error)
+	Delete(ctx context.Context, key string) error
+}
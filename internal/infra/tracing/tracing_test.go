@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetupWithOTLPExporter(t *testing.T) {
+	var received atomic.Int32
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/traces" {
+			received.Add(1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	shutdown, err := Setup(context.Background(), Config{
+		Exporter:     ExporterOTLP,
+		ServiceName:  "test",
+		OTLPEndpoint: strings.TrimPrefix(collector.URL, "http://"),
+		OTLPInsecure: true,
+	})
+	assert.Nil(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "span")
+	span.End()
+
+	assert.Nil(t, shutdown(context.Background()))
+	assert.Equal(t, int32(1), received.Load())
+}
+
+func TestSetupWithStdoutExporter(t *testing.T) {
+	var buf bytes.Buffer
+	shutdown, err := Setup(context.Background(), Config{
+		Exporter:    ExporterStdout,
+		ServiceName: "test",
+		Writer:      &buf,
+	})
+	assert.Nil(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "my-span")
+	span.End()
+
+	assert.Nil(t, shutdown(context.Background()))
+	assert.Contains(t, buf.String(), "my-span")
+}
+
+func TestSetupWithNoneExporter(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Config{Exporter: ExporterNone})
+	assert.Nil(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "span")
+	assert.False(t, span.SpanContext().IsValid())
+	span.End()
+	assert.Nil(t, shutdown(context.Background()))
+}
+
+func TestSetupWithInvalidExporter(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Config{Exporter: "zipkin"})
+	assert.ErrorIs(t, err, ErrInvalidExporter)
+	assert.Nil(t, shutdown)
+}
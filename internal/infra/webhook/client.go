@@ -0,0 +1,45 @@
+package webhook
+
+import (
+	"errors"
+	"goexpert-api/internal/entity"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+var ErrAddressNotAllowed = errors.New("webhook address is not allowed")
+
+// NewClient returns the client to send the deliveries. It only connects to
+// the addresses allowed by entity.WebhookAddrAllowed, checked once the host is
+// resolved, and doesn't follow redirects, so webhooks can't reach the internal
+// services. Proxies from the environment are not used for the same reason.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || !entity.WebhookAddrAllowed(addr) {
+				return ErrAddressNotAllowed
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		// A redirect is answered as a failed delivery
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
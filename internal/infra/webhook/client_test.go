@@ -0,0 +1,38 @@
+package webhook
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientRefusesInternalAddresses(t *testing.T) {
+	rc := &receiver{status: http.StatusOK}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	_, err := NewClient(time.Second).Post(server.URL, "application/json", nil)
+	assert.True(t, errors.Is(err, ErrAddressNotAllowed), err)
+	assert.Equal(t, 0, rc.received())
+}
+
+func TestClientDoesNotFollowRedirects(t *testing.T) {
+	rc := &receiver{status: http.StatusOK}
+	target := httptest.NewServer(rc)
+	defer target.Close()
+	server := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer server.Close()
+
+	client := NewClient(time.Second)
+	// The test servers listen on the loopback, refused by the client
+	client.Transport = server.Client().Transport
+	response, err := client.Post(server.URL, "application/json", nil)
+	assert.Nil(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusTemporaryRedirect, response.StatusCode)
+	assert.Equal(t, 0, rc.received())
+}
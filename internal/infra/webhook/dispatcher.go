@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"log/slog"
+	"slices"
+)
+
+// Dispatcher is an outbox.Publisher that queues a delivery of each event to
+// every active webhook subscribed to its type. Events of the
+// entity.AdminEventTypes are only queued for the webhooks of active admins, as
+// their owners may have lost the role since subscribing. The deliveries are
+// sent by the Sender.
+type Dispatcher struct {
+	Webhooks database.WebhookInterface
+	Users    database.UserInterface
+	Logger   *slog.Logger
+}
+
+func NewDispatcher(webhooks database.WebhookInterface, users database.UserInterface, logger *slog.Logger) *Dispatcher {
+	return &Dispatcher{Webhooks: webhooks, Users: users, Logger: logger}
+}
+
+func (d *Dispatcher) Publish(ctx context.Context, event entity.Event) error {
+	webhooks, err := d.Webhooks.FindActiveByEventType(ctx, event.Type)
+	if err != nil {
+		return err
+	}
+	deliveries := make([]*entity.WebhookDelivery, 0, len(webhooks))
+	adminOnly := slices.Contains(entity.AdminEventTypes, event.Type)
+	for i := range webhooks {
+		if adminOnly {
+			admin, err := d.ownedByAdmin(ctx, &webhooks[i])
+			if err != nil {
+				return err
+			}
+			if !admin {
+				continue
+			}
+		}
+		delivery, err := entity.NewWebhookDelivery(&webhooks[i], event)
+		if err != nil {
+			return err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	// Deliveries already queued when the event is published again are ignored
+	if err := d.Webhooks.AddDeliveries(ctx, deliveries...); err != nil {
+		return err
+	}
+	if len(deliveries) > 0 {
+		d.Logger.DebugContext(ctx, "webhook deliveries queued", "event_id", event.ID.String(), "deliveries", len(deliveries))
+	}
+	return nil
+}
+
+// ownedByAdmin tells if the owner of webhook is an active admin.
+func (d *Dispatcher) ownedByAdmin(ctx context.Context, webhook *entity.Webhook) (bool, error) {
+	owner, err := d.Users.FindByID(ctx, webhook.OwnerID)
+	if errors.Is(err, database.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return owner.Role == entity.RoleAdmin && !owner.Disabled(), nil
+}
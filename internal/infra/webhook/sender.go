@@ -0,0 +1,191 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"goexpert-api/internal/infra/outbox"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxResponseBody is how much of a response is read before the connection is
+// reused.
+const maxResponseBody = 64 << 10
+
+var ErrInvalidSender = errors.New("sender interval, batch size, max attempts and disable after must be positive")
+
+// Sender posts the queued deliveries to their webhooks. A delivery succeeds
+// when the receiver answers with a 2xx status, otherwise it is retried with an
+// exponential backoff up to MaxAttempts. A webhook failing DisableAfter
+// attempts in a row is disabled.
+type Sender struct {
+	Webhooks database.WebhookInterface
+	Client   *http.Client
+	Logger   *slog.Logger
+	// Time between polls of the queued deliveries
+	Interval  time.Duration
+	BatchSize int
+	// Time a sender has to send the deliveries it claimed before they are
+	// claimed again
+	Lease time.Duration
+	// Delay before the first retry, doubled on each new failure up to MaxDelay
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	MaxAttempts  int
+	DisableAfter int
+	now          func() time.Time
+}
+
+func NewSender(webhooks database.WebhookInterface, client *http.Client, logger *slog.Logger) *Sender {
+	return &Sender{
+		Webhooks:     webhooks,
+		Client:       client,
+		Logger:       logger,
+		Interval:     5 * time.Second,
+		BatchSize:    50,
+		Lease:        time.Minute,
+		BaseDelay:    10 * time.Second,
+		MaxDelay:     6 * time.Hour,
+		MaxAttempts:  8,
+		DisableAfter: 20,
+		now:          time.Now,
+	}
+}
+
+// Validate returns ErrInvalidSender unless Interval, BatchSize, MaxAttempts
+// and DisableAfter are positive. Run would panic without an interval and claim
+// empty batches forever without a batch size, and no delivery could be
+// attempted without the limits.
+func (s *Sender) Validate() error {
+	if s.Interval <= 0 || s.BatchSize <= 0 || s.MaxAttempts <= 0 || s.DisableAfter <= 0 {
+		return ErrInvalidSender
+	}
+	return nil
+}
+
+// Run sends the queued deliveries until ctx is canceled. It returns right
+// away the error of Validate.
+func (s *Sender) Run(ctx context.Context) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		for {
+			claimed, err := s.SendOnce(ctx)
+			if err != nil {
+				s.Logger.ErrorContext(ctx, "error sending webhooks", "error", err)
+			}
+			if err != nil || claimed < s.BatchSize {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendOnce sends a batch of due deliveries and returns how many were claimed.
+func (s *Sender) SendOnce(ctx context.Context) (int, error) {
+	deliveries, err := s.Webhooks.ClaimDeliveries(ctx, s.now(), s.BatchSize, s.Lease)
+	if err != nil {
+		return 0, err
+	}
+	for i := range deliveries {
+		s.deliver(ctx, &deliveries[i])
+	}
+	return len(deliveries), nil
+}
+
+func (s *Sender) deliver(ctx context.Context, delivery *entity.WebhookDelivery) {
+	webhookID := delivery.WebhookID.String()
+	logger := s.Logger.With("webhook_id", webhookID, "delivery_id", delivery.ID.String())
+
+	webhook, err := s.Webhooks.FindByID(ctx, webhookID)
+	if errors.Is(err, database.ErrNotFound) {
+		s.finish(ctx, logger, delivery, entity.DeliveryFailed, 0, "webhook not found")
+		return
+	}
+	if err != nil {
+		// Sent again once the lease is over
+		logger.ErrorContext(ctx, "error finding webhook", "error", err)
+		return
+	}
+	if !webhook.Active {
+		s.finish(ctx, logger, delivery, entity.DeliveryFailed, 0, "webhook disabled")
+		return
+	}
+
+	statusCode, err := s.send(ctx, webhook, delivery)
+	if err == nil {
+		s.finish(ctx, logger, delivery, entity.DeliverySucceeded, statusCode, "")
+		if err := s.Webhooks.RecordSuccess(ctx, webhookID); err != nil {
+			logger.ErrorContext(ctx, "error recording webhook success", "error", err)
+		}
+		return
+	}
+
+	if delivery.Attempts >= s.MaxAttempts {
+		logger.WarnContext(ctx, "webhook delivery failed", "attempts", delivery.Attempts, "error", err)
+		s.finish(ctx, logger, delivery, entity.DeliveryFailed, statusCode, err.Error())
+	} else {
+		delay := outbox.Backoff(s.BaseDelay, s.MaxDelay, delivery.Attempts)
+		logger.InfoContext(ctx, "webhook delivery will be retried", "attempts", delivery.Attempts, "retry_in", delay, "error", err)
+		delivery.NextAttemptAt = s.now().Add(delay)
+		s.finish(ctx, logger, delivery, entity.DeliveryPending, statusCode, err.Error())
+	}
+	disabled, err := s.Webhooks.RecordFailure(ctx, webhookID, s.DisableAfter, s.now())
+	if err != nil {
+		logger.ErrorContext(ctx, "error recording webhook failure", "error", err)
+	}
+	if disabled {
+		logger.WarnContext(ctx, "webhook disabled after repeated failures", "failures", s.DisableAfter)
+	}
+}
+
+// send posts the delivery and returns the status code of the response.
+func (s *Sender) send(ctx context.Context, webhook *entity.Webhook, delivery *entity.WebhookDelivery) (int, error) {
+	timestamp := s.now().Unix()
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("User-Agent", "goexpert-api-webhooks")
+	r.Header.Set(HeaderWebhookID, webhook.ID.String())
+	r.Header.Set(HeaderDelivery, delivery.ID.String())
+	r.Header.Set(HeaderEvent, delivery.EventType)
+	r.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	r.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, delivery.Payload))
+
+	response, err := s.Client.Do(r)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, maxResponseBody))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+func (s *Sender) finish(ctx context.Context, logger *slog.Logger, delivery *entity.WebhookDelivery, status string, statusCode int, message string) {
+	delivery.Status = status
+	delivery.StatusCode = statusCode
+	delivery.Error = message
+	if err := s.Webhooks.UpdateDelivery(ctx, delivery); err != nil {
+		logger.ErrorContext(ctx, "error updating webhook delivery", "error", err)
+	}
+}
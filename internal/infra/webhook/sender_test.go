@@ -0,0 +1,289 @@
+package webhook
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"goexpert-api/internal/infra/database/memory"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// receiver is a webhook endpoint recording the requests it gets and answering
+// with status.
+type receiver struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	status   int
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	w.WriteHeader(rc.status)
+}
+
+func (rc *receiver) received() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.requests)
+}
+
+type senderTestCase struct {
+	webhooks   *database.WebhookService
+	users      *memory.UserService
+	receiver   *receiver
+	dispatcher *Dispatcher
+	sender     *Sender
+	webhook    *entity.Webhook
+	now        time.Time
+}
+
+func setupSenderTest(t *testing.T) *senderTestCase {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	db.AutoMigrate(&entity.Webhook{}, &entity.WebhookDelivery{})
+
+	rc := &receiver{status: http.StatusOK}
+	server := httptest.NewServer(rc)
+	t.Cleanup(server.Close)
+
+	tc := &senderTestCase{
+		webhooks: database.NewWebhookService(db, slog.Default()),
+		users:    memory.NewUserService(),
+		receiver: rc,
+		// Deliveries queued by the test are due
+		now: time.Now().Add(time.Minute),
+	}
+	tc.webhook, err = entity.NewWebhook("owner", "https://example.com/hooks", []string{entity.EventProductCreated}, "")
+	assert.Nil(t, err)
+	// The test server listens on the loopback, refused by entity.Webhook
+	tc.webhook.URL = server.URL
+	assert.Nil(t, tc.webhooks.Create(context.Background(), tc.webhook))
+
+	tc.dispatcher = NewDispatcher(tc.webhooks, tc.users, slog.Default())
+	tc.sender = NewSender(tc.webhooks, server.Client(), slog.Default())
+	tc.sender.now = func() time.Time { return tc.now }
+	return tc
+}
+
+// publish queues the deliveries of a new event of eventType.
+func (tc *senderTestCase) publish(t *testing.T, eventType string) *entity.Event {
+	event, err := entity.NewEvent(eventType, "1", map[string]string{"name": "Product 1"})
+	assert.Nil(t, err)
+	assert.Nil(t, tc.dispatcher.Publish(context.Background(), *event))
+	return event
+}
+
+func (tc *senderTestCase) deliveries(t *testing.T) []entity.WebhookDelivery {
+	deliveries, err := tc.webhooks.FindDeliveries(context.Background(), tc.webhook.ID.String(), 0, 0)
+	assert.Nil(t, err)
+	return deliveries
+}
+
+func TestDispatcherQueuesSubscribedWebhooks(t *testing.T) {
+	tc := setupSenderTest(t)
+	ctx := context.Background()
+	other, _ := entity.NewWebhook("owner", "https://example.com", []string{entity.EventProductDeleted}, "")
+	disabled, _ := entity.NewWebhook("owner", "https://example.com", []string{entity.EventProductCreated}, "")
+	disabled.Active = false
+	assert.Nil(t, tc.webhooks.Create(ctx, other))
+	assert.Nil(t, tc.webhooks.Create(ctx, disabled))
+
+	event := tc.publish(t, entity.EventProductCreated)
+	// Publishing again the same event does not deliver it twice
+	assert.Nil(t, tc.dispatcher.Publish(ctx, *event))
+
+	assert.Len(t, tc.deliveries(t), 1)
+	deliveries, _ := tc.webhooks.FindDeliveries(ctx, other.ID.String(), 0, 0)
+	assert.Empty(t, deliveries)
+	deliveries, _ = tc.webhooks.FindDeliveries(ctx, disabled.ID.String(), 0, 0)
+	assert.Empty(t, deliveries)
+}
+
+func TestDispatcherQueuesAdminEventsForAdmins(t *testing.T) {
+	tc := setupSenderTest(t)
+	ctx := context.Background()
+	webhookOf := func(role string) *entity.Webhook {
+		owner, _ := entity.NewUser("John Doe", role+"@doe.com", "abc123")
+		assert.Nil(t, owner.SetRole(role))
+		assert.Nil(t, tc.users.Create(ctx, owner))
+		webhook, _ := entity.NewWebhook(owner.ID.String(), "https://example.com", []string{entity.EventUserRegistered}, "")
+		assert.Nil(t, tc.webhooks.Create(ctx, webhook))
+		return webhook
+	}
+	admin := webhookOf(entity.RoleAdmin)
+	// Created before its owner lost the admin role
+	demoted := webhookOf(entity.RoleUser)
+
+	tc.publish(t, entity.EventUserRegistered)
+
+	deliveries, _ := tc.webhooks.FindDeliveries(ctx, admin.ID.String(), 0, 0)
+	assert.Len(t, deliveries, 1)
+	deliveries, _ = tc.webhooks.FindDeliveries(ctx, demoted.ID.String(), 0, 0)
+	assert.Empty(t, deliveries)
+}
+
+func TestSenderSignsDeliveries(t *testing.T) {
+	tc := setupSenderTest(t)
+	event := tc.publish(t, entity.EventProductCreated)
+
+	claimed, err := tc.sender.SendOnce(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, claimed)
+	assert.Equal(t, 1, tc.receiver.received())
+
+	r, body := tc.receiver.requests[0], tc.receiver.bodies[0]
+	delivery := tc.deliveries(t)[0]
+	assert.Equal(t, http.MethodPost, r.Method)
+	assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+	assert.Equal(t, tc.webhook.ID.String(), r.Header.Get(HeaderWebhookID))
+	assert.Equal(t, delivery.ID.String(), r.Header.Get(HeaderDelivery))
+	assert.Equal(t, entity.EventProductCreated, r.Header.Get(HeaderEvent))
+	timestamp, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+	assert.Nil(t, err)
+	assert.Equal(t, tc.now.Unix(), timestamp)
+	assert.True(t, Verify(tc.webhook.Secret, timestamp, body, r.Header.Get(HeaderSignature)))
+	assert.Contains(t, string(body), event.ID.String())
+
+	assert.Equal(t, entity.DeliverySucceeded, delivery.Status)
+	assert.Equal(t, http.StatusOK, delivery.StatusCode)
+	assert.Equal(t, 1, delivery.Attempts)
+
+	// Nothing is left to send
+	claimed, _ = tc.sender.SendOnce(context.Background())
+	assert.Equal(t, 0, claimed)
+}
+
+func TestSenderRetriesWithBackoff(t *testing.T) {
+	tc := setupSenderTest(t)
+	tc.receiver.status = http.StatusInternalServerError
+	tc.publish(t, entity.EventProductCreated)
+
+	tc.sender.SendOnce(context.Background())
+	delivery := tc.deliveries(t)[0]
+	assert.Equal(t, entity.DeliveryPending, delivery.Status)
+	assert.Equal(t, http.StatusInternalServerError, delivery.StatusCode)
+	assert.Equal(t, "unexpected status 500", delivery.Error)
+	assert.WithinDuration(t, tc.now.Add(tc.sender.BaseDelay), delivery.NextAttemptAt, time.Second)
+
+	// Not sent again before the delay
+	claimed, _ := tc.sender.SendOnce(context.Background())
+	assert.Equal(t, 0, claimed)
+
+	tc.now = tc.now.Add(tc.sender.BaseDelay + time.Second)
+	tc.receiver.status = http.StatusNoContent
+	claimed, _ = tc.sender.SendOnce(context.Background())
+	assert.Equal(t, 1, claimed)
+	delivery = tc.deliveries(t)[0]
+	assert.Equal(t, entity.DeliverySucceeded, delivery.Status)
+	assert.Equal(t, 2, delivery.Attempts)
+	assert.Empty(t, delivery.Error)
+
+	webhook, _ := tc.webhooks.FindByID(context.Background(), tc.webhook.ID.String())
+	assert.Equal(t, 0, webhook.Failures)
+}
+
+func TestSenderGivesUpAfterMaxAttempts(t *testing.T) {
+	tc := setupSenderTest(t)
+	tc.sender.MaxAttempts = 3
+	tc.receiver.status = http.StatusBadGateway
+	tc.publish(t, entity.EventProductCreated)
+
+	for range 3 {
+		claimed, _ := tc.sender.SendOnce(context.Background())
+		assert.Equal(t, 1, claimed)
+		tc.now = tc.now.Add(tc.sender.MaxDelay)
+	}
+	claimed, _ := tc.sender.SendOnce(context.Background())
+	assert.Equal(t, 0, claimed)
+
+	delivery := tc.deliveries(t)[0]
+	assert.Equal(t, entity.DeliveryFailed, delivery.Status)
+	assert.Equal(t, 3, delivery.Attempts)
+	assert.Equal(t, http.StatusBadGateway, delivery.StatusCode)
+	assert.Equal(t, 3, tc.receiver.received())
+}
+
+func TestSenderDisablesFailingWebhook(t *testing.T) {
+	tc := setupSenderTest(t)
+	tc.sender.DisableAfter = 2
+	tc.receiver.status = http.StatusInternalServerError
+	tc.publish(t, entity.EventProductCreated)
+	tc.publish(t, entity.EventProductCreated)
+	tc.publish(t, entity.EventProductCreated)
+
+	// Two failures disable the webhook, the third delivery is not sent
+	tc.sender.BatchSize = 2
+	tc.sender.SendOnce(context.Background())
+	webhook, _ := tc.webhooks.FindByID(context.Background(), tc.webhook.ID.String())
+	assert.False(t, webhook.Active)
+	assert.NotNil(t, webhook.DisabledAt)
+
+	tc.sender.SendOnce(context.Background())
+	assert.Equal(t, 2, tc.receiver.received())
+
+	failed := 0
+	for _, delivery := range tc.deliveries(t) {
+		if delivery.Status == entity.DeliveryFailed {
+			assert.Equal(t, "webhook disabled", delivery.Error)
+			failed++
+		}
+	}
+	assert.Equal(t, 1, failed)
+}
+
+func TestSenderWithDeletedWebhook(t *testing.T) {
+	tc := setupSenderTest(t)
+	tc.publish(t, entity.EventProductCreated)
+	delivery := tc.deliveries(t)[0]
+	// Deleting the webhook removes its deliveries, so the delivery is added back
+	assert.Nil(t, tc.webhooks.Delete(context.Background(), tc.webhook.ID.String()))
+	assert.Nil(t, tc.webhooks.AddDeliveries(context.Background(), &delivery))
+
+	claimed, err := tc.sender.SendOnce(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, claimed)
+	assert.Equal(t, 0, tc.receiver.received())
+	delivery = tc.deliveries(t)[0]
+	assert.Equal(t, entity.DeliveryFailed, delivery.Status)
+	assert.Equal(t, "webhook not found", delivery.Error)
+}
+
+func TestSenderRunWithoutLimits(t *testing.T) {
+	tc := setupSenderTest(t)
+	tc.publish(t, entity.EventProductCreated)
+
+	for _, clear := range []func(*Sender){
+		func(s *Sender) { s.Interval = 0 },
+		func(s *Sender) { s.BatchSize = 0 },
+		func(s *Sender) { s.MaxAttempts = 0 },
+		func(s *Sender) { s.DisableAfter = -1 },
+	} {
+		sender := *tc.sender
+		clear(&sender)
+		assert.Equal(t, ErrInvalidSender, sender.Run(context.Background()))
+	}
+	assert.Equal(t, 0, tc.receiver.received())
+}
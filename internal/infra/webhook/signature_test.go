@@ -0,0 +1,20 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"type":"product.created"}`)
+	signature := Sign("secret", 1700000000, body)
+	assert.Regexp(t, "^sha256=[0-9a-f]{64}$", signature)
+	assert.Equal(t, signature, Sign("secret", 1700000000, body))
+
+	assert.True(t, Verify("secret", 1700000000, body, signature))
+	assert.False(t, Verify("other", 1700000000, body, signature))
+	assert.False(t, Verify("secret", 1700000001, body, signature))
+	assert.False(t, Verify("secret", 1700000000, []byte(`{}`), signature))
+	assert.False(t, Verify("secret", 1700000000, body, ""))
+}
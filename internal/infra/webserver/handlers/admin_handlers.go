@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"goexpert-api/internal/dto"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	entityPkg "goexpert-api/pkg/entity"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// AdminHandler lets admins manage the users. Admins can't disable themselves
+// or change their own role, so there is always an admin left.
+type AdminHandler struct {
+	UserService database.UserInterface
+	Logger      *slog.Logger
+}
+
+func NewAdminHandler(service database.UserInterface, logger *slog.Logger) *AdminHandler {
+	return &AdminHandler{
+		UserService: service,
+		Logger:      logger,
+	}
+}
+
+// Get users godoc
+// @Summary      Get users
+// @Description  Get the users ordered by email, optionally searching by email or name
+// @Tags         admin
+// @Produce      json
+// @Param        page     query     string false "page number"
+// @Param        limit    query     string false "limit"
+// @Param        q        query     string false "text searched in the email and name"
+// @Success      200      {array}   entity.User
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      403      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /admin/users [get]
+// @Security     ApiKeyAuth
+func (h *AdminHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 0
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil {
+		limit = 0
+	}
+	users, err := h.UserService.FindAll(r.Context(), page, limit, r.URL.Query().Get("q"))
+	if err != nil {
+		h.Logger.ErrorContext(r.Context(), "error listing users", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		error := dto.ErrorOutput{Message: "server error"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(users)
+}
+
+// Get user godoc
+// @Summary      Get a user
+// @Description  Get a user
+// @Tags         admin
+// @Produce      json
+// @Param        id       path      string true "user id"
+// @Success      200      {object}  entity.User
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      403      {object}  dto.ErrorOutput
+// @Failure      404      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /admin/users/{id} [get]
+// @Security     ApiKeyAuth
+func (h *AdminHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findUser(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
+// Disable user godoc
+// @Summary      Disable a user
+// @Description  Disable a user, who can't log in and whose tokens stop working
+// @Tags         admin
+// @Produce      json
+// @Param        id       path      string true "user id"
+// @Success      200      {object}  entity.User
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      403      {object}  dto.ErrorOutput
+// @Failure      404      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /admin/users/{id}/disable [post]
+// @Security     ApiKeyAuth
+func (h *AdminHandler) DisableUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findOtherUser(w, r)
+	if !ok {
+		return
+	}
+	user.Disable(time.Now().UTC())
+	h.update(w, r, user, []string{"DisabledAt"}, "user disabled")
+}
+
+// Enable user godoc
+// @Summary      Enable a user
+// @Description  Enable a disabled user
+// @Tags         admin
+// @Produce      json
+// @Param        id       path      string true "user id"
+// @Success      200      {object}  entity.User
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      403      {object}  dto.ErrorOutput
+// @Failure      404      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /admin/users/{id}/enable [post]
+// @Security     ApiKeyAuth
+func (h *AdminHandler) EnableUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findUser(w, r)
+	if !ok {
+		return
+	}
+	user.Enable()
+	h.update(w, r, user, []string{"DisabledAt"}, "user enabled")
+}
+
+// Update user role godoc
+// @Summary      Change the role of a user
+// @Description  Change the role of a user to user or admin
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        id       path      string true "user id"
+// @Param        request  body      dto.UpdateUserRoleInput true "role"
+// @Success      200      {object}  entity.User
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      403      {object}  dto.ErrorOutput
+// @Failure      404      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /admin/users/{id}/role [put]
+// @Security     ApiKeyAuth
+func (h *AdminHandler) UpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findOtherUser(w, r)
+	if !ok {
+		return
+	}
+	var input dto.UpdateUserRoleInput
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: "invalid format", Code: "invalid_format"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	if err := user.SetRole(input.Role); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: err.Error(), Code: "invalid_role"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	h.update(w, r, user, []string{"Role"}, "user role changed", "role", user.Role)
+}
+
+// Reset user password godoc
+// @Summary      Force a user to change the password
+// @Description  Revoke the tokens of a user, who must change the password with POST /user/me/password before using the rest of the API
+// @Tags         admin
+// @Produce      json
+// @Param        id       path      string true "user id"
+// @Success      200      {object}  entity.User
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      403      {object}  dto.ErrorOutput
+// @Failure      404      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /admin/users/{id}/reset_password [post]
+// @Security     ApiKeyAuth
+func (h *AdminHandler) ResetUserPassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findUser(w, r)
+	if !ok {
+		return
+	}
+	user.RequirePasswordReset()
+	h.update(w, r, user, []string{"PasswordResetRequired", "TokenVersion"}, "user password reset")
+}
+
+// Disable user MFA godoc
+// @Summary      Disable the two-factor authentication of a user
+// @Description  Disable the two-factor authentication of a user who lost the authenticator app and the recovery codes. Admins can't disable their own.
+// @Tags         admin
+// @Produce      json
+// @Param        id       path      string true "user id"
+// @Success      200      {object}  entity.User
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      403      {object}  dto.ErrorOutput
+// @Failure      404      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /admin/users/{id}/mfa [delete]
+// @Security     ApiKeyAuth
+func (h *AdminHandler) DisableUserMFA(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findOtherUser(w, r)
+	if !ok {
+		return
+	}
+	user.DisableMFA()
+	h.update(w, r, user, entity.MFAFields, "user mfa disabled")
+}
+
+// update saves the fields of user changed by an admin and writes it, logging
+// message with args for the audit. The admin is checked again with the
+// update, in case it was demoted or disabled since the request started.
+func (h *AdminHandler) update(w http.ResponseWriter, r *http.Request, user *entity.User, fields []string, message string, args ...any) {
+	err := h.UserService.UpdateAsAdmin(r.Context(), subject(r), user, fields...)
+	if errors.Is(err, database.ErrNotAdmin) {
+		w.WriteHeader(http.StatusForbidden)
+		error := dto.ErrorOutput{Message: "forbidden"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	if errors.Is(err, database.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		error := dto.ErrorOutput{Message: "user not found"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	if err != nil {
+		h.Logger.ErrorContext(r.Context(), "error updating user", "user_id", user.ID.String(), "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		error := dto.ErrorOutput{Message: "server error"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	h.Logger.InfoContext(r.Context(), message, append([]any{"user_id", user.ID.String(), "admin_id", subject(r)}, args...)...)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
+// findUser loads the user of the id route parameter, writing the error
+// response when it is invalid or not found.
+func (h *AdminHandler) findUser(w http.ResponseWriter, r *http.Request) (*entity.User, bool) {
+	id := chi.URLParam(r, "id")
+	if _, err := entityPkg.ParseID(id); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: "invalid format"}
+		json.NewEncoder(w).Encode(error)
+		return nil, false
+	}
+	user, err := h.UserService.FindByID(r.Context(), id)
+	if errors.Is(err, database.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		error := dto.ErrorOutput{Message: "user not found"}
+		json.NewEncoder(w).Encode(error)
+		return nil, false
+	}
+	if err != nil {
+		h.Logger.ErrorContext(r.Context(), "error finding user", "id", id, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		error := dto.ErrorOutput{Message: "server error"}
+		json.NewEncoder(w).Encode(error)
+		return nil, false
+	}
+	return user, true
+}
+
+// findOtherUser is findUser refusing the admin making the request.
+func (h *AdminHandler) findOtherUser(w http.ResponseWriter, r *http.Request) (*entity.User, bool) {
+	if chi.URLParam(r, "id") == subject(r) {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: "admins can't change themselves", Code: "cannot_change_self"}
+		json.NewEncoder(w).Encode(error)
+		return nil, false
+	}
+	return h.findUser(w, r)
+}
@@ -0,0 +1,174 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"goexpert-api/internal/dto"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database/memory"
+	entityPkg "goexpert-api/pkg/entity"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setupAdminServer returns a test server and the token of an admin.
+func setupAdminServer(t *testing.T) (*testServer, string) {
+	s := setupTestServer(t)
+	admin := s.createUser(t, "Admin", "admin@doe.com", entity.RoleAdmin)
+	return s, s.tokenFor(t, admin, time.Minute)
+}
+
+func TestAdminWhenNotAdmin(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodGet, "/v1/admin/users", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = s.request(http.MethodGet, "/v1/admin/users", s.validToken(t), "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = s.request(http.MethodPost, "/v1/admin/users/"+s.user.ID.String()+"/disable", s.validToken(t), "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestAdminGetUsers(t *testing.T) {
+	s, token := setupAdminServer(t)
+	s.createUser(t, "Jane Doe", "jane@doe.com", entity.RoleUser)
+
+	w := s.request(http.MethodGet, "/v1/admin/users", token, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var users []entity.User
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&users))
+	assert.Len(t, users, 3)
+	assert.NotContains(t, w.Body.String(), "password\":")
+
+	w = s.request(http.MethodGet, "/v1/admin/users?q=JANE", token, "")
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&users))
+	assert.Len(t, users, 1)
+	assert.Equal(t, "jane@doe.com", users[0].Email)
+
+	w = s.request(http.MethodGet, "/v1/admin/users?page=2&limit=2", token, "")
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&users))
+	assert.Len(t, users, 1)
+	assert.Equal(t, testEmail, users[0].Email)
+}
+
+func TestAdminGetUser(t *testing.T) {
+	s, token := setupAdminServer(t)
+
+	w := s.request(http.MethodGet, "/v1/admin/users/"+s.user.ID.String(), token, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"role":"user"`)
+
+	w = s.request(http.MethodGet, "/v1/admin/users/abc", token, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = s.request(http.MethodGet, "/v1/admin/users/"+entityPkg.NewID().String(), token, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdminDisableUser(t *testing.T) {
+	s, token := setupAdminServer(t)
+	userToken := s.validToken(t)
+	path := "/v1/admin/users/" + s.user.ID.String()
+
+	w := s.request(http.MethodPost, path+"/disable", token, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"disabled_at"`)
+
+	// Refused on existing tokens and at login
+	w = s.request(http.MethodGet, "/v1/products", userToken, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	var output dto.ErrorOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+	assert.Equal(t, "user_disabled", output.Code)
+	// Unless the password is wrong
+	w = s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"wrong"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = s.request(http.MethodPost, path+"/enable", token, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = s.request(http.MethodGet, "/v1/products", userToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAdminUpdateUserRole(t *testing.T) {
+	s, token := setupAdminServer(t)
+	path := "/v1/admin/users/" + s.user.ID.String() + "/role"
+
+	w := s.request(http.MethodPut, path, token, `{"role":"admin"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	user, _ := s.users.FindByID(context.Background(), s.user.ID.String())
+	assert.Equal(t, entity.RoleAdmin, user.Role)
+	// The new admin uses the admin routes with the same token
+	w = s.request(http.MethodGet, "/v1/admin/users", s.validToken(t), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = s.request(http.MethodPut, path, token, `{"role":"root"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_role")
+	w = s.request(http.MethodPut, path, token, `{"role":`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAdminCannotChangeThemselves(t *testing.T) {
+	s := setupTestServer(t)
+	admin := s.createUser(t, "Admin", "admin@doe.com", entity.RoleAdmin)
+	token := s.tokenFor(t, admin, time.Minute)
+	path := "/v1/admin/users/" + admin.ID.String()
+
+	w := s.request(http.MethodPost, path+"/disable", token, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "cannot_change_self")
+	w = s.request(http.MethodPut, path+"/role", token, `{"role":"user"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAdminResetUserPassword(t *testing.T) {
+	s, token := setupAdminServer(t)
+	oldToken := s.validToken(t)
+
+	w := s.request(http.MethodPost, "/v1/admin/users/"+s.user.ID.String()+"/reset_password", token, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = s.request(http.MethodGet, "/v1/products", oldToken, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// A new token only gives access to the account routes
+	w = s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var output dto.GetJWTOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+	w = s.request(http.MethodGet, "/v1/products", output.AccessToken, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "password_change_required")
+	w = s.request(http.MethodGet, "/v1/user/me", output.AccessToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = s.request(http.MethodPost, "/v1/user/me/password", output.AccessToken, `{"current_password":"abc123","new_password":"def45678"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+	w = s.request(http.MethodGet, "/v1/products", output.AccessToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// failingFindAll lists users with errDatabase, the other calls use memory.
+type failingFindAll struct {
+	*memory.UserService
+}
+
+func (failingFindAll) FindAll(context.Context, int, int, string) ([]entity.User, error) {
+	return nil, errDatabase
+}
+
+func TestAdminWhenDatabaseFails(t *testing.T) {
+	users := failingFindAll{memory.NewUserService()}
+	s := setupTestServer(t, withUsers(users))
+	admin, _ := entity.NewUser("Admin", "admin@doe.com", testPassword)
+	admin.Role = entity.RoleAdmin
+	assert.Nil(t, users.Create(context.Background(), admin))
+
+	w := s.request(http.MethodGet, "/v1/admin/users", s.tokenFor(t, admin, time.Minute), "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"goexpert-api/internal/dto"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	entityPkg "goexpert-api/pkg/entity"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// apiKeyErrorCodes are the codes of the errors returned when creating an API
+// key.
+var apiKeyErrorCodes = []struct {
+	err  error
+	code string
+}{
+	{entity.ErrNameIsRequired, "name_required"},
+	{entity.ErrAPIKeyNameTooLong, "name_too_long"},
+	{entity.ErrScopesRequired, "scopes_required"},
+	{entity.ErrInvalidScope, "invalid_scope"},
+	{entity.ErrInvalidAPIKeyExpires, "invalid_expiration"},
+}
+
+// APIKeyHandler manages the API keys of the authenticated user. Keys of other
+// users are reported as not found.
+type APIKeyHandler struct {
+	APIKeyService database.APIKeyInterface
+	// Validity of the keys created without one, and the longest allowed
+	TTL    time.Duration
+	MaxTTL time.Duration
+	Logger *slog.Logger
+}
+
+func NewAPIKeyHandler(service database.APIKeyInterface, ttl, maxTTL time.Duration, logger *slog.Logger) *APIKeyHandler {
+	return &APIKeyHandler{
+		APIKeyService: service,
+		TTL:           ttl,
+		MaxTTL:        maxTTL,
+		Logger:        logger,
+	}
+}
+
+// Create API key godoc
+// @Summary      Create an API key
+// @Description  Create an API key of the authenticated user, sent by machine clients in the X-API-Key header, limited to the given scopes. The key is only returned here. Invalid data is reported with the name_required, name_too_long, scopes_required, invalid_scope or invalid_expiration codes.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        request  body      dto.CreateAPIKeyInput true "API key data"
+// @Success      201      {object}  dto.CreateAPIKeyOutput
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /user/me/api_keys [post]
+// @Security     ApiKeyAuth
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var input dto.CreateAPIKeyInput
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: "invalid format", Code: "invalid_format"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	ttl := h.TTL
+	if input.ExpiresInDays != 0 {
+		// Compared in days, as huge durations overflow
+		if input.ExpiresInDays > int(h.MaxTTL/(24*time.Hour)) {
+			invalidAPIKey(w, entity.ErrInvalidAPIKeyExpires)
+			return
+		}
+		ttl = time.Duration(input.ExpiresInDays) * 24 * time.Hour
+	}
+	now := time.Now().UTC()
+	apiKey, key, err := entity.NewAPIKey(subject(r), input.Name, input.Scopes, now.Add(ttl), now)
+	if err != nil {
+		invalidAPIKey(w, err)
+		return
+	}
+	err = h.APIKeyService.Create(r.Context(), apiKey)
+	if err != nil {
+		h.serverError(w, r, "error creating api key", err)
+		return
+	}
+	h.Logger.InfoContext(r.Context(), "api key created", "api_key_id", apiKey.ID.String(), "scopes", apiKey.Scopes)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dto.CreateAPIKeyOutput{ID: apiKey.ID.String(), Key: key, ExpiresAt: apiKey.ExpiresAt})
+}
+
+// Get API keys godoc
+// @Summary      Get all API keys
+// @Description  Get all API keys of the authenticated user, revoked and expired ones included
+// @Tags         users
+// @Produce      json
+// @Success      200      {array}   entity.APIKey
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /user/me/api_keys [get]
+// @Security     ApiKeyAuth
+func (h *APIKeyHandler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	apiKeys, err := h.APIKeyService.FindByUser(r.Context(), subject(r))
+	if err != nil {
+		h.serverError(w, r, "error listing api keys", err)
+		return
+	}
+	if apiKeys == nil {
+		apiKeys = []entity.APIKey{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(apiKeys)
+}
+
+// Revoke API key godoc
+// @Summary      Revoke an API key
+// @Description  Revoke an API key of the authenticated user, refused from then on. Revoking a revoked key does nothing.
+// @Tags         users
+// @Param        id       path      string true "API key id"
+// @Success      204
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      404      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /user/me/api_keys/{id} [delete]
+// @Security     ApiKeyAuth
+func (h *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := entityPkg.ParseID(id); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: "invalid format"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	apiKey, err := h.APIKeyService.FindByID(r.Context(), id)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		h.serverError(w, r, "error finding api key", err)
+		return
+	}
+	if apiKey == nil || apiKey.UserID != subject(r) {
+		w.WriteHeader(http.StatusNotFound)
+		error := dto.ErrorOutput{Message: "api key not found"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	// A key revoked concurrently is not found
+	err = h.APIKeyService.Revoke(r.Context(), id, time.Now().UTC())
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		h.serverError(w, r, "error revoking api key", err)
+		return
+	}
+	if err == nil {
+		h.Logger.InfoContext(r.Context(), "api key revoked", "api_key_id", id)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// invalidAPIKey writes the 400 response of an invalid API key.
+func invalidAPIKey(w http.ResponseWriter, err error) {
+	output := dto.ErrorOutput{Message: "invalid api key data", Code: "invalid_api_key"}
+	for _, apiKeyError := range apiKeyErrorCodes {
+		if errors.Is(err, apiKeyError.err) {
+			output = dto.ErrorOutput{Message: err.Error(), Code: apiKeyError.code}
+			break
+		}
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(output)
+}
+
+func (h *APIKeyHandler) serverError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	h.Logger.ErrorContext(r.Context(), message, "error", err)
+	w.WriteHeader(http.StatusInternalServerError)
+	error := dto.ErrorOutput{Message: "server error"}
+	json.NewEncoder(w).Encode(error)
+}
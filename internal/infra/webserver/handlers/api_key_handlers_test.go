@@ -0,0 +1,216 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"goexpert-api/internal/dto"
+	"goexpert-api/internal/entity"
+	entityPkg "goexpert-api/pkg/entity"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// createAPIKey creates an API key of the test user with scopes, returning it.
+func (s *testServer) createAPIKey(t *testing.T, scopes ...string) dto.CreateAPIKeyOutput {
+	input, _ := json.Marshal(dto.CreateAPIKeyInput{Name: "CI", Scopes: scopes})
+	w := s.request(http.MethodPost, "/v1/user/me/api_keys", s.validToken(t), string(input))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var output dto.CreateAPIKeyOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+	return output
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	s := setupTestServer(t)
+
+	output := s.createAPIKey(t, entity.ScopeProductsRead)
+	assert.NotEmpty(t, output.ID)
+	assert.Regexp(t, `^gxk_[0-9a-f]+_[0-9a-f]+$`, output.Key)
+	assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), output.ExpiresAt, time.Minute)
+
+	w := s.request(http.MethodPost, "/v1/user/me/api_keys", s.validToken(t), `{"name":"deploy","scopes":["products:write"],"expires_in_days":90}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// The key is not shown again
+	w = s.request(http.MethodGet, "/v1/user/me/api_keys", s.validToken(t), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), output.Key)
+	var apiKeys []entity.APIKey
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&apiKeys))
+	if assert.Len(t, apiKeys, 2) {
+		assert.Equal(t, output.ID, apiKeys[0].ID.String())
+		assert.Equal(t, "CI", apiKeys[0].Name)
+		assert.Equal(t, []string{entity.ScopeProductsRead}, apiKeys[0].Scopes)
+		assert.Contains(t, output.Key, apiKeys[0].Prefix)
+		assert.Equal(t, "deploy", apiKeys[1].Name)
+	}
+
+	// Keys of other users are not listed
+	other := s.createUser(t, "Jane Doe", "jane@doe.com", entity.RoleUser)
+	w = s.request(http.MethodGet, "/v1/user/me/api_keys", s.tokenFor(t, other, time.Minute), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, "[]", w.Body.String())
+}
+
+func TestCreateAPIKeyWhenInputIsInvalid(t *testing.T) {
+	s := setupTestServer(t)
+
+	tests := []struct {
+		body string
+		code string
+	}{
+		{`{"name":`, "invalid_format"},
+		{`{"name":" ","scopes":["products:read"]}`, "name_required"},
+		{`{"name":"CI"}`, "scopes_required"},
+		{`{"name":"CI","scopes":["users:write"]}`, "invalid_scope"},
+		{`{"name":"CI","scopes":["products:read"],"expires_in_days":-1}`, "invalid_expiration"},
+		{`{"name":"CI","scopes":["products:read"],"expires_in_days":91}`, "invalid_expiration"},
+		{`{"name":"CI","scopes":["products:read"],"expires_in_days":9223372036854775807}`, "invalid_expiration"},
+	}
+	for _, tt := range tests {
+		w := s.request(http.MethodPost, "/v1/user/me/api_keys", s.validToken(t), tt.body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, tt.body)
+		assert.Contains(t, w.Body.String(), tt.code, tt.body)
+	}
+}
+
+func TestProductsWithAPIKey(t *testing.T) {
+	s := setupTestServer(t)
+	product := s.createProduct(t, "Product 1", 10)
+	readKey := s.createAPIKey(t, entity.ScopeProductsRead).Key
+	writeKey := s.createAPIKey(t, entity.ScopeProductsWrite).Key
+
+	w := s.request(http.MethodGet, "/v1/products", "", "", "X-API-Key", readKey)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = s.request(http.MethodGet, "/v1/products/"+product.ID.String(), "", "", "X-API-Key", readKey)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Writes need the products:write scope
+	w = s.request(http.MethodPost, "/v1/products", "", `{"name":"Product 2","price":20}`, "X-API-Key", readKey)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "insufficient_scope")
+	w = s.request(http.MethodDelete, "/v1/products/"+product.ID.String(), "", "", "X-API-Key", readKey)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = s.request(http.MethodGet, "/v1/products", "", "", "X-API-Key", writeKey)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = s.request(http.MethodPost, "/v1/products", "", `{"name":"Product 2","price":20}`, "X-API-Key", writeKey)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = s.request(http.MethodPut, "/v1/products/"+product.ID.String(), "", `{"name":"Product 1","price":15}`, "X-API-Key", writeKey)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// JWTs are not limited by scopes
+	w = s.request(http.MethodDelete, "/v1/products/"+product.ID.String(), s.validToken(t), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAPIKeyIsRefused(t *testing.T) {
+	s := setupTestServer(t)
+	ctx := context.Background()
+	key := s.createAPIKey(t, entity.ScopeProductsRead).Key
+	now := time.Now()
+	expired, expiredKey, err := entity.NewAPIKey(s.user.ID.String(), "expired", []string{entity.ScopeProductsRead}, now.Add(time.Second), now)
+	assert.Nil(t, err)
+	expired.ExpiresAt = now.Add(-time.Second)
+	assert.Nil(t, s.apiKeys.Create(ctx, expired))
+
+	for _, invalid := range []string{"abc", key + "0", "gxk_0123456789abcdef_0123", expiredKey} {
+		w := s.request(http.MethodGet, "/v1/products", "", "", "X-API-Key", invalid)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, invalid)
+		assert.Contains(t, w.Body.String(), "invalid_api_key", invalid)
+	}
+
+	// API keys only work on the product routes
+	w := s.request(http.MethodGet, "/v1/user/me", "", "", "X-API-Key", key)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = s.request(http.MethodPost, "/v1/user/me/api_keys", "", `{"name":"CI","scopes":["products:read"]}`, "X-API-Key", key)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Disabled users can't use their keys
+	s.user.Disable(now)
+	assert.Nil(t, s.users.Update(ctx, s.user, "DisabledAt"))
+	w = s.request(http.MethodGet, "/v1/products", "", "", "X-API-Key", key)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAPIKeyOutlivesPasswordChange(t *testing.T) {
+	s := setupTestServer(t)
+	key := s.createAPIKey(t, entity.ScopeProductsRead).Key
+
+	w := s.request(http.MethodPost, "/v1/user/me/password", s.validToken(t), `{"current_password":"abc123","new_password":"def45678"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = s.request(http.MethodGet, "/v1/products", "", "", "X-API-Key", key)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAPIKeyRecordsLastUse(t *testing.T) {
+	s := setupTestServer(t)
+	output := s.createAPIKey(t, entity.ScopeProductsRead)
+
+	w := s.request(http.MethodGet, "/v1/products", "", "", "X-API-Key", output.Key)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	apiKey, err := s.apiKeys.FindByID(context.Background(), output.ID)
+	assert.Nil(t, err)
+	if assert.NotNil(t, apiKey.LastUsedAt) {
+		assert.WithinDuration(t, time.Now(), *apiKey.LastUsedAt, time.Minute)
+	}
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	s := setupTestServer(t)
+	output := s.createAPIKey(t, entity.ScopeProductsRead)
+	other := s.createUser(t, "Jane Doe", "jane@doe.com", entity.RoleUser)
+
+	w := s.request(http.MethodDelete, "/v1/user/me/api_keys/"+output.ID, s.tokenFor(t, other, time.Minute), "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = s.request(http.MethodDelete, "/v1/user/me/api_keys/"+entityPkg.NewID().String(), s.validToken(t), "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = s.request(http.MethodDelete, "/v1/user/me/api_keys/abc", s.validToken(t), "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = s.request(http.MethodDelete, "/v1/user/me/api_keys/"+output.ID, s.validToken(t), "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = s.request(http.MethodGet, "/v1/products", "", "", "X-API-Key", output.Key)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = s.request(http.MethodGet, "/v1/user/me/api_keys", s.validToken(t), "")
+	assert.Contains(t, w.Body.String(), "revoked_at")
+
+	w = s.request(http.MethodDelete, "/v1/user/me/api_keys/"+output.ID, s.validToken(t), "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+// failingAPIKeyService fails every call with errDatabase.
+type failingAPIKeyService struct{}
+
+func (failingAPIKeyService) Create(context.Context, *entity.APIKey) error { return errDatabase }
+func (failingAPIKeyService) FindByID(context.Context, string) (*entity.APIKey, error) {
+	return nil, errDatabase
+}
+func (failingAPIKeyService) FindByPrefix(context.Context, string) (*entity.APIKey, error) {
+	return nil, errDatabase
+}
+func (failingAPIKeyService) FindByUser(context.Context, string) ([]entity.APIKey, error) {
+	return nil, errDatabase
+}
+func (failingAPIKeyService) Revoke(context.Context, string, time.Time) error { return errDatabase }
+func (failingAPIKeyService) UpdateLastUsed(context.Context, string, time.Time) error {
+	return errDatabase
+}
+
+func TestAPIKeysWhenDatabaseFails(t *testing.T) {
+	s := setupTestServer(t, withAPIKeys(failingAPIKeyService{}))
+
+	w := s.request(http.MethodPost, "/v1/user/me/api_keys", s.validToken(t), `{"name":"CI","scopes":["products:read"]}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	w = s.request(http.MethodGet, "/v1/user/me/api_keys", s.validToken(t), "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	w = s.request(http.MethodDelete, "/v1/user/me/api_keys/"+entityPkg.NewID().String(), s.validToken(t), "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	w = s.request(http.MethodGet, "/v1/products", "", "", "X-API-Key", "gxk_0123456789abcdef_0123")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
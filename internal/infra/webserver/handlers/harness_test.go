@@ -0,0 +1,331 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"goexpert-api/configs"
+	"goexpert-api/internal/app"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"goexpert-api/internal/infra/database/memory"
+	"goexpert-api/internal/infra/mail"
+	"goexpert-api/internal/infra/ratelimit"
+	"goexpert-api/pkg/jwtkeys"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errDatabase = errors.New("database is down")
+
+const (
+	testEmail    = "john@doe.com"
+	testPassword = "abc123"
+
+	testVerificationSecret = "verification secret"
+	testMFASecret          = "mfa secret"
+)
+
+// testServer is the complete API built by the app package, backed by
+// in-memory repositories.
+type testServer struct {
+	router    http.Handler
+	products  database.ProductInterface
+	users     database.UserInterface
+	webhooks  database.WebhookInterface
+	resets    database.PasswordResetInterface
+	apiKeys   database.APIKeyInterface
+	clients   database.OAuthClientInterface
+	revoked   database.RevokedTokenInterface
+	mailer    *mail.MemoryMailer
+	tokenAuth *jwtkeys.KeySet
+	clock     *testClock
+	user      *entity.User
+}
+
+type testServerOptions struct {
+	products         database.ProductInterface
+	users            database.UserInterface
+	webhooks         database.WebhookInterface
+	resets           database.PasswordResetInterface
+	apiKeys          database.APIKeyInterface
+	clients          database.OAuthClientInterface
+	revoked          database.RevokedTokenInterface
+	rateLimitStore   ratelimit.Store
+	tokenAuth        *jwtkeys.KeySet
+	unverifiedLogin  string
+	mfaRequiredRoles []string
+}
+
+type testServerOption func(*testServerOptions)
+
+// testClock is the clock of the rate limits of the test server. It stands
+// still unless advanced, so the limits don't depend on how long the requests
+// take (e.g. hashing passwords under the race detector).
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func withProducts(products database.ProductInterface) testServerOption {
+	return func(o *testServerOptions) { o.products = products }
+}
+
+func withUsers(users database.UserInterface) testServerOption {
+	return func(o *testServerOptions) { o.users = users }
+}
+
+// withWebhooks serves the webhook routes, which are not served by default.
+func withWebhooks(webhooks database.WebhookInterface) testServerOption {
+	return func(o *testServerOptions) { o.webhooks = webhooks }
+}
+
+func withPasswordResets(resets database.PasswordResetInterface) testServerOption {
+	return func(o *testServerOptions) { o.resets = resets }
+}
+
+func withAPIKeys(apiKeys database.APIKeyInterface) testServerOption {
+	return func(o *testServerOptions) { o.apiKeys = apiKeys }
+}
+
+func withOAuth(clients database.OAuthClientInterface, revoked database.RevokedTokenInterface) testServerOption {
+	return func(o *testServerOptions) {
+		o.clients = clients
+		o.revoked = revoked
+	}
+}
+
+// withTokenAuth sets the keys of the tokens, an HMAC secret by default.
+func withTokenAuth(tokenAuth *jwtkeys.KeySet) testServerOption {
+	return func(o *testServerOptions) { o.tokenAuth = tokenAuth }
+}
+
+// withUnverifiedLogin sets the policy of the logins of users who didn't verify
+// the email, which are allowed by default.
+func withUnverifiedLogin(policy string) testServerOption {
+	return func(o *testServerOptions) { o.unverifiedLogin = policy }
+}
+
+// withMFARequiredRoles sets the roles that must enable two-factor
+// authentication, none by default.
+func withMFARequiredRoles(roles ...string) testServerOption {
+	return func(o *testServerOptions) { o.mfaRequiredRoles = roles }
+}
+
+func withRateLimitStore(store ratelimit.Store) testServerOption {
+	return func(o *testServerOptions) { o.rateLimitStore = store }
+}
+
+func setupTestServer(t *testing.T, opts ...testServerOption) *testServer {
+	clock := &testClock{now: time.Now()}
+	store := ratelimit.NewMemoryStore()
+	store.Now = clock.Now
+	options := &testServerOptions{
+		products:       memory.NewProductService(),
+		users:          memory.NewUserService(),
+		resets:         memory.NewPasswordResetService(),
+		apiKeys:        memory.NewAPIKeyService(),
+		clients:        memory.NewOAuthClientService(),
+		revoked:        memory.NewRevokedTokenService(),
+		rateLimitStore: store,
+		tokenAuth:      jwtkeys.NewHMAC([]byte("secret")),
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	config := &configs.Config{
+		JWTExpiresIn:          300,
+		LoginIPRatePerMin:     60,
+		LoginIPBurst:          100,
+		LoginEmailRatePerMin:  60,
+		LoginEmailBurst:       3,
+		LoginLockoutFailures:  5,
+		LoginLockoutBaseDelay: 60,
+		LoginLockoutMaxDelay:  3600,
+		IdempotencyKeyTTL:     3600,
+		PasswordMinLength:     8,
+		PasswordMinClasses:    2,
+		PasswordBreachedFile:  "testdata/breached_passwords.txt",
+		PasswordResetURL:      "http://localhost:3000/reset_password",
+		PasswordResetTTL:      3600,
+		PasswordResetPerHour:  2,
+		VerificationSecret:    testVerificationSecret,
+		VerificationURL:       "http://localhost:8000/v1/user/verify",
+		VerificationTTL:       3600,
+		VerificationPerHour:   2,
+		UnverifiedLogin:       options.unverifiedLogin,
+		MFAIssuer:             "Go Expert API",
+		MFAChallengeTTL:       300,
+		MFASecret:             testMFASecret,
+		MFARequiredRoles:      options.mfaRequiredRoles,
+		APIKeyTTLDays:         30,
+		APIKeyMaxTTLDays:      90,
+		OAuthTokenTTL:         600,
+		TokenAuth:             options.tokenAuth,
+	}
+
+	// Users are created straight in memory, even when the server uses a failing repository
+	user, err := entity.NewUser("John Doe", testEmail, testPassword)
+	assert.Nil(t, err)
+	if users, ok := options.users.(*memory.UserService); ok {
+		assert.Nil(t, users.Create(context.Background(), user))
+	}
+
+	mailer := mail.NewMemoryMailer()
+	appOptions := []app.Option{
+		app.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		app.WithProductRepository(options.products),
+		app.WithUserRepository(options.users),
+		app.WithRateLimitStore(options.rateLimitStore),
+		app.WithClock(clock.Now),
+		// Sends the emails before the response, so tests can read them
+		app.WithBackground(func(fn func()) { fn() }),
+		app.WithPasswordResetRepository(options.resets),
+		app.WithAPIKeyRepository(options.apiKeys),
+		app.WithOAuthRepositories(options.clients, options.revoked),
+		app.WithMailer(mailer),
+	}
+	if options.webhooks != nil {
+		appOptions = append(appOptions, app.WithWebhookRepository(options.webhooks))
+	}
+	router, err := app.New(config, appOptions...)
+	assert.Nil(t, err)
+	return &testServer{
+		router:    router,
+		products:  options.products,
+		users:     options.users,
+		webhooks:  options.webhooks,
+		resets:    options.resets,
+		apiKeys:   options.apiKeys,
+		clients:   options.clients,
+		revoked:   options.revoked,
+		mailer:    mailer,
+		tokenAuth: options.tokenAuth,
+		clock:     clock,
+		user:      user,
+	}
+}
+
+// token returns a JWT for the test user expiring after expiresIn.
+func (s *testServer) token(t *testing.T, expiresIn time.Duration) string {
+	return s.tokenFor(t, s.user, expiresIn)
+}
+
+func (s *testServer) tokenFor(t *testing.T, user *entity.User, expiresIn time.Duration) string {
+	_, token, err := s.tokenAuth.Encode(map[string]interface{}{
+		"sub":                    user.ID.String(),
+		"exp":                    time.Now().Add(expiresIn).Unix(),
+		entity.TokenVersionClaim: user.TokenVersion,
+	})
+	assert.Nil(t, err)
+	return token
+}
+
+// createUser adds a user with the test password and role to the repository.
+func (s *testServer) createUser(t *testing.T, name, email, role string) *entity.User {
+	user, err := entity.NewUser(name, email, testPassword)
+	assert.Nil(t, err)
+	assert.Nil(t, user.SetRole(role))
+	assert.Nil(t, s.users.Create(context.Background(), user))
+	return user
+}
+
+func (s *testServer) validToken(t *testing.T) string {
+	return s.token(t, time.Minute)
+}
+
+// request sends a request to the router, with the given token if not empty.
+func (s *testServer) request(method, path, token, body string, headers ...string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, path, reader)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+	return w
+}
+
+func (s *testServer) createProduct(t *testing.T, name string, price float64) *entity.Product {
+	product, err := entity.NewProduct(name, price)
+	assert.Nil(t, err)
+	assert.Nil(t, s.products.Create(context.Background(), product))
+	return product
+}
+
+// failingProductService fails every call with errDatabase.
+type failingProductService struct{}
+
+func (failingProductService) Create(context.Context, *entity.Product) error { return errDatabase }
+func (failingProductService) FindAll(context.Context, int, int, string) ([]entity.Product, error) {
+	return nil, errDatabase
+}
+func (failingProductService) FindByID(context.Context, string) (*entity.Product, error) {
+	return nil, errDatabase
+}
+func (failingProductService) Update(context.Context, *entity.Product) error { return errDatabase }
+func (failingProductService) Delete(context.Context, string) error          { return errDatabase }
+
+// failingUserService fails every call with errDatabase.
+type failingUserService struct{}
+
+func (failingUserService) Create(context.Context, *entity.User) error { return errDatabase }
+func (failingUserService) FindByID(context.Context, string) (*entity.User, error) {
+	return nil, errDatabase
+}
+func (failingUserService) FindByEmail(context.Context, string) (*entity.User, error) {
+	return nil, errDatabase
+}
+func (failingUserService) FindAll(context.Context, int, int, string) ([]entity.User, error) {
+	return nil, errDatabase
+}
+func (failingUserService) Update(context.Context, *entity.User, ...string) error {
+	return errDatabase
+}
+func (failingUserService) UpdateAsAdmin(context.Context, string, *entity.User, ...string) error {
+	return errDatabase
+}
+func (failingUserService) UpdateMFA(context.Context, *entity.User, string, bool) error {
+	return errDatabase
+}
+func (failingUserService) UseMFACode(context.Context, string, entity.MFACode) error {
+	return errDatabase
+}
+func (failingUserService) Delete(context.Context, string) error { return errDatabase }
+
+// failingRateLimitStore fails every call with errDatabase.
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Get(context.Context, string) (ratelimit.State, error) {
+	return ratelimit.State{}, errDatabase
+}
+func (failingRateLimitStore) Update(context.Context, string, time.Duration, func(ratelimit.State) ratelimit.State) (ratelimit.State, error) {
+	return ratelimit.State{}, errDatabase
+}
+func (failingRateLimitStore) Delete(context.Context, string) error { return errDatabase }
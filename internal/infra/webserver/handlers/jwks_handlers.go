@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"encoding/json"
+	"goexpert-api/pkg/jwtkeys"
+	"log/slog"
+	"net/http"
+)
+
+// jwksMaxAge is how long, in seconds, other services may cache the keys. A
+// new signing key must be published at least this long before it is used.
+const jwksMaxAge = "300"
+
+// JWKSHandler publishes the public keys that verify the tokens of the API as
+// a JWK set (RFC 7517), so other services can verify them without a secret.
+type JWKSHandler struct {
+	TokenAuth *jwtkeys.KeySet
+	Logger    *slog.Logger
+}
+
+func NewJWKSHandler(tokenAuth *jwtkeys.KeySet, logger *slog.Logger) *JWKSHandler {
+	return &JWKSHandler{
+		TokenAuth: tokenAuth,
+		Logger:    logger,
+	}
+}
+
+// GetJWKS returns the public keys, each one with the kid of the tokens it
+// verifies. The set is empty when the tokens are signed with JWT_SECRET.
+func (h *JWKSHandler) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(h.TokenAuth.PublicKeys())
+	if err != nil {
+		h.Logger.ErrorContext(r.Context(), "error encoding the public keys", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age="+jwksMaxAge)
+	w.Write(data)
+}
@@ -0,0 +1,107 @@
+package handlers_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"goexpert-api/internal/dto"
+	"goexpert-api/internal/entity"
+	"goexpert-api/pkg/jwtkeys"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+// getJWKS returns the JWK set published by the server.
+func (s *testServer) getJWKS(t *testing.T) jwk.Set {
+	w := s.request(http.MethodGet, "/.well-known/jwks.json", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Cache-Control"), "max-age=")
+	data, _ := io.ReadAll(w.Body)
+	set, err := jwk.Parse(data)
+	assert.Nil(t, err)
+	return set
+}
+
+func TestGetJWKS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	tokenAuth, err := jwtkeys.New(key)
+	assert.Nil(t, err)
+	s := setupTestServer(t, withTokenAuth(tokenAuth))
+
+	w := s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var output dto.GetJWTOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+	message, err := jws.ParseString(output.AccessToken)
+	assert.Nil(t, err)
+	headers := message.Signatures()[0].ProtectedHeaders()
+	assert.Equal(t, "ES256", headers.Algorithm().String())
+
+	// Other services verify the tokens with the published key of their kid
+	set := s.getJWKS(t)
+	public, ok := set.LookupKeyID(headers.KeyID())
+	if assert.True(t, ok) {
+		var raw interface{}
+		assert.Nil(t, public.Raw(&raw))
+		token, err := jwt.ParseString(output.AccessToken, jwt.WithVerify(headers.Algorithm(), raw))
+		assert.Nil(t, err)
+		assert.Equal(t, s.user.ID.String(), token.Subject())
+	}
+	w = s.request(http.MethodGet, "/v1/user/me", output.AccessToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestGetJWKSWhenKeysRotate(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	oldAuth, _ := jwtkeys.New(oldKey)
+	newAuth, _ := jwtkeys.New(newKey, oldKey.Public())
+	s := setupTestServer(t, withTokenAuth(newAuth))
+
+	// The tokens of the previous key are still accepted, and both keys published
+	w := s.request(http.MethodGet, "/v1/user/me", s.tokenWith(t, oldAuth), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = s.request(http.MethodGet, "/v1/user/me", s.token(t, time.Minute), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, s.getJWKS(t).Len())
+
+	// Until the key is removed
+	removed, _ := jwtkeys.New(newKey)
+	s = setupTestServer(t, withTokenAuth(removed))
+	w = s.request(http.MethodGet, "/v1/user/me", s.tokenWith(t, oldAuth), "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, 1, s.getJWKS(t).Len())
+}
+
+// tokenWith returns a JWT for the test user signed with tokenAuth.
+func (s *testServer) tokenWith(t *testing.T, tokenAuth *jwtkeys.KeySet) string {
+	_, token, err := tokenAuth.Encode(map[string]interface{}{
+		"sub":                    s.user.ID.String(),
+		"exp":                    time.Now().Add(time.Minute).Unix(),
+		entity.TokenVersionClaim: s.user.TokenVersion,
+	})
+	assert.Nil(t, err)
+	return token
+}
+
+func TestGetJWKSWhenSignedWithSecret(t *testing.T) {
+	s := setupTestServer(t)
+
+	// The secret is never published
+	w := s.request(http.MethodGet, "/.well-known/jwks.json", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"keys":[]}`, w.Body.String())
+}
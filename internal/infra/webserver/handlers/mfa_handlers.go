@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"goexpert-api/internal/dto"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"goexpert-api/internal/infra/ratelimit"
+	"goexpert-api/pkg/jwtkeys"
+	"goexpert-api/pkg/totp"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// MFAHandler enrolls the users in two-factor authentication with TOTP codes,
+// and completes their logins with a code. The MFA tokens of the first step of
+// the login are signed with Secret.
+type MFAHandler struct {
+	UserService  database.UserInterface
+	TokenAuth    *jwtkeys.KeySet
+	JWTExpiresIn int
+	Secret       []byte
+	ChallengeTTL time.Duration
+	// Name of the API shown by the authenticator apps
+	Issuer string
+	// Limits the codes tried for each user
+	LoginGuard *ratelimit.LoginGuard
+	Logger     *slog.Logger
+}
+
+func NewMFAHandler(users database.UserInterface, tokenAuth *jwtkeys.KeySet, jwtExpiresIn int, secret []byte, challengeTTL time.Duration, issuer string, loginGuard *ratelimit.LoginGuard, logger *slog.Logger) *MFAHandler {
+	return &MFAHandler{
+		UserService:  users,
+		TokenAuth:    tokenAuth,
+		JWTExpiresIn: jwtExpiresIn,
+		Secret:       secret,
+		ChallengeTTL: challengeTTL,
+		Issuer:       issuer,
+		LoginGuard:   loginGuard,
+		Logger:       logger,
+	}
+}
+
+// challenge writes the MFA token of user, who logged in with the password.
+func (h *MFAHandler) challenge(w http.ResponseWriter, r *http.Request, user *entity.User) {
+	token, err := entity.NewMFAChallengeToken(user, h.Secret, time.Now().Add(h.ChallengeTTL))
+	if err != nil {
+		h.serverError(w, r, "error generating mfa token", err)
+		return
+	}
+	h.Logger.InfoContext(r.Context(), "mfa required", "user_id", user.ID.String())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(dto.MFAChallengeOutput{MFAToken: token, ExpiresIn: int(h.ChallengeTTL.Seconds())})
+}
+
+// Verify MFA godoc
+// @Summary      Get a user JWT with a second factor
+// @Description  Exchange the MFA token of POST /user/generate_token and a code of the authenticator app, or a recovery code, for a JWT. A wrong code is refused with the invalid_mfa_code code, and an expired MFA token with invalid_token.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        request  body      dto.VerifyMFAInput true "MFA token and code"
+// @Success      200      {object}  dto.GetJWTOutput
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      429      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /user/generate_token/mfa [post]
+func (h *MFAHandler) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var input dto.VerifyMFAInput
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: "invalid format", Code: "invalid_format"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+
+	userID, tokenVersion, err := entity.ParseMFAChallengeToken(input.MFAToken, h.Secret, time.Now())
+	if err != nil {
+		h.Logger.WarnContext(r.Context(), "mfa failed", "reason", "invalid mfa token")
+		h.unauthorized(w, "invalid or expired token", "invalid_token")
+		return
+	}
+	user, err := h.UserService.FindByID(r.Context(), userID)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		h.serverError(w, r, "error finding user", err)
+		return
+	}
+	if user == nil || user.TokenVersion != tokenVersion || user.Disabled() {
+		h.Logger.WarnContext(r.Context(), "mfa failed", "reason", "mfa token revoked", "user_id", userID)
+		h.unauthorized(w, "invalid or expired token", "invalid_token")
+		return
+	}
+
+	if !h.verifyCode(w, r, user, input.Code) {
+		return
+	}
+	token, err := newAccessToken(h.TokenAuth, h.JWTExpiresIn, user)
+	if err != nil {
+		h.serverError(w, r, "error generating token", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(dto.GetJWTOutput{AccessToken: token})
+}
+
+// Start MFA godoc
+// @Summary      Start the two-factor authentication enrollment
+// @Description  Generate the TOTP secret of the authenticated user, to be added to an authenticator app by scanning the QR code of the URI. The enrollment ends with POST /user/me/mfa/confirm.
+// @Tags         users
+// @Produce      json
+// @Success      200      {object}  dto.StartMFAOutput
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      409      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /user/me/mfa [post]
+// @Security     ApiKeyAuth
+func (h *MFAHandler) StartMFA(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findMe(w, r)
+	if !ok {
+		return
+	}
+	previousSecret := user.MFASecret
+	secret, err := user.StartMFA()
+	if errors.Is(err, entity.ErrMFAAlreadyEnabled) {
+		w.WriteHeader(http.StatusConflict)
+		error := dto.ErrorOutput{Message: err.Error(), Code: "mfa_already_enabled"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	if err != nil {
+		h.serverError(w, r, "error generating mfa secret", err)
+		return
+	}
+	err = h.UserService.UpdateMFA(r.Context(), user, previousSecret, false)
+	if errors.Is(err, database.ErrNotFound) {
+		h.mfaChanged(w)
+		return
+	}
+	if err != nil {
+		h.serverError(w, r, "error updating user", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(dto.StartMFAOutput{
+		Secret: secret,
+		URI:    totp.URI(h.Issuer, user.Email, secret),
+	})
+}
+
+// Confirm MFA godoc
+// @Summary      Enable two-factor authentication
+// @Description  End the enrollment with a code of the authenticator app, returning the recovery codes. They are shown only once. A wrong code is refused with the invalid_mfa_code code.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        request  body      dto.MFACodeInput true "code of the authenticator app"
+// @Success      200      {object}  dto.EnableMFAOutput
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      409      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /user/me/mfa/confirm [post]
+// @Security     ApiKeyAuth
+func (h *MFAHandler) ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findMe(w, r)
+	if !ok {
+		return
+	}
+	var input dto.MFACodeInput
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: "invalid format", Code: "invalid_format"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	codes, err := user.EnableMFA(input.Code, time.Now().UTC())
+	if err == nil {
+		// Refused if the enrollment was restarted, disabled by an admin or
+		// confirmed by a concurrent request meanwhile
+		err = h.UserService.UpdateMFA(r.Context(), user, user.MFASecret, false)
+		if errors.Is(err, database.ErrNotFound) {
+			err = entity.ErrInvalidMFACode
+		}
+	}
+	switch {
+	case errors.Is(err, entity.ErrMFAAlreadyEnabled):
+		w.WriteHeader(http.StatusConflict)
+		error := dto.ErrorOutput{Message: err.Error(), Code: "mfa_already_enabled"}
+		json.NewEncoder(w).Encode(error)
+		return
+	case errors.Is(err, entity.ErrMFANotStarted):
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: err.Error(), Code: "mfa_not_started"}
+		json.NewEncoder(w).Encode(error)
+		return
+	case errors.Is(err, entity.ErrInvalidMFACode):
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: err.Error(), Code: "invalid_mfa_code"}
+		json.NewEncoder(w).Encode(error)
+		return
+	case err != nil:
+		h.serverError(w, r, "error enabling mfa", err)
+		return
+	}
+	h.Logger.InfoContext(r.Context(), "mfa enabled", "user_id", user.ID.String())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(dto.EnableMFAOutput{RecoveryCodes: codes})
+}
+
+// Disable MFA godoc
+// @Summary      Disable two-factor authentication
+// @Description  Disable two-factor authentication with a code of the authenticator app or a recovery code. A wrong code is refused with the invalid_mfa_code code.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        request  body      dto.MFACodeInput true "code of the authenticator app or recovery code"
+// @Success      204
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      409      {object}  dto.ErrorOutput
+// @Failure      429      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /user/me/mfa [delete]
+// @Security     ApiKeyAuth
+func (h *MFAHandler) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findMe(w, r)
+	if !ok {
+		return
+	}
+	var input dto.MFACodeInput
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: "invalid format", Code: "invalid_format"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	if !user.MFAEnabled() {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !h.verifyCode(w, r, user, input.Code) {
+		return
+	}
+	secret := user.MFASecret
+	user.DisableMFA()
+	err = h.UserService.UpdateMFA(r.Context(), user, secret, true)
+	if errors.Is(err, database.ErrNotFound) {
+		h.mfaChanged(w)
+		return
+	}
+	if err != nil {
+		h.serverError(w, r, "error updating user", err)
+		return
+	}
+	h.Logger.InfoContext(r.Context(), "mfa disabled", "user_id", user.ID.String())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifyCode checks a code of the second factor of user, saving it as used,
+// and writes the error response when it is wrong. Failures are limited by the
+// LoginGuard.
+func (h *MFAHandler) verifyCode(w http.ResponseWriter, r *http.Request, user *entity.User, code string) bool {
+	guardKey := "mfa:" + user.ID.String()
+	retryAfter, err := h.LoginGuard.Allow(r.Context(), guardKey)
+	if errors.Is(err, ratelimit.ErrRateLimited) || errors.Is(err, ratelimit.ErrLocked) {
+		h.Logger.WarnContext(r.Context(), "mfa refused", "reason", err.Error(), "user_id", user.ID.String())
+		seconds := int(math.Max(1, math.Ceil(retryAfter.Seconds())))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		w.WriteHeader(http.StatusTooManyRequests)
+		error := dto.ErrorOutput{Message: "too many requests"}
+		json.NewEncoder(w).Encode(error)
+		return false
+	}
+	if err != nil {
+		h.serverError(w, r, "error checking mfa attempts", err)
+		return false
+	}
+	used, ok := user.VerifyMFA(code, time.Now())
+	if ok {
+		// Saves the code as used, refused if a concurrent request used it first
+		err := h.UserService.UseMFACode(r.Context(), user.ID.String(), used)
+		if errors.Is(err, database.ErrNotFound) {
+			ok = false
+		} else if err != nil {
+			h.serverError(w, r, "error updating user", err)
+			return false
+		}
+	}
+	if !ok {
+		h.Logger.WarnContext(r.Context(), "mfa failed", "reason", "invalid code", "user_id", user.ID.String())
+		if err := h.LoginGuard.Failure(r.Context(), guardKey); err != nil {
+			h.Logger.ErrorContext(r.Context(), "error recording mfa failure", "error", err)
+		}
+		h.unauthorized(w, entity.ErrInvalidMFACode.Error(), "invalid_mfa_code")
+		return false
+	}
+	if err := h.LoginGuard.Success(r.Context(), guardKey); err != nil {
+		h.Logger.ErrorContext(r.Context(), "error resetting mfa attempts", "error", err)
+	}
+	return true
+}
+
+// findMe loads the user of the token, writing the error response when it
+// can't be found.
+func (h *MFAHandler) findMe(w http.ResponseWriter, r *http.Request) (*entity.User, bool) {
+	user, err := h.UserService.FindByID(r.Context(), subject(r))
+	if errors.Is(err, database.ErrNotFound) {
+		h.unauthorized(w, "unauthorized", "")
+		return nil, false
+	}
+	if err != nil {
+		h.serverError(w, r, "error finding user", err)
+		return nil, false
+	}
+	return user, true
+}
+
+// mfaChanged writes the response of a change refused because the two-factor
+// authentication changed since the user was read.
+func (h *MFAHandler) mfaChanged(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusConflict)
+	error := dto.ErrorOutput{Message: "two-factor authentication changed meanwhile", Code: "mfa_changed"}
+	json.NewEncoder(w).Encode(error)
+}
+
+func (h *MFAHandler) unauthorized(w http.ResponseWriter, message, code string) {
+	w.WriteHeader(http.StatusUnauthorized)
+	error := dto.ErrorOutput{Message: message, Code: code}
+	json.NewEncoder(w).Encode(error)
+}
+
+func (h *MFAHandler) serverError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	h.Logger.ErrorContext(r.Context(), message, "error", err)
+	w.WriteHeader(http.StatusInternalServerError)
+	error := dto.ErrorOutput{Message: "server error"}
+	json.NewEncoder(w).Encode(error)
+}
@@ -0,0 +1,298 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"goexpert-api/internal/dto"
+	"goexpert-api/internal/entity"
+	"goexpert-api/pkg/totp"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mfaCode returns the TOTP code of secret at now plus offset time steps.
+func mfaCode(t *testing.T, secret string, offset int64) string {
+	code, err := totp.Code(secret, totp.Step(time.Now())+offset)
+	assert.Nil(t, err)
+	return code
+}
+
+// enableMFA enrolls the test user with the code of the previous time step,
+// so the current one is still unused, and returns the secret and the
+// recovery codes.
+func (s *testServer) enableMFA(t *testing.T) (string, []string) {
+	token := s.validToken(t)
+	w := s.request(http.MethodPost, "/v1/user/me/mfa", token, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var started dto.StartMFAOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&started))
+
+	w = s.request(http.MethodPost, "/v1/user/me/mfa/confirm", token, `{"code":"`+mfaCode(t, started.Secret, -1)+`"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var enabled dto.EnableMFAOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&enabled))
+	return started.Secret, enabled.RecoveryCodes
+}
+
+// mfaToken logs in with the password of the test user, returning the MFA
+// token of the first step.
+func (s *testServer) mfaToken(t *testing.T) string {
+	w := s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var output dto.MFAChallengeOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+	assert.Equal(t, 300, output.ExpiresIn)
+	return output.MFAToken
+}
+
+func (s *testServer) verifyMFA(mfaToken, code string) *httptest.ResponseRecorder {
+	return s.request(http.MethodPost, "/v1/user/generate_token/mfa", "", `{"mfa_token":"`+mfaToken+`","code":"`+code+`"}`)
+}
+
+func TestStartMFA(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodPost, "/v1/user/me/mfa", s.validToken(t), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var output dto.StartMFAOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+	assert.NotEmpty(t, output.Secret)
+	assert.Equal(t, totp.URI("Go Expert API", testEmail, output.Secret), output.URI)
+
+	// Not enabled until confirmed
+	user, err := s.users.FindByID(context.Background(), s.user.ID.String())
+	assert.Nil(t, err)
+	assert.False(t, user.MFAEnabled())
+	w = s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestConfirmMFA(t *testing.T) {
+	s := setupTestServer(t)
+	token := s.validToken(t)
+
+	w := s.request(http.MethodPost, "/v1/user/me/mfa/confirm", token, `{"code":"123456"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "mfa_not_started")
+
+	w = s.request(http.MethodPost, "/v1/user/me/mfa", token, "")
+	var started dto.StartMFAOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&started))
+	w = s.request(http.MethodPost, "/v1/user/me/mfa/confirm", token, `{"code":"`+mfaCode(t, started.Secret, 5)+`"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_mfa_code")
+
+	w = s.request(http.MethodPost, "/v1/user/me/mfa/confirm", token, `{"code":"`+mfaCode(t, started.Secret, 0)+`"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var enabled dto.EnableMFAOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&enabled))
+	assert.Len(t, enabled.RecoveryCodes, entity.RecoveryCodeCount)
+
+	// The user shows when it was enabled, but not the secret
+	w = s.request(http.MethodGet, "/v1/user/me", token, "")
+	assert.Contains(t, w.Body.String(), "mfa_enabled_at")
+	assert.NotContains(t, w.Body.String(), started.Secret)
+
+	w = s.request(http.MethodPost, "/v1/user/me/mfa", token, "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "mfa_already_enabled")
+	w = s.request(http.MethodPost, "/v1/user/me/mfa/confirm", token, `{"code":"`+mfaCode(t, started.Secret, 1)+`"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestConfirmMFAConcurrently(t *testing.T) {
+	s := setupTestServer(t)
+	token := s.validToken(t)
+	w := s.request(http.MethodPost, "/v1/user/me/mfa", token, "")
+	var started dto.StartMFAOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&started))
+	body := `{"code":"` + mfaCode(t, started.Secret, 0) + `"}`
+
+	// Only one request enables it, so the recovery codes returned are the ones
+	// saved
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var recoveryCodes []string
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := s.request(http.MethodPost, "/v1/user/me/mfa/confirm", token, body)
+			if w.Code == http.StatusOK {
+				var enabled dto.EnableMFAOutput
+				assert.Nil(t, json.NewDecoder(w.Body).Decode(&enabled))
+				mu.Lock()
+				recoveryCodes = append(recoveryCodes, enabled.RecoveryCodes...)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, recoveryCodes, entity.RecoveryCodeCount)
+	assert.Equal(t, http.StatusOK, s.verifyMFA(s.mfaToken(t), recoveryCodes[0]).Code)
+}
+
+func TestLoginWithMFA(t *testing.T) {
+	s := setupTestServer(t)
+	secret, _ := s.enableMFA(t)
+
+	mfaToken := s.mfaToken(t)
+	// The MFA token is not an access token
+	w := s.request(http.MethodGet, "/v1/user/me", mfaToken, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	code := mfaCode(t, secret, 0)
+	w = s.verifyMFA(mfaToken, code)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var output dto.GetJWTOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+	w = s.request(http.MethodGet, "/v1/user/me", output.AccessToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Codes can't be replayed
+	w = s.verifyMFA(s.mfaToken(t), code)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_mfa_code")
+	w = s.verifyMFA(s.mfaToken(t), mfaCode(t, secret, 1))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestLoginWithMFAConcurrently(t *testing.T) {
+	s := setupTestServer(t)
+	secret, _ := s.enableMFA(t)
+	mfaToken := s.mfaToken(t)
+	code := mfaCode(t, secret, 0)
+
+	// The code is used only once, even by requests verifying it at once
+	var wg sync.WaitGroup
+	var succeeded atomic.Int32
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if s.verifyMFA(mfaToken, code).Code == http.StatusOK {
+				succeeded.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), succeeded.Load())
+}
+
+func TestLoginWithRecoveryCode(t *testing.T) {
+	s := setupTestServer(t)
+	_, recoveryCodes := s.enableMFA(t)
+
+	w := s.verifyMFA(s.mfaToken(t), recoveryCodes[0])
+	assert.Equal(t, http.StatusOK, w.Code)
+	// Each recovery code works once
+	w = s.verifyMFA(s.mfaToken(t), recoveryCodes[0])
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = s.verifyMFA(s.mfaToken(t), recoveryCodes[1])
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestVerifyMFAWhenTokenIsInvalid(t *testing.T) {
+	s := setupTestServer(t)
+	secret, _ := s.enableMFA(t)
+	ctx := context.Background()
+
+	expired, err := entity.NewMFAChallengeToken(s.user, []byte(testMFASecret), time.Now().Add(-time.Second))
+	assert.Nil(t, err)
+	wrongSecret, err := entity.NewMFAChallengeToken(s.user, []byte("other secret"), time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	// MFA tokens are revoked with the access tokens
+	revoked := s.mfaToken(t)
+	user, err := s.users.FindByID(ctx, s.user.ID.String())
+	assert.Nil(t, err)
+	assert.Nil(t, s.users.Update(ctx, user, "TokenVersion"))
+
+	for _, token := range []string{"", "abc", expired, wrongSecret, revoked} {
+		w := s.verifyMFA(token, mfaCode(t, secret, 0))
+		assert.Equal(t, http.StatusUnauthorized, w.Code, token)
+		assert.Contains(t, w.Body.String(), "invalid_token", token)
+	}
+	w := s.request(http.MethodPost, "/v1/user/generate_token/mfa", "", `{"mfa_token":`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestVerifyMFAWhenRateLimited(t *testing.T) {
+	s := setupTestServer(t)
+	secret, _ := s.enableMFA(t)
+	mfaToken := s.mfaToken(t)
+
+	// The burst of the test server is 3 attempts
+	for i := 0; i < 3; i++ {
+		w := s.verifyMFA(mfaToken, "000000")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+	w := s.verifyMFA(mfaToken, mfaCode(t, secret, 0))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+}
+
+func TestDisableMFA(t *testing.T) {
+	s := setupTestServer(t)
+	secret, _ := s.enableMFA(t)
+	token := s.validToken(t)
+
+	w := s.request(http.MethodDelete, "/v1/user/me/mfa", token, `{"code":"000000"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_mfa_code")
+
+	w = s.request(http.MethodDelete, "/v1/user/me/mfa", token, `{"code":"`+mfaCode(t, secret, 0)+`"}`)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestMFARequiredRoles(t *testing.T) {
+	s := setupTestServer(t, withMFARequiredRoles(entity.RoleAdmin))
+	admin := s.createUser(t, "Jane Doe", "jane@doe.com", entity.RoleAdmin)
+	adminToken := s.tokenFor(t, admin, time.Minute)
+
+	// Users of other roles are not affected
+	w := s.request(http.MethodGet, "/v1/products", s.validToken(t), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = s.request(http.MethodGet, "/v1/admin/users", adminToken, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "mfa_enrollment_required")
+
+	// The enrollment is allowed
+	w = s.request(http.MethodPost, "/v1/user/me/mfa", adminToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var started dto.StartMFAOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&started))
+	w = s.request(http.MethodPost, "/v1/user/me/mfa/confirm", adminToken, `{"code":"`+mfaCode(t, started.Secret, 0)+`"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = s.request(http.MethodGet, "/v1/admin/users", adminToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAdminDisableUserMFA(t *testing.T) {
+	s := setupTestServer(t)
+	s.enableMFA(t)
+	admin := s.createUser(t, "Jane Doe", "jane@doe.com", entity.RoleAdmin)
+	adminToken := s.tokenFor(t, admin, time.Minute)
+
+	w := s.request(http.MethodDelete, "/v1/admin/users/"+s.user.ID.String()+"/mfa", s.validToken(t), "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = s.request(http.MethodDelete, "/v1/admin/users/"+s.user.ID.String()+"/mfa", adminToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "mfa_enabled_at")
+	w = s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = s.request(http.MethodDelete, "/v1/admin/users/"+admin.ID.String()+"/mfa", adminToken, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "cannot_change_self")
+}
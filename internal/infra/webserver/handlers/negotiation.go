@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"goexpert-api/internal/dto"
+	"goexpert-api/internal/entity"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	contentTypeJSON   = "application/json"
+	contentTypeXML    = "application/xml"
+	contentTypeCSV    = "text/csv"
+	contentTypeNDJSON = "application/x-ndjson"
+)
+
+// Media types products can be rendered as, the first one is the default.
+var productContentTypes = []string{contentTypeJSON, contentTypeXML, contentTypeCSV, contentTypeNDJSON}
+
+type acceptedType struct {
+	mediaType string
+	quality   float64
+}
+
+// negotiateContentType picks the offered media type best matching the Accept
+// header of the request. It returns false when none is acceptable.
+func negotiateContentType(r *http.Request, offers []string) (string, bool) {
+	header := r.Header.Get("Accept")
+	if strings.TrimSpace(header) == "" {
+		return offers[0], true
+	}
+
+	var accepted []acceptedType
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+		accepted = append(accepted, acceptedType{mediaType: mediaType, quality: quality})
+	}
+	// Higher quality first, then the most specific types
+	sort.SliceStable(accepted, func(i, j int) bool {
+		if accepted[i].quality != accepted[j].quality {
+			return accepted[i].quality > accepted[j].quality
+		}
+		return strings.Count(accepted[i].mediaType, "*") < strings.Count(accepted[j].mediaType, "*")
+	})
+
+	for _, a := range accepted {
+		if a.quality <= 0 {
+			continue
+		}
+		for _, offer := range offers {
+			if mediaTypeMatches(a.mediaType, offer) && !isRefused(accepted, offer) {
+				return offer, true
+			}
+		}
+	}
+	return "", false
+}
+
+func mediaTypeMatches(pattern, mediaType string) bool {
+	if pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))
+	}
+	return false
+}
+
+// isRefused reports whether mediaType was explicitly sent with q=0.
+func isRefused(accepted []acceptedType, mediaType string) bool {
+	for _, a := range accepted {
+		if a.mediaType == mediaType && a.quality <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func writeNotAcceptable(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(http.StatusNotAcceptable)
+	error := dto.ErrorOutput{Message: "supported types: " + strings.Join(productContentTypes, ", ")}
+	json.NewEncoder(w).Encode(error)
+}
+
+type productListXML struct {
+	XMLName  xml.Name         `xml:"products"`
+	Products []entity.Product `xml:"product"`
+}
+
+var productCSVHeader = []string{"id", "name", "price", "created_at"}
+
+func productCSVRecord(product entity.Product) []string {
+	return []string{
+		product.ID.String(),
+		product.Name,
+		strconv.FormatFloat(product.Price, 'f', -1, 64),
+		product.CreatedAt.Format(time.RFC3339Nano),
+	}
+}
+
+// writeProducts renders products as contentType.
+func writeProducts(w http.ResponseWriter, status int, contentType string, products []entity.Product) error {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	switch contentType {
+	case contentTypeXML:
+		w.Write([]byte(xml.Header))
+		return xml.NewEncoder(w).Encode(productListXML{Products: products})
+	case contentTypeCSV:
+		writer := csv.NewWriter(w)
+		writer.Write(productCSVHeader)
+		for _, product := range products {
+			writer.Write(productCSVRecord(product))
+		}
+		writer.Flush()
+		return writer.Error()
+	case contentTypeNDJSON:
+		encoder := json.NewEncoder(w)
+		for _, product := range products {
+			if err := encoder.Encode(product); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return json.NewEncoder(w).Encode(products)
+	}
+}
+
+// writeProduct renders a single product as contentType.
+func writeProduct(w http.ResponseWriter, status int, contentType string, product *entity.Product) error {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	switch contentType {
+	case contentTypeXML:
+		w.Write([]byte(xml.Header))
+		return xml.NewEncoder(w).EncodeElement(product, xml.StartElement{Name: xml.Name{Local: "product"}})
+	case contentTypeCSV:
+		writer := csv.NewWriter(w)
+		writer.Write(productCSVHeader)
+		writer.Write(productCSVRecord(*product))
+		writer.Flush()
+		return writer.Error()
+	default:
+		// A single JSON document is also valid NDJSON
+		return json.NewEncoder(w).Encode(product)
+	}
+}
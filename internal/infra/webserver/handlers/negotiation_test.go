@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"goexpert-api/internal/entity"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateContentType(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected string
+		ok       bool
+	}{
+		{"", contentTypeJSON, true},
+		{"*/*", contentTypeJSON, true},
+		{"application/xml", contentTypeXML, true},
+		{"text/csv, application/json;q=0.5", contentTypeCSV, true},
+		{"application/json;q=0.5, application/x-ndjson", contentTypeNDJSON, true},
+		{"text/*", contentTypeCSV, true},
+		{"*/*, application/json;q=0", contentTypeXML, true},
+		{"text/html", "", false},
+		{"application/json;q=0", "", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/products", nil)
+		r.Header.Set("Accept", test.accept)
+		contentType, ok := negotiateContentType(r, productContentTypes)
+		assert.Equal(t, test.ok, ok, test.accept)
+		assert.Equal(t, test.expected, contentType, test.accept)
+	}
+}
+
+func TestWriteProducts(t *testing.T) {
+	p1, _ := entity.NewProduct("Product 1", 10.5)
+	p2, _ := entity.NewProduct("Product, 2", 20)
+	products := []entity.Product{*p1, *p2}
+
+	w := httptest.NewRecorder()
+	assert.Nil(t, writeProducts(w, http.StatusOK, contentTypeCSV, products))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Equal(t, contentTypeCSV, w.Header().Get("Content-Type"))
+	assert.Len(t, lines, 3)
+	assert.Equal(t, "id,name,price,created_at", lines[0])
+	assert.True(t, strings.HasPrefix(lines[2], p2.ID.String()+`,"Product, 2",20,`))
+
+	w = httptest.NewRecorder()
+	assert.Nil(t, writeProducts(w, http.StatusOK, contentTypeNDJSON, products))
+	lines = strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"name":"Product 1"`)
+
+	w = httptest.NewRecorder()
+	assert.Nil(t, writeProducts(w, http.StatusOK, contentTypeXML, products))
+	var list productListXML
+	assert.Nil(t, xml.Unmarshal(w.Body.Bytes(), &list))
+	assert.Len(t, list.Products, 2)
+	assert.Equal(t, p1.ID, list.Products[0].ID)
+	assert.Equal(t, p2.Name, list.Products[1].Name)
+}
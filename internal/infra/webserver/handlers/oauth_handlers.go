@@ -0,0 +1,417 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"goexpert-api/internal/dto"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	entityPkg "goexpert-api/pkg/entity"
+	"goexpert-api/pkg/jwtkeys"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+// oauthClientErrorCodes are the codes of the errors returned when creating an
+// OAuth client.
+var oauthClientErrorCodes = []struct {
+	err  error
+	code string
+}{
+	{entity.ErrNameIsRequired, "name_required"},
+	{entity.ErrClientNameTooLong, "name_too_long"},
+	{entity.ErrScopesRequired, "scopes_required"},
+	{entity.ErrInvalidScope, "invalid_scope"},
+}
+
+// OAuthHandler serves the OAuth 2.0 client credentials grant (RFC 6749), the
+// introspection (RFC 7662) and revocation (RFC 7009) of its tokens, and the
+// admin routes managing the clients. Tokens are signed with TokenAuth, like
+// the tokens of users, with the client in entity.ClientIDClaim.
+type OAuthHandler struct {
+	ClientService database.OAuthClientInterface
+	Revocations   database.RevokedTokenInterface
+	// Users of the introspected user tokens
+	UserService database.UserInterface
+	TokenAuth   *jwtkeys.KeySet
+	TokenTTL    time.Duration
+	Logger      *slog.Logger
+}
+
+func NewOAuthHandler(
+	clients database.OAuthClientInterface,
+	revocations database.RevokedTokenInterface,
+	users database.UserInterface,
+	tokenAuth *jwtkeys.KeySet,
+	tokenTTL time.Duration,
+	logger *slog.Logger,
+) *OAuthHandler {
+	return &OAuthHandler{
+		ClientService: clients,
+		Revocations:   revocations,
+		UserService:   users,
+		TokenAuth:     tokenAuth,
+		TokenTTL:      tokenTTL,
+		Logger:        logger,
+	}
+}
+
+// Token issues an access token to the client authenticated with HTTP Basic
+// or the client_id and client_secret parameters, for the grant_type
+// client_credentials. The scope parameter narrows the scopes of the client.
+func (h *OAuthHandler) Token(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+	switch r.PostForm.Get("grant_type") {
+	case "client_credentials":
+	case "":
+		oauthError(w, http.StatusBadRequest, "invalid_request", "grant_type is required")
+		return
+	default:
+		oauthError(w, http.StatusBadRequest, "unsupported_grant_type", "only client_credentials is supported")
+		return
+	}
+	scopes, err := client.GrantScopes(strings.Fields(r.PostForm.Get("scope")))
+	if err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_scope", "scope not allowed for the client")
+		return
+	}
+
+	now := time.Now()
+	scope := strings.Join(scopes, " ")
+	_, token, err := h.TokenAuth.Encode(map[string]interface{}{
+		jwt.SubjectKey:       client.ID.String(),
+		jwt.JwtIDKey:         entityPkg.NewID().String(),
+		jwt.IssuedAtKey:      now.Unix(),
+		jwt.ExpirationKey:    now.Add(h.TokenTTL).Unix(),
+		entity.ClientIDClaim: client.ID.String(),
+		entity.ScopeClaim:    scope,
+	})
+	if err != nil {
+		h.oauthServerError(w, r, "error signing token", err)
+		return
+	}
+	h.Logger.InfoContext(r.Context(), "oauth token issued", "client_id", client.ID.String(), "scope", scope)
+	noStore(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(dto.OAuthTokenOutput{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(h.TokenTTL / time.Second),
+		Scope:       scope,
+	})
+}
+
+// Introspect tells an authenticated client if the token parameter is active:
+// a client token of an existing client that was not revoked, or a user token
+// of an enabled user that was not revoked with the tokens of the user.
+func (h *OAuthHandler) Introspect(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.authenticateClient(w, r); !ok {
+		return
+	}
+	tokenString := r.PostForm.Get("token")
+	if tokenString == "" {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+	output := dto.IntrospectionOutput{}
+	token, err := h.TokenAuth.Verify(tokenString)
+	if err == nil {
+		active, err := h.active(r.Context(), token)
+		if err != nil {
+			h.oauthServerError(w, r, "error introspecting token", err)
+			return
+		}
+		if active {
+			output = dto.IntrospectionOutput{
+				Active:    true,
+				ClientID:  clientID(token),
+				TokenType: "Bearer",
+				Exp:       token.Expiration().Unix(),
+				Sub:       token.Subject(),
+				Jti:       token.JwtID(),
+			}
+			if scope, ok := token.Get(entity.ScopeClaim); ok {
+				output.Scope, _ = scope.(string)
+			}
+			if !token.IssuedAt().IsZero() {
+				output.Iat = token.IssuedAt().Unix()
+			}
+		}
+	}
+	noStore(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(output)
+}
+
+// Revoke revokes the token parameter, a token issued to the authenticated
+// client. Invalid and expired tokens are ignored, as RFC 7009 requires.
+// Tokens of other clients are refused with unauthorized_client, and user
+// tokens with unsupported_token_type, as they are revoked when their user
+// changes the password.
+func (h *OAuthHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+	tokenString := r.PostForm.Get("token")
+	if tokenString == "" {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+	token, err := h.TokenAuth.Verify(tokenString)
+	if err != nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	switch clientID(token) {
+	case client.ID.String():
+	case "":
+		oauthError(w, http.StatusBadRequest, "unsupported_token_type", "only client tokens can be revoked")
+		return
+	default:
+		oauthError(w, http.StatusBadRequest, "unauthorized_client", "token issued to another client")
+		return
+	}
+	err = h.Revocations.Revoke(r.Context(), token.JwtID(), token.Expiration(), time.Now().UTC())
+	if err != nil {
+		h.oauthServerError(w, r, "error revoking token", err)
+		return
+	}
+	h.Logger.InfoContext(r.Context(), "oauth token revoked", "client_id", client.ID.String(), "jti", token.JwtID())
+	w.WriteHeader(http.StatusOK)
+}
+
+// Create OAuth client godoc
+// @Summary      Create an OAuth client
+// @Description  Create a client of the OAuth 2.0 client credentials grant, allowed the given scopes. The secret is only returned here. Invalid data is reported with the name_required, name_too_long, scopes_required or invalid_scope codes. Only admins can use this route.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        request  body      dto.CreateOAuthClientInput true "client data"
+// @Success      201      {object}  dto.CreateOAuthClientOutput
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      403      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /admin/oauth_clients [post]
+// @Security     ApiKeyAuth
+func (h *OAuthHandler) CreateClient(w http.ResponseWriter, r *http.Request) {
+	var input dto.CreateOAuthClientInput
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: "invalid format", Code: "invalid_format"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	client, secret, err := entity.NewOAuthClient(input.Name, input.Scopes)
+	if err != nil {
+		invalidOAuthClient(w, err)
+		return
+	}
+	err = h.ClientService.Create(r.Context(), client)
+	if err != nil {
+		h.serverError(w, r, "error creating oauth client", err)
+		return
+	}
+	h.Logger.InfoContext(r.Context(), "oauth client created", "client_id", client.ID.String(), "scopes", client.Scopes)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dto.CreateOAuthClientOutput{ClientID: client.ID.String(), ClientSecret: secret, Scopes: client.Scopes})
+}
+
+// Get OAuth clients godoc
+// @Summary      Get all OAuth clients
+// @Description  Get all OAuth clients, without their secrets. Only admins can use this route.
+// @Tags         admin
+// @Produce      json
+// @Success      200      {array}   entity.OAuthClient
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      403      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /admin/oauth_clients [get]
+// @Security     ApiKeyAuth
+func (h *OAuthHandler) GetClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := h.ClientService.FindAll(r.Context())
+	if err != nil {
+		h.serverError(w, r, "error listing oauth clients", err)
+		return
+	}
+	if clients == nil {
+		clients = []entity.OAuthClient{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(clients)
+}
+
+// Delete OAuth client godoc
+// @Summary      Delete an OAuth client
+// @Description  Delete an OAuth client. Its tokens are refused from then on. Only admins can use this route.
+// @Tags         admin
+// @Param        id       path      string true "client id"
+// @Success      204
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      403      {object}  dto.ErrorOutput
+// @Failure      404      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /admin/oauth_clients/{id} [delete]
+// @Security     ApiKeyAuth
+func (h *OAuthHandler) DeleteClient(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := entityPkg.ParseID(id); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: "invalid format"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	err := h.ClientService.Delete(r.Context(), id)
+	if errors.Is(err, database.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		error := dto.ErrorOutput{Message: "oauth client not found"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	if err != nil {
+		h.serverError(w, r, "error deleting oauth client", err)
+		return
+	}
+	h.Logger.InfoContext(r.Context(), "oauth client deleted", "client_id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticateClient parses the form and returns the client authenticated by
+// it or by HTTP Basic, writing the error response if there is none.
+func (h *OAuthHandler) authenticateClient(w http.ResponseWriter, r *http.Request) (*entity.OAuthClient, bool) {
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "invalid form")
+		return nil, false
+	}
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		if r.PostForm.Has("client_id") || r.PostForm.Has("client_secret") {
+			oauthError(w, http.StatusBadRequest, "invalid_request", "more than one client authentication method")
+			return nil, false
+		}
+		// Basic credentials are form encoded first (RFC 6749 section 2.3.1)
+		var errID, errSecret error
+		id, errID = url.QueryUnescape(id)
+		secret, errSecret = url.QueryUnescape(secret)
+		if errID != nil || errSecret != nil {
+			invalidClient(w)
+			return nil, false
+		}
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if id == "" || secret == "" {
+		invalidClient(w)
+		return nil, false
+	}
+	client, err := h.ClientService.FindByID(r.Context(), id)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		h.oauthServerError(w, r, "error finding oauth client", err)
+		return nil, false
+	}
+	if client == nil || !client.Authenticate(secret) {
+		h.Logger.WarnContext(r.Context(), "oauth client refused", "client_id", id)
+		invalidClient(w)
+		return nil, false
+	}
+	return client, true
+}
+
+// active tells if a valid token was not revoked.
+func (h *OAuthHandler) active(ctx context.Context, token jwt.Token) (bool, error) {
+	if id := clientID(token); id != "" {
+		_, err := h.ClientService.FindByID(ctx, id)
+		if errors.Is(err, database.ErrNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		revoked, err := h.Revocations.IsRevoked(ctx, token.JwtID())
+		return !revoked, err
+	}
+	user, err := h.UserService.FindByID(ctx, token.Subject())
+	if errors.Is(err, database.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var version int
+	if claim, ok := token.Get(entity.TokenVersionClaim); ok {
+		value, _ := claim.(float64)
+		version = int(value)
+	}
+	return !user.Disabled() && version == user.TokenVersion, nil
+}
+
+// clientID returns the client a token was issued to, empty for user tokens.
+func clientID(token jwt.Token) string {
+	claim, _ := token.Get(entity.ClientIDClaim)
+	id, _ := claim.(string)
+	return id
+}
+
+// invalidClient writes the 401 response of a failed client authentication.
+func invalidClient(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	oauthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+}
+
+// oauthError writes an error response of RFC 6749.
+func oauthError(w http.ResponseWriter, status int, code, description string) {
+	noStore(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(dto.OAuthErrorOutput{Error: code, ErrorDescription: description})
+}
+
+// noStore keeps the responses with tokens out of caches.
+func noStore(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+}
+
+// invalidOAuthClient writes the 400 response of an invalid OAuth client.
+func invalidOAuthClient(w http.ResponseWriter, err error) {
+	output := dto.ErrorOutput{Message: "invalid oauth client data", Code: "invalid_oauth_client"}
+	for _, clientError := range oauthClientErrorCodes {
+		if errors.Is(err, clientError.err) {
+			output = dto.ErrorOutput{Message: err.Error(), Code: clientError.code}
+			break
+		}
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(output)
+}
+
+func (h *OAuthHandler) serverError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	h.Logger.ErrorContext(r.Context(), message, "error", err)
+	w.WriteHeader(http.StatusInternalServerError)
+	error := dto.ErrorOutput{Message: "server error"}
+	json.NewEncoder(w).Encode(error)
+}
+
+// oauthServerError is serverError for the OAuth endpoints, in the format of
+// RFC 6749.
+func (h *OAuthHandler) oauthServerError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	h.Logger.ErrorContext(r.Context(), message, "error", err)
+	oauthError(w, http.StatusInternalServerError, "server_error", "")
+}
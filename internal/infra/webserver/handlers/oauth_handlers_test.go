@@ -0,0 +1,324 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"goexpert-api/internal/dto"
+	"goexpert-api/internal/entity"
+	entityPkg "goexpert-api/pkg/entity"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const formContentType = "application/x-www-form-urlencoded"
+
+// createClient adds an OAuth client allowed scopes to the repository,
+// returning it with its secret.
+func (s *testServer) createClient(t *testing.T, scopes ...string) (*entity.OAuthClient, string) {
+	client, secret, err := entity.NewOAuthClient("billing", scopes)
+	assert.Nil(t, err)
+	assert.Nil(t, s.clients.Create(context.Background(), client))
+	return client, secret
+}
+
+// postForm posts form to an OAuth endpoint, authenticated with HTTP Basic
+// when id is set.
+func (s *testServer) postForm(path, id, secret string, form url.Values) *http.Response {
+	headers := []string{"Content-Type", formContentType}
+	if id != "" {
+		r, _ := http.NewRequest(http.MethodPost, path, nil)
+		r.SetBasicAuth(url.QueryEscape(id), url.QueryEscape(secret))
+		headers = append(headers, "Authorization", r.Header.Get("Authorization"))
+	}
+	return s.request(http.MethodPost, path, "", form.Encode(), headers...).Result()
+}
+
+// clientToken gets a token for the client with the requested scope.
+func (s *testServer) clientToken(t *testing.T, client *entity.OAuthClient, secret, scope string) string {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if scope != "" {
+		form.Set("scope", scope)
+	}
+	response := s.postForm("/oauth/token", client.ID.String(), secret, form)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var output dto.OAuthTokenOutput
+	assert.Nil(t, json.NewDecoder(response.Body).Decode(&output))
+	return output.AccessToken
+}
+
+func TestOAuthToken(t *testing.T) {
+	s := setupTestServer(t)
+	client, secret := s.createClient(t, entity.ScopeProductsRead, entity.ScopeProductsWrite)
+
+	response := s.postForm("/oauth/token", client.ID.String(), secret, url.Values{"grant_type": {"client_credentials"}})
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "no-store", response.Header.Get("Cache-Control"))
+	assert.Equal(t, "no-cache", response.Header.Get("Pragma"))
+	var output dto.OAuthTokenOutput
+	assert.Nil(t, json.NewDecoder(response.Body).Decode(&output))
+	assert.Equal(t, "Bearer", output.TokenType)
+	assert.Equal(t, 600, output.ExpiresIn)
+	assert.Equal(t, "products:read products:write", output.Scope)
+
+	token, err := s.tokenAuth.Verify(output.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, client.ID.String(), token.Subject())
+	assert.NotEmpty(t, token.JwtID())
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), token.Expiration(), time.Minute)
+	claim, _ := token.Get(entity.ClientIDClaim)
+	assert.Equal(t, client.ID.String(), claim)
+
+	// The credentials can also be sent in the form, and the scope narrowed
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {client.ID.String()},
+		"client_secret": {secret},
+		"scope":         {"products:read"},
+	}
+	response = s.postForm("/oauth/token", "", "", form)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Nil(t, json.NewDecoder(response.Body).Decode(&output))
+	assert.Equal(t, "products:read", output.Scope)
+}
+
+func TestOAuthTokenWhenRequestIsInvalid(t *testing.T) {
+	s := setupTestServer(t)
+	client, secret := s.createClient(t, entity.ScopeProductsRead)
+	id := client.ID.String()
+
+	tests := []struct {
+		id, secret string
+		form       url.Values
+		status     int
+		code       string
+	}{
+		{"", "", url.Values{"grant_type": {"client_credentials"}}, http.StatusUnauthorized, "invalid_client"},
+		{id, secret + "0", url.Values{"grant_type": {"client_credentials"}}, http.StatusUnauthorized, "invalid_client"},
+		{entityPkg.NewID().String(), secret, url.Values{"grant_type": {"client_credentials"}}, http.StatusUnauthorized, "invalid_client"},
+		{"", "", url.Values{"grant_type": {"client_credentials"}, "client_id": {id}}, http.StatusUnauthorized, "invalid_client"},
+		{id, secret, url.Values{"grant_type": {"client_credentials"}, "client_id": {id}, "client_secret": {secret}}, http.StatusBadRequest, "invalid_request"},
+		{id, secret, url.Values{}, http.StatusBadRequest, "invalid_request"},
+		{id, secret, url.Values{"grant_type": {"password"}}, http.StatusBadRequest, "unsupported_grant_type"},
+		{id, secret, url.Values{"grant_type": {"client_credentials"}, "scope": {"products:write"}}, http.StatusBadRequest, "invalid_scope"},
+		{id, secret, url.Values{"grant_type": {"client_credentials"}, "scope": {"users:write"}}, http.StatusBadRequest, "invalid_scope"},
+	}
+	for _, tt := range tests {
+		response := s.postForm("/oauth/token", tt.id, tt.secret, tt.form)
+		assert.Equal(t, tt.status, response.StatusCode, tt.form)
+		var output dto.OAuthErrorOutput
+		assert.Nil(t, json.NewDecoder(response.Body).Decode(&output))
+		assert.Equal(t, tt.code, output.Error, tt.form)
+		if tt.status == http.StatusUnauthorized {
+			assert.Contains(t, response.Header.Get("WWW-Authenticate"), "Basic")
+		}
+	}
+}
+
+func TestProductsWithOAuthToken(t *testing.T) {
+	s := setupTestServer(t)
+	product := s.createProduct(t, "Product 1", 10)
+	client, secret := s.createClient(t, entity.ScopeProductsRead, entity.ScopeProductsWrite)
+	readToken := s.clientToken(t, client, secret, "products:read")
+
+	w := s.request(http.MethodGet, "/v1/products/"+product.ID.String(), readToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = s.request(http.MethodDelete, "/v1/products/"+product.ID.String(), readToken, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "insufficient_scope")
+
+	writeToken := s.clientToken(t, client, secret, "")
+	w = s.request(http.MethodPost, "/v1/products", writeToken, `{"name":"Product 2","price":20}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// Client tokens only work on the product routes
+	w = s.request(http.MethodGet, "/v1/user/me", writeToken, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = s.request(http.MethodPost, "/v1/user/me/api_keys", writeToken, `{"name":"CI","scopes":["products:read"]}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Tokens of deleted clients are refused
+	assert.Nil(t, s.clients.Delete(context.Background(), client.ID.String()))
+	w = s.request(http.MethodGet, "/v1/products", readToken, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+// introspect returns the introspection of token by the client.
+func (s *testServer) introspect(t *testing.T, client *entity.OAuthClient, secret, token string) dto.IntrospectionOutput {
+	response := s.postForm("/oauth/introspect", client.ID.String(), secret, url.Values{"token": {token}})
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var output dto.IntrospectionOutput
+	assert.Nil(t, json.NewDecoder(response.Body).Decode(&output))
+	return output
+}
+
+func TestOAuthIntrospect(t *testing.T) {
+	s := setupTestServer(t)
+	client, secret := s.createClient(t, entity.ScopeProductsRead)
+	other, otherSecret := s.createClient(t, entity.ScopeProductsWrite)
+	token := s.clientToken(t, client, secret, "")
+
+	// Any client can introspect the tokens
+	output := s.introspect(t, other, otherSecret, token)
+	assert.True(t, output.Active)
+	assert.Equal(t, "products:read", output.Scope)
+	assert.Equal(t, client.ID.String(), output.ClientID)
+	assert.Equal(t, client.ID.String(), output.Sub)
+	assert.Equal(t, "Bearer", output.TokenType)
+	assert.NotEmpty(t, output.Jti)
+	assert.InDelta(t, time.Now().Add(10*time.Minute).Unix(), output.Exp, 60)
+	assert.InDelta(t, time.Now().Unix(), output.Iat, 60)
+
+	// User tokens are active until revoked with the tokens of the user
+	output = s.introspect(t, client, secret, s.validToken(t))
+	assert.True(t, output.Active)
+	assert.Equal(t, s.user.ID.String(), output.Sub)
+	assert.Empty(t, output.ClientID)
+	assert.Empty(t, output.Scope)
+	userToken := s.validToken(t)
+	s.user.RequirePasswordReset()
+	assert.Nil(t, s.users.Update(context.Background(), s.user, "PasswordResetRequired", "TokenVersion"))
+	assert.False(t, s.introspect(t, client, secret, userToken).Active)
+
+	expired := s.token(t, -time.Minute)
+	for _, inactive := range []string{"abc", token + "0", expired} {
+		response := s.postForm("/oauth/introspect", client.ID.String(), secret, url.Values{"token": {inactive}})
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		var body map[string]interface{}
+		assert.Nil(t, json.NewDecoder(response.Body).Decode(&body))
+		assert.Equal(t, map[string]interface{}{"active": false}, body)
+	}
+
+	response := s.postForm("/oauth/introspect", client.ID.String(), secret+"0", url.Values{"token": {token}})
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	response = s.postForm("/oauth/introspect", client.ID.String(), secret, url.Values{})
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
+
+func TestOAuthRevoke(t *testing.T) {
+	s := setupTestServer(t)
+	client, secret := s.createClient(t, entity.ScopeProductsRead)
+	other, otherSecret := s.createClient(t, entity.ScopeProductsRead)
+	token := s.clientToken(t, client, secret, "")
+	otherToken := s.clientToken(t, other, otherSecret, "")
+
+	response := s.postForm("/oauth/revoke", client.ID.String(), secret, url.Values{"token": {token}})
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.False(t, s.introspect(t, client, secret, token).Active)
+	w := s.request(http.MethodGet, "/v1/products", token, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Revoking twice, or an invalid token, is not an error
+	response = s.postForm("/oauth/revoke", client.ID.String(), secret, url.Values{"token": {token}})
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response = s.postForm("/oauth/revoke", client.ID.String(), secret, url.Values{"token": {"abc"}})
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	// Clients only revoke their tokens
+	response = s.postForm("/oauth/revoke", client.ID.String(), secret, url.Values{"token": {otherToken}})
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	var output dto.OAuthErrorOutput
+	assert.Nil(t, json.NewDecoder(response.Body).Decode(&output))
+	assert.Equal(t, "unauthorized_client", output.Error)
+	assert.True(t, s.introspect(t, client, secret, otherToken).Active)
+
+	response = s.postForm("/oauth/revoke", client.ID.String(), secret, url.Values{"token": {s.validToken(t)}})
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	assert.Nil(t, json.NewDecoder(response.Body).Decode(&output))
+	assert.Equal(t, "unsupported_token_type", output.Error)
+
+	response = s.postForm("/oauth/revoke", "", "", url.Values{"token": {otherToken}})
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+}
+
+func TestAdminOAuthClients(t *testing.T) {
+	s, token := setupAdminServer(t)
+
+	w := s.request(http.MethodPost, "/v1/admin/oauth_clients", token, `{"name":"billing","scopes":["products:read"]}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var output dto.CreateOAuthClientOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+	assert.Equal(t, []string{entity.ScopeProductsRead}, output.Scopes)
+	client, err := s.clients.FindByID(context.Background(), output.ClientID)
+	assert.Nil(t, err)
+	assert.True(t, client.Authenticate(output.ClientSecret))
+
+	// The secret is not shown again
+	w = s.request(http.MethodGet, "/v1/admin/oauth_clients", token, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), output.ClientSecret)
+	var clients []entity.OAuthClient
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&clients))
+	if assert.Len(t, clients, 1) {
+		assert.Equal(t, output.ClientID, clients[0].ID.String())
+		assert.Equal(t, "billing", clients[0].Name)
+	}
+
+	w = s.request(http.MethodDelete, "/v1/admin/oauth_clients/"+output.ClientID, token, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = s.request(http.MethodDelete, "/v1/admin/oauth_clients/"+output.ClientID, token, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = s.request(http.MethodDelete, "/v1/admin/oauth_clients/abc", token, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = s.request(http.MethodGet, "/v1/admin/oauth_clients", token, "")
+	assert.JSONEq(t, "[]", w.Body.String())
+}
+
+func TestAdminCreateOAuthClientWhenInputIsInvalid(t *testing.T) {
+	s, token := setupAdminServer(t)
+
+	tests := []struct {
+		body string
+		code string
+	}{
+		{`{"name":`, "invalid_format"},
+		{`{"name":" ","scopes":["products:read"]}`, "name_required"},
+		{`{"name":"billing"}`, "scopes_required"},
+		{`{"name":"billing","scopes":["users:write"]}`, "invalid_scope"},
+	}
+	for _, tt := range tests {
+		w := s.request(http.MethodPost, "/v1/admin/oauth_clients", token, tt.body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, tt.body)
+		assert.Contains(t, w.Body.String(), tt.code, tt.body)
+	}
+
+	w := s.request(http.MethodGet, "/v1/admin/oauth_clients", s.validToken(t), "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+// failingOAuthClientService fails every call with errDatabase.
+type failingOAuthClientService struct{}
+
+func (failingOAuthClientService) Create(context.Context, *entity.OAuthClient) error {
+	return errDatabase
+}
+func (failingOAuthClientService) FindByID(context.Context, string) (*entity.OAuthClient, error) {
+	return nil, errDatabase
+}
+func (failingOAuthClientService) FindAll(context.Context) ([]entity.OAuthClient, error) {
+	return nil, errDatabase
+}
+func (failingOAuthClientService) Delete(context.Context, string) error { return errDatabase }
+
+func TestOAuthWhenDatabaseFails(t *testing.T) {
+	s := setupTestServer(t)
+	client, secret := s.createClient(t, entity.ScopeProductsRead)
+	token := s.clientToken(t, client, secret, "")
+	failing := setupTestServer(t, withOAuth(failingOAuthClientService{}, s.revoked))
+	admin := failing.createUser(t, "Admin", "admin@doe.com", entity.RoleAdmin)
+	adminToken := failing.tokenFor(t, admin, time.Minute)
+
+	response := failing.postForm("/oauth/token", client.ID.String(), secret, url.Values{"grant_type": {"client_credentials"}})
+	assert.Equal(t, http.StatusInternalServerError, response.StatusCode)
+	w := failing.request(http.MethodGet, "/v1/products", token, "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	w = failing.request(http.MethodPost, "/v1/admin/oauth_clients", adminToken, `{"name":"billing","scopes":["products:read"]}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	w = failing.request(http.MethodGet, "/v1/admin/oauth_clients", adminToken, "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	w = failing.request(http.MethodDelete, "/v1/admin/oauth_clients/"+client.ID.String(), adminToken, "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
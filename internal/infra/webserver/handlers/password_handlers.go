@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goexpert-api/internal/dto"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"goexpert-api/internal/infra/mail"
+	"goexpert-api/internal/infra/ratelimit"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// passwordFields are the fields of entity.User changed by ChangePassword.
+var passwordFields = []string{"Password", "PasswordResetRequired", "TokenVersion"}
+
+// PasswordResetHandler lets users who forgot the password set a new one with
+// a token sent by email.
+type PasswordResetHandler struct {
+	UserService  database.UserInterface
+	ResetService database.PasswordResetInterface
+	// Uses the token and changes the password atomically
+	Transactions   database.TransactionManager
+	Mailer         mail.Mailer
+	PasswordPolicy *entity.PasswordPolicy
+	// Limits the emails sent to each address
+	Limiter    *ratelimit.TokenBucket
+	LoginGuard *ratelimit.LoginGuard
+	// Page of the client where the user sets the new password, receiving the
+	// token in the token query parameter
+	ResetURL string
+	TTL      time.Duration
+	// Runs the work of ForgotPassword after the response, in a new goroutine
+	// if nil
+	Background func(fn func())
+	Logger     *slog.Logger
+}
+
+func NewPasswordResetHandler(users database.UserInterface, resets database.PasswordResetInterface, transactions database.TransactionManager, mailer mail.Mailer, passwordPolicy *entity.PasswordPolicy, limiter *ratelimit.TokenBucket, loginGuard *ratelimit.LoginGuard, resetURL string, ttl time.Duration, logger *slog.Logger) *PasswordResetHandler {
+	return &PasswordResetHandler{
+		UserService:    users,
+		ResetService:   resets,
+		Transactions:   transactions,
+		Mailer:         mailer,
+		PasswordPolicy: passwordPolicy,
+		Limiter:        limiter,
+		LoginGuard:     loginGuard,
+		ResetURL:       resetURL,
+		TTL:            ttl,
+		Logger:         logger,
+	}
+}
+
+// Forgot password godoc
+// @Summary      Request a password reset
+// @Description  Send an email with a link to reset the password. The email is sent after the response, which is the same whether the email is registered or not.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        request  body      dto.ForgotPasswordInput true "email of the user"
+// @Success      202
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      429      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /user/password/forgot [post]
+func (h *PasswordResetHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var input dto.ForgotPasswordInput
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: "invalid format", Code: "invalid_format"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	email := entity.NormalizeEmail(input.Email)
+	if email == "" {
+		invalidUser(w, entity.ErrEmailIsRequired)
+		return
+	}
+
+	// Done after the response and failures are only logged, so neither the
+	// response nor its timing tell if the email is registered
+	ctx := context.WithoutCancel(r.Context())
+	h.background(func() {
+		if err := h.sendReset(ctx, email); err != nil {
+			h.Logger.ErrorContext(ctx, "error sending password reset", "error", err)
+		}
+	})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *PasswordResetHandler) background(fn func()) {
+	if h.Background != nil {
+		h.Background(fn)
+		return
+	}
+	go fn()
+}
+
+// sendReset emails a new reset token to the user of email, if there is one
+// and the address was not sent too many emails.
+func (h *PasswordResetHandler) sendReset(ctx context.Context, email string) error {
+	if _, err := h.Limiter.Allow(ctx, "password_reset:"+email); err != nil {
+		if errors.Is(err, ratelimit.ErrRateLimited) {
+			h.Logger.WarnContext(ctx, "password reset refused", "reason", "rate limited")
+			return nil
+		}
+		return err
+	}
+	user, err := h.UserService.FindByEmail(ctx, email)
+	if errors.Is(err, database.ErrNotFound) {
+		h.Logger.InfoContext(ctx, "password reset refused", "reason", "user not found")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.Disabled() {
+		h.Logger.InfoContext(ctx, "password reset refused", "reason", "user disabled", "user_id", user.ID.String())
+		return nil
+	}
+
+	reset, token, err := entity.NewPasswordReset(user, h.TTL, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if err := h.ResetService.Create(ctx, reset); err != nil {
+		return err
+	}
+	link, err := linkWithToken(h.ResetURL, token)
+	if err != nil {
+		return err
+	}
+	err = h.Mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It expires in %s and works only once.\n\n%s\n\nIf you didn't ask to reset your password, ignore this email.\n",
+			user.Name, h.TTL, link),
+	})
+	if err != nil {
+		return err
+	}
+	h.Logger.InfoContext(ctx, "password reset sent", "user_id", user.ID.String())
+	return nil
+}
+
+// Reset password godoc
+// @Summary      Reset the password
+// @Description  Set a new password with the token sent by email. The token works only once and the tokens issued before are revoked. An invalid, used or expired token is reported with the invalid_token code.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        request  body      dto.ResetPasswordInput true "token and new password"
+// @Success      204
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      429      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /user/password/reset [post]
+func (h *PasswordResetHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var input dto.ResetPasswordInput
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: "invalid format", Code: "invalid_format"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+
+	now := time.Now().UTC()
+	reset, err := h.ResetService.FindByTokenHash(r.Context(), entity.HashPasswordResetToken(input.Token))
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		h.serverError(w, r, "error finding password reset", err)
+		return
+	}
+	var user *entity.User
+	if reset != nil {
+		user, err = h.UserService.FindByID(r.Context(), reset.UserID)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			h.serverError(w, r, "error finding user", err)
+			return
+		}
+	}
+	if user == nil || !reset.Valid(user, now) {
+		h.Logger.WarnContext(r.Context(), "password reset failed", "reason", "invalid token")
+		invalidToken(w)
+		return
+	}
+
+	if err := h.PasswordPolicy.Validate(input.Password); err != nil {
+		invalidUser(w, err)
+		return
+	}
+	if err := user.ChangePassword(input.Password); err != nil {
+		invalidUser(w, err)
+		return
+	}
+	// The token is only spent if the password is changed
+	used := false
+	err = h.Transactions.Run(r.Context(), func(ctx context.Context, repos database.Repositories) error {
+		err := repos.PasswordResets.Use(ctx, reset.ID.String(), now)
+		if errors.Is(err, database.ErrNotFound) {
+			used = true
+		}
+		if err != nil {
+			return err
+		}
+		return repos.Users.Update(ctx, user, passwordFields...)
+	})
+	if used {
+		h.Logger.WarnContext(r.Context(), "password reset failed", "reason", "token already used", "user_id", user.ID.String())
+		invalidToken(w)
+		return
+	}
+	if err != nil {
+		h.serverError(w, r, "error resetting password", err)
+		return
+	}
+	// The user proved to own the email, so the account is unlocked
+	if err := h.LoginGuard.Success(r.Context(), "login:"+user.Email); err != nil {
+		h.Logger.ErrorContext(r.Context(), "error resetting login attempts", "error", err)
+	}
+	h.Logger.InfoContext(r.Context(), "password reset", "user_id", user.ID.String())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *PasswordResetHandler) serverError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	h.Logger.ErrorContext(r.Context(), message, "error", err)
+	w.WriteHeader(http.StatusInternalServerError)
+	error := dto.ErrorOutput{Message: "server error"}
+	json.NewEncoder(w).Encode(error)
+}
+
+func invalidToken(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusBadRequest)
+	error := dto.ErrorOutput{Message: "invalid or expired token", Code: "invalid_token"}
+	json.NewEncoder(w).Encode(error)
+}
+
+// linkWithToken adds token to the query of the page at rawURL.
+func linkWithToken(rawURL, token string) (string, error) {
+	link, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
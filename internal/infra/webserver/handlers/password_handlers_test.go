@@ -0,0 +1,159 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"goexpert-api/internal/dto"
+	"goexpert-api/internal/entity"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var mailTokenPattern = regexp.MustCompile(`\?token=(\S+)`)
+
+// lastMailToken returns the token of the link of the last email sent, empty
+// if no email was sent.
+func (s *testServer) lastMailToken(t *testing.T) string {
+	messages := s.mailer.Messages()
+	if len(messages) == 0 {
+		return ""
+	}
+	match := mailTokenPattern.FindStringSubmatch(messages[len(messages)-1].Body)
+	assert.NotNil(t, match)
+	return match[1]
+}
+
+// forgotPassword requests a reset for email and returns the token of the last
+// email sent, empty if none was sent.
+func (s *testServer) forgotPassword(t *testing.T, email string) string {
+	w := s.request(http.MethodPost, "/v1/user/password/forgot", "", `{"email":"`+email+`"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	return s.lastMailToken(t)
+}
+
+func TestForgotPassword(t *testing.T) {
+	s := setupTestServer(t)
+
+	token := s.forgotPassword(t, " John@Doe.com ")
+	assert.NotEmpty(t, token)
+	messages := s.mailer.Messages()
+	assert.Len(t, messages, 1)
+	assert.Equal(t, testEmail, messages[0].To)
+	assert.Contains(t, messages[0].Body, "http://localhost:3000/reset_password?token="+token)
+}
+
+func TestForgotPasswordWhenUserIsNotFound(t *testing.T) {
+	s := setupTestServer(t)
+
+	assert.Empty(t, s.forgotPassword(t, "jane@doe.com"))
+}
+
+func TestForgotPasswordWhenUserIsDisabled(t *testing.T) {
+	s := setupTestServer(t)
+	s.user.Disable(time.Now())
+	assert.Nil(t, s.users.Update(context.Background(), s.user, "DisabledAt"))
+
+	assert.Empty(t, s.forgotPassword(t, testEmail))
+}
+
+func TestForgotPasswordWhenInputIsInvalid(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodPost, "/v1/user/password/forgot", "", `{"email":`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = s.request(http.MethodPost, "/v1/user/password/forgot", "", `{"email":" "}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "email_required")
+}
+
+func TestForgotPasswordIsThrottled(t *testing.T) {
+	s := setupTestServer(t)
+
+	s.forgotPassword(t, testEmail)
+	s.forgotPassword(t, testEmail)
+	s.forgotPassword(t, testEmail)
+	assert.Len(t, s.mailer.Messages(), 2)
+}
+
+func TestResetPassword(t *testing.T) {
+	s := setupTestServer(t)
+	oldToken := s.validToken(t)
+	token := s.forgotPassword(t, testEmail)
+
+	w := s.request(http.MethodPost, "/v1/user/password/reset", "", `{"token":"`+token+`","password":"def45678"}`)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	// The tokens issued before are revoked
+	w = s.request(http.MethodGet, "/v1/products", oldToken, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"def45678"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The token works only once
+	w = s.request(http.MethodPost, "/v1/user/password/reset", "", `{"token":"`+token+`","password":"ghi91234"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var output dto.ErrorOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+	assert.Equal(t, "invalid_token", output.Code)
+}
+
+func TestResetPasswordWhenPasswordIsInvalid(t *testing.T) {
+	s := setupTestServer(t)
+	token := s.forgotPassword(t, testEmail)
+
+	w := s.request(http.MethodPost, "/v1/user/password/reset", "", `{"token":"`+token+`","password":"abc"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "password_too_short")
+	w = s.request(http.MethodPost, "/v1/user/password/reset", "", `{"token":`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_format")
+
+	// The token was not used
+	w = s.request(http.MethodPost, "/v1/user/password/reset", "", `{"token":"`+token+`","password":"def45678"}`)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestResetPasswordWhenTokenIsInvalid(t *testing.T) {
+	s := setupTestServer(t)
+	ctx := context.Background()
+
+	expired, expiredToken, _ := entity.NewPasswordReset(s.user, time.Hour, time.Now().Add(-2*time.Hour))
+	assert.Nil(t, s.resets.Create(ctx, expired))
+	// Resets requested before a password change are invalid
+	token := s.forgotPassword(t, testEmail)
+	w := s.request(http.MethodPost, "/v1/user/me/password", s.validToken(t), `{"current_password":"abc123","new_password":"def45678"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	for _, token := range []string{"", "abc", expiredToken, token} {
+		w := s.request(http.MethodPost, "/v1/user/password/reset", "", `{"token":"`+token+`","password":"ghi91234"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code, token)
+		assert.Contains(t, w.Body.String(), "invalid_token", token)
+	}
+}
+
+// failingPasswordResetService fails every call with errDatabase.
+type failingPasswordResetService struct{}
+
+func (failingPasswordResetService) Create(context.Context, *entity.PasswordReset) error {
+	return errDatabase
+}
+func (failingPasswordResetService) FindByTokenHash(context.Context, string) (*entity.PasswordReset, error) {
+	return nil, errDatabase
+}
+func (failingPasswordResetService) Use(context.Context, string, time.Time) error { return errDatabase }
+
+func TestPasswordResetWhenDatabaseFails(t *testing.T) {
+	s := setupTestServer(t, withPasswordResets(failingPasswordResetService{}))
+
+	// Not told to the client, as it would tell the email is registered
+	assert.Empty(t, s.forgotPassword(t, testEmail))
+
+	w := s.request(http.MethodPost, "/v1/user/password/reset", "", `{"token":"abc","password":"def45678"}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
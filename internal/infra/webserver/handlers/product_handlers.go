@@ -5,7 +5,9 @@ import (
 	"goexpert-api/internal/dto"
 	"goexpert-api/internal/entity"
 	"goexpert-api/internal/infra/database"
+	"goexpert-api/internal/infra/metrics"
 	entityPkg "goexpert-api/pkg/entity"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
@@ -15,11 +17,15 @@ import (
 
 type ProductHandler struct {
 	ProductService database.ProductInterface
+	Logger         *slog.Logger
+	Metrics        *metrics.Metrics
 }
 
-func NewProductHandler(service database.ProductInterface) *ProductHandler {
+func NewProductHandler(service database.ProductInterface, logger *slog.Logger, metrics *metrics.Metrics) *ProductHandler {
 	return &ProductHandler{
 		ProductService: service,
+		Logger:         logger,
+		Metrics:        metrics,
 	}
 }
 
@@ -30,12 +36,17 @@ func NewProductHandler(service database.ProductInterface) *ProductHandler {
 // @Accept       json
 // @Produce      json
 // @Param        request  body      dto.CreateProductInput true "product data"
-// @Success      201
+// @Param        Idempotency-Key  header  string false "key to safely retry the request"
+// @Success      201      {object}  dto.CreateProductOutput
 // @Failure      400      {object}  dto.ErrorOutput
-// @Failure      403
+// @Failure      401
+// @Failure      409      {object}  dto.ErrorOutput
+// @Failure      413      {object}  dto.ErrorOutput
+// @Failure      422      {object}  dto.ErrorOutput
 // @Failure      500      {object}  dto.ErrorOutput
 // @Router       /products [post]
 // @Security     ApiKeyAuth
+// @Security     XAPIKey
 func (h *ProductHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 	var product dto.CreateProductInput
 	err := json.NewDecoder(r.Body).Decode(&product)
@@ -52,13 +63,15 @@ func (h *ProductHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(error)
 		return
 	}
-	err = h.ProductService.Create(p)
+	err = h.ProductService.Create(r.Context(), p)
 	if err != nil {
+		h.Logger.ErrorContext(r.Context(), "error creating product", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		error := dto.ErrorOutput{Message: "error creating product"}
 		json.NewEncoder(w).Encode(error)
 		return
 	}
+	h.Metrics.ProductsCreated.Inc()
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(dto.CreateProductOutput{ID: p.ID.String()})
@@ -68,15 +81,23 @@ func (h *ProductHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 // @Summary      Get a product data
 // @Description  Get a product data
 // @Tags         products
-// @Produce      json
+// @Produce      json,application/xml,text/csv,application/x-ndjson
 // @Param        id       path      string true "product id"
 // @Success      200      {object}  entity.Product
 // @Failure      400      {object}  dto.ErrorOutput
-// @Failure      403
+// @Failure      401
 // @Failure      404      {object}  dto.ErrorOutput
+// @Failure      406      {object}  dto.ErrorOutput
 // @Router       /products/{id} [get]
 // @Security     ApiKeyAuth
+// @Security     XAPIKey
 func (h *ProductHandler) GetProduct(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	contentType, ok := negotiateContentType(r, productContentTypes)
+	if !ok {
+		writeNotAcceptable(w)
+		return
+	}
 	id := chi.URLParam(r, "id")
 	if id == "" {
 		w.WriteHeader(http.StatusBadRequest)
@@ -84,16 +105,14 @@ func (h *ProductHandler) GetProduct(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(error)
 		return
 	}
-	product, err := h.ProductService.FindByID(id)
+	product, err := h.ProductService.FindByID(r.Context(), id)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		error := dto.ErrorOutput{Message: "product not found"}
 		json.NewEncoder(w).Encode(error)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(product)
+	writeProduct(w, http.StatusOK, contentType, product)
 }
 
 // Update product godoc
@@ -106,11 +125,12 @@ func (h *ProductHandler) GetProduct(w http.ResponseWriter, r *http.Request) {
 // @Param        request  body      dto.CreateProductInput true "product data"
 // @Success      200
 // @Failure      400      {object}  dto.ErrorOutput
-// @Failure      403
+// @Failure      401
 // @Failure      404      {object}  dto.ErrorOutput
 // @Failure      500      {object}  dto.ErrorOutput
 // @Router       /products/{id} [put]
 // @Security     ApiKeyAuth
+// @Security     XAPIKey
 func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if id == "" {
@@ -137,7 +157,7 @@ func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.ProductService.Update(&product)
+	err = h.ProductService.Update(r.Context(), &product)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			w.WriteHeader(http.StatusNotFound)
@@ -145,6 +165,7 @@ func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(error)
 			return
 		}
+		h.Logger.ErrorContext(r.Context(), "error updating product", "id", id, "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		error := dto.ErrorOutput{Message: "server error"}
 		json.NewEncoder(w).Encode(error)
@@ -161,11 +182,12 @@ func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 // @Param        id       path      string true "product id"
 // @Success      200
 // @Failure      400      {object}  dto.ErrorOutput
-// @Failure      403
+// @Failure      401
 // @Failure      404      {object}  dto.ErrorOutput
 // @Failure      500      {object}  dto.ErrorOutput
 // @Router       /products/{id} [delete]
 // @Security     ApiKeyAuth
+// @Security     XAPIKey
 func (h *ProductHandler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if id == "" {
@@ -183,7 +205,7 @@ func (h *ProductHandler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.ProductService.Delete(id)
+	err = h.ProductService.Delete(r.Context(), id)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			w.WriteHeader(http.StatusNotFound)
@@ -191,6 +213,7 @@ func (h *ProductHandler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(error)
 			return
 		}
+		h.Logger.ErrorContext(r.Context(), "error deleting product", "id", id, "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		error := dto.ErrorOutput{Message: "server error"}
 		json.NewEncoder(w).Encode(error)
@@ -203,15 +226,23 @@ func (h *ProductHandler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 // @Summary      Get all products data
 // @Description  Get all products data
 // @Tags         products
-// @Produce      json
+// @Produce      json,application/xml,text/csv,application/x-ndjson
 // @Param        page     query     string false "page number"
 // @Param        limit    query     string false "limit"
 // @Success      200      {array}   entity.Product
-// @Failure      403
+// @Failure      401
+// @Failure      406      {object}  dto.ErrorOutput
 // @Failure      500      {object}  dto.ErrorOutput
 // @Router       /products [get]
 // @Security     ApiKeyAuth
+// @Security     XAPIKey
 func (h *ProductHandler) GetProducts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	contentType, ok := negotiateContentType(r, productContentTypes)
+	if !ok {
+		writeNotAcceptable(w)
+		return
+	}
 	pageStr := r.URL.Query().Get("page")
 	limitStr := r.URL.Query().Get("limit")
 	sort := r.URL.Query().Get("sort")
@@ -226,14 +257,13 @@ func (h *ProductHandler) GetProducts(w http.ResponseWriter, r *http.Request) {
 		limit = 0
 	}
 
-	products, err := h.ProductService.FindAll(page, limit, sort)
+	products, err := h.ProductService.FindAll(r.Context(), page, limit, sort)
 	if err != nil {
+		h.Logger.ErrorContext(r.Context(), "error listing products", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		error := dto.ErrorOutput{Message: "server error"}
 		json.NewEncoder(w).Encode(error)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(products)
+	writeProducts(w, http.StatusOK, contentType, products)
 }
@@ -0,0 +1,294 @@
+package handlers_test
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"goexpert-api/internal/dto"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database/memory"
+	"goexpert-api/internal/infra/metrics"
+	"goexpert-api/internal/infra/webserver/handlers"
+	entityPkg "goexpert-api/pkg/entity"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/jwtauth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProductsWhenTokenIsInvalid(t *testing.T) {
+	s := setupTestServer(t)
+	product := s.createProduct(t, "Product 1", 10)
+	_, wrongSecretToken, _ := jwtauth.New("HS256", []byte("wrong"), nil).Encode(map[string]interface{}{
+		"sub": s.user.ID.String(),
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+
+	tokens := map[string]string{
+		"missing":      "",
+		"malformed":    "not-a-jwt",
+		"expired":      s.token(t, -time.Minute),
+		"wrong secret": wrongSecretToken,
+	}
+	routes := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodGet, "/v1/products", ""},
+		{http.MethodPost, "/v1/products", `{"name":"Product 2","price":20}`},
+		{http.MethodGet, "/v1/products/" + product.ID.String(), ""},
+		{http.MethodPut, "/v1/products/" + product.ID.String(), `{"name":"Product 2","price":20}`},
+		{http.MethodDelete, "/v1/products/" + product.ID.String(), ""},
+	}
+	for name, token := range tokens {
+		for _, route := range routes {
+			w := s.request(route.method, route.path, token, route.body)
+			assert.Equal(t, http.StatusUnauthorized, w.Code, "%s token on %s %s", name, route.method, route.path)
+		}
+	}
+
+	_, err := s.products.FindByID(context.Background(), product.ID.String())
+	assert.Nil(t, err, "product must not be deleted")
+}
+
+func TestCreateProduct(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodPost, "/v1/products", s.validToken(t), `{"name":"Product 1","price":10}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var output dto.CreateProductOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+
+	product, err := s.products.FindByID(context.Background(), output.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Product 1", product.Name)
+	assert.Equal(t, 10.0, product.Price)
+}
+
+func TestCreateProductWhenInvalid(t *testing.T) {
+	s := setupTestServer(t)
+
+	bodies := []string{
+		`{"name":`,
+		`{"name":"","price":10}`,
+		`{"name":"Product 1","price":0}`,
+		`{"name":"Product 1","price":-10}`,
+	}
+	for _, body := range bodies {
+		w := s.request(http.MethodPost, "/v1/products", s.validToken(t), body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
+
+func TestCreateProductWithIdempotencyKey(t *testing.T) {
+	s := setupTestServer(t)
+	token := s.validToken(t)
+
+	first := s.request(http.MethodPost, "/v1/products", token, `{"name":"Product 1","price":10}`, "Idempotency-Key", "key-1")
+	second := s.request(http.MethodPost, "/v1/products", token, `{"name":"Product 1","price":10}`, "Idempotency-Key", "key-1")
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Equal(t, http.StatusCreated, second.Code)
+	assert.Equal(t, first.Body.String(), second.Body.String())
+
+	products, _ := s.products.FindAll(context.Background(), 0, 0, "")
+	assert.Len(t, products, 1)
+
+	w := s.request(http.MethodPost, "/v1/products", token, `{"name":"Product 2","price":10}`, "Idempotency-Key", "key-1")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestCreateProductWhenDatabaseFails(t *testing.T) {
+	s := setupTestServer(t, withProducts(failingProductService{}))
+
+	w := s.request(http.MethodPost, "/v1/products", s.validToken(t), `{"name":"Product 1","price":10}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestGetProduct(t *testing.T) {
+	s := setupTestServer(t)
+	product := s.createProduct(t, "Product 1", 10)
+
+	w := s.request(http.MethodGet, "/v1/products/"+product.ID.String(), s.validToken(t), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var productFound entity.Product
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&productFound))
+	assert.Equal(t, product.ID, productFound.ID)
+	assert.Equal(t, product.Name, productFound.Name)
+}
+
+func TestGetProductWhenNotFound(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodGet, "/v1/products/"+entityPkg.NewID().String(), s.validToken(t), "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGetProductWhenIDIsMissing(t *testing.T) {
+	handler := handlers.NewProductHandler(memory.NewProductService(), slog.Default(), metrics.New())
+
+	// The router never matches an empty id, so the handler is called directly
+	w := httptest.NewRecorder()
+	handler.GetProduct(w, httptest.NewRequest(http.MethodGet, "/v1/products/", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetProductAsCSV(t *testing.T) {
+	s := setupTestServer(t)
+	product := s.createProduct(t, "Product 1", 10)
+
+	w := s.request(http.MethodGet, "/v1/products/"+product.ID.String(), s.validToken(t), "", "Accept", "text/csv")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), product.ID.String()+",Product 1,10,")
+}
+
+func TestGetProductWhenNotAcceptable(t *testing.T) {
+	s := setupTestServer(t)
+	product := s.createProduct(t, "Product 1", 10)
+
+	w := s.request(http.MethodGet, "/v1/products/"+product.ID.String(), s.validToken(t), "", "Accept", "text/html")
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+}
+
+func TestGetProducts(t *testing.T) {
+	s := setupTestServer(t)
+	for i := range 3 {
+		s.createProduct(t, fmt.Sprintf("Product %d", i+1), 10)
+	}
+
+	w := s.request(http.MethodGet, "/v1/products?page=1&limit=2", s.validToken(t), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var products []entity.Product
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&products))
+	assert.Len(t, products, 2)
+}
+
+func TestGetProductsCompressed(t *testing.T) {
+	s := setupTestServer(t)
+	s.createProduct(t, "Product 1", 10)
+
+	w := s.request(http.MethodGet, "/v1/products", s.validToken(t), "", "Accept-Encoding", "gzip")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	reader, err := gzip.NewReader(w.Body)
+	assert.Nil(t, err)
+	var products []entity.Product
+	assert.Nil(t, json.NewDecoder(reader).Decode(&products))
+	assert.Len(t, products, 1)
+}
+
+func TestGetProductsWhenNotAcceptable(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodGet, "/v1/products", s.validToken(t), "", "Accept", "image/png")
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+}
+
+func TestGetProductsWhenDatabaseFails(t *testing.T) {
+	s := setupTestServer(t, withProducts(failingProductService{}))
+
+	w := s.request(http.MethodGet, "/v1/products", s.validToken(t), "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestUpdateProduct(t *testing.T) {
+	s := setupTestServer(t)
+	product := s.createProduct(t, "Product 1", 10)
+
+	w := s.request(http.MethodPut, "/v1/products/"+product.ID.String(), s.validToken(t), `{"name":"Updated product 1","price":20}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	productFound, err := s.products.FindByID(context.Background(), product.ID.String())
+	assert.Nil(t, err)
+	assert.Equal(t, "Updated product 1", productFound.Name)
+	assert.Equal(t, 20.0, productFound.Price)
+}
+
+func TestUpdateProductWhenInvalid(t *testing.T) {
+	s := setupTestServer(t)
+	product := s.createProduct(t, "Product 1", 10)
+
+	w := s.request(http.MethodPut, "/v1/products/"+product.ID.String(), s.validToken(t), `{"name":`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = s.request(http.MethodPut, "/v1/products/abc123", s.validToken(t), `{"name":"Product 1","price":10}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestUpdateProductWhenNotFound(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodPut, "/v1/products/"+entityPkg.NewID().String(), s.validToken(t), `{"name":"Product 1","price":10}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestUpdateProductWhenDatabaseFails(t *testing.T) {
+	s := setupTestServer(t, withProducts(failingProductService{}))
+
+	w := s.request(http.MethodPut, "/v1/products/"+entityPkg.NewID().String(), s.validToken(t), `{"name":"Product 1","price":10}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestDeleteProduct(t *testing.T) {
+	s := setupTestServer(t)
+	product := s.createProduct(t, "Product 1", 10)
+
+	w := s.request(http.MethodDelete, "/v1/products/"+product.ID.String(), s.validToken(t), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	_, err := s.products.FindByID(context.Background(), product.ID.String())
+	assert.NotNil(t, err)
+}
+
+func TestDeleteProductWhenInvalidID(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodDelete, "/v1/products/abc123", s.validToken(t), "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestDeleteProductWhenNotFound(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodDelete, "/v1/products/"+entityPkg.NewID().String(), s.validToken(t), "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestDeleteProductWhenDatabaseFails(t *testing.T) {
+	s := setupTestServer(t, withProducts(failingProductService{}))
+
+	w := s.request(http.MethodDelete, "/v1/products/"+entityPkg.NewID().String(), s.validToken(t), "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestLegacyProductRoutesAreDeprecated(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodGet, "/products", s.validToken(t), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Regexp(t, `^@\d+$`, w.Header().Get("Deprecation"))
+	assert.Equal(t, `</v1/products>; rel="successor-version"`, w.Header().Get("Link"))
+
+	w = s.request(http.MethodPost, "/user/generate_token", "", `{"email":"john@doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `</v1/user/generate_token>; rel="successor-version"`, w.Header().Get("Link"))
+}
+
+func TestRoutesAddedAfterVersioningAreOnlyVersioned(t *testing.T) {
+	s := setupWebhookServer(t)
+
+	for _, path := range []string{"/user/me", "/user/me/api_keys", "/admin/users", "/admin/oauth_clients", "/webhooks"} {
+		w := s.request(http.MethodGet, path, s.validToken(t), "")
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+		w = s.request(http.MethodGet, "/v1"+path, s.validToken(t), "")
+		assert.NotEqual(t, http.StatusNotFound, w.Code, "/v1"+path)
+	}
+	w := s.request(http.MethodPost, "/user/password/forgot", "", `{"email":"john@doe.com"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
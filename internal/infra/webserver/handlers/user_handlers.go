@@ -2,40 +2,94 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"goexpert-api/internal/dto"
 	"goexpert-api/internal/entity"
 	"goexpert-api/internal/infra/database"
+	"goexpert-api/internal/infra/metrics"
+	"goexpert-api/internal/infra/ratelimit"
+	"goexpert-api/pkg/jwtkeys"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
-
-	"github.com/go-chi/jwtauth"
 )
 
 type UserHandler struct {
-	UserService  database.UserInterface
-	TokenAuth    *jwtauth.JWTAuth
-	JWTExpiresIn int
+	UserService    database.UserInterface
+	TokenAuth      *jwtkeys.KeySet
+	JWTExpiresIn   int
+	Logger         *slog.Logger
+	Metrics        *metrics.Metrics
+	LoginGuard     *ratelimit.LoginGuard
+	PasswordPolicy *entity.PasswordPolicy
+	// Sends the verification of new emails, nil when no emails are sent
+	EmailVerification *EmailVerificationHandler
+	// One of the UnverifiedLogin policies
+	UnverifiedLogin string
+	// Issues the MFA challenges of the users with two-factor authentication
+	MFA *MFAHandler
 }
 
-func NewUserHandler(service database.UserInterface, tokenAuth *jwtauth.JWTAuth, jwtExpiresIn int) *UserHandler {
+func NewUserHandler(service database.UserInterface, tokenAuth *jwtkeys.KeySet, jwtExpiresIn int, logger *slog.Logger, metrics *metrics.Metrics, loginGuard *ratelimit.LoginGuard, passwordPolicy *entity.PasswordPolicy, emailVerification *EmailVerificationHandler, unverifiedLogin string, mfa *MFAHandler) *UserHandler {
 	return &UserHandler{
-		UserService:  service,
-		TokenAuth:    tokenAuth,
-		JWTExpiresIn: jwtExpiresIn,
+		UserService:       service,
+		TokenAuth:         tokenAuth,
+		JWTExpiresIn:      jwtExpiresIn,
+		Logger:            logger,
+		Metrics:           metrics,
+		LoginGuard:        loginGuard,
+		PasswordPolicy:    passwordPolicy,
+		EmailVerification: emailVerification,
+		UnverifiedLogin:   unverifiedLogin,
+		MFA:               mfa,
+	}
+}
+
+// userErrorCodes are the codes of the errors returned when creating a user.
+var userErrorCodes = []struct {
+	err  error
+	code string
+}{
+	{entity.ErrNameIsRequired, "name_required"},
+	{entity.ErrEmailIsRequired, "email_required"},
+	{entity.ErrInvalidEmail, "invalid_email"},
+	{entity.ErrPasswordIsRequired, "password_required"},
+	{entity.ErrPasswordTooShort, "password_too_short"},
+	{entity.ErrPasswordTooLong, "password_too_long"},
+	{entity.ErrPasswordTooWeak, "password_too_weak"},
+	{entity.ErrPasswordBreached, "password_breached"},
+}
+
+// invalidUser writes the 400 response of an invalid user.
+func invalidUser(w http.ResponseWriter, err error) {
+	output := dto.ErrorOutput{Message: "invalid user data", Code: "invalid_user"}
+	for _, userError := range userErrorCodes {
+		if errors.Is(err, userError.err) {
+			output = dto.ErrorOutput{Message: err.Error(), Code: userError.code}
+			break
+		}
 	}
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(output)
 }
 
 // Get JWT godoc
 // @Summary      Get a user JWT
-// @Description  Get a user JWT
+// @Description  Get a user JWT. Users with two-factor authentication get a 202 response with an MFA token instead, exchanged for the JWT with a code at POST /user/generate_token/mfa. Users who know the password are told when they are disabled, with the user_disabled code, or, if unverified users can't log in, when the email was not verified, with the email_not_verified code.
 // @Tags         users
 // @Accept       json
 // @Produce      json
 // @Param        request  body      dto.GetJWTInput true "user credentials"
 // @Success      200      {object}  dto.GetJWTOutput
+// @Success      202      {object}  dto.MFAChallengeOutput
 // @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401      {object}  dto.ErrorOutput
 // @Failure      403      {object}  dto.ErrorOutput
 // @Failure      404      {object}  dto.ErrorOutput
+// @Failure      429      {object}  dto.ErrorOutput
 // @Failure      500      {object}  dto.ErrorOutput
 // @Router       /user/generate_token [post]
 func (h *UserHandler) GetJWT(w http.ResponseWriter, r *http.Request) {
@@ -48,39 +102,116 @@ func (h *UserHandler) GetJWT(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.UserService.FindByEmail(userInput.Email)
+	guardKey := "login:" + entity.NormalizeEmail(userInput.Email)
+	retryAfter, err := h.LoginGuard.Allow(r.Context(), guardKey)
+	if errors.Is(err, ratelimit.ErrRateLimited) || errors.Is(err, ratelimit.ErrLocked) {
+		h.Logger.WarnContext(r.Context(), "login refused", "reason", err.Error())
+		h.Metrics.LoginFailures.WithLabelValues("rate_limited").Inc()
+		seconds := int(math.Max(1, math.Ceil(retryAfter.Seconds())))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		w.WriteHeader(http.StatusTooManyRequests)
+		error := dto.ErrorOutput{Message: "too many requests"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	if err != nil {
+		h.Logger.ErrorContext(r.Context(), "error checking login attempts", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		error := dto.ErrorOutput{Message: "server error"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+
+	user, err := h.UserService.FindByEmail(r.Context(), entity.NormalizeEmail(userInput.Email))
 	if err != nil {
+		h.Logger.WarnContext(r.Context(), "login failed", "reason", "user not found")
+		h.Metrics.LoginFailures.WithLabelValues("user_not_found").Inc()
+		h.recordLoginFailure(r, guardKey)
 		w.WriteHeader(http.StatusNotFound)
 		error := dto.ErrorOutput{Message: "not found"}
 		json.NewEncoder(w).Encode(error)
 		return
 	}
 	if !user.ValidatePassword(userInput.Password) {
+		h.Logger.WarnContext(r.Context(), "login failed", "reason", "invalid password", "user_id", user.ID.String())
+		h.Metrics.LoginFailures.WithLabelValues("invalid_password").Inc()
+		h.recordLoginFailure(r, guardKey)
 		w.WriteHeader(http.StatusUnauthorized)
 		error := dto.ErrorOutput{Message: "unauthorized"}
 		json.NewEncoder(w).Encode(error)
 		return
 	}
+	// Only told to clients that know the password
+	if user.Disabled() {
+		h.Logger.WarnContext(r.Context(), "login failed", "reason", "user disabled", "user_id", user.ID.String())
+		h.Metrics.LoginFailures.WithLabelValues("user_disabled").Inc()
+		w.WriteHeader(http.StatusForbidden)
+		error := dto.ErrorOutput{Message: "user disabled", Code: "user_disabled"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	if h.UnverifiedLogin == UnverifiedLoginDeny && !user.EmailVerified() {
+		h.Logger.WarnContext(r.Context(), "login failed", "reason", "email not verified", "user_id", user.ID.String())
+		h.Metrics.LoginFailures.WithLabelValues("email_not_verified").Inc()
+		w.WriteHeader(http.StatusForbidden)
+		error := dto.ErrorOutput{Message: "email not verified", Code: "email_not_verified"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	if err := h.LoginGuard.Success(r.Context(), guardKey); err != nil {
+		h.Logger.ErrorContext(r.Context(), "error resetting login attempts", "error", err)
+	}
+	if user.MFAEnabled() {
+		h.MFA.challenge(w, r, user)
+		return
+	}
 
-	_, token, _ := h.TokenAuth.Encode(map[string]interface{}{
-		"sub": user.ID.String(),
-		"exp": time.Now().Add(time.Second * time.Duration(h.JWTExpiresIn)).Unix(),
-	})
+	token, err := h.newToken(user)
+	if err != nil {
+		h.Logger.ErrorContext(r.Context(), "error generating token", "user_id", user.ID.String(), "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		error := dto.ErrorOutput{Message: "error generating token"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
 	accessToken := dto.GetJWTOutput{AccessToken: token}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(accessToken)
 }
 
+// newToken issues a JWT for user with the expiration of the handler.
+func (h *UserHandler) newToken(user *entity.User) (string, error) {
+	return newAccessToken(h.TokenAuth, h.JWTExpiresIn, user)
+}
+
+// newAccessToken issues a JWT for user expiring in expiresIn seconds, revoked
+// when its TokenVersion changes.
+func newAccessToken(tokenAuth *jwtkeys.KeySet, expiresIn int, user *entity.User) (string, error) {
+	_, token, err := tokenAuth.Encode(map[string]interface{}{
+		"sub":                    user.ID.String(),
+		"exp":                    time.Now().Add(time.Second * time.Duration(expiresIn)).Unix(),
+		entity.TokenVersionClaim: user.TokenVersion,
+	})
+	return token, err
+}
+
+func (h *UserHandler) recordLoginFailure(r *http.Request, key string) {
+	if err := h.LoginGuard.Failure(r.Context(), key); err != nil {
+		h.Logger.ErrorContext(r.Context(), "error recording login failure", "error", err)
+	}
+}
+
 // Create user godoc
 // @Summary      Create user
-// @Description  Create user
+// @Description  Create user, pending the verification of the email with the link sent to it. Invalid data is reported with one of the codes name_required, email_required, invalid_email, password_required, password_too_short, password_too_long, password_too_weak and password_breached.
 // @Tags         users
 // @Accept       json
 // @Produce      json
 // @Param        request  body      dto.CreateUserInput true "user request"
 // @Success      201
 // @Failure      400      {object}  dto.ErrorOutput
+// @Failure      409      {object}  dto.ErrorOutput
 // @Failure      500      {object}  dto.ErrorOutput
 // @Router       /user [post]
 func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
@@ -88,23 +219,257 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		error := dto.ErrorOutput{Message: "invalid format"}
+		error := dto.ErrorOutput{Message: "invalid format", Code: "invalid_format"}
 		json.NewEncoder(w).Encode(error)
 		return
 	}
+	// Checked before hashing the password in NewUser
+	if err := h.PasswordPolicy.Validate(user.Password); err != nil {
+		invalidUser(w, err)
+		return
+	}
 	u, err := entity.NewUser(user.Name, user.Email, user.Password)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		error := dto.ErrorOutput{Message: "invalid user data"}
+		invalidUser(w, err)
+		return
+	}
+	err = h.UserService.Create(r.Context(), u)
+	if errors.Is(err, database.ErrDuplicatedKey) {
+		w.WriteHeader(http.StatusConflict)
+		error := dto.ErrorOutput{Message: "email already registered", Code: "email_taken"}
 		json.NewEncoder(w).Encode(error)
 		return
 	}
-	err = h.UserService.Create(u)
 	if err != nil {
+		h.Logger.ErrorContext(r.Context(), "error creating user", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		error := dto.ErrorOutput{Message: "error creating user"}
 		json.NewEncoder(w).Encode(error)
 		return
 	}
+	h.sendVerification(r, u)
 	w.WriteHeader(http.StatusCreated)
 }
+
+// sendVerification emails the link that verifies the email of user, if emails
+// are sent. Failures are only logged, the user can ask to resend it.
+func (h *UserHandler) sendVerification(r *http.Request, user *entity.User) {
+	if h.EmailVerification == nil {
+		return
+	}
+	if err := h.EmailVerification.SendVerification(r.Context(), user); err != nil {
+		h.Logger.ErrorContext(r.Context(), "error sending email verification", "user_id", user.ID.String(), "error", err)
+	}
+}
+
+// Get me godoc
+// @Summary      Get the authenticated user
+// @Description  Get the authenticated user
+// @Tags         users
+// @Produce      json
+// @Success      200      {object}  entity.User
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /user/me [get]
+// @Security     ApiKeyAuth
+func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findMe(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
+// Update me godoc
+// @Summary      Update the authenticated user
+// @Description  Update the name and email of the authenticated user. A new email must be verified with the link sent to it.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        request  body      dto.UpdateUserInput true "user data"
+// @Success      200      {object}  entity.User
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      409      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /user/me [put]
+// @Security     ApiKeyAuth
+func (h *UserHandler) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findMe(w, r)
+	if !ok {
+		return
+	}
+	var input dto.UpdateUserInput
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: "invalid format", Code: "invalid_format"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	previousEmail := user.Email
+	user.Name = strings.TrimSpace(input.Name)
+	user.ChangeEmail(input.Email)
+	if err := user.Validate(); err != nil {
+		invalidUser(w, err)
+		return
+	}
+	err = h.UserService.Update(r.Context(), user, "Name", "Email", "EmailVerifiedAt")
+	if errors.Is(err, database.ErrDuplicatedKey) {
+		w.WriteHeader(http.StatusConflict)
+		error := dto.ErrorOutput{Message: "email already registered", Code: "email_taken"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	if err != nil {
+		h.Logger.ErrorContext(r.Context(), "error updating user", "user_id", user.ID.String(), "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		error := dto.ErrorOutput{Message: "server error"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	if user.Email != previousEmail {
+		h.sendVerification(r, user)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
+// Change password godoc
+// @Summary      Change the password of the authenticated user
+// @Description  Change the password of the authenticated user. The tokens issued before are revoked and a new one is returned. Failed attempts count towards the login limits.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        request  body      dto.ChangePasswordInput true "current and new passwords"
+// @Success      200      {object}  dto.GetJWTOutput
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      403      {object}  dto.ErrorOutput
+// @Failure      429      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /user/me/password [post]
+// @Security     ApiKeyAuth
+func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findMe(w, r)
+	if !ok {
+		return
+	}
+	var input dto.ChangePasswordInput
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: "invalid format", Code: "invalid_format"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+
+	guardKey := "login:" + user.Email
+	retryAfter, err := h.LoginGuard.Allow(r.Context(), guardKey)
+	if errors.Is(err, ratelimit.ErrRateLimited) || errors.Is(err, ratelimit.ErrLocked) {
+		seconds := int(math.Max(1, math.Ceil(retryAfter.Seconds())))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		w.WriteHeader(http.StatusTooManyRequests)
+		error := dto.ErrorOutput{Message: "too many requests"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	if err != nil {
+		h.Logger.ErrorContext(r.Context(), "error checking login attempts", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		error := dto.ErrorOutput{Message: "server error"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	if !user.ValidatePassword(input.CurrentPassword) {
+		h.Logger.WarnContext(r.Context(), "password change failed", "reason", "invalid password", "user_id", user.ID.String())
+		h.recordLoginFailure(r, guardKey)
+		w.WriteHeader(http.StatusForbidden)
+		error := dto.ErrorOutput{Message: "invalid current password", Code: "invalid_current_password"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	if err := h.LoginGuard.Success(r.Context(), guardKey); err != nil {
+		h.Logger.ErrorContext(r.Context(), "error resetting login attempts", "error", err)
+	}
+
+	if err := h.PasswordPolicy.Validate(input.NewPassword); err != nil {
+		invalidUser(w, err)
+		return
+	}
+	if err := user.ChangePassword(input.NewPassword); err != nil {
+		invalidUser(w, err)
+		return
+	}
+	err = h.UserService.Update(r.Context(), user, passwordFields...)
+	if err != nil {
+		h.Logger.ErrorContext(r.Context(), "error updating user", "user_id", user.ID.String(), "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		error := dto.ErrorOutput{Message: "server error"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	h.Logger.InfoContext(r.Context(), "password changed", "user_id", user.ID.String())
+
+	token, err := h.newToken(user)
+	if err != nil {
+		h.Logger.ErrorContext(r.Context(), "error generating token", "user_id", user.ID.String(), "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		error := dto.ErrorOutput{Message: "error generating token"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(dto.GetJWTOutput{AccessToken: token})
+}
+
+// Delete me godoc
+// @Summary      Delete the authenticated user
+// @Description  Delete the authenticated user. Its tokens stop working.
+// @Tags         users
+// @Produce      json
+// @Success      200
+// @Failure      401      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /user/me [delete]
+// @Security     ApiKeyAuth
+func (h *UserHandler) DeleteMe(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findMe(w, r)
+	if !ok {
+		return
+	}
+	err := h.UserService.Delete(r.Context(), user.ID.String())
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		h.Logger.ErrorContext(r.Context(), "error deleting user", "user_id", user.ID.String(), "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		error := dto.ErrorOutput{Message: "server error"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	h.Logger.InfoContext(r.Context(), "user deleted", "user_id", user.ID.String())
+	w.WriteHeader(http.StatusOK)
+}
+
+// findMe loads the authenticated user, writing the error response when it
+// can't be found.
+func (h *UserHandler) findMe(w http.ResponseWriter, r *http.Request) (*entity.User, bool) {
+	user, err := h.UserService.FindByID(r.Context(), subject(r))
+	if errors.Is(err, database.ErrNotFound) {
+		w.WriteHeader(http.StatusUnauthorized)
+		error := dto.ErrorOutput{Message: "unauthorized"}
+		json.NewEncoder(w).Encode(error)
+		return nil, false
+	}
+	if err != nil {
+		h.Logger.ErrorContext(r.Context(), "error finding user", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		error := dto.ErrorOutput{Message: "server error"}
+		json.NewEncoder(w).Encode(error)
+		return nil, false
+	}
+	return user, true
+}
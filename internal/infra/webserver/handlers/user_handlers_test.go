@@ -0,0 +1,294 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"goexpert-api/internal/dto"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateUser(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodPost, "/v1/user", "", `{"name":"Jane Doe","email":" Jane@Doe.com","password":"abc12345"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	user, err := s.users.FindByEmail(context.Background(), "jane@doe.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "Jane Doe", user.Name)
+	assert.True(t, user.ValidatePassword("abc12345"))
+}
+
+func TestCreateUserWhenInvalid(t *testing.T) {
+	s := setupTestServer(t)
+
+	tests := map[string]string{
+		`{"name":`: "invalid_format",
+		`{"name":"","email":"jane@doe.com","password":"abc12345"}`:          "name_required",
+		`{"name":"Jane Doe","email":"","password":"abc12345"}`:              "email_required",
+		`{"name":"Jane Doe","email":"jane@doe","password":"abc12345"}`:      "invalid_email",
+		`{"name":"Jane Doe","email":"jane@doe.com","password":""}`:          "password_required",
+		`{"name":"Jane Doe","email":"jane@doe.com","password":"111"}`:       "password_too_short",
+		`{"name":"Jane Doe","email":"jane@doe.com","password":"abcdefghi"}`: "password_too_weak",
+		`{"name":"Jane Doe","email":"jane@doe.com","password":"Password1"}`: "password_breached",
+		// bcrypt refuses passwords longer than 72 bytes
+		`{"name":"Jane Doe","email":"jane@doe.com","password":"` + strings.Repeat("a1", 37) + `"}`: "password_too_long",
+	}
+	for body, code := range tests {
+		w := s.request(http.MethodPost, "/v1/user", "", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		var output dto.ErrorOutput
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+		assert.Equal(t, code, output.Code, body)
+		assert.NotEmpty(t, output.Message)
+	}
+	_, err := s.users.FindByEmail(context.Background(), "jane@doe.com")
+	assert.NotNil(t, err)
+}
+
+func TestCreateUserWhenEmailExists(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodPost, "/v1/user", "", `{"name":"Other John","email":"John@Doe.com","password":"abc12345"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var output dto.ErrorOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+	assert.Equal(t, "email_taken", output.Code)
+}
+
+func TestCreateUserWhenDatabaseFails(t *testing.T) {
+	s := setupTestServer(t, withUsers(failingUserService{}))
+
+	w := s.request(http.MethodPost, "/v1/user", "", `{"name":"Jane Doe","email":"jane@doe.com","password":"abc12345"}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestGetJWT(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var output dto.GetJWTOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+
+	token, err := s.tokenAuth.Verify(output.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, s.user.ID.String(), token.Subject())
+
+	// The token gives access to the products
+	w = s.request(http.MethodGet, "/v1/products", output.AccessToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestGetJWTWithUnnormalizedEmail(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":" John@Doe.com ","password":"abc123"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestGetJWTWhenInvalid(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetJWTWhenPasswordIsWrong(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"wrong"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestGetJWTWhenUserIsNotFound(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"jane@doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGetJWTWhenRateLimited(t *testing.T) {
+	s := setupTestServer(t)
+
+	// The test server allows a burst of 3 attempts per email
+	for range 3 {
+		w := s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"wrong"}`)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+	w := s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"John@Doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// The test server refills a token per second
+	s.clock.Advance(time.Second)
+	w = s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestGetJWTWhenRateLimitStoreFails(t *testing.T) {
+	s := setupTestServer(t, withRateLimitStore(failingRateLimitStore{}))
+
+	w := s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestMeWhenTokenIsInvalid(t *testing.T) {
+	s := setupTestServer(t)
+
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		w := s.request(method, "/v1/user/me", "", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code, method)
+	}
+	w := s.request(http.MethodPost, "/v1/user/me/password", s.token(t, -time.Minute), "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestMeWhenDatabaseFails(t *testing.T) {
+	s := setupTestServer(t, withUsers(failingUserService{}))
+
+	w := s.request(http.MethodGet, "/v1/user/me", s.validToken(t), "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestGetMe(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodGet, "/v1/user/me", s.validToken(t), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var output map[string]any
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+	assert.Equal(t, s.user.ID.String(), output["id"])
+	assert.Equal(t, "John Doe", output["name"])
+	assert.Equal(t, testEmail, output["email"])
+	assert.NotContains(t, output, "password")
+}
+
+func TestUpdateMe(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodPut, "/v1/user/me", s.validToken(t), `{"name":" Johnny ","email":"Johnny@Doe.com"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"email":"johnny@doe.com"`)
+
+	user, err := s.users.FindByID(context.Background(), s.user.ID.String())
+	assert.Nil(t, err)
+	assert.Equal(t, "Johnny", user.Name)
+	assert.Equal(t, "johnny@doe.com", user.Email)
+	// The token still works
+	w = s.request(http.MethodGet, "/v1/user/me", s.validToken(t), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestUpdateMeWhenInvalid(t *testing.T) {
+	s := setupTestServer(t)
+	other, _ := entity.NewUser("Jane Doe", "jane@doe.com", testPassword)
+	assert.Nil(t, s.users.Create(context.Background(), other))
+
+	tests := map[string]struct {
+		status int
+		code   string
+	}{
+		`{"name":`:                               {http.StatusBadRequest, "invalid_format"},
+		`{"name":"","email":"john@doe.com"}`:     {http.StatusBadRequest, "name_required"},
+		`{"name":"John","email":"john"}`:         {http.StatusBadRequest, "invalid_email"},
+		`{"name":"John","email":"Jane@doe.com"}`: {http.StatusConflict, "email_taken"},
+	}
+	for body, want := range tests {
+		w := s.request(http.MethodPut, "/v1/user/me", s.validToken(t), body)
+		assert.Equal(t, want.status, w.Code, body)
+		var output dto.ErrorOutput
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+		assert.Equal(t, want.code, output.Code, body)
+	}
+	user, _ := s.users.FindByID(context.Background(), s.user.ID.String())
+	assert.Equal(t, "John Doe", user.Name)
+	assert.Equal(t, testEmail, user.Email)
+}
+
+func TestChangePassword(t *testing.T) {
+	s := setupTestServer(t)
+	oldToken := s.validToken(t)
+
+	w := s.request(http.MethodPost, "/v1/user/me/password", oldToken, `{"current_password":"abc123","new_password":"def45678"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var output dto.GetJWTOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+
+	user, _ := s.users.FindByID(context.Background(), s.user.ID.String())
+	assert.True(t, user.ValidatePassword("def45678"))
+
+	// The tokens issued before are revoked
+	w = s.request(http.MethodGet, "/v1/products", oldToken, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = s.request(http.MethodGet, "/v1/user/me", output.AccessToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"def45678"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestChangePasswordWhenInvalid(t *testing.T) {
+	s := setupTestServer(t)
+
+	tests := map[string]struct {
+		status int
+		code   string
+	}{
+		`{"current_password":`: {http.StatusBadRequest, "invalid_format"},
+		`{"current_password":"wrong","new_password":"def45678"}`:   {http.StatusForbidden, "invalid_current_password"},
+		`{"current_password":"abc123","new_password":"short1"}`:    {http.StatusBadRequest, "password_too_short"},
+		`{"current_password":"abc123","new_password":"password1"}`: {http.StatusBadRequest, "password_breached"},
+	}
+	for body, want := range tests {
+		w := s.request(http.MethodPost, "/v1/user/me/password", s.validToken(t), body)
+		assert.Equal(t, want.status, w.Code, body)
+		var output dto.ErrorOutput
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+		assert.Equal(t, want.code, output.Code, body)
+	}
+	user, _ := s.users.FindByID(context.Background(), s.user.ID.String())
+	assert.True(t, user.ValidatePassword(testPassword))
+	assert.Equal(t, 0, user.TokenVersion)
+}
+
+func TestChangePasswordWhenRateLimited(t *testing.T) {
+	s := setupTestServer(t)
+
+	// Wrong current passwords count as failed logins
+	for range 3 {
+		w := s.request(http.MethodPost, "/v1/user/me/password", s.validToken(t), `{"current_password":"wrong","new_password":"def45678"}`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	}
+	w := s.request(http.MethodPost, "/v1/user/me/password", s.validToken(t), `{"current_password":"abc123","new_password":"def45678"}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	user, _ := s.users.FindByID(context.Background(), s.user.ID.String())
+	assert.True(t, user.ValidatePassword(testPassword))
+
+	// Allowed again once a token is refilled
+	s.clock.Advance(time.Second)
+	w = s.request(http.MethodPost, "/v1/user/me/password", s.validToken(t), `{"current_password":"abc123","new_password":"def45678"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestDeleteMe(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodDelete, "/v1/user/me", s.validToken(t), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, err := s.users.FindByID(context.Background(), s.user.ID.String())
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	// The token of a deleted user does not work anymore
+	w = s.request(http.MethodGet, "/v1/products", s.validToken(t), "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goexpert-api/internal/dto"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"goexpert-api/internal/infra/mail"
+	"goexpert-api/internal/infra/ratelimit"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Policies for the logins of users who didn't verify their email.
+const (
+	// Unverified users log in and use the whole API
+	UnverifiedLoginAllow = "allow"
+	// Unverified users log in, but only use the /user/me routes
+	UnverifiedLoginLimit = "limit"
+	// Unverified users can't log in
+	UnverifiedLoginDeny = "deny"
+)
+
+var ErrInvalidUnverifiedLogin = errors.New("invalid unverified login policy")
+
+// EmailVerificationHandler sends the links that verify the email of the
+// users, signed with Secret, and verifies them.
+type EmailVerificationHandler struct {
+	UserService database.UserInterface
+	Mailer      mail.Mailer
+	Secret      []byte
+	// Address of GET /user/verify, receiving the token in the token query
+	// parameter
+	VerifyURL string
+	TTL       time.Duration
+	// Limits the emails resent to each address
+	Limiter *ratelimit.TokenBucket
+	Logger  *slog.Logger
+}
+
+func NewEmailVerificationHandler(users database.UserInterface, mailer mail.Mailer, secret []byte, verifyURL string, ttl time.Duration, limiter *ratelimit.TokenBucket, logger *slog.Logger) *EmailVerificationHandler {
+	return &EmailVerificationHandler{
+		UserService: users,
+		Mailer:      mailer,
+		Secret:      secret,
+		VerifyURL:   verifyURL,
+		TTL:         ttl,
+		Limiter:     limiter,
+		Logger:      logger,
+	}
+}
+
+// SendVerification emails the link that verifies the email of user.
+func (h *EmailVerificationHandler) SendVerification(ctx context.Context, user *entity.User) error {
+	token, err := entity.NewEmailVerificationToken(user, h.Secret, time.Now().Add(h.TTL))
+	if err != nil {
+		return err
+	}
+	link, err := linkWithToken(h.VerifyURL, token)
+	if err != nil {
+		return err
+	}
+	err = h.Mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the link below to verify your email. It expires in %s.\n\n%s\n\nIf you didn't create an account, ignore this email.\n",
+			user.Name, h.TTL, link),
+	})
+	if err != nil {
+		return err
+	}
+	h.Logger.InfoContext(ctx, "email verification sent", "user_id", user.ID.String())
+	return nil
+}
+
+// Verify email godoc
+// @Summary      Verify the email of a user
+// @Description  Verify the email of a user with the token of the link sent by email. An invalid or expired token, or one sent to a previous email of the user, is reported with the invalid_token code.
+// @Tags         users
+// @Produce      json
+// @Param        token    query     string true "token of the link"
+// @Success      200      {object}  entity.User
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /user/verify [get]
+func (h *EmailVerificationHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	userID, email, err := entity.ParseEmailVerificationToken(r.URL.Query().Get("token"), h.Secret, time.Now())
+	if err != nil {
+		h.Logger.WarnContext(r.Context(), "email verification failed", "reason", "invalid token")
+		invalidToken(w)
+		return
+	}
+	user, err := h.UserService.FindByID(r.Context(), userID)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		h.serverError(w, r, "error finding user", err)
+		return
+	}
+	if user == nil || user.Email != email {
+		h.Logger.WarnContext(r.Context(), "email verification failed", "reason", "email changed", "user_id", userID)
+		invalidToken(w)
+		return
+	}
+
+	if !user.EmailVerified() {
+		user.VerifyEmail(time.Now().UTC())
+		if err := h.UserService.Update(r.Context(), user, "EmailVerifiedAt"); err != nil {
+			h.serverError(w, r, "error updating user", err)
+			return
+		}
+		h.Logger.InfoContext(r.Context(), "email verified", "user_id", user.ID.String())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
+// Resend verification godoc
+// @Summary      Resend the email verification
+// @Description  Send the link that verifies the email again. The response is the same whether the email is registered, or already verified, or not.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        request  body      dto.ResendVerificationInput true "email of the user"
+// @Success      202
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      429      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /user/verify/resend [post]
+func (h *EmailVerificationHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var input dto.ResendVerificationInput
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: "invalid format", Code: "invalid_format"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	email := entity.NormalizeEmail(input.Email)
+	if email == "" {
+		invalidUser(w, entity.ErrEmailIsRequired)
+		return
+	}
+
+	// Failures are only logged, so the response doesn't tell if the email is registered
+	if err := h.resend(r, email); err != nil {
+		h.Logger.ErrorContext(r.Context(), "error resending email verification", "error", err)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// resend sends the verification to the user of email again, if there is one
+// pending and the address was not sent too many emails.
+func (h *EmailVerificationHandler) resend(r *http.Request, email string) error {
+	if _, err := h.Limiter.Allow(r.Context(), "email_verification:"+email); err != nil {
+		if errors.Is(err, ratelimit.ErrRateLimited) {
+			h.Logger.WarnContext(r.Context(), "email verification refused", "reason", "rate limited")
+			return nil
+		}
+		return err
+	}
+	user, err := h.UserService.FindByEmail(r.Context(), email)
+	if errors.Is(err, database.ErrNotFound) {
+		h.Logger.InfoContext(r.Context(), "email verification refused", "reason", "user not found")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.EmailVerified() || user.Disabled() {
+		h.Logger.InfoContext(r.Context(), "email verification refused", "reason", "verified or disabled", "user_id", user.ID.String())
+		return nil
+	}
+	return h.SendVerification(r.Context(), user)
+}
+
+func (h *EmailVerificationHandler) serverError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	h.Logger.ErrorContext(r.Context(), message, "error", err)
+	w.WriteHeader(http.StatusInternalServerError)
+	error := dto.ErrorOutput{Message: "server error"}
+	json.NewEncoder(w).Encode(error)
+}
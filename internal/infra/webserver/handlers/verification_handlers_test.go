@@ -0,0 +1,151 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"goexpert-api/internal/dto"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/webserver/handlers"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateUserSendsVerification(t *testing.T) {
+	s := setupTestServer(t)
+
+	w := s.request(http.MethodPost, "/v1/user", "", `{"name":"Jane Doe","email":"Jane@Doe.com","password":"abc12345"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	messages := s.mailer.Messages()
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "jane@doe.com", messages[0].To)
+	assert.Contains(t, messages[0].Body, "http://localhost:8000/v1/user/verify?token=")
+	user, _ := s.users.FindByEmail(context.Background(), "jane@doe.com")
+	assert.False(t, user.EmailVerified())
+
+	token := s.lastMailToken(t)
+	w = s.request(http.MethodGet, "/v1/user/verify?token="+token, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var output entity.User
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+	assert.NotNil(t, output.EmailVerifiedAt)
+	user, _ = s.users.FindByEmail(context.Background(), "jane@doe.com")
+	assert.True(t, user.EmailVerified())
+
+	// Opening the link again is harmless
+	w = s.request(http.MethodGet, "/v1/user/verify?token="+token, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestVerifyEmailWhenTokenIsInvalid(t *testing.T) {
+	s := setupTestServer(t)
+	expired, _ := entity.NewEmailVerificationToken(s.user, []byte(testVerificationSecret), time.Now().Add(-time.Minute))
+	forged, _ := entity.NewEmailVerificationToken(s.user, []byte("other secret"), time.Now().Add(time.Hour))
+	other, _ := entity.NewUser("Jane Doe", "jane@doe.com", testPassword)
+	unknown, _ := entity.NewEmailVerificationToken(other, []byte(testVerificationSecret), time.Now().Add(time.Hour))
+
+	for _, token := range []string{"", "abc", expired, forged, unknown} {
+		w := s.request(http.MethodGet, "/v1/user/verify?token="+token, "", "")
+		assert.Equal(t, http.StatusBadRequest, w.Code, token)
+		var output dto.ErrorOutput
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+		assert.Equal(t, "invalid_token", output.Code)
+	}
+}
+
+func TestUpdateMeSendsVerification(t *testing.T) {
+	s := setupTestServer(t)
+	oldToken, _ := entity.NewEmailVerificationToken(s.user, []byte(testVerificationSecret), time.Now().Add(time.Hour))
+
+	// Only a new email is verified
+	w := s.request(http.MethodPut, "/v1/user/me", s.validToken(t), `{"name":"Johnny Doe","email":"john@doe.com"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, s.mailer.Messages())
+
+	w = s.request(http.MethodPut, "/v1/user/me", s.validToken(t), `{"name":"Johnny Doe","email":"johnny@doe.com"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	messages := s.mailer.Messages()
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "johnny@doe.com", messages[0].To)
+
+	// The links sent to the previous email no longer work
+	w = s.request(http.MethodGet, "/v1/user/verify?token="+oldToken, "", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = s.request(http.MethodGet, "/v1/user/verify?token="+s.lastMailToken(t), "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestResendVerification(t *testing.T) {
+	s := setupTestServer(t)
+	resend := func(email string) {
+		w := s.request(http.MethodPost, "/v1/user/verify/resend", "", `{"email":"`+email+`"}`)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+	}
+
+	resend("jane@doe.com")
+	assert.Empty(t, s.mailer.Messages())
+
+	resend(" John@Doe.com ")
+	assert.Len(t, s.mailer.Messages(), 1)
+	// Throttled by address
+	resend(testEmail)
+	resend(testEmail)
+	assert.Len(t, s.mailer.Messages(), 2)
+
+	w := s.request(http.MethodPost, "/v1/user/verify/resend", "", `{"email":`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = s.request(http.MethodPost, "/v1/user/verify/resend", "", `{"email":""}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "email_required")
+}
+
+func TestResendVerificationWhenVerified(t *testing.T) {
+	s := setupTestServer(t)
+	s.user.VerifyEmail(time.Now())
+	assert.Nil(t, s.users.Update(context.Background(), s.user, "EmailVerifiedAt"))
+
+	w := s.request(http.MethodPost, "/v1/user/verify/resend", "", `{"email":"john@doe.com"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Empty(t, s.mailer.Messages())
+}
+
+func TestUnverifiedLoginLimit(t *testing.T) {
+	s := setupTestServer(t, withUnverifiedLogin(handlers.UnverifiedLoginLimit))
+
+	w := s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var output dto.GetJWTOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+
+	w = s.request(http.MethodGet, "/v1/products", output.AccessToken, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "email_not_verified")
+	w = s.request(http.MethodGet, "/v1/user/me", output.AccessToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	token, _ := entity.NewEmailVerificationToken(s.user, []byte(testVerificationSecret), time.Now().Add(time.Hour))
+	w = s.request(http.MethodGet, "/v1/user/verify?token="+token, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = s.request(http.MethodGet, "/v1/products", output.AccessToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestUnverifiedLoginDeny(t *testing.T) {
+	s := setupTestServer(t, withUnverifiedLogin(handlers.UnverifiedLoginDeny))
+
+	w := s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	var output dto.ErrorOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+	assert.Equal(t, "email_not_verified", output.Code)
+	// Only told to clients that know the password
+	w = s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"wrong"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	s.user.VerifyEmail(time.Now())
+	assert.Nil(t, s.users.Update(context.Background(), s.user, "EmailVerifiedAt"))
+	w = s.request(http.MethodPost, "/v1/user/generate_token", "", `{"email":"john@doe.com","password":"abc123"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
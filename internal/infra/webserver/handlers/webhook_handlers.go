@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"goexpert-api/internal/dto"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	entityPkg "goexpert-api/pkg/entity"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth"
+)
+
+// WebhookHandler manages the webhooks of the authenticated user. Webhooks of
+// other users are reported as not found, and only admins subscribe to the
+// entity.AdminEventTypes.
+type WebhookHandler struct {
+	WebhookService database.WebhookInterface
+	UserService    database.UserInterface
+	Logger         *slog.Logger
+}
+
+func NewWebhookHandler(service database.WebhookInterface, users database.UserInterface, logger *slog.Logger) *WebhookHandler {
+	return &WebhookHandler{
+		WebhookService: service,
+		UserService:    users,
+		Logger:         logger,
+	}
+}
+
+// Create webhook godoc
+// @Summary      Create a webhook
+// @Description  Subscribe an URL to product and user events. Only admins subscribe to user events. The secret used to sign the payloads is only returned here.
+// @Tags         webhooks
+// @Accept       json
+// @Produce      json
+// @Param        request  body      dto.CreateWebhookInput true "webhook data"
+// @Success      201      {object}  dto.CreateWebhookOutput
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401
+// @Failure      403      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /webhooks [post]
+// @Security     ApiKeyAuth
+func (h *WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var input dto.CreateWebhookInput
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: "invalid format"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	webhook, err := entity.NewWebhook(subject(r), input.URL, input.EventTypes, input.Secret)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: err.Error()}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	if !h.allowEventTypes(w, r, webhook) {
+		return
+	}
+	err = h.WebhookService.Create(r.Context(), webhook)
+	if err != nil {
+		h.Logger.ErrorContext(r.Context(), "error creating webhook", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		error := dto.ErrorOutput{Message: "error creating webhook"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dto.CreateWebhookOutput{ID: webhook.ID.String(), Secret: webhook.Secret})
+}
+
+// Get webhooks godoc
+// @Summary      Get all webhooks
+// @Description  Get all webhooks of the authenticated user
+// @Tags         webhooks
+// @Produce      json
+// @Success      200      {array}   entity.Webhook
+// @Failure      401
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /webhooks [get]
+// @Security     ApiKeyAuth
+func (h *WebhookHandler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := h.WebhookService.FindByOwner(r.Context(), subject(r))
+	if err != nil {
+		h.Logger.ErrorContext(r.Context(), "error listing webhooks", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		error := dto.ErrorOutput{Message: "server error"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(webhooks)
+}
+
+// Get webhook godoc
+// @Summary      Get a webhook
+// @Description  Get a webhook
+// @Tags         webhooks
+// @Produce      json
+// @Param        id       path      string true "webhook id"
+// @Success      200      {object}  entity.Webhook
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401
+// @Failure      404      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /webhooks/{id} [get]
+// @Security     ApiKeyAuth
+func (h *WebhookHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := h.findWebhook(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(webhook)
+}
+
+// Update webhook godoc
+// @Summary      Update a webhook
+// @Description  Update a webhook. Setting active to true enables again a webhook disabled after repeated failures.
+// @Tags         webhooks
+// @Accept       json
+// @Produce      json
+// @Param        id       path      string true "webhook id"
+// @Param        request  body      dto.UpdateWebhookInput true "webhook data"
+// @Success      200      {object}  entity.Webhook
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401
+// @Failure      403      {object}  dto.ErrorOutput
+// @Failure      404      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /webhooks/{id} [put]
+// @Security     ApiKeyAuth
+func (h *WebhookHandler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := h.findWebhook(w, r)
+	if !ok {
+		return
+	}
+	var input dto.UpdateWebhookInput
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: "invalid format"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+
+	webhook.URL = input.URL
+	webhook.EventTypes = input.EventTypes
+	switch {
+	case input.Active && !webhook.Active:
+		webhook.Enable()
+	case !input.Active && webhook.Active:
+		now := time.Now()
+		webhook.Active = false
+		webhook.DisabledAt = &now
+	}
+	if err := webhook.Validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: err.Error()}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	if !h.allowEventTypes(w, r, webhook) {
+		return
+	}
+
+	err = h.WebhookService.Update(r.Context(), webhook)
+	if err != nil {
+		h.Logger.ErrorContext(r.Context(), "error updating webhook", "id", webhook.ID.String(), "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		error := dto.ErrorOutput{Message: "server error"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(webhook)
+}
+
+// Delete webhook godoc
+// @Summary      Delete a webhook
+// @Description  Delete a webhook and its deliveries
+// @Tags         webhooks
+// @Produce      json
+// @Param        id       path      string true "webhook id"
+// @Success      200
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401
+// @Failure      404      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /webhooks/{id} [delete]
+// @Security     ApiKeyAuth
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := h.findWebhook(w, r)
+	if !ok {
+		return
+	}
+	err := h.WebhookService.Delete(r.Context(), webhook.ID.String())
+	if err != nil {
+		h.Logger.ErrorContext(r.Context(), "error deleting webhook", "id", webhook.ID.String(), "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		error := dto.ErrorOutput{Message: "server error"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Get webhook deliveries godoc
+// @Summary      Get the deliveries of a webhook
+// @Description  Get the deliveries of a webhook, newest first
+// @Tags         webhooks
+// @Produce      json
+// @Param        id       path      string true "webhook id"
+// @Param        page     query     string false "page number"
+// @Param        limit    query     string false "limit"
+// @Success      200      {array}   entity.WebhookDelivery
+// @Failure      400      {object}  dto.ErrorOutput
+// @Failure      401
+// @Failure      404      {object}  dto.ErrorOutput
+// @Failure      500      {object}  dto.ErrorOutput
+// @Router       /webhooks/{id}/deliveries [get]
+// @Security     ApiKeyAuth
+func (h *WebhookHandler) GetDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := h.findWebhook(w, r)
+	if !ok {
+		return
+	}
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 0
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil {
+		limit = 0
+	}
+	deliveries, err := h.WebhookService.FindDeliveries(r.Context(), webhook.ID.String(), page, limit)
+	if err != nil {
+		h.Logger.ErrorContext(r.Context(), "error listing webhook deliveries", "id", webhook.ID.String(), "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		error := dto.ErrorOutput{Message: "server error"}
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// findWebhook loads the webhook of the id route parameter, writing the error
+// response when it is invalid or not owned by the user.
+func (h *WebhookHandler) findWebhook(w http.ResponseWriter, r *http.Request) (*entity.Webhook, bool) {
+	id := chi.URLParam(r, "id")
+	if _, err := entityPkg.ParseID(id); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		error := dto.ErrorOutput{Message: "invalid format"}
+		json.NewEncoder(w).Encode(error)
+		return nil, false
+	}
+	webhook, err := h.WebhookService.FindByID(r.Context(), id)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		h.Logger.ErrorContext(r.Context(), "error finding webhook", "id", id, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		error := dto.ErrorOutput{Message: "server error"}
+		json.NewEncoder(w).Encode(error)
+		return nil, false
+	}
+	if err != nil || webhook.OwnerID != subject(r) {
+		w.WriteHeader(http.StatusNotFound)
+		error := dto.ErrorOutput{Message: "webhook not found"}
+		json.NewEncoder(w).Encode(error)
+		return nil, false
+	}
+	return webhook, true
+}
+
+// allowEventTypes tells if the authenticated user can subscribe to the event
+// types of webhook, writing the error response when not.
+func (h *WebhookHandler) allowEventTypes(w http.ResponseWriter, r *http.Request, webhook *entity.Webhook) bool {
+	if !webhook.AdminOnly() {
+		return true
+	}
+	user, err := h.UserService.FindByID(r.Context(), subject(r))
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		h.Logger.ErrorContext(r.Context(), "error finding user", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		error := dto.ErrorOutput{Message: "server error"}
+		json.NewEncoder(w).Encode(error)
+		return false
+	}
+	if err != nil || user.Role != entity.RoleAdmin {
+		w.WriteHeader(http.StatusForbidden)
+		error := dto.ErrorOutput{Message: "only admins subscribe to user events"}
+		json.NewEncoder(w).Encode(error)
+		return false
+	}
+	return true
+}
+
+// subject returns the "sub" claim of the verified JWT of the request.
+func subject(r *http.Request) string {
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	sub, _ := claims["sub"].(string)
+	return sub
+}
@@ -0,0 +1,212 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"goexpert-api/internal/dto"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const webhookBody = `{"url":"https://example.com/hooks","event_types":["product.created"]}`
+
+func setupWebhookServer(t *testing.T) *testServer {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	db.AutoMigrate(&entity.Webhook{}, &entity.WebhookDelivery{})
+	return setupTestServer(t, withWebhooks(database.NewWebhookService(db, slog.Default())))
+}
+
+func (s *testServer) createWebhook(t *testing.T) dto.CreateWebhookOutput {
+	w := s.request(http.MethodPost, "/v1/webhooks", s.validToken(t), webhookBody)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var output dto.CreateWebhookOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&output))
+	return output
+}
+
+func TestWebhooksWhenTokenIsInvalid(t *testing.T) {
+	s := setupWebhookServer(t)
+	w := s.request(http.MethodGet, "/v1/webhooks", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = s.request(http.MethodPost, "/v1/webhooks", s.token(t, -time.Minute), webhookBody)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestWebhooksAreNotServedWithoutRepository(t *testing.T) {
+	s := setupTestServer(t)
+	w := s.request(http.MethodGet, "/v1/webhooks", s.validToken(t), "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestCreateWebhook(t *testing.T) {
+	s := setupWebhookServer(t)
+	output := s.createWebhook(t)
+	assert.NotEmpty(t, output.ID)
+	assert.Regexp(t, "^whsec_", output.Secret)
+
+	webhook, err := s.webhooks.FindByID(context.Background(), output.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, s.user.ID.String(), webhook.OwnerID)
+	assert.Equal(t, output.Secret, webhook.Secret)
+	assert.True(t, webhook.Active)
+
+	// The secret can be chosen
+	w := s.request(http.MethodPost, "/v1/webhooks", s.validToken(t), `{"url":"https://example.com","event_types":["product.deleted"],"secret":"my-secret"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"secret":"my-secret"`)
+}
+
+func TestCreateWebhookWhenInvalid(t *testing.T) {
+	s := setupWebhookServer(t)
+	tests := map[string]string{
+		"invalid format":           `{"url":`,
+		"invalid url":              `{"url":"ftp://example.com","event_types":["product.created"]}`,
+		"url host is not allowed":  `{"url":"http://169.254.169.254/latest","event_types":["product.created"]}`,
+		"event types are required": `{"url":"https://example.com","event_types":[]}`,
+		"invalid event type":       `{"url":"https://example.com","event_types":["product.sold"]}`,
+	}
+	for message, body := range tests {
+		w := s.request(http.MethodPost, "/v1/webhooks", s.validToken(t), body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		assert.Contains(t, w.Body.String(), message)
+	}
+}
+
+func TestUserEventWebhooksRequireAdmin(t *testing.T) {
+	s := setupWebhookServer(t)
+	body := `{"url":"https://example.com","event_types":["product.created","user.registered"]}`
+	w := s.request(http.MethodPost, "/v1/webhooks", s.validToken(t), body)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "only admins subscribe to user events")
+
+	output := s.createWebhook(t)
+	w = s.request(http.MethodPut, "/v1/webhooks/"+output.ID, s.validToken(t), body)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	webhook, _ := s.webhooks.FindByID(context.Background(), output.ID)
+	assert.Equal(t, []string{entity.EventProductCreated}, webhook.EventTypes)
+
+	for _, eventType := range []string{entity.EventUserUpdated, entity.EventUserDeleted} {
+		w = s.request(http.MethodPost, "/v1/webhooks", s.validToken(t), `{"url":"https://example.com","event_types":["`+eventType+`"]}`)
+		assert.Equal(t, http.StatusForbidden, w.Code, eventType)
+	}
+
+	admin := s.createUser(t, "Admin", "admin@doe.com", entity.RoleAdmin)
+	w = s.request(http.MethodPost, "/v1/webhooks", s.tokenFor(t, admin, time.Minute), body)
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestGetWebhooks(t *testing.T) {
+	s := setupWebhookServer(t)
+	output := s.createWebhook(t)
+	other, _ := entity.NewWebhook("other", "https://example.com", []string{entity.EventProductCreated}, "")
+	assert.Nil(t, s.webhooks.Create(context.Background(), other))
+
+	w := s.request(http.MethodGet, "/v1/webhooks", s.validToken(t), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var webhooks []entity.Webhook
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&webhooks))
+	assert.Len(t, webhooks, 1)
+	assert.Equal(t, output.ID, webhooks[0].ID.String())
+	assert.NotContains(t, w.Body.String(), output.Secret)
+}
+
+func TestGetWebhook(t *testing.T) {
+	s := setupWebhookServer(t)
+	output := s.createWebhook(t)
+
+	w := s.request(http.MethodGet, "/v1/webhooks/"+output.ID, s.validToken(t), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"url":"https://example.com/hooks"`)
+	assert.NotContains(t, w.Body.String(), output.Secret)
+
+	w = s.request(http.MethodGet, "/v1/webhooks/abc", s.validToken(t), "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetWebhookOfAnotherUser(t *testing.T) {
+	s := setupWebhookServer(t)
+	other, _ := entity.NewWebhook("other", "https://example.com", []string{entity.EventProductCreated}, "")
+	assert.Nil(t, s.webhooks.Create(context.Background(), other))
+
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		w := s.request(method, "/v1/webhooks/"+other.ID.String(), s.validToken(t), webhookBody)
+		assert.Equal(t, http.StatusNotFound, w.Code, method)
+	}
+	w := s.request(http.MethodGet, "/v1/webhooks/"+other.ID.String()+"/deliveries", s.validToken(t), "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestUpdateWebhook(t *testing.T) {
+	s := setupWebhookServer(t)
+	output := s.createWebhook(t)
+	path := "/v1/webhooks/" + output.ID
+
+	w := s.request(http.MethodPut, path, s.validToken(t), `{"url":"https://example.com/new","event_types":["product.deleted"],"active":false}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	webhook, _ := s.webhooks.FindByID(context.Background(), output.ID)
+	assert.Equal(t, "https://example.com/new", webhook.URL)
+	assert.Equal(t, []string{entity.EventProductDeleted}, webhook.EventTypes)
+	assert.False(t, webhook.Active)
+	assert.NotNil(t, webhook.DisabledAt)
+
+	// Enabled again, forgetting the failures
+	_, err := s.webhooks.RecordFailure(context.Background(), output.ID, 20, time.Now())
+	assert.Nil(t, err)
+	w = s.request(http.MethodPut, path, s.validToken(t), `{"url":"https://example.com/new","event_types":["product.deleted"],"active":true}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	webhook, _ = s.webhooks.FindByID(context.Background(), output.ID)
+	assert.True(t, webhook.Active)
+	assert.Equal(t, 0, webhook.Failures)
+	assert.Nil(t, webhook.DisabledAt)
+
+	w = s.request(http.MethodPut, path, s.validToken(t), `{"url":"example","event_types":["product.deleted"],"active":true}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = s.request(http.MethodPut, path, s.validToken(t), `{"url":`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestDeleteWebhook(t *testing.T) {
+	s := setupWebhookServer(t)
+	output := s.createWebhook(t)
+
+	w := s.request(http.MethodDelete, "/v1/webhooks/"+output.ID, s.validToken(t), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, err := s.webhooks.FindByID(context.Background(), output.ID)
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	w = s.request(http.MethodDelete, "/v1/webhooks/"+output.ID, s.validToken(t), "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGetWebhookDeliveries(t *testing.T) {
+	s := setupWebhookServer(t)
+	output := s.createWebhook(t)
+	webhook, _ := s.webhooks.FindByID(context.Background(), output.ID)
+	event, _ := entity.NewEvent(entity.EventProductCreated, "1", nil)
+	delivery, _ := entity.NewWebhookDelivery(webhook, *event)
+	assert.Nil(t, s.webhooks.AddDeliveries(context.Background(), delivery))
+
+	w := s.request(http.MethodGet, "/v1/webhooks/"+output.ID+"/deliveries?page=1&limit=10", s.validToken(t), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var deliveries []entity.WebhookDelivery
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&deliveries))
+	assert.Len(t, deliveries, 1)
+	assert.Equal(t, delivery.ID, deliveries[0].ID)
+	assert.Equal(t, entity.DeliveryPending, deliveries[0].Status)
+}
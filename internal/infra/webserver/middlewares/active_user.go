@@ -0,0 +1,120 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/go-chi/jwtauth"
+)
+
+type userContextKey struct{}
+
+// ActiveUser refuses the tokens of users that no longer exist or are disabled,
+// and the tokens revoked by a new TokenVersion of their user, except the ones
+// of API keys. The user is stored in the context for UserFromContext. It must
+// run after jwtauth.Authenticator.
+func ActiveUser(users database.UserInterface, logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, claims, _ := jwtauth.FromContext(r.Context())
+			sub, _ := claims["sub"].(string)
+			user, err := users.FindByID(r.Context(), sub)
+			if errors.Is(err, database.ErrNotFound) {
+				writeError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+			if err != nil {
+				logger.ErrorContext(r.Context(), "error finding the user of the token", "error", err)
+				writeError(w, http.StatusInternalServerError, "server error")
+				return
+			}
+			// API keys are revoked on their own, not with the tokens of the user
+			if _, apiKey := claims[entity.APIKeyClaim]; !apiKey && tokenVersion(claims) != user.TokenVersion {
+				writeError(w, http.StatusUnauthorized, "token revoked")
+				return
+			}
+			if user.Disabled() {
+				writeErrorCode(w, http.StatusUnauthorized, "user disabled", "user_disabled")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+		})
+	}
+}
+
+// UserFromContext returns the user stored by ActiveUser, nil if there is none.
+func UserFromContext(ctx context.Context) *entity.User {
+	user, _ := ctx.Value(userContextKey{}).(*entity.User)
+	return user
+}
+
+// PasswordChanged refuses the requests of users who must change their
+// password, after it was reset by an admin. It must run after ActiveUser.
+func PasswordChanged(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := UserFromContext(r.Context()); user == nil || user.PasswordResetRequired {
+			writeErrorCode(w, http.StatusForbidden, "password change required", "password_change_required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// EmailVerified refuses the requests of users who didn't verify their email.
+// It must run after ActiveUser.
+func EmailVerified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := UserFromContext(r.Context()); user == nil || !user.EmailVerified() {
+			writeErrorCode(w, http.StatusForbidden, "email not verified", "email_not_verified")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MFAEnrolled refuses the requests of users of roles who must enable
+// two-factor authentication and didn't. It must run after ActiveUser.
+func MFAEnrolled(roles []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := UserFromContext(r.Context())
+			if user == nil || (slices.Contains(roles, user.Role) && !user.MFAEnabled()) {
+				writeErrorCode(w, http.StatusForbidden, "two-factor authentication required", "mfa_enrollment_required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireRole refuses the requests of users without role. It must run after
+// ActiveUser.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user := UserFromContext(r.Context()); user == nil || user.Role != role {
+				writeError(w, http.StatusForbidden, "forbidden")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// tokenVersion returns the entity.TokenVersionClaim of the token, decoded from
+// JSON as a float64.
+func tokenVersion(claims map[string]interface{}) int {
+	switch version := claims[entity.TokenVersionClaim].(type) {
+	case float64:
+		return int(version)
+	case int:
+		return version
+	default:
+		return 0
+	}
+}
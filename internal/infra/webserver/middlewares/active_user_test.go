@@ -0,0 +1,155 @@
+package middlewares
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database/memory"
+	"goexpert-api/pkg/totp"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/jwtauth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActiveUser(t *testing.T) {
+	tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
+	users := memory.NewUserService()
+	user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+	assert.Nil(t, users.Create(context.Background(), user))
+
+	handler := jwtauth.Verifier(tokenAuth)(jwtauth.Authenticator(ActiveUser(users, slog.Default())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	)))
+	request := func(claims map[string]interface{}) int {
+		_, token, _ := tokenAuth.Encode(claims)
+		r := httptest.NewRequest(http.MethodGet, "/products", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, request(map[string]interface{}{"sub": user.ID.String()}))
+	assert.Equal(t, http.StatusOK, request(map[string]interface{}{"sub": user.ID.String(), "ver": 0}))
+	assert.Equal(t, http.StatusUnauthorized, request(map[string]interface{}{"sub": "unknown"}))
+
+	// Changing the password revokes the tokens issued before
+	assert.Nil(t, user.ChangePassword("def456"))
+	assert.Nil(t, users.Update(context.Background(), user, "Password", "PasswordResetRequired", "TokenVersion"))
+	assert.Equal(t, http.StatusUnauthorized, request(map[string]interface{}{"sub": user.ID.String()}))
+	assert.Equal(t, http.StatusOK, request(map[string]interface{}{"sub": user.ID.String(), "ver": 1}))
+
+	assert.Nil(t, users.Delete(context.Background(), user.ID.String()))
+	assert.Equal(t, http.StatusUnauthorized, request(map[string]interface{}{"sub": user.ID.String(), "ver": 1}))
+}
+
+func TestActiveUserWhenDisabled(t *testing.T) {
+	tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
+	users := memory.NewUserService()
+	user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+	user.Disable(time.Now())
+	assert.Nil(t, users.Create(context.Background(), user))
+
+	handler := jwtauth.Verifier(tokenAuth)(jwtauth.Authenticator(ActiveUser(users, slog.Default())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	)))
+	_, token, _ := tokenAuth.Encode(map[string]interface{}{"sub": user.ID.String()})
+	r := httptest.NewRequest(http.MethodGet, "/products", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "user_disabled")
+}
+
+func TestPasswordChangedAndRequireRole(t *testing.T) {
+	user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	request := func(middleware func(http.Handler) http.Handler) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/admin/users", nil)
+		if user != nil {
+			r = r.WithContext(context.WithValue(r.Context(), userContextKey{}, user))
+		}
+		w := httptest.NewRecorder()
+		middleware(ok).ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, request(PasswordChanged).Code)
+	assert.Equal(t, http.StatusForbidden, request(RequireRole(entity.RoleAdmin)).Code)
+
+	assert.Nil(t, user.SetRole(entity.RoleAdmin))
+	assert.Equal(t, http.StatusOK, request(RequireRole(entity.RoleAdmin)).Code)
+
+	user.RequirePasswordReset()
+	w := request(PasswordChanged)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "password_change_required")
+
+	// Without ActiveUser
+	user = nil
+	assert.Equal(t, http.StatusForbidden, request(PasswordChanged).Code)
+	assert.Equal(t, http.StatusForbidden, request(RequireRole(entity.RoleAdmin)).Code)
+}
+
+func TestEmailVerified(t *testing.T) {
+	user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	request := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/products", nil)
+		if user != nil {
+			r = r.WithContext(context.WithValue(r.Context(), userContextKey{}, user))
+		}
+		w := httptest.NewRecorder()
+		EmailVerified(ok).ServeHTTP(w, r)
+		return w
+	}
+
+	w := request()
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "email_not_verified")
+
+	user.VerifyEmail(time.Now())
+	assert.Equal(t, http.StatusOK, request().Code)
+
+	// Without ActiveUser
+	user = nil
+	assert.Equal(t, http.StatusForbidden, request().Code)
+}
+
+func TestMFAEnrolled(t *testing.T) {
+	user, _ := entity.NewUser("John Doe", "john@doe.com", "abc123")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	request := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/products", nil)
+		if user != nil {
+			r = r.WithContext(context.WithValue(r.Context(), userContextKey{}, user))
+		}
+		w := httptest.NewRecorder()
+		MFAEnrolled([]string{entity.RoleAdmin})(ok).ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, request().Code)
+
+	assert.Nil(t, user.SetRole(entity.RoleAdmin))
+	w := request()
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "mfa_enrollment_required")
+
+	secret, err := user.StartMFA()
+	assert.Nil(t, err)
+	now := time.Now()
+	code, _ := totp.Code(secret, totp.Step(now))
+	_, err = user.EnableMFA(code, now)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, request().Code)
+
+	// Without ActiveUser
+	user = nil
+	assert.Equal(t, http.StatusForbidden, request().Code)
+}
@@ -0,0 +1,86 @@
+package middlewares
+
+import (
+	"errors"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/jwtauth"
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+// APIKeyHeader carries the API keys of machine clients.
+const APIKeyHeader = "X-API-Key"
+
+// lastUsedInterval is how often the last use of an API key is recorded, so
+// busy keys don't write on every request.
+const lastUsedInterval = time.Minute
+
+// APIKey authenticates the requests with an API key in the X-API-Key header,
+// replacing the token of jwtauth.Verifier with one of the user of the key,
+// limited to its scopes and with its ID in entity.APIKeyClaim. Requests
+// without the header are left to the JWT. It must run after jwtauth.Verifier
+// and before jwtauth.Authenticator.
+func APIKey(apiKeys database.APIKeyInterface, logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(APIKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			now := time.Now()
+			prefix, err := entity.ParseAPIKey(key)
+			if err != nil {
+				writeErrorCode(w, http.StatusUnauthorized, "invalid api key", "invalid_api_key")
+				return
+			}
+			apiKey, err := apiKeys.FindByPrefix(r.Context(), prefix)
+			if err != nil && !errors.Is(err, database.ErrNotFound) {
+				logger.ErrorContext(r.Context(), "error finding api key", "error", err)
+				writeError(w, http.StatusInternalServerError, "server error")
+				return
+			}
+			if apiKey == nil || !apiKey.Matches(key) || !apiKey.Valid(now) {
+				logger.WarnContext(r.Context(), "api key refused", "prefix", prefix)
+				writeErrorCode(w, http.StatusUnauthorized, "invalid api key", "invalid_api_key")
+				return
+			}
+
+			if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= lastUsedInterval {
+				if err := apiKeys.UpdateLastUsed(r.Context(), apiKey.ID.String(), now.UTC()); err != nil {
+					logger.ErrorContext(r.Context(), "error recording api key use", "error", err)
+				}
+			}
+			token := jwt.New()
+			token.Set(jwt.SubjectKey, apiKey.UserID)
+			token.Set(entity.ScopeClaim, strings.Join(apiKey.Scopes, " "))
+			token.Set(entity.APIKeyClaim, apiKey.ID.String())
+			next.ServeHTTP(w, r.WithContext(jwtauth.NewContext(r.Context(), token, nil)))
+		})
+	}
+}
+
+// RequireScope refuses the requests of tokens limited to scopes without
+// scope. Tokens without entity.ScopeClaim are not limited. It must run after
+// jwtauth.Authenticator.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, claims, _ := jwtauth.FromContext(r.Context())
+			if scopes, ok := claims[entity.ScopeClaim]; ok {
+				value, _ := scopes.(string)
+				if !slices.Contains(strings.Fields(value), scope) {
+					writeErrorCode(w, http.StatusForbidden, "insufficient scope", "insufficient_scope")
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package middlewares
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database/memory"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/jwtauth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKey(t *testing.T) {
+	tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
+	apiKeys := memory.NewAPIKeyService()
+	now := time.Now()
+	apiKey, key, err := entity.NewAPIKey("user-id", "CI", []string{entity.ScopeProductsRead}, now.Add(time.Hour), now)
+	assert.Nil(t, err)
+	assert.Nil(t, apiKeys.Create(context.Background(), apiKey))
+
+	var claims map[string]interface{}
+	handler := jwtauth.Verifier(tokenAuth)(APIKey(apiKeys, slog.Default())(jwtauth.Authenticator(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, claims, _ = jwtauth.FromContext(r.Context())
+		}),
+	)))
+	request := func(headers ...string) *httptest.ResponseRecorder {
+		claims = nil
+		r := httptest.NewRequest(http.MethodGet, "/products", nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request(APIKeyHeader, key)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "user-id", claims["sub"])
+	assert.Equal(t, entity.ScopeProductsRead, claims[entity.ScopeClaim])
+	assert.Equal(t, apiKey.ID.String(), claims[entity.APIKeyClaim])
+
+	// Requests without the header are left to the JWT
+	_, token, _ := tokenAuth.Encode(map[string]interface{}{"sub": "other-id"})
+	w = request("Authorization", "Bearer "+token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "other-id", claims["sub"])
+	assert.NotContains(t, claims, entity.ScopeClaim)
+	assert.Equal(t, http.StatusUnauthorized, request().Code)
+
+	w = request(APIKeyHeader, key+"0", "Authorization", "Bearer "+token)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_api_key")
+}
+
+func TestRequireScope(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	request := func(claims map[string]interface{}) *httptest.ResponseRecorder {
+		tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
+		token, _, _ := tokenAuth.Encode(claims)
+		r := httptest.NewRequest(http.MethodPost, "/products", nil)
+		r = r.WithContext(jwtauth.NewContext(r.Context(), token, nil))
+		w := httptest.NewRecorder()
+		RequireScope(entity.ScopeProductsWrite)(ok).ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, request(map[string]interface{}{"sub": "user-id"}).Code)
+	assert.Equal(t, http.StatusOK, request(map[string]interface{}{entity.ScopeClaim: "products:read products:write"}).Code)
+	w := request(map[string]interface{}{entity.ScopeClaim: "products:read"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "insufficient_scope")
+	assert.Equal(t, http.StatusForbidden, request(map[string]interface{}{entity.ScopeClaim: ""}).Code)
+}
@@ -0,0 +1,40 @@
+package middlewares
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/cors"
+)
+
+type CORSConfig struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is how long, in seconds, browsers may cache preflight responses.
+	MaxAge int
+}
+
+// CORS answers preflight requests and adds the CORS headers to the responses
+// of the allowed origins. It must be registered before any authentication
+// middleware, since preflight requests carry no credentials. Without allowed
+// origins CORS is disabled and requests are passed on untouched, as
+// go-chi/cors would allow every origin.
+func CORS(config CORSConfig) func(http.Handler) http.Handler {
+	origins := slices.DeleteFunc(slices.Clone(config.AllowedOrigins), func(origin string) bool {
+		return strings.TrimSpace(origin) == ""
+	})
+	if len(origins) == 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return cors.Handler(cors.Options{
+		AllowedOrigins:   origins,
+		AllowedMethods:   config.AllowedMethods,
+		AllowedHeaders:   config.AllowedHeaders,
+		ExposedHeaders:   []string{RequestIDHeader, "Retry-After"},
+		AllowCredentials: config.AllowCredentials,
+		MaxAge:           config.MaxAge,
+	})
+}
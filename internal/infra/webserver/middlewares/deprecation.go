@@ -0,0 +1,37 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Deprecated marks every response as deprecated (RFC 9745), announcing when
+// the routes stop working (RFC 8594) and linking to the same path under the
+// successor prefix, e.g. /products -> /v1/products. Without deprecatedAt the
+// routes are deprecated since the middleware is created. Once sunsetAt has
+// passed the requests are answered with 410 Gone.
+func Deprecated(deprecatedAt, sunsetAt time.Time, successorPrefix string) func(http.Handler) http.Handler {
+	return deprecated(deprecatedAt, sunsetAt, successorPrefix, time.Now)
+}
+
+func deprecated(deprecatedAt, sunsetAt time.Time, successorPrefix string, now func() time.Time) func(http.Handler) http.Handler {
+	if deprecatedAt.IsZero() {
+		deprecatedAt = now()
+	}
+	deprecation := fmt.Sprintf("@%d", deprecatedAt.Unix())
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", deprecation)
+			if !sunsetAt.IsZero() {
+				w.Header().Set("Sunset", sunsetAt.UTC().Format(http.TimeFormat))
+			}
+			w.Header().Add("Link", fmt.Sprintf(`<%s%s>; rel="successor-version"`, successorPrefix, r.URL.Path))
+			if !sunsetAt.IsZero() && !now().Before(sunsetAt) {
+				writeErrorCode(w, http.StatusGone, fmt.Sprintf("route removed, use %s%s", successorPrefix, r.URL.Path), "route_sunset")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeprecatedHeaders(t *testing.T) {
+	deprecatedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunsetAt := time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC)
+	now := func() time.Time { return time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC) }
+	handler := deprecated(deprecatedAt, sunsetAt, "/v1", now)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products/1", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "@1704067200", w.Header().Get("Deprecation"))
+	assert.Equal(t, "Sun, 30 Jun 2024 00:00:00 GMT", w.Header().Get("Sunset"))
+	assert.Equal(t, `</v1/products/1>; rel="successor-version"`, w.Header().Get("Link"))
+}
+
+func TestDeprecatedWithoutDates(t *testing.T) {
+	startedAt := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	now := startedAt
+	handler := deprecated(time.Time{}, time.Time{}, "/v1", func() time.Time { return now })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	now = now.Add(time.Hour)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "@1709251200", w.Header().Get("Deprecation"), "deprecated since the middleware was created")
+	assert.Empty(t, w.Header().Get("Sunset"))
+}
+
+func TestDeprecatedAfterSunset(t *testing.T) {
+	sunsetAt := time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC)
+	now := sunsetAt.Add(-time.Second)
+	called := 0
+	handler := deprecated(time.Time{}, sunsetAt, "/v1", func() time.Time { return now })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, called)
+
+	// The same process stops serving the routes at the sunset date
+	now = sunsetAt
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products", nil))
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Equal(t, 1, called)
+	assert.Contains(t, w.Body.String(), `"code":"route_sunset"`)
+	assert.Equal(t, `</v1/products>; rel="successor-version"`, w.Header().Get("Link"))
+}
@@ -0,0 +1,22 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"goexpert-api/internal/dto"
+	"net/http"
+)
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	error := dto.ErrorOutput{Message: message}
+	json.NewEncoder(w).Encode(error)
+}
+
+// writeErrorCode writes an error with a code clients can check.
+func writeErrorCode(w http.ResponseWriter, status int, message, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	error := dto.ErrorOutput{Message: message, Code: code}
+	json.NewEncoder(w).Encode(error)
+}
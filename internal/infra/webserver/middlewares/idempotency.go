@@ -0,0 +1,122 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"goexpert-api/internal/infra/idempotency"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/jwtauth"
+)
+
+const (
+	IdempotencyKeyHeader    = "Idempotency-Key"
+	maxIdempotencyKeyLength = 255
+	// The body is read in memory to be hashed, far above the size of a product
+	maxIdempotentBodySize = 64 << 10
+)
+
+// Response headers replayed along with the stored body. Others, like the
+// request ID, belong to the request that produced the response.
+var replayedHeaders = []string{"Content-Type", "Location"}
+
+// Idempotency replays the stored response of requests repeated with the same
+// Idempotency-Key header. Keys are scoped to the authenticated user, so it
+// must run after jwtauth.Verifier. Requests without the header, and requests
+// that fail with a server error, are not stored.
+func Idempotency(store idempotency.Store, ttl time.Duration, logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				writeError(w, http.StatusBadRequest, "invalid idempotency key")
+				return
+			}
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodySize))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid format")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			_, claims, _ := jwtauth.FromContext(r.Context())
+			sub, _ := claims["sub"].(string)
+			storeKey := sub + ":" + key
+			hash := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+
+			stored, err := store.Reserve(r.Context(), storeKey, hex.EncodeToString(hash[:]), ttl)
+			switch {
+			case errors.Is(err, idempotency.ErrMismatch):
+				writeError(w, http.StatusUnprocessableEntity, "idempotency key already used with a different request")
+				return
+			case errors.Is(err, idempotency.ErrInProgress):
+				writeError(w, http.StatusConflict, "request with the same idempotency key in progress")
+				return
+			case err != nil:
+				logger.ErrorContext(r.Context(), "error reserving idempotency key", "error", err)
+				writeError(w, http.StatusInternalServerError, "server error")
+				return
+			case stored != nil:
+				for name, values := range stored.Header {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.StatusCode)
+				w.Write(stored.Body)
+				return
+			}
+
+			var buf bytes.Buffer
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			ww.Tee(&buf)
+			completed := false
+			defer func() {
+				// Release the key if the handler panicked
+				if !completed {
+					store.Release(r.Context(), storeKey)
+				}
+			}()
+
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if status >= http.StatusInternalServerError {
+				err = store.Release(r.Context(), storeKey)
+			} else {
+				header := http.Header{}
+				for _, name := range replayedHeaders {
+					if values := w.Header().Values(name); len(values) > 0 {
+						header[name] = values
+					}
+				}
+				err = store.Complete(r.Context(), storeKey, idempotency.Response{
+					StatusCode: status,
+					Header:     header,
+					Body:       buf.Bytes(),
+				})
+			}
+			completed = true
+			if err != nil {
+				logger.ErrorContext(r.Context(), "error storing idempotent response", "error", err)
+			}
+		})
+	}
+}
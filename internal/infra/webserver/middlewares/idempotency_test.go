@@ -0,0 +1,108 @@
+package middlewares
+
+import (
+	"goexpert-api/internal/infra/idempotency"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/jwtauth"
+	"github.com/stretchr/testify/assert"
+)
+
+type idempotencyTestCase struct {
+	handler http.Handler
+	calls   int
+	token   string
+}
+
+func setupIdempotency(t *testing.T) *idempotencyTestCase {
+	tc := &idempotencyTestCase{}
+	tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
+	_, tc.token, _ = tokenAuth.Encode(map[string]interface{}{"sub": "user-1"})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tc.calls++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(string(body), "fail") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"call":` + strconv.Itoa(tc.calls) + `}`))
+	})
+	middleware := Idempotency(idempotency.NewMemoryStore(), time.Hour, slog.Default())
+	tc.handler = jwtauth.Verifier(tokenAuth)(middleware(next))
+	return tc
+}
+
+func (tc *idempotencyTestCase) post(key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/products", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+tc.token)
+	if key != "" {
+		r.Header.Set(IdempotencyKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	tc.handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestIdempotencyReplaysResponse(t *testing.T) {
+	tc := setupIdempotency(t)
+
+	first := tc.post("key-1", `{"name":"Product 1"}`)
+	second := tc.post("key-1", `{"name":"Product 1"}`)
+
+	assert.Equal(t, 1, tc.calls)
+	assert.Equal(t, http.StatusCreated, second.Code)
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.Equal(t, "application/json", second.Header().Get("Content-Type"))
+	assert.Equal(t, "true", second.Header().Get("Idempotent-Replayed"))
+}
+
+func TestIdempotencyConflictingPayload(t *testing.T) {
+	tc := setupIdempotency(t)
+
+	tc.post("key-1", `{"name":"Product 1"}`)
+	w := tc.post("key-1", `{"name":"Product 2"}`)
+
+	assert.Equal(t, 1, tc.calls)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestIdempotencyWithoutKey(t *testing.T) {
+	tc := setupIdempotency(t)
+
+	tc.post("", `{"name":"Product 1"}`)
+	tc.post("", `{"name":"Product 1"}`)
+
+	assert.Equal(t, 2, tc.calls)
+}
+
+func TestIdempotencyServerErrorIsNotStored(t *testing.T) {
+	tc := setupIdempotency(t)
+
+	w := tc.post("key-1", `{"name":"fail"}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	tc.post("key-1", `{"name":"fail"}`)
+
+	assert.Equal(t, 2, tc.calls)
+}
+
+func TestIdempotencyBodyTooLarge(t *testing.T) {
+	tc := setupIdempotency(t)
+
+	w := tc.post("key-1", `{"name":"`+strings.Repeat("a", maxIdempotentBodySize)+`"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, 0, tc.calls)
+
+	// The key was not reserved by the rejected request
+	w = tc.post("key-1", `{"name":"Product 1"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, 1, tc.calls)
+}
@@ -0,0 +1,60 @@
+package middlewares
+
+import (
+	"goexpert-api/internal/infra/logging"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/jwtauth"
+)
+
+// Logger writes an access log entry for every request once it is done.
+func Logger(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ctx, entry := logging.WithAccessLog(r.Context())
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", status),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("remote_addr", r.RemoteAddr),
+			}
+			if sub := entry.Subject(); sub != "" {
+				attrs = append(attrs, slog.String("sub", sub))
+			}
+			level := slog.LevelInfo
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			logger.LogAttrs(ctx, level, "request", attrs...)
+		})
+	}
+}
+
+// Subject reads the "sub" claim of a verified JWT and stores it in the request
+// context, so it shows up in the access log and in handler logs. It must run
+// after jwtauth.Verifier.
+func Subject(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, claims, err := jwtauth.FromContext(r.Context())
+		if err == nil {
+			if sub, ok := claims["sub"].(string); ok {
+				r = r.WithContext(logging.WithSubject(r.Context(), sub))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,38 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"goexpert-api/internal/infra/logging"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggerWritesAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, "info", "json")
+	handler := RequestID(Logger(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logging.WithSubject(r.Context(), "user-id")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})))
+
+	r := httptest.NewRequest(http.MethodPost, "/products", nil)
+	r.Header.Set(RequestIDHeader, "my-request-id")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	var entry map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	assert.Nil(t, err)
+	assert.Equal(t, "request", entry["msg"])
+	assert.Equal(t, "POST", entry["method"])
+	assert.Equal(t, "/products", entry["path"])
+	assert.Equal(t, float64(http.StatusCreated), entry["status"])
+	assert.Equal(t, float64(5), entry["bytes"])
+	assert.Equal(t, "user-id", entry["sub"])
+	assert.Equal(t, "my-request-id", entry["request_id"])
+	assert.Contains(t, entry, "duration")
+}
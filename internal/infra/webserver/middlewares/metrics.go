@@ -0,0 +1,38 @@
+package middlewares
+
+import (
+	"goexpert-api/internal/infra/metrics"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Metrics records request count, latency and in-flight requests. Requests are
+// labeled with the chi route pattern (e.g. /products/{id}) instead of the raw
+// path to keep the label cardinality bounded.
+func Metrics(m *metrics.Metrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			m.RequestsInFlight.Inc()
+			defer m.RequestsInFlight.Dec()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r)
+
+			route := "unmatched"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			m.RequestsTotal.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+			m.RequestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+		})
+	}
+}
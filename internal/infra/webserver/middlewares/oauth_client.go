@@ -0,0 +1,52 @@
+package middlewares
+
+import (
+	"errors"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/jwtauth"
+)
+
+// OAuthClient checks the tokens issued to OAuth clients, with
+// entity.ClientIDClaim: the client must still exist and the token must not be
+// revoked. Other tokens go through users, the middlewares checking the user
+// of the token, which clients don't have. It must run after
+// jwtauth.Authenticator.
+func OAuthClient(clients database.OAuthClientInterface, revocations database.RevokedTokenInterface, logger *slog.Logger, users func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		userNext := users(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, claims, _ := jwtauth.FromContext(r.Context())
+			claim, ok := claims[entity.ClientIDClaim]
+			if !ok {
+				userNext.ServeHTTP(w, r)
+				return
+			}
+			clientID, _ := claim.(string)
+			_, err := clients.FindByID(r.Context(), clientID)
+			if errors.Is(err, database.ErrNotFound) {
+				writeError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+			if err != nil {
+				logger.ErrorContext(r.Context(), "error finding the client of the token", "error", err)
+				writeError(w, http.StatusInternalServerError, "server error")
+				return
+			}
+			revoked, err := revocations.IsRevoked(r.Context(), token.JwtID())
+			if err != nil {
+				logger.ErrorContext(r.Context(), "error checking the revocation of the token", "error", err)
+				writeError(w, http.StatusInternalServerError, "server error")
+				return
+			}
+			if revoked {
+				writeError(w, http.StatusUnauthorized, "token revoked")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package middlewares
+
+import (
+	"context"
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database/memory"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/jwtauth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOAuthClient(t *testing.T) {
+	ctx := context.Background()
+	tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
+	clients := memory.NewOAuthClientService()
+	revocations := memory.NewRevokedTokenService()
+	client, _, err := entity.NewOAuthClient("billing", []string{entity.ScopeProductsRead})
+	assert.Nil(t, err)
+	assert.Nil(t, clients.Create(ctx, client))
+
+	// The user checks refuse every request
+	users := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusForbidden, "user checked")
+		})
+	}
+	handler := jwtauth.Verifier(tokenAuth)(jwtauth.Authenticator(OAuthClient(clients, revocations, slog.Default(), users)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	)))
+	request := func(claims map[string]interface{}) int {
+		_, token, _ := tokenAuth.Encode(claims)
+		r := httptest.NewRequest(http.MethodGet, "/products", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	clientToken := func(clientID, jti string) map[string]interface{} {
+		return map[string]interface{}{"sub": clientID, entity.ClientIDClaim: clientID, "jti": jti}
+	}
+
+	assert.Equal(t, http.StatusOK, request(clientToken(client.ID.String(), "jti")))
+	assert.Equal(t, http.StatusForbidden, request(map[string]interface{}{"sub": "user-id"}))
+
+	assert.Nil(t, revocations.Revoke(ctx, "jti", time.Now().Add(time.Hour), time.Now()))
+	assert.Equal(t, http.StatusUnauthorized, request(clientToken(client.ID.String(), "jti")))
+	assert.Equal(t, http.StatusOK, request(clientToken(client.ID.String(), "other")))
+
+	assert.Nil(t, clients.Delete(ctx, client.ID.String()))
+	assert.Equal(t, http.StatusUnauthorized, request(clientToken(client.ID.String(), "other")))
+}
@@ -0,0 +1,50 @@
+package middlewares
+
+import (
+	"errors"
+	"goexpert-api/internal/infra/ratelimit"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimit limits the requests of each client IP address with limiter.
+func RateLimit(limiter *ratelimit.TokenBucket, logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			retryAfter, err := limiter.Allow(r.Context(), "ip:"+ClientIP(r))
+			if errors.Is(err, ratelimit.ErrRateLimited) {
+				TooManyRequests(w, retryAfter)
+				return
+			}
+			if err != nil {
+				logger.ErrorContext(r.Context(), "error checking rate limit", "error", err)
+				writeError(w, http.StatusInternalServerError, "server error")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// TooManyRequests writes a 429 response telling the client when to retry.
+func TooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeError(w, http.StatusTooManyRequests, "too many requests")
+}
+
+// ClientIP returns the IP address of the client without the port.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
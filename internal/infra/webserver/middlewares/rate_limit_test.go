@@ -0,0 +1,32 @@
+package middlewares
+
+import (
+	"goexpert-api/internal/infra/ratelimit"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitPerIP(t *testing.T) {
+	limiter, err := ratelimit.NewTokenBucket(ratelimit.NewMemoryStore(), 0.1, 2)
+	assert.Nil(t, err)
+	handler := RateLimit(limiter, slog.Default())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/user/generate_token", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, request("10.0.0.1:1000").Code)
+	assert.Equal(t, http.StatusOK, request("10.0.0.1:1001").Code)
+	w := request("10.0.0.1:1002")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, request("10.0.0.2:1000").Code)
+}
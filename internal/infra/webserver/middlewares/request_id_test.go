@@ -0,0 +1,38 @@
+package middlewares
+
+import (
+	"goexpert-api/internal/infra/logging"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestIDIsGenerated(t *testing.T) {
+	var idFound string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idFound = logging.RequestID(r.Context())
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.NotEmpty(t, idFound)
+	assert.Equal(t, idFound, w.Header().Get(RequestIDHeader))
+}
+
+func TestRequestIDIsPropagated(t *testing.T) {
+	var idFound string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idFound = logging.RequestID(r.Context())
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(RequestIDHeader, "my-request-id")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, "my-request-id", idFound)
+	assert.Equal(t, "my-request-id", w.Header().Get(RequestIDHeader))
+}
@@ -0,0 +1,233 @@
+package webserver
+
+import (
+	"goexpert-api/internal/entity"
+	"goexpert-api/internal/infra/database"
+	"goexpert-api/internal/infra/idempotency"
+	"goexpert-api/internal/infra/metrics"
+	"goexpert-api/internal/infra/ratelimit"
+	"goexpert-api/internal/infra/webserver/handlers"
+	"goexpert-api/internal/infra/webserver/middlewares"
+	"goexpert-api/pkg/jwtkeys"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/jwtauth"
+	httpSwagger "github.com/swaggo/http-swagger"
+)
+
+// RouterConfig has everything the routes of the API depend on.
+type RouterConfig struct {
+	ProductHandler *handlers.ProductHandler
+	UserHandler    *handlers.UserHandler
+	AdminHandler   *handlers.AdminHandler
+	MFAHandler     *handlers.MFAHandler
+	// API key routes, and the X-API-Key header, are only served when set
+	APIKeyHandler *handlers.APIKeyHandler
+	// OAuth routes, and the tokens of OAuth clients, are only served when set
+	OAuthHandler *handlers.OAuthHandler
+	JWKSHandler  *handlers.JWKSHandler
+	// Users of the tokens, checked by the authenticated routes
+	UserService database.UserInterface
+	// Webhook routes are only served when set
+	WebhookHandler *handlers.WebhookHandler
+	// Password reset routes are only served when set
+	PasswordResetHandler *handlers.PasswordResetHandler
+	// Email verification routes are only served when set
+	EmailVerificationHandler *handlers.EmailVerificationHandler
+	// Users who didn't verify the email only use the /user/me routes
+	RequireVerifiedEmail bool
+	// Users of these roles must enable two-factor authentication
+	MFARequiredRoles []string
+	TokenAuth        *jwtkeys.KeySet
+	Logger           *slog.Logger
+	Metrics          *metrics.Metrics
+	CORS             middlewares.CORSConfig
+	// Login requests allowed per client IP
+	LoginIPLimiter *ratelimit.TokenBucket
+	// Responses stored for the Idempotency-Key header
+	IdempotencyStore  idempotency.Store
+	IdempotencyKeyTTL time.Duration
+	// Unversioned /products and /user routes are served as v1 until
+	// LegacySunsetAt, if set
+	LegacyDeprecatedAt time.Time
+	LegacySunsetAt     time.Time
+	// URL of the swagger document served at /docs
+	DocsURL string
+}
+
+func NewRouter(cfg RouterConfig) http.Handler {
+	// Using Chi as router
+	r := chi.NewRouter()
+
+	// General middlewares
+	r.Use(middlewares.Tracing)
+	r.Use(middlewares.RequestID)
+	r.Use(middlewares.Logger(cfg.Logger))
+	r.Use(middlewares.Metrics(cfg.Metrics))
+	r.Use(middlewares.CORS(cfg.CORS))
+	r.Use(middleware.Compress(5, "application/json", "application/xml", "text/csv", "application/x-ndjson"))
+
+	// Middlewares of the routes that require a token, or an API key if
+	// acceptAPIKey
+	verifyCredentials := func(r chi.Router, acceptAPIKey bool) {
+		r.Use(cfg.TokenAuth.Verifier)
+		if acceptAPIKey && cfg.APIKeyHandler != nil {
+			r.Use(middlewares.APIKey(cfg.APIKeyHandler.APIKeyService, cfg.Logger))
+		}
+		r.Use(middlewares.Subject)
+		r.Use(jwtauth.Authenticator)
+	}
+	activeUser := middlewares.ActiveUser(cfg.UserService, cfg.Logger)
+	verifyToken := func(r chi.Router) {
+		verifyCredentials(r, false)
+		r.Use(activeUser)
+	}
+	// ... and a password that was not reset by an admin, and a verified email
+	// and two-factor authentication if required
+	restrictions := chi.Middlewares{middlewares.PasswordChanged}
+	if cfg.RequireVerifiedEmail {
+		restrictions = append(restrictions, middlewares.EmailVerified)
+	}
+	if len(cfg.MFARequiredRoles) > 0 {
+		restrictions = append(restrictions, middlewares.MFAEnrolled(cfg.MFARequiredRoles))
+	}
+	restricted := func(r chi.Router) {
+		r.Use(restrictions...)
+	}
+	authenticated := func(r chi.Router) {
+		verifyToken(r)
+		restricted(r)
+	}
+
+	// Product routes, which accept API keys and OAuth client tokens too
+	productRoutes := func(r chi.Router) {
+		// Group middlewares
+		verifyCredentials(r, true)
+		userChecks := append(chi.Middlewares{activeUser}, restrictions...)
+		if cfg.OAuthHandler != nil {
+			// Tokens of OAuth clients have no user to check
+			r.Use(middlewares.OAuthClient(cfg.OAuthHandler.ClientService, cfg.OAuthHandler.Revocations, cfg.Logger, userChecks.Handler))
+		} else {
+			r.Use(userChecks...)
+		}
+		// Routes
+		read := r.With(middlewares.RequireScope(entity.ScopeProductsRead))
+		write := r.With(middlewares.RequireScope(entity.ScopeProductsWrite))
+		read.Get("/", cfg.ProductHandler.GetProducts)
+		write.With(middlewares.Idempotency(cfg.IdempotencyStore, cfg.IdempotencyKeyTTL, cfg.Logger)).Post("/", cfg.ProductHandler.CreateProduct)
+		read.Get("/{id}", cfg.ProductHandler.GetProduct)
+		write.Put("/{id}", cfg.ProductHandler.UpdateProduct)
+		write.Delete("/{id}", cfg.ProductHandler.DeleteProduct)
+	}
+	// User routes served before the versioning
+	registrationRoutes := func(r chi.Router) {
+		r.Post("/", cfg.UserHandler.CreateUser)
+		r.With(middlewares.RateLimit(cfg.LoginIPLimiter, cfg.Logger)).Post("/generate_token", cfg.UserHandler.GetJWT)
+	}
+
+	// API v1. A new major version goes side by side in its own route group
+	// (e.g. r.Route("/v2", ...)) with its own handlers and DTOs.
+	apiV1 := func(r chi.Router) {
+		r.Route("/products", productRoutes)
+
+		if cfg.WebhookHandler != nil {
+			r.Route("/webhooks", func(r chi.Router) {
+				authenticated(r)
+				r.Get("/", cfg.WebhookHandler.GetWebhooks)
+				r.Post("/", cfg.WebhookHandler.CreateWebhook)
+				r.Get("/{id}", cfg.WebhookHandler.GetWebhook)
+				r.Put("/{id}", cfg.WebhookHandler.UpdateWebhook)
+				r.Delete("/{id}", cfg.WebhookHandler.DeleteWebhook)
+				r.Get("/{id}/deliveries", cfg.WebhookHandler.GetDeliveries)
+			})
+		}
+
+		r.Route("/admin/users", func(r chi.Router) {
+			authenticated(r)
+			r.Use(middlewares.RequireRole(entity.RoleAdmin))
+			r.Get("/", cfg.AdminHandler.GetUsers)
+			r.Get("/{id}", cfg.AdminHandler.GetUser)
+			r.Post("/{id}/disable", cfg.AdminHandler.DisableUser)
+			r.Post("/{id}/enable", cfg.AdminHandler.EnableUser)
+			r.Put("/{id}/role", cfg.AdminHandler.UpdateUserRole)
+			r.Post("/{id}/reset_password", cfg.AdminHandler.ResetUserPassword)
+			r.Delete("/{id}/mfa", cfg.AdminHandler.DisableUserMFA)
+		})
+
+		if cfg.OAuthHandler != nil {
+			r.Route("/admin/oauth_clients", func(r chi.Router) {
+				authenticated(r)
+				r.Use(middlewares.RequireRole(entity.RoleAdmin))
+				r.Get("/", cfg.OAuthHandler.GetClients)
+				r.Post("/", cfg.OAuthHandler.CreateClient)
+				r.Delete("/{id}", cfg.OAuthHandler.DeleteClient)
+			})
+		}
+
+		r.Route("/user", func(r chi.Router) {
+			// Routes
+			registrationRoutes(r)
+			r.With(middlewares.RateLimit(cfg.LoginIPLimiter, cfg.Logger)).Post("/generate_token/mfa", cfg.MFAHandler.VerifyMFA)
+			if cfg.EmailVerificationHandler != nil {
+				r.Get("/verify", cfg.EmailVerificationHandler.VerifyEmail)
+				r.With(middlewares.RateLimit(cfg.LoginIPLimiter, cfg.Logger)).Post("/verify/resend", cfg.EmailVerificationHandler.ResendVerification)
+			}
+			if cfg.PasswordResetHandler != nil {
+				r.Route("/password", func(r chi.Router) {
+					r.Use(middlewares.RateLimit(cfg.LoginIPLimiter, cfg.Logger))
+					r.Post("/forgot", cfg.PasswordResetHandler.ForgotPassword)
+					r.Post("/reset", cfg.PasswordResetHandler.ResetPassword)
+				})
+			}
+			// Users whose password was reset use these routes to change it, the
+			// ones who didn't verify the email to change it, and the ones who must
+			// enable two-factor authentication to enable it
+			r.Route("/me", func(r chi.Router) {
+				verifyToken(r)
+				r.Get("/", cfg.UserHandler.GetMe)
+				r.Put("/", cfg.UserHandler.UpdateMe)
+				r.Delete("/", cfg.UserHandler.DeleteMe)
+				r.Post("/password", cfg.UserHandler.ChangePassword)
+				r.Post("/mfa", cfg.MFAHandler.StartMFA)
+				r.Post("/mfa/confirm", cfg.MFAHandler.ConfirmMFA)
+				r.Delete("/mfa", cfg.MFAHandler.DisableMFA)
+				if cfg.APIKeyHandler != nil {
+					r.Route("/api_keys", func(r chi.Router) {
+						restricted(r)
+						r.Get("/", cfg.APIKeyHandler.GetAPIKeys)
+						r.Post("/", cfg.APIKeyHandler.CreateAPIKey)
+						r.Delete("/{id}", cfg.APIKeyHandler.RevokeAPIKey)
+					})
+				}
+			})
+		})
+	}
+	r.Route("/v1", apiV1)
+
+	// Legacy unversioned routes, only the ones served before /v1, answered as
+	// v1 until their sunset date and with 410 Gone after it
+	r.Group(func(r chi.Router) {
+		r.Use(middlewares.Deprecated(cfg.LegacyDeprecatedAt, cfg.LegacySunsetAt, "/v1"))
+		r.Route("/products", productRoutes)
+		r.Route("/user", registrationRoutes)
+	})
+
+	// OAuth 2.0 endpoints, at the paths clients expect, outside the versions
+	if cfg.OAuthHandler != nil {
+		r.Route("/oauth", func(r chi.Router) {
+			r.With(middlewares.RateLimit(cfg.LoginIPLimiter, cfg.Logger)).Post("/token", cfg.OAuthHandler.Token)
+			r.Post("/introspect", cfg.OAuthHandler.Introspect)
+			r.Post("/revoke", cfg.OAuthHandler.Revoke)
+		})
+	}
+
+	// Public keys of the tokens, at the path other services look for them
+	r.Get("/.well-known/jwks.json", cfg.JWKSHandler.GetJWKS)
+	r.Handle("/metrics", cfg.Metrics.Handler())
+	r.Get("/docs/*", httpSwagger.Handler(httpSwagger.URL(cfg.DocsURL)))
+	return r
+}
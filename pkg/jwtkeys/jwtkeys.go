@@ -0,0 +1,254 @@
+// Package jwtkeys signs and verifies JWTs with a set of keys, so the signing
+// key can be replaced while the tokens it signed are still accepted, and
+// publishes the public keys as a JWK set (RFC 7517) for other services.
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/go-chi/jwtauth"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+var (
+	ErrUnsupportedKey = errors.New("unsupported key, use RSA, ECDSA P-256 or Ed25519")
+	ErrInvalidPEM     = errors.New("invalid PEM key")
+	ErrDuplicatedKey  = errors.New("duplicated key")
+)
+
+// verifyKey is a key that verifies the tokens with its kid header.
+type verifyKey struct {
+	alg jwa.SignatureAlgorithm
+	key interface{}
+}
+
+// KeySet signs tokens with its signing key and verifies them with any of its
+// keys, found by the kid header of the token. Its methods mirror the ones of
+// jwtauth.JWTAuth, and it stores the tokens in the context like jwtauth, so
+// jwtauth.Authenticator and jwtauth.FromContext work with it.
+type KeySet struct {
+	alg     jwa.SignatureAlgorithm
+	signKey interface{}
+	keys    map[string]verifyKey
+	public  jwk.Set
+}
+
+// NewHMAC returns a key set signing and verifying with secret (HS256), for
+// APIs that only verify their own tokens. Its tokens have no kid, and it
+// publishes no keys.
+func NewHMAC(secret []byte) *KeySet {
+	return &KeySet{
+		alg:     jwa.HS256,
+		signKey: secret,
+		keys:    map[string]verifyKey{"": {alg: jwa.HS256, key: secret}},
+		public:  jwk.NewSet(),
+	}
+}
+
+// New returns a key set signing with private, an RSA (RS256), ECDSA P-256
+// (ES256) or Ed25519 (EdDSA) key, and verifying with its public key and the
+// others, such as the previous signing keys or the next one. Keys are
+// identified by their RFC 7638 thumbprint.
+func New(private crypto.Signer, others ...crypto.PublicKey) (*KeySet, error) {
+	alg, err := algorithm(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	signKey, err := jwk.New(private)
+	if err != nil {
+		return nil, err
+	}
+	if err := jwk.AssignKeyID(signKey); err != nil {
+		return nil, err
+	}
+	s := &KeySet{alg: alg, signKey: signKey, keys: map[string]verifyKey{}, public: jwk.NewSet()}
+	for _, public := range append([]crypto.PublicKey{private.Public()}, others...) {
+		if err := s.add(public); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// LoadPEM returns the key set of New with the private key in the PEM file
+// privateFile and the public keys in the PEM files otherFiles.
+func LoadPEM(privateFile string, otherFiles ...string) (*KeySet, error) {
+	data, err := os.ReadFile(privateFile)
+	if err != nil {
+		return nil, err
+	}
+	private, err := ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", privateFile, err)
+	}
+	others := make([]crypto.PublicKey, 0, len(otherFiles))
+	for _, file := range otherFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		public, err := ParsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		others = append(others, public)
+	}
+	return New(private, others...)
+}
+
+// ParsePrivateKey parses a PEM private key in the PKCS #8, PKCS #1 (RSA) or
+// SEC 1 (ECDSA) format.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidPEM
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, ErrInvalidPEM
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+	return signer, nil
+}
+
+// ParsePublicKey parses a PEM public key in the PKIX format.
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, ErrInvalidPEM
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// Encode signs a token with claims, like jwtauth.JWTAuth.Encode.
+func (s *KeySet) Encode(claims map[string]interface{}) (jwt.Token, string, error) {
+	token := jwt.New()
+	for name, value := range claims {
+		if err := token.Set(name, value); err != nil {
+			return nil, "", err
+		}
+	}
+	signed, err := jwt.Sign(token, s.alg, s.signKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return token, string(signed), nil
+}
+
+// Verify verifies the signature and the time claims of a token, like
+// jwtauth.VerifyToken, returning the same errors.
+func (s *KeySet) Verify(tokenString string) (jwt.Token, error) {
+	message, err := jws.ParseString(tokenString)
+	if err != nil || len(message.Signatures()) != 1 {
+		return nil, jwtauth.ErrUnauthorized
+	}
+	// The algorithm of the key is used, not the one of the header
+	key, ok := s.keys[message.Signatures()[0].ProtectedHeaders().KeyID()]
+	if !ok {
+		return nil, jwtauth.ErrUnauthorized
+	}
+	token, err := jwt.ParseString(tokenString, jwt.WithVerify(key.alg, key.key))
+	if err != nil {
+		return nil, jwtauth.ErrorReason(err)
+	}
+	if err := jwt.Validate(token); err != nil {
+		return token, jwtauth.ErrorReason(err)
+	}
+	return token, nil
+}
+
+// Verifier verifies the token of the Authorization header or jwt cookie, like
+// jwtauth.Verifier.
+func (s *KeySet) Verifier(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString := jwtauth.TokenFromHeader(r)
+		if tokenString == "" {
+			tokenString = jwtauth.TokenFromCookie(r)
+		}
+		var token jwt.Token
+		err := jwtauth.ErrNoTokenFound
+		if tokenString != "" {
+			token, err = s.Verify(tokenString)
+		}
+		next.ServeHTTP(w, r.WithContext(jwtauth.NewContext(r.Context(), token, err)))
+	})
+}
+
+// PublicKeys returns the public keys that verify the tokens, empty for HMAC
+// key sets.
+func (s *KeySet) PublicKeys() jwk.Set {
+	return s.public
+}
+
+// add adds a public key that verifies the tokens.
+func (s *KeySet) add(public crypto.PublicKey) error {
+	alg, err := algorithm(public)
+	if err != nil {
+		return err
+	}
+	key, err := jwk.New(public)
+	if err != nil {
+		return err
+	}
+	if err := jwk.AssignKeyID(key); err != nil {
+		return err
+	}
+	if _, ok := s.keys[key.KeyID()]; ok {
+		return ErrDuplicatedKey
+	}
+	if err := key.Set(jwk.AlgorithmKey, alg.String()); err != nil {
+		return err
+	}
+	if err := key.Set(jwk.KeyUsageKey, string(jwk.ForSignature)); err != nil {
+		return err
+	}
+	var raw interface{}
+	if err := key.Raw(&raw); err != nil {
+		return err
+	}
+	s.keys[key.KeyID()] = verifyKey{alg: alg, key: raw}
+	s.public.Add(key)
+	return nil
+}
+
+// algorithm returns the signature algorithm of a public key.
+func algorithm(public crypto.PublicKey) (jwa.SignatureAlgorithm, error) {
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		return jwa.RS256, nil
+	case *ecdsa.PublicKey:
+		if key.Curve == elliptic.P256() {
+			return jwa.ES256, nil
+		}
+	case ed25519.PublicKey:
+		return jwa.EdDSA, nil
+	}
+	return "", ErrUnsupportedKey
+}
@@ -0,0 +1,230 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chi/jwtauth"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRSAKey(t *testing.T) crypto.Signer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	return key
+}
+
+func newECDSAKey(t *testing.T) crypto.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	return key
+}
+
+func newEd25519Key(t *testing.T) crypto.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	return key
+}
+
+// claims returns the claims of a token expiring in expiresIn.
+func claims(expiresIn time.Duration) map[string]interface{} {
+	return map[string]interface{}{"sub": "user-id", "exp": time.Now().Add(expiresIn).Unix()}
+}
+
+func TestKeySet(t *testing.T) {
+	tests := []struct {
+		alg string
+		key crypto.Signer
+	}{
+		{"RS256", newRSAKey(t)},
+		{"ES256", newECDSAKey(t)},
+		{"EdDSA", newEd25519Key(t)},
+	}
+	for _, tt := range tests {
+		keys, err := New(tt.key)
+		assert.Nil(t, err, tt.alg)
+
+		_, tokenString, err := keys.Encode(claims(time.Minute))
+		assert.Nil(t, err, tt.alg)
+		message, err := jws.ParseString(tokenString)
+		assert.Nil(t, err, tt.alg)
+		headers := message.Signatures()[0].ProtectedHeaders()
+		assert.Equal(t, tt.alg, headers.Algorithm().String())
+		assert.NotEmpty(t, headers.KeyID(), tt.alg)
+
+		token, err := keys.Verify(tokenString)
+		assert.Nil(t, err, tt.alg)
+		assert.Equal(t, "user-id", token.Subject())
+
+		// The public key is published with the kid of the tokens
+		if assert.Equal(t, 1, keys.PublicKeys().Len(), tt.alg) {
+			public, _ := keys.PublicKeys().Get(0)
+			assert.Equal(t, headers.KeyID(), public.KeyID())
+			assert.Equal(t, tt.alg, public.Algorithm())
+			assert.Equal(t, "sig", public.KeyUsage())
+		}
+		data, err := json.Marshal(keys.PublicKeys())
+		assert.Nil(t, err)
+		assert.NotContains(t, string(data), `"d"`, tt.alg)
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	oldKey, newKey := newRSAKey(t), newECDSAKey(t)
+	oldKeys, err := New(oldKey)
+	assert.Nil(t, err)
+	_, oldToken, _ := oldKeys.Encode(claims(time.Minute))
+
+	// The new key signs, and the old one still verifies its tokens
+	keys, err := New(newKey, oldKey.Public())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, keys.PublicKeys().Len())
+	_, newToken, _ := keys.Encode(claims(time.Minute))
+	_, err = keys.Verify(oldToken)
+	assert.Nil(t, err)
+	_, err = keys.Verify(newToken)
+	assert.Nil(t, err)
+
+	// Until it is removed
+	keys, err = New(newKey)
+	assert.Nil(t, err)
+	_, err = keys.Verify(oldToken)
+	assert.Equal(t, jwtauth.ErrUnauthorized, err)
+	_, err = oldKeys.Verify(newToken)
+	assert.Equal(t, jwtauth.ErrUnauthorized, err)
+
+	_, err = New(newKey, newKey.Public())
+	assert.Equal(t, ErrDuplicatedKey, err)
+}
+
+func TestKeySetWhenTokenIsInvalid(t *testing.T) {
+	key := newRSAKey(t)
+	keys, _ := New(key)
+	hmac := NewHMAC([]byte("secret"))
+
+	_, expired, _ := keys.Encode(claims(-time.Minute))
+	_, err := keys.Verify(expired)
+	assert.Equal(t, jwtauth.ErrExpired, err)
+
+	// Tokens without kid, or signed with the public key as an HMAC secret
+	_, hmacToken, _ := hmac.Encode(claims(time.Minute))
+	_, err = keys.Verify(hmacToken)
+	assert.Equal(t, jwtauth.ErrUnauthorized, err)
+	public, _ := keys.PublicKeys().Get(0)
+	headers := jws.NewHeaders()
+	headers.Set(jws.KeyIDKey, public.KeyID())
+	confused, err := jwt.Sign(jwt.New(), jwa.HS256, x509.MarshalPKCS1PublicKey(key.Public().(*rsa.PublicKey)), jwt.WithHeaders(headers))
+	assert.Nil(t, err)
+	_, err = keys.Verify(string(confused))
+	assert.Equal(t, jwtauth.ErrUnauthorized, err)
+
+	_, err = keys.Verify("abc")
+	assert.Equal(t, jwtauth.ErrUnauthorized, err)
+	_, token, _ := keys.Encode(claims(time.Minute))
+	_, err = keys.Verify(token + "0")
+	assert.Equal(t, jwtauth.ErrUnauthorized, err)
+
+	_, err = New(newECDSAKey(t), &ecdsa.PublicKey{Curve: elliptic.P384()})
+	assert.Equal(t, ErrUnsupportedKey, err)
+}
+
+func TestNewHMAC(t *testing.T) {
+	keys := NewHMAC([]byte("secret"))
+	_, tokenString, err := keys.Encode(claims(time.Minute))
+	assert.Nil(t, err)
+
+	// Tokens are compatible with jwtauth
+	ja := jwtauth.New("HS256", []byte("secret"), nil)
+	_, err = jwtauth.VerifyToken(ja, tokenString)
+	assert.Nil(t, err)
+	_, jaToken, _ := ja.Encode(claims(time.Minute))
+	_, err = keys.Verify(jaToken)
+	assert.Nil(t, err)
+
+	_, err = NewHMAC([]byte("other")).Verify(tokenString)
+	assert.Equal(t, jwtauth.ErrUnauthorized, err)
+	assert.Equal(t, 0, keys.PublicKeys().Len())
+}
+
+func TestVerifier(t *testing.T) {
+	keys, _ := New(newEd25519Key(t))
+	_, tokenString, _ := keys.Encode(claims(time.Minute))
+
+	var sub interface{}
+	handler := keys.Verifier(jwtauth.Authenticator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, claims, _ := jwtauth.FromContext(r.Context())
+		sub = claims["sub"]
+	})))
+	request := func(token string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, request(tokenString))
+	assert.Equal(t, "user-id", sub)
+	assert.Equal(t, http.StatusUnauthorized, request(""))
+	assert.Equal(t, http.StatusUnauthorized, request(tokenString+"0"))
+}
+
+func TestLoadPEM(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, blockType string, data []byte) string {
+		file := filepath.Join(dir, name)
+		assert.Nil(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0o600))
+		return file
+	}
+	rsaKey := newRSAKey(t).(*rsa.PrivateKey)
+	ecdsaKey := newECDSAKey(t).(*ecdsa.PrivateKey)
+	edKey := newEd25519Key(t)
+
+	pkcs1 := write("rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	ecData, _ := x509.MarshalECPrivateKey(ecdsaKey)
+	sec1 := write("ec.pem", "EC PRIVATE KEY", ecData)
+	edData, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	pkcs8 := write("ed.pem", "PRIVATE KEY", edData)
+	rsaPublicData, _ := x509.MarshalPKIXPublicKey(rsaKey.Public())
+	rsaPublic := write("rsa.pub.pem", "PUBLIC KEY", rsaPublicData)
+
+	for _, file := range []string{pkcs1, sec1, pkcs8} {
+		keys, err := LoadPEM(file)
+		assert.Nil(t, err, file)
+		if keys != nil {
+			assert.Equal(t, 1, keys.PublicKeys().Len(), file)
+		}
+	}
+
+	keys, err := LoadPEM(pkcs8, rsaPublic)
+	assert.Nil(t, err)
+	rsaKeys, _ := New(rsaKey)
+	_, token, _ := rsaKeys.Encode(claims(time.Minute))
+	_, err = keys.Verify(token)
+	assert.Nil(t, err)
+
+	_, err = LoadPEM(rsaPublic)
+	assert.ErrorIs(t, err, ErrInvalidPEM)
+	_, err = LoadPEM(pkcs8, pkcs1)
+	assert.ErrorIs(t, err, ErrInvalidPEM)
+	_, err = LoadPEM(filepath.Join(dir, "missing.pem"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}